@@ -0,0 +1,278 @@
+// Package analysis recovers the functions and basic blocks of a binary by walking its code from the entry point
+//
+// The walk is static: it follows the jumps and calls whose target is a literal, jumps through registers are not
+// resolved and the code decrypted at runtime is only seen if the memory given is a dump taken after the decryption.
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	Addr uint16        // Address of the instruction
+	Op   isa.Operation // Operation
+	Args []uint16      // Raw arguments, values from vm.M are registers
+}
+
+// Next returns the address following the instruction
+func (i Instruction) Next() uint16 {
+	return i.Addr + 1 + i.Op.NArgs
+}
+
+// Target returns the literal destination of a JMP, JT, JF or CALL, ok is false for other operations or when the
+// destination is a register
+func (i Instruction) Target() (addr uint16, ok bool) {
+	var arg uint16
+	switch i.Op.Code {
+	case vm.JMP, vm.CALL:
+		arg = i.Args[0]
+	case vm.JT, vm.JF:
+		arg = i.Args[1]
+	default:
+		return 0, false
+	}
+	return arg, arg < vm.M
+}
+
+// Block is a basic block: a sequence of instructions only entered at its start and only left at its end
+type Block struct {
+	Start        uint16        // Address of the first instruction
+	Instructions []Instruction // Instructions of the block
+	Succs        []uint16      // Start of the blocks the execution can go to after the block
+}
+
+// Last returns the last instruction of the block
+func (b *Block) Last() Instruction {
+	return b.Instructions[len(b.Instructions)-1]
+}
+
+// Function is the code reachable from an entry point without following calls
+type Function struct {
+	Entry   uint16            // Address of the first instruction
+	Blocks  map[uint16]*Block // Blocks indexed by start address
+	Calls   []uint16          // Functions called with a literal address, sorted
+	Callers []uint16          // Functions calling this one, sorted
+	code    map[uint16]Instruction
+}
+
+// Name returns the name given to the function in outputs
+func (f *Function) Name() string {
+	if f.Entry == 0 {
+		return "main"
+	}
+	return fmt.Sprintf("fn_%d", f.Entry)
+}
+
+// Instruction returns the instruction of the function at addr
+func (f *Function) Instruction(addr uint16) (Instruction, bool) {
+	i, ok := f.code[addr]
+	return i, ok
+}
+
+// Addresses returns the addresses of the instructions of the function, sorted
+func (f *Function) Addresses() []uint16 {
+	addrs := make([]uint16, 0, len(f.code))
+	for addr := range f.code {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// SortedBlocks returns the blocks sorted by address
+func (f *Function) SortedBlocks() []*Block {
+	blocks := make([]*Block, 0, len(f.Blocks))
+	for _, b := range f.Blocks {
+		blocks = append(blocks, b)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Start < blocks[j].Start })
+	return blocks
+}
+
+// Program is the result of the analysis of a binary
+type Program struct {
+	Memory    []uint16
+	Functions map[uint16]*Function // Functions indexed by entry
+}
+
+// SortedFunctions returns the functions sorted by entry
+func (p *Program) SortedFunctions() []*Function {
+	fns := make([]*Function, 0, len(p.Functions))
+	for _, f := range p.Functions {
+		fns = append(fns, f)
+	}
+	sort.Slice(fns, func(i, j int) bool { return fns[i].Entry < fns[j].Entry })
+	return fns
+}
+
+// Decode decodes the instruction at addr, ok is false for an invalid opcode or an instruction cut by the end of memory
+func Decode(memory []uint16, addr uint16) (Instruction, bool) {
+	if int(addr) >= len(memory) {
+		return Instruction{}, false
+	}
+
+	op, ok := isa.Lookup(memory[addr])
+	if !ok || int(addr)+int(op.NArgs) >= len(memory) {
+		return Instruction{}, false
+	}
+
+	return Instruction{Addr: addr, Op: op, Args: memory[addr+1 : addr+1+op.NArgs]}, true
+}
+
+// Analyze walks the memory from the given entry points (0 if none) and from every function they call
+func Analyze(memory []uint16, entries ...uint16) *Program {
+	if len(entries) == 0 {
+		entries = []uint16{0}
+	}
+
+	p := &Program{Memory: memory, Functions: map[uint16]*Function{}}
+	queue := append([]uint16{}, entries...)
+
+	for len(queue) != 0 {
+		entry := queue[0]
+		queue = queue[1:]
+		if _, done := p.Functions[entry]; done {
+			continue
+		}
+
+		f := walk(memory, entry)
+		p.Functions[entry] = f
+		queue = append(queue, f.Calls...)
+	}
+
+	for _, f := range p.SortedFunctions() {
+		for _, callee := range f.Calls {
+			if g, ok := p.Functions[callee]; ok {
+				g.Callers = append(g.Callers, f.Entry)
+			}
+		}
+	}
+
+	return p
+}
+
+// AnalyzeAll analyzes the memory from the entry point, every literal CALL target and the extra entry points given
+func AnalyzeAll(memory []uint16, extra ...uint16) *Program {
+	entries := append([]uint16{0}, CallTargets(memory)...)
+	return Analyze(memory, append(entries, extra...)...)
+}
+
+// CallTargets returns the literal targets of the CALL found by decoding the memory linearly, they make good entry points
+// for Analyze since most of the functions are called through registers from the entry point
+func CallTargets(memory []uint16) []uint16 {
+	seen := map[uint16]bool{}
+	targets := []uint16{}
+	for addr := 0; addr < len(memory); {
+		ins, ok := Decode(memory, uint16(addr))
+		if !ok {
+			addr++
+			continue
+		}
+
+		if target, literal := ins.Target(); literal && ins.Op.Code == vm.CALL && int(target) < len(memory) && !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+		addr = int(ins.Next())
+	}
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+	return targets
+}
+
+// walk decodes the function starting at entry and splits it in basic blocks
+func walk(memory []uint16, entry uint16) *Function {
+	f := &Function{Entry: entry, Blocks: map[uint16]*Block{}, code: map[uint16]Instruction{}}
+	leaders := map[uint16]bool{entry: true}
+	calls := map[uint16]bool{}
+
+	queue := []uint16{entry}
+	for len(queue) != 0 {
+		addr := queue[0]
+		queue = queue[1:]
+
+		// Decode until the end of the straight line of code
+		for {
+			if _, seen := f.code[addr]; seen {
+				break
+			}
+			ins, ok := Decode(memory, addr)
+			if !ok {
+				break
+			}
+			f.code[addr] = ins
+
+			target, literal := ins.Target()
+			switch ins.Op.Code {
+			case vm.JMP:
+				if literal {
+					leaders[target] = true
+					queue = append(queue, target)
+				}
+			case vm.JT, vm.JF:
+				if literal {
+					leaders[target] = true
+					queue = append(queue, target)
+				}
+				leaders[ins.Next()] = true
+			case vm.CALL:
+				if literal {
+					calls[target] = true
+				}
+			}
+
+			if ends(ins) {
+				break
+			}
+			addr = ins.Next()
+		}
+	}
+
+	// Cut the code at the leaders
+	for _, addr := range f.Addresses() {
+		if !leaders[addr] {
+			continue
+		}
+
+		b := &Block{Start: addr}
+		for {
+			ins := f.code[addr]
+			b.Instructions = append(b.Instructions, ins)
+
+			next, falls := ins.Next(), !ends(ins)
+			if target, literal := ins.Target(); literal && ins.Op.Code != vm.CALL {
+				b.Succs = append(b.Succs, target)
+			}
+
+			if _, decoded := f.code[next]; !falls || !decoded || leaders[next] {
+				if falls && decoded {
+					b.Succs = append(b.Succs, next)
+				}
+				break
+			}
+			addr = next
+		}
+		f.Blocks[b.Start] = b
+	}
+
+	for addr := range calls {
+		f.Calls = append(f.Calls, addr)
+	}
+	sort.Slice(f.Calls, func(i, j int) bool { return f.Calls[i] < f.Calls[j] })
+
+	return f
+}
+
+// ends returns true if the execution never goes to the next instruction after ins
+func ends(ins Instruction) bool {
+	switch ins.Op.Code {
+	case vm.JMP, vm.RET, vm.HALT:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/sfluor/synacor/symbols"
+)
+
+// Kinds of the symbols of an export
+const (
+	SymbolFunction = "function"
+	SymbolLabel    = "label"
+	SymbolString   = "string"
+)
+
+// Symbol is an address described by the exports for other disassemblers, see Symbols
+type Symbol struct {
+	Addr uint16
+	Kind string // SymbolFunction, SymbolLabel or SymbolString
+	Name string // Name of a function or label, text of a string
+}
+
+// Symbols returns the functions of p (named by syms when they have a name), the other names of syms (nil for none) and
+// the strings, sorted by address
+func Symbols(p *Program, syms *symbols.Table, strs []String) []Symbol {
+	list := []Symbol{}
+	for _, f := range p.SortedFunctions() {
+		name := f.Name()
+		if n, ok := syms.Name(f.Entry); ok {
+			name = n
+		}
+		list = append(list, Symbol{Addr: f.Entry, Kind: SymbolFunction, Name: name})
+	}
+	if syms != nil {
+		for _, addr := range syms.Addresses() {
+			if _, ok := p.Functions[addr]; !ok {
+				name, _ := syms.Name(addr)
+				list = append(list, Symbol{Addr: addr, Kind: SymbolLabel, Name: name})
+			}
+		}
+	}
+	for _, s := range strs {
+		list = append(list, Symbol{Addr: s.Addr, Kind: SymbolString, Name: s.Text})
+	}
+
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Addr < list[j].Addr })
+	return list
+}
+
+// WriteMap writes a line per symbol: its address in words, its offset in bytes in the raw image (the binary, two bytes
+// per word), its kind and its name or quoted text
+func WriteMap(w io.Writer, list []Symbol) error {
+	if _, err := fmt.Fprintf(w, "%-6s %-8s %-8s %s\n", "addr", "offset", "kind", "name"); err != nil {
+		return err
+	}
+	for _, s := range list {
+		name := s.Name
+		if s.Kind == SymbolString {
+			name = strconv.Quote(name)
+		}
+		if _, err := fmt.Fprintf(w, "%-6d 0x%06x %-8s %s\n", s.Addr, 2*int(s.Addr), s.Kind, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ghidraScript applies the symbols to the binary loaded in Ghidra as a raw image
+const ghidraScript = `# Names the functions and labels and comments the strings of the Synacor binary loaded as a raw image
+# (File > Import File, format Raw Binary), written by the export subcommand.
+# @category Synacor
+
+# Bytes per address of the image: 2 for a byte-addressed language (the offsets of the map file), 1 for a
+# word-addressed one
+WORD = 2
+
+FUNCTIONS = [
+%s]
+
+LABELS = [
+%s]
+
+STRINGS = [
+%s]
+
+
+def addr(word):
+    return toAddr(word * WORD)
+
+
+for a, name in FUNCTIONS:
+    if getFunctionAt(addr(a)) is None:
+        createFunction(addr(a), name)
+    else:
+        createLabel(addr(a), name, True)
+
+for a, name in LABELS:
+    createLabel(addr(a), name, True)
+
+for a, text in STRINGS:
+    setEOLComment(addr(a), text)
+
+print("%%d functions, %%d labels and %%d strings" %% (len(FUNCTIONS), len(LABELS), len(STRINGS)))
+`
+
+// WriteGhidraScript writes a Ghidra Python script creating the functions and the labels and commenting the strings of
+// list in the binary loaded as a raw image
+func WriteGhidraScript(w io.Writer, list []Symbol) error {
+	entries := map[string]string{}
+	for _, s := range list {
+		entries[s.Kind] += fmt.Sprintf("    (%d, %s),\n", s.Addr, strconv.Quote(s.Name))
+	}
+	_, err := fmt.Fprintf(w, ghidraScript, entries[SymbolFunction], entries[SymbolLabel], entries[SymbolString])
+	return err
+}
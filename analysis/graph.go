@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// dotEscaper escapes the text of a DOT label
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// WriteCallGraphDOT writes the call graph in the Graphviz DOT format, the calls through registers are not part of it
+func (p *Program) WriteCallGraphDOT(w io.Writer) error {
+	buf := &bytes.Buffer{}
+	buf.WriteString("digraph calls {\n")
+	buf.WriteString("\tnode [shape=box];\n")
+
+	fns := p.SortedFunctions()
+	for _, f := range fns {
+		fmt.Fprintf(buf, "\t%q;\n", f.Name())
+	}
+	for _, f := range fns {
+		for _, callee := range f.Calls {
+			if g, ok := p.Functions[callee]; ok {
+				fmt.Fprintf(buf, "\t%q -> %q;\n", f.Name(), g.Name())
+			}
+		}
+	}
+
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteCFGDOT writes the control-flow graph of the functions starting at entries (every function if there are none)
+// in the Graphviz DOT format, one cluster per function
+func (p *Program) WriteCFGDOT(w io.Writer, entries ...uint16) error {
+	fns, err := p.Select(entries)
+	if err != nil {
+		return err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("digraph cfg {\n")
+	buf.WriteString("\tnode [shape=box, fontname=monospace];\n")
+
+	for _, f := range fns {
+		fmt.Fprintf(buf, "\tsubgraph \"cluster_%s\" {\n", f.Name())
+		fmt.Fprintf(buf, "\t\tlabel=%q;\n", f.Name())
+
+		for _, b := range f.SortedBlocks() {
+			lines := []string{}
+			for _, ins := range b.Instructions {
+				lines = append(lines, dotEscaper.Replace(fmt.Sprintf("(%6d) %s", ins.Addr, isa.Disassemble(p.Memory, ins.Addr))))
+			}
+			fmt.Fprintf(buf, "\t\t\"%s_%d\" [label=\"%s\\l\"];\n", f.Name(), b.Start, strings.Join(lines, `\l`))
+		}
+
+		for _, b := range f.SortedBlocks() {
+			for _, succ := range b.Succs {
+				if _, ok := f.Blocks[succ]; ok {
+					fmt.Fprintf(buf, "\t\t\"%s_%d\" -> \"%s_%d\";\n", f.Name(), b.Start, f.Name(), succ)
+				}
+			}
+		}
+
+		buf.WriteString("\t}\n")
+	}
+
+	buf.WriteString("}\n")
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// jsonFunction is the JSON form of a function
+type jsonFunction struct {
+	Entry   uint16      `json:"entry"`
+	Name    string      `json:"name"`
+	Calls   []uint16    `json:"calls"`
+	Callers []uint16    `json:"callers"`
+	Blocks  []jsonBlock `json:"blocks"`
+}
+
+// jsonBlock is the JSON form of a basic block
+type jsonBlock struct {
+	Start        uint16   `json:"start"`
+	End          uint16   `json:"end"` // Address following the last instruction
+	Instructions []string `json:"instructions"`
+	Succs        []uint16 `json:"succs"`
+}
+
+// WriteJSON writes the functions starting at entries (every function if there are none) with their blocks, calls
+// and callers as JSON
+func (p *Program) WriteJSON(w io.Writer, entries ...uint16) error {
+	fns, err := p.Select(entries)
+	if err != nil {
+		return err
+	}
+
+	out := []jsonFunction{}
+	for _, f := range fns {
+		jf := jsonFunction{Entry: f.Entry, Name: f.Name(), Calls: f.Calls, Callers: f.Callers, Blocks: []jsonBlock{}}
+		if jf.Calls == nil {
+			jf.Calls = []uint16{}
+		}
+		if jf.Callers == nil {
+			jf.Callers = []uint16{}
+		}
+
+		for _, b := range f.SortedBlocks() {
+			jb := jsonBlock{Start: b.Start, End: b.Last().Next(), Instructions: []string{}, Succs: b.Succs}
+			if jb.Succs == nil {
+				jb.Succs = []uint16{}
+			}
+			for _, ins := range b.Instructions {
+				jb.Instructions = append(jb.Instructions, isa.Disassemble(p.Memory, ins.Addr))
+			}
+			jf.Blocks = append(jf.Blocks, jb)
+		}
+		out = append(out, jf)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// Select returns the functions starting at entries, or every function sorted by entry if there are none
+func (p *Program) Select(entries []uint16) ([]*Function, error) {
+	if len(entries) == 0 {
+		return p.SortedFunctions(), nil
+	}
+
+	fns := []*Function{}
+	for _, entry := range entries {
+		f, ok := p.Functions[entry]
+		if !ok {
+			return nil, fmt.Errorf("no function starts at %d", entry)
+		}
+		fns = append(fns, f)
+	}
+	return fns, nil
+}
@@ -0,0 +1,59 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// Range is a range of addresses, End is excluded
+type Range struct {
+	Start, End uint16
+}
+
+// ChangedRanges returns the ranges of addresses whose value differ between before and after
+func ChangedRanges(before, after []uint16) []Range {
+	ranges := []Range{}
+	start := -1
+	for addr := 0; addr <= len(after); addr++ {
+		changed := addr < len(after) && (addr >= len(before) || before[addr] != after[addr])
+		if changed && start < 0 {
+			start = addr
+		}
+		if !changed && start >= 0 {
+			ranges = append(ranges, Range{uint16(start), uint16(addr)})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// WriteLiveListing writes the disassembly of memory, a dump of the VM memory at runtime. The instructions whose words
+// differ from original (the binary on disk) are written with a * instead of a |, the changed ranges are listed first.
+func WriteLiveListing(w io.Writer, memory, original []uint16) error {
+	bw := bufio.NewWriter(w)
+
+	ranges := ChangedRanges(original, memory)
+	fmt.Fprintf(bw, "; %d ranges changed at runtime\n", len(ranges))
+	for _, r := range ranges {
+		fmt.Fprintf(bw, "; (%6d) - (%6d) %d words\n", r.Start, r.End, r.End-r.Start)
+	}
+
+	for addr := 0; addr < len(memory); {
+		size := isa.Operands(memory[addr]) + 1
+
+		sep := "|"
+		for i := addr; i < addr+size && i < len(memory); i++ {
+			if i >= len(original) || original[i] != memory[i] {
+				sep = "*"
+			}
+		}
+
+		fmt.Fprintf(bw, "(%6d) %s %s\n", addr, sep, isa.Disassemble(memory, uint16(addr)))
+		addr += size
+	}
+
+	return bw.Flush()
+}
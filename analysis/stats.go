@@ -0,0 +1,183 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Kinds of the regions found by Regions
+const (
+	KindCode = "code"
+	KindText = "text"
+	KindData = "data"
+)
+
+// Region is a range of addresses classified by Regions
+type Region struct {
+	Start, End uint16  // End is excluded
+	Kind       string  // KindCode, KindText or KindData
+	Valid      float64 // Fraction of the words decoding as valid instructions, from 0 to 1
+	Entropy    float64 // Shannon entropy of the bytes, in bits per byte (at most 8)
+}
+
+// Entry is a candidate function entry point
+type Entry struct {
+	Addr     uint16
+	Callers  int  // Literal CALL targeting it
+	AfterRet bool // Follows a RET, where the next function often starts
+}
+
+// OpcodeHistogram counts the operations found by decoding the memory linearly, an invalid word is skipped
+func OpcodeHistogram(memory []uint16) map[isa.Operation]int {
+	counts := map[isa.Operation]int{}
+	linear(memory, func(ins Instruction) {
+		counts[ins.Op]++
+	})
+	return counts
+}
+
+// linear decodes the memory from its start, calling fn with each valid instruction: an invalid word or an instruction
+// with an invalid operand is skipped by one word, which resynchronizes the decoding in the code following data
+func linear(memory []uint16, fn func(ins Instruction)) {
+	for addr := 0; addr < len(memory); {
+		ins, ok := Decode(memory, uint16(addr))
+		if !ok || !validOperands(ins) {
+			addr++
+			continue
+		}
+		fn(ins)
+		addr = int(ins.Next())
+	}
+}
+
+// validOperands tells whether every operand is a literal or a register
+func validOperands(ins Instruction) bool {
+	for _, a := range ins.Args {
+		if a >= vm.M+8 {
+			return false
+		}
+	}
+	return true
+}
+
+// Thresholds of Regions
+const (
+	codeValid     = 0.9 // Code decodes almost entirely
+	textPrintable = 0.8 // Text is mostly printable characters
+)
+
+// Regions splits the memory in windows of the given size, classifies each of them as code (mostly valid instructions),
+// text (mostly printable characters) or data and merges the neighbours of the same kind. Data with a high entropy is
+// likely encrypted or compressed.
+func Regions(memory []uint16, window int) []Region {
+	valid := make([]bool, len(memory))
+	linear(memory, func(ins Instruction) {
+		for addr := ins.Addr; addr < ins.Next(); addr++ {
+			valid[addr] = true
+		}
+	})
+
+	regions := []Region{}
+	for start := 0; start < len(memory); start += window {
+		end := start + window
+		if end > len(memory) {
+			end = len(memory)
+		}
+
+		words := memory[start:end]
+		r := Region{Start: uint16(start), End: uint16(end), Valid: fraction(valid[start:end]), Entropy: entropy(words)}
+		printables := make([]bool, len(words))
+		for i, w := range words {
+			printables[i] = printable(w)
+		}
+		switch {
+		case fraction(printables) >= textPrintable:
+			r.Kind = KindText
+		case r.Valid >= codeValid:
+			r.Kind = KindCode
+		default:
+			r.Kind = KindData
+		}
+
+		if n := len(regions); n > 0 && regions[n-1].Kind == r.Kind {
+			regions[n-1] = merge(regions[n-1], r)
+			continue
+		}
+		regions = append(regions, r)
+	}
+	return regions
+}
+
+// merge returns the region covering a and b, which follow each other, with their averages weighted by their sizes
+func merge(a, b Region) Region {
+	na, nb := float64(a.End-a.Start), float64(b.End-b.Start)
+	return Region{
+		Start:   a.Start,
+		End:     b.End,
+		Kind:    a.Kind,
+		Valid:   (a.Valid*na + b.Valid*nb) / (na + nb),
+		Entropy: (a.Entropy*na + b.Entropy*nb) / (na + nb),
+	}
+}
+
+// fraction returns the fraction of the values that are true
+func fraction(values []bool) float64 {
+	n := 0
+	for _, v := range values {
+		if v {
+			n++
+		}
+	}
+	return float64(n) / float64(len(values))
+}
+
+// entropy returns the Shannon entropy of the bytes of words (little-endian), in bits per byte
+func entropy(words []uint16) float64 {
+	counts := [256]int{}
+	for _, w := range words {
+		counts[w&0xff]++
+		counts[w>>8]++
+	}
+
+	total, h := float64(2*len(words)), 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / total
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// EntryPoints returns the candidate function entry points: the literal targets of CALL and the valid instructions
+// following a RET, sorted by address
+func EntryPoints(memory []uint16) []Entry {
+	entries := map[uint16]*Entry{}
+	get := func(addr uint16) *Entry {
+		if entries[addr] == nil {
+			entries[addr] = &Entry{Addr: addr}
+		}
+		return entries[addr]
+	}
+
+	linear(memory, func(ins Instruction) {
+		if target, literal := ins.Target(); literal && ins.Op.Code == vm.CALL && int(target) < len(memory) {
+			get(target).Callers++
+		}
+		if ins.Op.Code == vm.RET {
+			if next, ok := Decode(memory, ins.Next()); ok && validOperands(next) && next.Op.Code != vm.RET {
+				get(next.Addr).AfterRet = true
+			}
+		}
+	})
+
+	sorted := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, *e)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Addr < sorted[j].Addr })
+	return sorted
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// String is a text of the binary
+type String struct {
+	Addr uint16 // Address of the first character, or of the first OUT instruction for texts printed by literal OUTs
+	Text string
+	Kind string // "memory" for texts read by RMEM before being printed, "code" for literal OUTs, "scan" for ScanStrings
+}
+
+// ScanStrings finds the length-prefixed strings of printable characters in memory with at least min characters. The
+// binary decrypts its texts at runtime so scanning a memory dump taken after running for a while finds more of them.
+func ScanStrings(memory []uint16, min int) []String {
+	strs := []String{}
+	for addr := 0; addr < len(memory); addr++ {
+		n := int(memory[addr])
+		if n < min || n < 1 || addr+n >= len(memory) {
+			continue
+		}
+
+		text := make([]rune, 0, n)
+		for _, v := range memory[addr+1 : addr+1+n] {
+			if !printable(v) {
+				break
+			}
+			text = append(text, rune(v))
+		}
+
+		if len(text) == n {
+			strs = append(strs, String{Addr: uint16(addr), Text: string(text), Kind: "scan"})
+			addr += n
+		}
+	}
+	return strs
+}
+
+// TraceStrings runs the machine until it stops and returns the texts it printed with the addresses they come from. A
+// character printed by OUT comes from the last address read by RMEM, or from the OUT instruction itself if its
+// operand is a literal, the characters coming from consecutive addresses are gathered in a string. Texts printed
+// several times from the same address are only returned once.
+func TraceStrings(machine *vm.VM, min int) ([]String, error) {
+	strs := []String{}
+	seen := map[String]bool{}
+
+	current := String{}
+	next := -1 // Address the next character must come from to extend the current string
+	flush := func() {
+		if len(current.Text) >= min && !seen[current] {
+			seen[current] = true
+			strs = append(strs, current)
+		}
+		current, next = String{}, -1
+	}
+
+	lastRead := -1
+	for {
+		cursor := machine.Cursor()
+		ins, ok := Decode(machine.MemRange(cursor, cursor+4), 0)
+
+		if ok && ins.Op.Code == vm.RMEM {
+			lastRead = int(machine.Operand(ins.Args[1]))
+		}
+
+		if ok && ins.Op.Code == vm.OUT {
+			from, kind, step := lastRead, "memory", 1
+			if ins.Args[0] < vm.M {
+				from, kind, step = int(cursor), "code", int(ins.Op.NArgs)+1
+			}
+
+			if from < 0 {
+				// Computed without reading the memory
+				flush()
+			} else {
+				if from != next || kind != current.Kind {
+					flush()
+					current = String{Addr: uint16(from), Kind: kind}
+				}
+				current.Text += string(rune(machine.Operand(ins.Args[0])))
+				next = from + step
+			}
+		}
+
+		if err := machine.Step(); err != nil {
+			flush()
+			if _, err := vm.ExitReasonOf(err); err != nil {
+				return strs, fmt.Errorf("the machine failed: %w", err)
+			}
+			break
+		}
+	}
+
+	sort.SliceStable(strs, func(i, j int) bool { return strs[i].Addr < strs[j].Addr })
+	return strs, nil
+}
+
+// printable returns true for the characters found in the texts of the game
+func printable(v uint16) bool {
+	return v == '\n' || (v >= ' ' && v < 127)
+}
@@ -0,0 +1,597 @@
+// Package asm compiles a textual assembly into a binary that the VM can execute
+//
+// The syntax mirrors the extracted code:
+//
+//	; comments start with ';' or '#'
+//	start:              ; labels end with ':' and can be used as operands
+//	    set R0 'a'      ; registers are R0 to R7, literals are decimal, 0x hexadecimal or quoted characters
+//	    out R0
+//	    jmp start
+//	msg: .data "Hi\n", 0
+//
+// A few directives keep the longer programs maintainable:
+//
+//	.include "lib.asm"  ; assembles another file here, relative to the including one
+//	.equ newline 10     ; a constant usable as an operand, its value can be a literal, a register or a constant
+//	.macro putc c       ; a macro with its parameters, expanded where its name is used as an operation
+//	    out c
+//	.endm
+//	    print "Hi\n"    ; the built-in macro print writes its strings and operands with one out per character
+//
+// An '@' in the body of a macro is replaced by a number unique to each expansion, so that its labels (loop@:) don't
+// clash.
+//
+// A program can be split across files compiled separately into objects (see Compile) and linked (see Link):
+//
+//	.global main        ; exports labels to the other objects
+//	.extern puts        ; uses a label exported by another object
+package asm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// maxExpansionDepth is how deep macros can expand other macros, deeper is assumed to be a macro expanding itself
+const maxExpansionDepth = 64
+
+// line is a parsed line of assembly
+type line struct {
+	where    string   // Position in the sources for the errors, e.g. "line 3" or "lib.asm line 3"
+	labels   []string // Labels defined on this line
+	mnemonic string   // Operation name or directive
+	args     []string // Raw operands
+}
+
+// macro is a macro defined by .macro
+type macro struct {
+	where  string // Position of its .macro line
+	name   string
+	params []string
+	body   []line
+}
+
+// reader expands the includes, the constants and the macros of the sources into the lines of the program
+type reader struct {
+	lines      []line
+	constants  map[string]uint16 // Values of the constants defined by .equ
+	macros     map[string]*macro
+	defining   *macro   // Macro whose body is being read, between .macro and .endm
+	including  []string // Files being read, to refuse an include cycle
+	expansions int      // Number of macros expanded, for their '@'
+	globals    []line   // The .global lines, checked once the labels are known
+	externs    map[string]bool
+}
+
+// Assemble compiles the given assembly source into 16-bits values, its includes are relative to the current directory
+func Assemble(src string) ([]uint16, error) {
+	return link(Compile(src))
+}
+
+// AssembleFile compiles the assembly source of the given file like Assemble, its includes are relative to its directory
+func AssembleFile(path string) ([]uint16, error) {
+	return link(CompileFile(path))
+}
+
+// link links the object of a whole program compiled by Assemble
+func link(o *Object, err error) ([]uint16, error) {
+	if err != nil {
+		return nil, err
+	}
+	if len(o.Externals) > 0 {
+		return nil, fmt.Errorf("undefined label %q declared .extern, link the program with the object exporting it", o.Externals[0].Label)
+	}
+	return Link([]*Object{o})
+}
+
+// Compile compiles the given assembly source into an object to link with others, see Link
+func Compile(src string) (*Object, error) {
+	return compile(src, "")
+}
+
+// CompileFile compiles the assembly source of the given file like Compile, its includes are relative to its directory
+func CompileFile(path string) (*Object, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return compile(string(src), path)
+}
+
+// compile compiles src read from path, empty if it wasn't read from a file
+func compile(src, path string) (*Object, error) {
+	r := &reader{constants: map[string]uint16{}, macros: map[string]*macro{}, externs: map[string]bool{}}
+	dir := "."
+	if path != "" {
+		r.including, dir = []string{filepath.Clean(path)}, filepath.Dir(path)
+	}
+	if err := r.read(src, "", dir); err != nil {
+		return nil, err
+	}
+	lines := r.lines
+
+	// First pass: compute the address of every label. The operands are looked up in labels, which has the constants
+	// and the .extern labels too (at address 0 until they are linked).
+	labels, addrs := map[string]uint16{}, map[string]bool{}
+	for name, v := range r.constants {
+		labels[name] = v
+	}
+	for name := range r.externs {
+		labels[name] = 0
+	}
+	addr := 0
+	for _, l := range lines {
+		for _, label := range l.labels {
+			if _, exists := r.constants[label]; exists {
+				return nil, fmt.Errorf("%s: label %q is already a constant", l.where, label)
+			}
+			if r.externs[label] {
+				return nil, fmt.Errorf("%s: label %q is declared .extern", l.where, label)
+			}
+			if _, exists := labels[label]; exists {
+				return nil, fmt.Errorf("%s: label %q already defined", l.where, label)
+			}
+			labels[label], addrs[label] = uint16(addr), true
+		}
+
+		size, err := l.size()
+		if err != nil {
+			return nil, err
+		}
+		addr += size
+		if addr > vm.M {
+			return nil, fmt.Errorf("%s: program does not fit in memory", l.where)
+		}
+	}
+
+	o := &Object{Version: objectVersion, Name: path, Code: make([]uint16, 0, addr), Globals: map[string]uint16{}}
+	for _, g := range r.globals {
+		if !addrs[g.args[0]] {
+			return nil, fmt.Errorf("%s: .global %q is not a label", g.where, g.args[0])
+		}
+		o.Globals[g.args[0]] = labels[g.args[0]]
+	}
+
+	// Second pass: emit the code, remembering the words holding an address for Link
+	emit := func(l line, arg string) error {
+		if r.externs[arg] {
+			o.Externals = append(o.Externals, Reference{Offset: uint16(len(o.Code)), Label: arg})
+		} else if addrs[arg] {
+			o.Relocations = append(o.Relocations, uint16(len(o.Code)))
+		}
+
+		v, err := operand(arg, labels)
+		if err != nil {
+			return fmt.Errorf("%s: %s", l.where, err)
+		}
+		o.Code = append(o.Code, v)
+		return nil
+	}
+	for _, l := range lines {
+		switch l.mnemonic {
+		case "":
+			continue
+
+		case ".data":
+			for _, arg := range l.args {
+				if isString(arg) {
+					s, _ := strconv.Unquote(arg)
+					for _, r := range s {
+						o.Code = append(o.Code, uint16(r))
+					}
+					continue
+				}
+
+				if err := emit(l, arg); err != nil {
+					return nil, err
+				}
+			}
+
+		default:
+			op, _ := isa.LookupName(l.mnemonic)
+			o.Code = append(o.Code, op.Code)
+			for _, arg := range l.args {
+				if err := emit(l, arg); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return o, nil
+}
+
+// size returns the number of 16-bits values the line will be compiled into
+func (l line) size() (int, error) {
+	if l.mnemonic == "" {
+		return 0, nil
+	}
+
+	if l.mnemonic == ".data" {
+		size := 0
+		for _, arg := range l.args {
+			if !isString(arg) {
+				size++
+				continue
+			}
+
+			s, err := strconv.Unquote(arg)
+			if err != nil {
+				return 0, fmt.Errorf("%s: invalid string %s", l.where, arg)
+			}
+			size += len([]rune(s))
+		}
+		return size, nil
+	}
+
+	op, ok := isa.LookupName(l.mnemonic)
+	if !ok {
+		return 0, fmt.Errorf("%s: unknown operation %q", l.where, l.mnemonic)
+	}
+
+	if len(l.args) != int(op.NArgs) {
+		return 0, fmt.Errorf("%s: %s expects %d arguments, got %d", l.where, op.Name, op.NArgs, len(l.args))
+	}
+
+	return int(op.NArgs) + 1, nil
+}
+
+// parse splits a line of the source in labels, mnemonic and operands
+func parse(raw, where string) (line, error) {
+	tokens, err := tokenize(raw)
+	if err != nil {
+		return line{}, fmt.Errorf("%s: %s", where, err)
+	}
+
+	l := line{where: where}
+	for len(tokens) > 0 && strings.HasSuffix(tokens[0], ":") && !isString(tokens[0]) {
+		label := strings.TrimSuffix(tokens[0], ":")
+		if !isIdentifier(strings.Replace(label, "@", "", -1)) {
+			return line{}, fmt.Errorf("%s: invalid label %q", where, label)
+		}
+		l.labels = append(l.labels, label)
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) > 0 {
+		l.mnemonic = strings.ToLower(tokens[0])
+		l.args = tokens[1:]
+	}
+	return l, nil
+}
+
+// read adds the lines of src read from file (empty for the main source) to the program, its includes are relative to
+// dir
+func (r *reader) read(src, file, dir string) error {
+	for i, raw := range strings.Split(src, "\n") {
+		where := fmt.Sprintf("line %d", i+1)
+		if file != "" {
+			where = fmt.Sprintf("%s line %d", file, i+1)
+		}
+
+		l, err := parse(raw, where)
+		if err != nil {
+			return err
+		}
+		if err := r.add(l, dir, 0); err != nil {
+			return err
+		}
+	}
+
+	if m := r.defining; m != nil {
+		r.defining = nil
+		return fmt.Errorf("%s: macro %s has no .endm", m.where, m.name)
+	}
+	return nil
+}
+
+// add adds a line to the program, or to the macro being defined, running its directive. depth is the number of macros
+// being expanded.
+func (r *reader) add(l line, dir string, depth int) error {
+	if r.defining != nil {
+		switch l.mnemonic {
+		case ".endm":
+			if len(l.labels) > 0 {
+				r.defining.body = append(r.defining.body, line{where: l.where, labels: l.labels})
+			}
+			r.macros[r.defining.name], r.defining = r.defining, nil
+		case ".macro":
+			return fmt.Errorf("%s: .macro inside macro %s", l.where, r.defining.name)
+		default:
+			r.defining.body = append(r.defining.body, l)
+		}
+		return nil
+	}
+
+	for _, label := range l.labels {
+		if strings.Contains(label, "@") {
+			return fmt.Errorf("%s: label %q outside of a macro", l.where, label)
+		}
+	}
+	switch l.mnemonic {
+	case ".macro", ".equ", ".include", ".global", ".extern":
+		if len(l.labels) > 0 {
+			return fmt.Errorf("%s: %s can't be labelled", l.where, l.mnemonic)
+		}
+	}
+
+	switch l.mnemonic {
+	case ".include":
+		return r.include(l, dir)
+
+	case ".equ":
+		if len(l.args) != 2 || !isIdentifier(l.args[0]) {
+			return fmt.Errorf("%s: should be .equ <name> <value>", l.where)
+		}
+		if _, exists := r.constants[l.args[0]]; exists || r.externs[l.args[0]] {
+			return fmt.Errorf("%s: constant %q already defined", l.where, l.args[0])
+		}
+		v, err := operand(l.args[1], r.constants)
+		if err != nil {
+			return fmt.Errorf("%s: %s", l.where, err)
+		}
+		r.constants[l.args[0]] = v
+		return nil
+
+	case ".global", ".extern":
+		if len(l.args) == 0 {
+			return fmt.Errorf("%s: should be %s <label>...", l.where, l.mnemonic)
+		}
+		for _, name := range l.args {
+			switch _, constant := r.constants[name]; {
+			case !isIdentifier(name):
+				return fmt.Errorf("%s: invalid label %q", l.where, name)
+			case constant:
+				return fmt.Errorf("%s: label %q is already a constant", l.where, name)
+			case l.mnemonic == ".global":
+				r.globals = append(r.globals, line{where: l.where, mnemonic: l.mnemonic, args: []string{name}})
+			default:
+				r.externs[name] = true
+			}
+		}
+		return nil
+
+	case ".macro":
+		return r.define(l)
+
+	case ".endm":
+		return fmt.Errorf("%s: .endm without .macro", l.where)
+
+	case "print":
+		if _, ok := isa.LookupName(l.mnemonic); !ok {
+			return r.print(l)
+		}
+	}
+
+	if m, ok := r.macros[l.mnemonic]; ok {
+		return r.expand(m, l, dir, depth)
+	}
+	r.lines = append(r.lines, l)
+	return nil
+}
+
+// include reads the file of an .include line
+func (r *reader) include(l line, dir string) error {
+	if len(l.args) != 1 || !isString(l.args[0]) {
+		return fmt.Errorf("%s: should be .include \"<file>\"", l.where)
+	}
+	name, err := strconv.Unquote(l.args[0])
+	if err != nil {
+		return fmt.Errorf("%s: invalid string %s", l.where, l.args[0])
+	}
+
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, name)
+	}
+	for _, p := range r.including {
+		if p == path {
+			return fmt.Errorf("%s: %s includes itself", l.where, name)
+		}
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %s", l.where, err)
+	}
+
+	r.including = append(r.including, path)
+	defer func() { r.including = r.including[:len(r.including)-1] }()
+	return r.read(string(src), path, filepath.Dir(path))
+}
+
+// define starts the definition of the macro of a .macro line, its body is read until .endm
+func (r *reader) define(l line) error {
+	if len(l.args) == 0 || !isIdentifier(l.args[0]) {
+		return fmt.Errorf("%s: should be .macro <name> [<param>...]", l.where)
+	}
+
+	name := strings.ToLower(l.args[0])
+	if _, ok := isa.LookupName(name); ok || name == "print" {
+		return fmt.Errorf("%s: %s is an operation", l.where, name)
+	}
+	if _, exists := r.macros[name]; exists {
+		return fmt.Errorf("%s: macro %s already defined", l.where, name)
+	}
+	for _, p := range l.args[1:] {
+		if !isIdentifier(p) {
+			return fmt.Errorf("%s: invalid parameter %q", l.where, p)
+		}
+	}
+
+	r.defining = &macro{where: l.where, name: name, params: l.args[1:]}
+	return nil
+}
+
+// expand adds the body of macro m invoked by l, with the arguments of l
+func (r *reader) expand(m *macro, l line, dir string, depth int) error {
+	if len(l.args) != len(m.params) {
+		return fmt.Errorf("%s: macro %s expects %d arguments, got %d", l.where, m.name, len(m.params), len(l.args))
+	}
+	if depth == maxExpansionDepth {
+		return fmt.Errorf("%s: macro %s expands itself", l.where, m.name)
+	}
+
+	args := map[string]string{}
+	for i, p := range m.params {
+		args[p] = l.args[i]
+	}
+	r.expansions++
+	suffix := fmt.Sprintf(".%d", r.expansions)
+	substitute := func(token string) string {
+		if v, ok := args[token]; ok {
+			return v
+		}
+		if isString(token) || strings.HasPrefix(token, "'") {
+			return token
+		}
+		return strings.Replace(token, "@", suffix, -1)
+	}
+
+	// The labels of the invocation name the first address of the expansion
+	if len(l.labels) > 0 {
+		r.lines = append(r.lines, line{where: l.where, labels: l.labels})
+	}
+	for _, b := range m.body {
+		expanded := line{where: l.where, mnemonic: strings.ToLower(substitute(b.mnemonic))}
+		for _, label := range b.labels {
+			expanded.labels = append(expanded.labels, substitute(label))
+		}
+		for _, arg := range b.args {
+			expanded.args = append(expanded.args, substitute(arg))
+		}
+		if err := r.add(expanded, dir, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print adds the out instructions of the built-in macro print: one per character of its strings and one per other
+// operand
+func (r *reader) print(l line) error {
+	if len(l.args) == 0 {
+		return fmt.Errorf("%s: should be print <string or operand>...", l.where)
+	}
+
+	out := []line{{where: l.where, labels: l.labels}}
+	for _, arg := range l.args {
+		if !isString(arg) {
+			out = append(out, line{where: l.where, mnemonic: "out", args: []string{arg}})
+			continue
+		}
+
+		s, err := strconv.Unquote(arg)
+		if err != nil {
+			return fmt.Errorf("%s: invalid string %s", l.where, arg)
+		}
+		for _, c := range s {
+			out = append(out, line{where: l.where, mnemonic: "out", args: []string{strconv.Itoa(int(c))}})
+		}
+	}
+
+	r.lines = append(r.lines, out...)
+	return nil
+}
+
+// tokenize splits a line on spaces and commas, keeping quoted strings and characters intact and dropping comments
+func tokenize(raw string) ([]string, error) {
+	tokens := []string{}
+	current := ""
+
+	flush := func() {
+		if current != "" {
+			tokens = append(tokens, current)
+			current = ""
+		}
+	}
+
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch c {
+		case '"', '\'':
+			// Read until the matching quote, skipping escaped characters
+			end := i + 1
+			for ; end < len(raw) && raw[end] != c; end++ {
+				if raw[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(raw) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			current += raw[i : end+1]
+			i = end
+		case ';', '#':
+			flush()
+			return tokens, nil
+		case ',', ' ', '\t', '\r':
+			flush()
+		default:
+			current += string(c)
+		}
+	}
+	flush()
+
+	return tokens, nil
+}
+
+// operand converts an argument to its 16-bits value
+func operand(arg string, labels map[string]uint16) (uint16, error) {
+	// Registers
+	if len(arg) == 2 && (arg[0] == 'R' || arg[0] == 'r') && arg[1] >= '0' && arg[1] <= '7' {
+		return vm.M + uint16(arg[1]-'0'), nil
+	}
+
+	// Characters
+	if strings.HasPrefix(arg, "'") {
+		s, err := strconv.Unquote(arg)
+		if err != nil || len([]rune(s)) != 1 {
+			return 0, fmt.Errorf("invalid character %s", arg)
+		}
+		return uint16([]rune(s)[0]), nil
+	}
+
+	// Labels
+	if v, ok := labels[arg]; ok {
+		return v, nil
+	}
+
+	v, err := strconv.ParseUint(arg, 0, 16)
+	if err != nil {
+		if isIdentifier(arg) {
+			return 0, fmt.Errorf("undefined label %q", arg)
+		}
+		return 0, fmt.Errorf("invalid operand %q", arg)
+	}
+
+	if v >= vm.M {
+		return 0, fmt.Errorf("literal %d is out of range, use R0 to R7 for registers", v)
+	}
+
+	return uint16(v), nil
+}
+
+// isString returns true if the token is a string literal
+func isString(token string) bool {
+	return strings.HasPrefix(token, `"`)
+}
+
+// isIdentifier returns true if s can be used as a label
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !(r == '_' || r == '.' || unicode.IsLetter(r) || (i > 0 && unicode.IsDigit(r))) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package asm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// output runs a binary until it halts and returns what it wrote
+func output(t *testing.T, bin []uint16) string {
+	t.Helper()
+	out := &bytes.Buffer{}
+	if reason, err := vm.New(bin, bytes.NewReader(nil), out).Run(); reason != vm.ExitHalt {
+		t.Fatalf("stopped with %s (%v) instead of halting", reason, err)
+	}
+	return out.String()
+}
+
+func TestDirectives(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, want string
+	}{
+		{"equ", ".equ newline 10\n.equ char 'a'\nout char\nout newline\nhalt", "a\n"},
+		{"equ of a register", ".equ counter R1\nset counter 'b'\nout R1\nhalt", "b"},
+		{"equ of a constant", ".equ a 'c'\n.equ b a\nout b\nhalt", "c"},
+		{"macro", ".macro putc c\nout c\n.endm\nputc 'x'\nputc 'y'\nhalt", "xy"},
+		{
+			"macro labels",
+			".macro times n c\nset R0 n\nloop@: out c\nadd R0 R0 32767\njt R0 loop@\n.endm\ntimes 3 'a'\ntimes 2 'b'\nhalt",
+			"aaabb",
+		},
+		{"macro expanding a macro", ".macro putc c\nout c\n.endm\n.macro twice c\nputc c\nputc c\n.endm\ntwice 'z'\nhalt", "zz"},
+		{"labelled macro", "jmp start\n.macro putc c\nout c\n.endm\nstart: putc 'k'\nhalt", "k"},
+		{"print", "set R2 '!'\nprint \"Hi \", 'o', R2, \"\\n\"\nhalt", "Hi o!\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bin, err := Assemble(tc.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := output(t, bin); got != tc.want {
+				t.Errorf("wrote %q instead of %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDirectiveErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, err string
+	}{
+		{"equ redefined", ".equ a 1\n.equ a 2", `constant "a" already defined`},
+		{"equ of a label", ".equ a later\nlater: halt", `undefined label "later"`},
+		{"label named like a constant", ".equ a 1\na: halt", `label "a" is already a constant`},
+		{"macro without endm", ".macro putc c\nout c", "macro putc has no .endm"},
+		{"endm without macro", ".endm", ".endm without .macro"},
+		{"macro arguments", ".macro putc c\nout c\n.endm\nputc 1 2", "macro putc expects 1 arguments, got 2"},
+		{"recursive macro", ".macro loop\nloop\n.endm\nloop", "macro loop expands itself"},
+		{"macro named like an operation", ".macro out c\n.endm", "out is an operation"},
+		{"unique label outside of a macro", "a@: halt", `label "a@" outside of a macro`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Assemble(tc.src)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error %v instead of %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.asm":     ".include \"lib/putc.asm\"\nputc 'i'\nputc newline\nhalt",
+		"lib/putc.asm": ".include \"newline.asm\"\n.macro putc c\nout c\n.endm",
+		// Relative to the including file
+		"lib/newline.asm": ".equ newline 10",
+		"loop.asm":        ".include \"loop.asm\"",
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bin, err := AssembleFile(filepath.Join(dir, "main.asm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := output(t, bin); got != "i\n" {
+		t.Errorf("wrote %q instead of %q", got, "i\n")
+	}
+
+	if _, err := AssembleFile(filepath.Join(dir, "loop.asm")); err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("error %v for a file including itself", err)
+	}
+}
@@ -0,0 +1,114 @@
+package asm
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// objectVersion is the version of the Object format, bump it when the Object type changes
+const objectVersion = 1
+
+// Object is a relocatable section of a program: its code is compiled as if it started at address 0 and Link moves it
+// after the objects before it, resolving the labels it uses from the other objects
+type Object struct {
+	Version     int
+	Name        string            // Path of the source, for the errors of Link
+	Code        []uint16          // Words of the section
+	Globals     map[string]uint16 // Labels exported by .global, by their address in Code
+	Relocations []uint16          // Offsets in Code of the words holding an address of Code, moved with it
+	Externals   []Reference       // Words of Code holding the address of a label of another object
+}
+
+// Reference is a word of the code of an object holding the address of a label declared by .extern
+type Reference struct {
+	Offset uint16
+	Label  string
+}
+
+// Link places the objects one after the other from address 0, so that the execution starts with the first one, and
+// returns the binary where their addresses are moved and their external labels resolved
+func Link(objects []*Object) ([]uint16, error) {
+	bases := make([]uint16, len(objects))
+	globals, owners := map[string]uint16{}, map[string]string{}
+	size := 0
+	for i, o := range objects {
+		bases[i] = uint16(size)
+		size += len(o.Code)
+		if size > vm.M {
+			return nil, fmt.Errorf("%s: program does not fit in memory", objectName(o, i))
+		}
+
+		for label, addr := range o.Globals {
+			if owner, exists := owners[label]; exists {
+				return nil, fmt.Errorf("%s: label %q is already exported by %s", objectName(o, i), label, owner)
+			}
+			globals[label], owners[label] = bases[i]+addr, objectName(o, i)
+		}
+	}
+
+	bin := make([]uint16, 0, size)
+	for i, o := range objects {
+		code := append([]uint16{}, o.Code...)
+		for _, offset := range o.Relocations {
+			if int(offset) >= len(code) {
+				return nil, fmt.Errorf("%s: relocation at %d out of the code", objectName(o, i), offset)
+			}
+			code[offset] += bases[i]
+		}
+		for _, ref := range o.Externals {
+			addr, ok := globals[ref.Label]
+			if !ok {
+				return nil, fmt.Errorf("%s: label %q isn't exported by any object", objectName(o, i), ref.Label)
+			}
+			if int(ref.Offset) >= len(code) {
+				return nil, fmt.Errorf("%s: reference to %q at %d out of the code", objectName(o, i), ref.Label, ref.Offset)
+			}
+			code[ref.Offset] = addr
+		}
+		bin = append(bin, code...)
+	}
+	return bin, nil
+}
+
+// objectName names the object i of Link in its errors
+func objectName(o *Object, i int) string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return fmt.Sprintf("object %d", i+1)
+}
+
+// Save writes the object to the given file
+func (o *Object) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(f).Encode(o); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadObject reads an object from the given file
+func LoadObject(path string) (*Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	o := &Object{}
+	if err := gob.NewDecoder(f).Decode(o); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if o.Version != objectVersion {
+		return nil, fmt.Errorf("%s: unsupported object version %d (expected %d)", path, o.Version, objectVersion)
+	}
+	return o, nil
+}
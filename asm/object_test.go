@@ -0,0 +1,102 @@
+package asm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compileAll compiles each source into an object
+func compileAll(t *testing.T, srcs ...string) []*Object {
+	t.Helper()
+	objects := []*Object{}
+	for _, src := range srcs {
+		o, err := Compile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, o)
+	}
+	return objects
+}
+
+func TestLink(t *testing.T) {
+	objects := compileAll(t,
+		".extern puts\n.global msg\nmain: set R0 msg\ncall puts\nhalt\nmsg: .data \"linked\\n\", 0",
+		// The loop and its jumps are moved after the first object
+		".global puts\nputs: rmem R1 R0\njf R1 done\nout R1\nadd R0 R0 1\njmp puts\ndone: ret",
+	)
+
+	bin, err := Link(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := output(t, bin); got != "linked\n" {
+		t.Errorf("wrote %q instead of %q", got, "linked\n")
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		srcs []string
+		err  string
+	}{
+		{"undefined extern", []string{".extern puts\ncall puts\nhalt"}, `label "puts" isn't exported by any object`},
+		{"exported twice", []string{".global f\nf: ret", ".global f\nf: ret"}, `label "f" is already exported by object 1`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Link(compileAll(t, tc.srcs...))
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error %v instead of %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, src, err string
+	}{
+		{"global not a label", ".global f\nhalt", `.global "f" is not a label`},
+		{"extern defined", ".extern f\nf: ret", `label "f" is declared .extern`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.src)
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("error %v instead of %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestAssembleExtern(t *testing.T) {
+	// Compiling alone is fine, assembling a whole program isn't
+	_, err := Assemble(".extern f\ncall f")
+	if want := `undefined label "f" declared .extern`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v instead of %q", err, want)
+	}
+}
+
+func TestObjectSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	o := compileAll(t, ".global f\nf: jmp f")[0]
+	path := filepath.Join(dir, "f.obj")
+	if err := o.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadObject(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Globals["f"] != 0 || len(loaded.Relocations) != 1 || len(loaded.Code) != 2 {
+		t.Errorf("loaded %+v instead of %+v", loaded, o)
+	}
+}
@@ -0,0 +1,252 @@
+// Package autoplay completes the adventure without any input: it looks for the items and the places it needs by
+// exploring the rooms on clones of the VM, like the mapper, and uses the solvers of the puzzles package for the coins,
+// the teleporter and the vault. The commands it played can be given back to the VM with -input.
+package autoplay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sfluor/synacor/puzzles"
+	"github.com/sfluor/synacor/versions"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Result is what the player did and found
+type Result struct {
+	Commands []string // Commands played, debugger commands included
+	Codes    []string // Codes printed, in order
+	Final    string   // Last code read in the mirror, see Mirror
+}
+
+// player plays commands on the VM and remembers them
+type player struct {
+	machine  *vm.VM
+	room     uint16 // Where the binary stores the current room, different for every room including the ones that look alike
+	out      io.Writer
+	commands []string
+}
+
+// Play completes the adventure on bin and writes the output of the game to out. The confirmation of the teleporter is
+// replaced by its native implementation (see puzzles.ReplaceConfirmation) so the commands must be played again with
+// it, e.g. with -native-confirmation.
+func Play(bin []uint16, out io.Writer) (*Result, error) {
+	v, err := versions.Detect(bin)
+	if err != nil {
+		return nil, err
+	}
+	if v.Room == 0 {
+		return nil, fmt.Errorf("the address of the current room of the %s is unknown", v.Name)
+	}
+
+	machine := vm.New(append([]uint16{}, bin...), bytes.NewReader(nil), out)
+	if err := puzzles.ReplaceConfirmation(machine); err != nil {
+		return nil, err
+	}
+	if _, err := machine.Run(); err != nil {
+		return nil, err
+	}
+
+	p := &player{machine: machine, room: v.Room, out: out}
+	if err := p.complete(); err != nil {
+		return nil, err
+	}
+
+	codes := machine.Codes()
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("no code was found")
+	}
+	return &Result{Commands: p.commands, Codes: codes, Final: Mirror(codes[len(codes)-1])}, nil
+}
+
+// complete plays every step of the adventure
+func (p *player) complete() error {
+	steps := []func() error{
+		// The tablet has the first code of the game
+		p.take("tablet"), p.play("use tablet"),
+
+		// The caves are dark, the lantern needs the oil of the can
+		p.take("empty lantern"), p.take("can"), p.play("use can"), p.play("use lantern"),
+
+		// The coins open the door of the ruins once placed on the monument in the right order
+		p.take("red coin"), p.take("corroded coin"), p.take("shiny coin"), p.take("concave coin"), p.take("blue coin"),
+		p.goTo("the monument", func(s vm.GameState) bool { return strings.Contains(s.Description, "monument") }),
+		p.placeCoins,
+
+		// The first use of the teleporter goes to the headquarters, the next one needs the eighth register
+		p.take("teleporter"), p.play("use teleporter"), p.setTeleporter, p.play("use teleporter"),
+
+		// The orb has to reach the vault door with the right weight
+		p.take("orb"), p.walkVault, p.play("vault"), p.take("mirror"), p.play("use mirror"),
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// play returns a step playing cmd
+func (p *player) play(cmd string) func() error {
+	return func() error {
+		return p.send(cmd)
+	}
+}
+
+// send plays a command on the VM, an error is returned if the game stops
+func (p *player) send(cmd string) error {
+	fmt.Fprintln(p.out, cmd)
+	p.commands = append(p.commands, cmd)
+
+	output, alive, err := run(p.machine, cmd, p.out)
+	if err != nil {
+		return fmt.Errorf("%s: %w", cmd, err)
+	}
+	if !alive {
+		return fmt.Errorf("the game stopped after %q:\n%s", cmd, output)
+	}
+	return nil
+}
+
+// take returns a step going to the room of item and taking it
+func (p *player) take(item string) func() error {
+	return func() error {
+		err := p.goTo(item, func(s vm.GameState) bool { return contains(s.Things, item) })()
+		if err != nil {
+			return err
+		}
+		if err := p.send("take " + item); err != nil {
+			return err
+		}
+		if !contains(p.machine.GameState().Inventory, item) {
+			return fmt.Errorf("could not take the %s", item)
+		}
+		return nil
+	}
+}
+
+// goTo returns a step walking to the closest room satisfying goal. The rooms are explored in breadth-first order on
+// clones of the VM, the exits killing the player are dead ends.
+func (p *player) goTo(name string, goal func(vm.GameState) bool) func() error {
+	return func() error {
+		if goal(p.machine.GameState()) {
+			return nil
+		}
+
+		type node struct {
+			machine *vm.VM
+			path    []string
+		}
+		queue := []node{{p.machine, nil}}
+		seen := map[uint16]bool{p.machine.Memory(p.room): true}
+
+		for len(queue) != 0 {
+			current := queue[0]
+			queue = queue[1:]
+
+			for _, exit := range current.machine.GameState().Exits {
+				next := current.machine.Clone()
+				if _, alive, err := run(next, exit, ioutil.Discard); err != nil || !alive {
+					continue
+				}
+
+				room := next.Memory(p.room)
+				if seen[room] {
+					continue
+				}
+				seen[room] = true
+
+				path := append(append([]string{}, current.path...), exit)
+				if goal(next.GameState()) {
+					for _, cmd := range path {
+						if err := p.send(cmd); err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+				queue = append(queue, node{next, path})
+			}
+		}
+
+		return fmt.Errorf("no room with %s can be reached from %s", name, p.machine.GameState().Room)
+	}
+}
+
+// placeCoins uses the coins in the order satisfying the equation of the monument
+func (p *player) placeCoins() error {
+	coins, ok := puzzles.SolveCoins()
+	if !ok {
+		return fmt.Errorf("no coin order satisfies the equation")
+	}
+	for _, c := range coins {
+		if err := p.send("use " + c.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTeleporter sets the eighth register to the value passing the confirmation of the teleporter
+func (p *player) setTeleporter() error {
+	r7, ok := puzzles.SolveTeleporter()
+	if !ok {
+		return fmt.Errorf("no R7 value satisfies the confirmation")
+	}
+	return p.send(fmt.Sprintf("%ssetreg R7 %d", p.machine.CommandPrefix(), r7))
+}
+
+// walkVault leads the orb from its pedestal to the vault door
+func (p *player) walkVault() error {
+	moves, ok := puzzles.SolveVault()
+	if !ok {
+		return fmt.Errorf("no path leads the orb to the vault door")
+	}
+	for _, move := range moves {
+		if err := p.send(move); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run sends a command to the VM and returns its output, alive is false if the program stopped
+func run(machine *vm.VM, cmd string, out io.Writer) (output string, alive bool, err error) {
+	buf := &bytes.Buffer{}
+	machine.SetInput(strings.NewReader(cmd + "\n"))
+	machine.SetOutput(io.MultiWriter(buf, out))
+
+	reason, err := machine.Run()
+	if err != nil {
+		return "", false, err
+	}
+	return buf.String(), reason == vm.ExitInputEOF, nil
+}
+
+// Mirror returns a code as read in a mirror: reversed, with the letters that are the mirror of each other swapped
+func Mirror(code string) string {
+	swapped := map[rune]rune{'b': 'd', 'd': 'b', 'p': 'q', 'q': 'p'}
+	runes := []rune(code)
+	mirrored := make([]rune, len(runes))
+	for i, r := range runes {
+		if s, ok := swapped[r]; ok {
+			r = s
+		}
+		mirrored[len(runes)-1-i] = r
+	}
+	return string(mirrored)
+}
+
+func contains(list []string, item string) bool {
+	for _, v := range list {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
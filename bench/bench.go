@@ -0,0 +1,216 @@
+// Package bench measures the speed of the interpreter, so that a change of the dispatch loop can be measured before
+// being merged. go test -bench runs its benchmarks, the bench subcommand runs them with Measure and reports the
+// instructions per second.
+package bench
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sfluor/synacor/asm"
+	"github.com/sfluor/synacor/vm"
+)
+
+// selfTestDone is the line printed once the self-test of the challenge passed
+const selfTestDone = "self-test complete, all tests pass"
+
+// benchTime is how long Measure runs a benchmark, like the default of go test -benchtime
+const benchTime = time.Second
+
+// maxOps is the number of operations Measure runs at most
+const maxOps = 1e9
+
+// Ops runs n operations of a benchmark
+type Ops func(n int) error
+
+// Benchmark is a benchmark of the interpreter, each of its operations executes Instructions instructions (none for
+// the clones and the snapshots of forkBenchmarks)
+type Benchmark struct {
+	Name         string
+	Instructions uint64
+	Setup        func() (Ops, error) // Prepares the operations, it isn't measured
+}
+
+// Benchmarks returns the benchmarks, the self-test one runs bin (the challenge binary). With decodeCache the VMs use
+// their decode cache, see vm.EnableDecodeCache.
+func Benchmarks(bin []uint16, decodeCache bool) ([]Benchmark, error) {
+	selfTest, err := SelfTestInstructions(bin)
+	if err != nil {
+		return nil, err
+	}
+
+	benchmarks := []Benchmark{
+		{"ADD", 1, Loop(addLoop, decodeCache)},
+		{"Dispatch", 1, Loop(dispatchLoop, decodeCache)},
+		{"FullSelfTest", selfTest, FullSelfTest(bin, selfTest, decodeCache, false)},
+		{"FullSelfTestCOW", selfTest, FullSelfTest(bin, selfTest, decodeCache, true)},
+	}
+	return append(benchmarks, forkBenchmarks(bin, selfTest)...), nil
+}
+
+// addLoop only adds, except for the jump closing the loop
+const addLoop = `loop: add R0 R0 1
+add R1 R1 R0
+add R2 R2 32767
+add R3 R1 R2
+add R0 R0 1
+add R1 R1 R0
+add R2 R2 32767
+add R3 R1 R2
+jmp loop
+`
+
+// dispatchLoop runs every operation except halt, in and out, so that the cost of the dispatch dominates
+const dispatchLoop = `jmp loop
+.data 0
+loop: set R0 1
+add R1 R1 R0
+mult R2 R1 3
+mod R3 R2 7
+and R4 R3 R1
+or R5 R4 R2
+not R6 R5
+eq R7 R6 R5
+gt R7 R6 R5
+push R1
+pop R1
+wmem 2 R1
+rmem R0 2
+noop
+call f
+jt R0 loop
+jf R0 loop
+f: ret
+`
+
+// Loop executes one instruction of a program that never stops per operation, e.g. addLoop or dispatchLoop
+func Loop(src string, decodeCache bool) func() (Ops, error) {
+	return func() (Ops, error) {
+		bin, err := asm.Assemble(src)
+		if err != nil {
+			return nil, err
+		}
+		machine := newVM(bin, decodeCache)
+
+		return func(n int) error {
+			if reason, err := machine.RunFor(uint64(n)); reason != vm.ExitBudget {
+				return fmt.Errorf("the loop stopped: %s %v", reason, err)
+			}
+			return nil
+		}, nil
+	}
+}
+
+// FullSelfTest runs the self-test of bin, which lasts n instructions, once per operation on a new VM. Its memory is
+// copy-on-write (see vm.SetCopyOnWrite) with cow.
+func FullSelfTest(bin []uint16, n uint64, decodeCache, cow bool) func() (Ops, error) {
+	return func() (Ops, error) {
+		return func(ops int) error {
+			for i := 0; i < ops; i++ {
+				machine := newVM(append([]uint16{}, bin...), decodeCache)
+				machine.SetCopyOnWrite(cow)
+				if reason, err := machine.RunFor(n); reason != vm.ExitBudget {
+					return fmt.Errorf("the self-test stopped: %s %v", reason, err)
+				}
+			}
+			return nil
+		}, nil
+	}
+}
+
+// SelfTestInstructions returns the number of instructions executed by bin until its self-test passed
+func SelfTestInstructions(bin []uint16) (uint64, error) {
+	machine := vm.New(append([]uint16{}, bin...), strings.NewReader(""), ioutil.Discard)
+
+	line, done := []byte{}, false
+	machine.SubscribeOutput(func(c byte) {
+		if c != '\n' {
+			line = append(line, c)
+			return
+		}
+		done = done || string(line) == selfTestDone
+		line = line[:0]
+	})
+
+	for !done {
+		if err := machine.Step(); err != nil {
+			return 0, fmt.Errorf("the binary stopped before passing its self-test: %w", err)
+		}
+	}
+	return machine.Instructions(), nil
+}
+
+// newVM creates a VM without input running bin
+func newVM(bin []uint16, decodeCache bool) *vm.VM {
+	machine := vm.New(bin, strings.NewReader(""), ioutil.Discard)
+	if decodeCache {
+		machine.EnableDecodeCache()
+	}
+	return machine
+}
+
+// Result is the measure of a benchmark by Measure
+type Result struct {
+	N      int           // Operations run
+	T      time.Duration // Time they took
+	Bytes  uint64        // Bytes allocated by them
+	Allocs uint64        // Allocations made by them
+}
+
+// NsPerOp returns the time of an operation in nanoseconds
+func (r Result) NsPerOp() int64 {
+	return r.T.Nanoseconds() / int64(r.N)
+}
+
+// BytesPerOp returns the bytes allocated by an operation
+func (r Result) BytesPerOp() uint64 {
+	return r.Bytes / uint64(r.N)
+}
+
+// AllocsPerOp returns the allocations made by an operation
+func (r Result) AllocsPerOp() uint64 {
+	return r.Allocs / uint64(r.N)
+}
+
+// Measure runs the operations of a benchmark, more of them each time until they last about a second like go test
+// -bench does, and returns the measure of the last run
+func Measure(b Benchmark) (Result, error) {
+	ops, err := b.Setup()
+	if err != nil {
+		return Result{}, err
+	}
+
+	for n := 1; ; {
+		res, err := measure(ops, n)
+		if err != nil || res.T >= benchTime || n >= maxOps {
+			return res, err
+		}
+
+		// Aim a bit above benchTime from the speed measured, without growing too fast on a noisy measure
+		next := int(1.2 * float64(n) * float64(benchTime) / float64(res.T+1))
+		if next > 100*n {
+			next = 100 * n
+		}
+		if next <= n {
+			next = n + 1
+		}
+		n = next
+	}
+}
+
+// measure runs n operations and returns their time and allocations
+func measure(ops Ops, n int) (Result, error) {
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	start := time.Now()
+	err := ops(n)
+	t := time.Since(start)
+
+	runtime.ReadMemStats(&after)
+	return Result{N: n, T: t, Bytes: after.TotalAlloc - before.TotalAlloc, Allocs: after.Mallocs - before.Mallocs}, err
+}
@@ -0,0 +1,101 @@
+package bench
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/sfluor/synacor/loader"
+)
+
+var (
+	challengeOnce sync.Once
+	challengeBin  []uint16
+	selfTest      uint64 // Instructions executed by the self-test of challengeBin
+	challengeErr  error
+)
+
+// challenge returns the embedded challenge binary and the number of instructions of its self-test
+func challenge(b *testing.B) ([]uint16, uint64) {
+	challengeOnce.Do(func() {
+		if challengeBin, challengeErr = loader.LoadEmbedded(); challengeErr == nil {
+			selfTest, challengeErr = SelfTestInstructions(challengeBin)
+		}
+	})
+	if challengeErr != nil {
+		b.Fatal(challengeErr)
+	}
+	return challengeBin, selfTest
+}
+
+// run runs b.N operations of a benchmark, after its setup
+func run(b *testing.B, setup func() (Ops, error)) {
+	ops, err := setup()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	if err := ops(b.N); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkADD(b *testing.B) {
+	run(b, Loop(addLoop, false))
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	run(b, Loop(dispatchLoop, false))
+}
+
+func BenchmarkDispatchDecodeCache(b *testing.B) {
+	run(b, Loop(dispatchLoop, true))
+}
+
+func BenchmarkFullSelfTest(b *testing.B) {
+	bin, n := challenge(b)
+	run(b, FullSelfTest(bin, n, false, false))
+}
+
+func BenchmarkFullSelfTestCOW(b *testing.B) {
+	bin, n := challenge(b)
+	run(b, FullSelfTest(bin, n, false, true))
+}
+
+// The clones, snapshots and forks execute few instructions, their allocations tell the memory the copy-on-write saves
+
+func BenchmarkCloneDeepCopy(b *testing.B) {
+	bin, n := challenge(b)
+	b.ReportAllocs()
+	run(b, Clone(bin, n, false))
+}
+
+func BenchmarkCloneCOW(b *testing.B) {
+	bin, n := challenge(b)
+	b.ReportAllocs()
+	run(b, Clone(bin, n, true))
+}
+
+func BenchmarkSnapshotDeepCopy(b *testing.B) {
+	bin, n := challenge(b)
+	b.ReportAllocs()
+	run(b, Snapshot(bin, n, false))
+}
+
+func BenchmarkSnapshotCOW(b *testing.B) {
+	bin, n := challenge(b)
+	b.ReportAllocs()
+	run(b, Snapshot(bin, n, true))
+}
+
+func BenchmarkForkDeepCopy(b *testing.B) {
+	bin, n := challenge(b)
+	b.ReportAllocs()
+	run(b, Fork(bin, n, false))
+}
+
+func BenchmarkForkCOW(b *testing.B) {
+	bin, n := challenge(b)
+	b.ReportAllocs()
+	run(b, Fork(bin, n, true))
+}
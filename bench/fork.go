@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// forkInstructions is the number of instructions executed by each fork of Fork, enough to write to a few pages
+const forkInstructions = 10000
+
+// forkBenchmarks returns the benchmarks of the clones and the snapshots of a VM that passed the self-test of bin
+// (lasting n instructions), with a deep copy of its memory and with a copy-on-write one: they execute no instruction
+// but their allocations per operation tell the memory saved
+func forkBenchmarks(bin []uint16, n uint64) []Benchmark {
+	benchmarks := []Benchmark{}
+	for _, cow := range []bool{false, true} {
+		suffix := "DeepCopy"
+		if cow {
+			suffix = "COW"
+		}
+		benchmarks = append(benchmarks,
+			Benchmark{"Clone" + suffix, 0, Clone(bin, n, cow)},
+			Benchmark{"Snapshot" + suffix, 0, Snapshot(bin, n, cow)},
+			Benchmark{"Fork" + suffix, 0, Fork(bin, n, cow)},
+		)
+	}
+	return benchmarks
+}
+
+// Clone clones the VM once per operation, its memory is a vm.COWMemory with cow and a vm.FlatMemory otherwise
+func Clone(bin []uint16, n uint64, cow bool) func() (Ops, error) {
+	return func() (Ops, error) {
+		machine, err := forkBase(bin, n, cow)
+		if err != nil {
+			return nil, err
+		}
+		return func(ops int) error {
+			for i := 0; i < ops; i++ {
+				machine.Clone()
+			}
+			return nil
+		}, nil
+	}
+}
+
+// Snapshot takes a snapshot of the VM once per operation, like Clone
+func Snapshot(bin []uint16, n uint64, cow bool) func() (Ops, error) {
+	return func() (Ops, error) {
+		machine, err := forkBase(bin, n, cow)
+		if err != nil {
+			return nil, err
+		}
+		return func(ops int) error {
+			for i := 0; i < ops; i++ {
+				machine.Snapshot()
+			}
+			return nil
+		}, nil
+	}
+}
+
+// Fork clones the VM and runs forkInstructions instructions on the clone once per operation, like the candidates of a
+// search
+func Fork(bin []uint16, n uint64, cow bool) func() (Ops, error) {
+	return func() (Ops, error) {
+		machine, err := forkBase(bin, n, cow)
+		if err != nil {
+			return nil, err
+		}
+		return func(ops int) error {
+			for i := 0; i < ops; i++ {
+				if reason, err := machine.Clone().RunFor(forkInstructions); reason == vm.ExitError {
+					return fmt.Errorf("the fork stopped: %s", err)
+				}
+			}
+			return nil
+		}, nil
+	}
+}
+
+// forkBase returns a VM that passed the self-test of bin, whose memory is copy-on-write with cow
+func forkBase(bin []uint16, n uint64, cow bool) (*vm.VM, error) {
+	machine := newVM(append([]uint16{}, bin...), false)
+	if reason, err := machine.RunFor(n); reason != vm.ExitBudget {
+		return nil, fmt.Errorf("the self-test stopped: %s %v", reason, err)
+	}
+	machine.SetOutput(ioutil.Discard)
+	machine.SetCopyOnWrite(cow)
+	return machine, nil
+}
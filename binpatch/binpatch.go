@@ -0,0 +1,96 @@
+// Package binpatch modifies a binary with declarative patches: each one replaces words at an address once it checked
+// that they are the expected ones, and can be inverted to undo it
+package binpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Patch describes a patch in a patch file:
+//
+//	{"address": 5489, "old": [17, 6027], "new": [21, 21], "comment": "skip the call of the confirmation"}
+//
+// replaces the call at 5489 by two noops. Old and new have the same length.
+type Patch struct {
+	Address uint16   `json:"address"`           // Address of the first word replaced
+	Old     []uint16 `json:"old"`               // Words expected at the address
+	New     []uint16 `json:"new"`               // Words written instead
+	Comment string   `json:"comment,omitempty"` // What the patch does
+}
+
+// Load reads a JSON file containing a list of patches
+func Load(path string) ([]Patch, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	patches := []Patch{}
+	if err := json.Unmarshal(b, &patches); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for _, p := range patches {
+		if len(p.Old) != len(p.New) {
+			return nil, fmt.Errorf("%s: the patch at %d replaces %d words by %d", path, p.Address, len(p.Old), len(p.New))
+		}
+	}
+	return patches, nil
+}
+
+// Save writes the patches to a JSON file, one per line
+func Save(path string, patches []Patch) error {
+	lines := []byte("[\n")
+	for i, p := range patches {
+		b, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "  "...)
+		lines = append(lines, b...)
+		if i < len(patches)-1 {
+			lines = append(lines, ',')
+		}
+		lines = append(lines, '\n')
+	}
+	return ioutil.WriteFile(path, append(lines, "]\n"...), 0644)
+}
+
+// Apply returns a copy of bin with the patches applied in order, bin is left unmodified. It fails if the words found
+// at the address of a patch (after the previous patches) are not its old ones.
+func Apply(bin []uint16, patches []Patch) ([]uint16, error) {
+	patched := append([]uint16{}, bin...)
+
+	for _, p := range patches {
+		if len(p.Old) != len(p.New) {
+			return nil, fmt.Errorf("the patch at %d replaces %d words by %d", p.Address, len(p.Old), len(p.New))
+		}
+		if int(p.Address)+len(p.Old) > len(patched) {
+			return nil, fmt.Errorf("the patch at %d goes past the end of the binary (%d words)", p.Address, len(patched))
+		}
+
+		for i, old := range p.Old {
+			if found := patched[int(p.Address)+i]; found != old {
+				return nil, fmt.Errorf("the patch at %d expects %d at %d, found %d", p.Address, old, int(p.Address)+i, found)
+			}
+		}
+		copy(patched[p.Address:], p.New)
+	}
+
+	return patched, nil
+}
+
+// Invert returns the patches undoing patches: applied to the patched binary they give back the original one
+func Invert(patches []Patch) []Patch {
+	inverse := make([]Patch, len(patches))
+	for i, p := range patches {
+		undo := Patch{Address: p.Address, Old: p.New, New: p.Old}
+		if p.Comment != "" {
+			undo.Comment = "undo: " + p.Comment
+		}
+		inverse[len(patches)-1-i] = undo
+	}
+	return inverse
+}
@@ -0,0 +1,195 @@
+// Package checkpoint saves a snapshot of a VM each time its output matches a rule, like a code being printed or the
+// player being eaten by a grue, so that a long session can be resumed from its milestones without saving by hand
+package checkpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// indexFile is the file of a checkpoint directory listing its checkpoints
+const indexFile = "index.json"
+
+// Rule describes the output triggering a checkpoint, as written in a rules file
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"` // Regular expression matched against each line, its first group (or the whole match) is the label
+	Codes   bool   `json:"codes"`   // Match the challenge codes found by the VM (see vm.VM.Codes) instead of Pattern
+	Once    bool   `json:"once"`    // Trigger only the first time
+
+	re *regexp.Regexp
+}
+
+// Checkpoint describes a saved snapshot, listed in the index of the directory
+type Checkpoint struct {
+	File         string    `json:"file"`  // Snapshot file, relative to the directory
+	Rule         string    `json:"rule"`  // Name of the rule that matched
+	Label        string    `json:"label"` // Text matched by the rule
+	Time         time.Time `json:"time"`
+	Instructions uint64    `json:"instructions"` // Instructions executed before the snapshot
+}
+
+// Watcher follows the output of a VM and saves its checkpoints
+type Watcher struct {
+	rules   []Rule
+	dir     string
+	log     io.Writer
+	line    []byte
+	codes   int          // Codes already seen
+	fired   map[int]bool // Rules with Once that already triggered
+	pending []Checkpoint // Matches of the last line, saved before the next instruction
+	saved   []Checkpoint
+}
+
+// LoadRules reads a rules file: a JSON list of rules
+func LoadRules(path string) ([]Rule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := []Rule{}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return rules, nil
+}
+
+// Watch saves a snapshot of machine to dir each time its output matches one of the rules and writes a line to log
+// (if not nil) for each of them. The snapshot is taken before the instruction following the end of the matching line,
+// loading it doesn't print the line again.
+func Watch(machine *vm.VM, rules []Rule, dir string, log io.Writer) (*Watcher, error) {
+	w := &Watcher{dir: dir, log: log, codes: len(machine.Codes()), fired: map[int]bool{}}
+	for _, r := range rules {
+		if !r.Codes {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %s", r.Name, err)
+			}
+			r.re = re
+		}
+		w.rules = append(w.rules, r)
+	}
+
+	saved, err := readIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	w.saved = saved
+
+	machine.OnOutput(w.feed)
+	machine.OnBeforeInstruction(w.flush)
+	return w, nil
+}
+
+// Checkpoints returns the checkpoints of the directory, the oldest first
+func (w *Watcher) Checkpoints() []Checkpoint {
+	return append([]Checkpoint{}, w.saved...)
+}
+
+// feed matches the rules against each line of output
+func (w *Watcher) feed(machine *vm.VM, c byte) {
+	if c != '\n' {
+		w.line = append(w.line, c)
+		return
+	}
+
+	line := string(w.line)
+	w.line = w.line[:0]
+
+	codes := machine.Codes()
+	for i, r := range w.rules {
+		if r.Once && w.fired[i] {
+			continue
+		}
+
+		labels := []string{}
+		if r.Codes {
+			labels = codes[w.codes:]
+		} else if m := r.re.FindStringSubmatch(line); m != nil {
+			label := m[0]
+			if len(m) > 1 && m[1] != "" {
+				label = m[1]
+			}
+			labels = append(labels, label)
+		}
+
+		for _, label := range labels {
+			w.pending = append(w.pending, Checkpoint{Rule: r.Name, Label: label})
+			w.fired[i] = true
+		}
+	}
+	w.codes = len(codes)
+}
+
+// flush saves the pending checkpoints
+func (w *Watcher) flush(machine *vm.VM) {
+	if len(w.pending) == 0 {
+		return
+	}
+	pending := w.pending
+	w.pending = nil
+
+	for _, c := range pending {
+		if err := w.save(machine, c); err != nil {
+			w.logf("Could not save checkpoint %q: %s\n", c.Label, err)
+		}
+	}
+}
+
+// save writes the snapshot of a checkpoint and adds it to the index
+func (w *Watcher) save(machine *vm.VM, c Checkpoint) error {
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return err
+	}
+
+	c.File = fmt.Sprintf("%03d.snapshot", len(w.saved)+1)
+	c.Time = time.Now()
+	c.Instructions = machine.Instructions()
+	if err := machine.Snapshot().Save(filepath.Join(w.dir, c.File)); err != nil {
+		return err
+	}
+
+	w.saved = append(w.saved, c)
+	data, err := json.MarshalIndent(w.saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(w.dir, indexFile), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+
+	w.logf("Checkpoint %s: %s ($load %s)\n", c.Rule, c.Label, filepath.Join(w.dir, c.File))
+	return nil
+}
+
+func (w *Watcher) logf(format string, args ...interface{}) {
+	if w.log != nil {
+		fmt.Fprintf(w.log, format, args...)
+	}
+}
+
+// readIndex returns the checkpoints listed in the index of dir, none if it doesn't exist yet
+func readIndex(dir string) ([]Checkpoint, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, indexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	saved := []Checkpoint{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath.Join(dir, indexFile), err)
+	}
+	return saved, nil
+}
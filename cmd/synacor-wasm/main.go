@@ -0,0 +1,34 @@
+//go:build js && wasm
+
+// Command synacor-wasm plays the challenge in a browser, without any server: build it with
+// GOOS=js GOARCH=wasm go build -o wasm/synacor.wasm ./cmd/synacor-wasm and open wasm/index.html, see the README.
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/puzzles"
+	"github.com/sfluor/synacor/vm"
+	"github.com/sfluor/synacor/wasm"
+)
+
+func main() {
+	bin, err := loader.LoadEmbedded()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	wasm.Expose("synacor", func(in io.Reader, out io.Writer) *vm.VM {
+		machine := vm.New(append([]uint16{}, bin...), in, out)
+		// The debugger would write to the console of the browser, not to the page
+		machine.SetCommands(false)
+		puzzles.ReplaceConfirmation(machine)
+		return machine
+	})
+
+	// The page calls the functions of synacor until it's closed
+	select {}
+}
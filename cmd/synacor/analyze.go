@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/isa"
+)
+
+// runAnalyze handles the "analyze" subcommand: statistics of the binary to start reversing it without running it
+func runAnalyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the binary (e.g. a region written by extract), the embedded challenge.bin is used by default")
+	window := fs.Int("window", 64, "Size in words of the windows classified as code, text or data")
+	fs.Parse(args)
+
+	if *window <= 0 {
+		fmt.Fprintf(os.Stderr, "Wrong -window %d, should be positive\n", *window)
+		os.Exit(1)
+	}
+
+	bin := loadBinary(*file)
+
+	counts := analysis.OpcodeHistogram(bin)
+	ops, total := []isa.Operation{}, 0
+	for op, n := range counts {
+		ops = append(ops, op)
+		total += n
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if counts[ops[i]] != counts[ops[j]] {
+			return counts[ops[i]] > counts[ops[j]]
+		}
+		return ops[i].Code < ops[j].Code
+	})
+
+	fmt.Printf("Opcodes (%d instructions decoded linearly from %d words):\n", total, len(bin))
+	for _, op := range ops {
+		fmt.Printf("  %-5s %7d %5.1f%%\n", op.Name, counts[op], 100*float64(counts[op])/float64(total))
+	}
+
+	fmt.Println("\nRegions (valid: words decoding as instructions, entropy: bits per byte):")
+	for _, r := range analysis.Regions(bin, *window) {
+		fmt.Printf("  %6d-%-6d %-4s %6d words  valid %5.1f%%  entropy %.2f\n", r.Start, r.End, r.Kind, r.End-r.Start, 100*r.Valid, r.Entropy)
+	}
+
+	entries := analysis.EntryPoints(bin)
+	fmt.Printf("\nCandidate function entry points (%d):\n", len(entries))
+	for _, e := range entries {
+		reason := "after ret"
+		if e.Callers > 0 {
+			reason = fmt.Sprintf("%d calls", e.Callers)
+			if e.Callers == 1 {
+				reason = "1 call"
+			}
+			if e.AfterRet {
+				reason += ", after ret"
+			}
+		}
+		fmt.Printf("  %6d  %s\n", e.Addr, reason)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/autoplay"
+)
+
+// runAutoplay handles the "autoplay" subcommand: it completes the adventure without any input and writes the commands
+// it played, it exits with a non-zero code if it couldn't reach the end
+func runAutoplay(args []string) {
+	fs := flag.NewFlagSet("autoplay", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	out := fs.String("out", "autoplay.record", "Path of the file where the commands played are written, play them again with -native-confirmation -input")
+	verbose := fs.Bool("v", false, "Print the output of the game")
+	fs.Parse(args)
+
+	var output io.Writer = ioutil.Discard
+	if *verbose {
+		output = os.Stdout
+	}
+
+	res, err := autoplay.Play(loadBinary(*file), output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Autoplay failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(*out, []byte(strings.Join(res.Commands, "\n")+"\n"), 0644); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%d commands written to %s\n", len(res.Commands), *out)
+	fmt.Printf("Codes: %s\n", strings.Join(res.Codes, " "))
+	fmt.Printf("Final code (read in the mirror): %s\n", res.Final)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/sfluor/synacor/bench"
+)
+
+// runBench handles the "bench" subcommand: it runs the benchmarks of the bench package and prints the speed of the
+// interpreter in instructions per second
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file whose self-test is benchmarked, the embedded one is used by default")
+	decodeCache := fs.Bool("decode-cache", false, "Enable the decode cache of the VMs")
+	run := fs.String("run", "", "Only run the benchmarks whose name matches this regular expression")
+	fs.Parse(args)
+
+	re, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	benchmarks, err := bench.Benchmarks(loadBinary(*file), *decodeCache)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, b := range benchmarks {
+		if !re.MatchString(b.Name) {
+			continue
+		}
+
+		res, err := bench.Measure(b)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Benchmark%s failed: %s\n", b.Name, err)
+			os.Exit(1)
+		}
+
+		if b.Instructions == 0 {
+			// The benchmarks of the clones and the snapshots execute no instruction, their allocations matter
+			fmt.Printf("Benchmark%-17s %10d %14d ns/op %10d B/op %8d allocs/op\n", b.Name, res.N, res.NsPerOp(),
+				res.BytesPerOp(), res.AllocsPerOp())
+			continue
+		}
+
+		instructions := float64(res.N) * float64(b.Instructions)
+		fmt.Printf("Benchmark%-17s %10d %14d ns/op %10.2f ns/instruction %8.2f M instructions/s\n", b.Name, res.N, res.NsPerOp(),
+			float64(res.T.Nanoseconds())/instructions, instructions/res.T.Seconds()/1e6)
+	}
+}
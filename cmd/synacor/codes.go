@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/codes"
+	"github.com/sfluor/synacor/vm"
+)
+
+// runCodes handles the "codes verify" subcommand: the milestones earned by the codes of a session
+func runCodes(args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintf(os.Stderr, "Usage: %s codes verify -hashes <file> [transcript or text files...]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("codes verify", flag.ExitOnError)
+	hashes := fs.String("hashes", "", "Path to the MD5 hashes of the codes of the milestones, one per line followed by the name of the milestone (see the codes package)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s codes verify -hashes <file> [transcript or text files...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args[1:])
+
+	if *hashes == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	milestones, err := codes.LoadHashes(*hashes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	found := []string{}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	for _, path := range paths {
+		text, err := readCodesInput(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		for _, loc := range vm.FindCodes(text) {
+			found = append(found, text[loc[0]:loc[1]])
+		}
+	}
+
+	earned := 0
+	for i, r := range codes.Verify(found, milestones) {
+		name := r.Name
+		if name == "" {
+			name = r.Hash
+		}
+		switch {
+		case r.Mirrored:
+			fmt.Printf("[x] %d %-20s %s (read in the mirror)\n", i+1, name, r.Code)
+		case r.Earned():
+			fmt.Printf("[x] %d %-20s %s\n", i+1, name, r.Code)
+		default:
+			fmt.Printf("[ ] %d %s\n", i+1, name)
+		}
+		if r.Earned() {
+			earned++
+		}
+	}
+	fmt.Printf("%d/%d milestones earned\n", earned, len(milestones))
+}
+
+// readCodesInput returns the text where the codes are looked for: the output of a transcript written by -record or
+// the content of any other file (e.g. the output of -print-codes), - is stdin
+func readCodesInput(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if t, err := vm.ParseTranscript(bytes.NewReader(data)); err == nil && len(t) > 0 {
+		return string(t.Output()), nil
+	}
+	return string(data), nil
+}
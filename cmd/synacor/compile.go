@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sfluor/synacor/compiler"
+	"github.com/sfluor/synacor/vm"
+)
+
+// runCompile handles the "compile" subcommand: it writes a Go program running the binary, or the state of a snapshot
+func runCompile(args []string) {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the binary (e.g. a dump written by live -dump), the embedded challenge.bin is used by default")
+	snapshot := fs.String("snapshot", "", "Path to a snapshot ($save) to compile instead of -bin, the program starts from its state")
+	out := fs.String("out", "compiled.go", "Path of the Go file to write, run it with go run")
+	fs.Parse(args)
+
+	var s *vm.Snapshot
+	if *snapshot != "" {
+		var err error
+		if s, err = vm.LoadSnapshot(*snapshot); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		s = vm.New(loadBinary(*file), nil, nil).Snapshot()
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	if err := compiler.Compile(f, s); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Program written to %s, run it with go run %[1]s\n", *out)
+}
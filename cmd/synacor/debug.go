@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/sfluor/synacor/dap"
+	"github.com/sfluor/synacor/tui"
+	"github.com/sfluor/synacor/vm"
+)
+
+// runDebug handles the "debug" subcommand: the terminal debugger in stepping mode, full screen with -tui, or a Debug
+// Adapter Protocol server
+func runDebug(args []string) {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	dapFlag := fs.Bool("dap", false, "Serve the Debug Adapter Protocol on stdin and stdout (or -listen) instead of the terminal debugger")
+	tuiFlag := fs.Bool("tui", false, "Use the full screen debugger showing the disassembly, registers, stack, memory and output in panes")
+	width := fs.Int("width", 0, "Width of the terminal for -tui, detected by default")
+	height := fs.Int("height", 0, "Height of the terminal for -tui, detected by default")
+	listen := fs.String("listen", "", "Address to serve the Debug Adapter Protocol on (e.g. localhost:4711), one client is served")
+	opts := runOptions{}
+	opts.register(fs)
+	fs.Parse(args)
+
+	bin := loadBinary(*file)
+
+	if *tuiFlag {
+		opts.step = true
+		runTUI(bin, opts, *width, *height)
+		return
+	}
+
+	if !*dapFlag {
+		opts.step = true
+		run(bin, opts)
+		return
+	}
+
+	// The mode flags make no sense here: the client drives the execution
+	opts.debug, opts.step = false, false
+	closeAll := func() {}
+	configure := func(machine *vm.VM) {
+		closeAll = opts.configure(machine)
+	}
+	defer func() { closeAll() }()
+
+	var err error
+	if *listen == "" {
+		err = dap.NewSession(bin, os.Stdin, os.Stdout, configure).Serve()
+	} else {
+		err = serveDAP(*listen, bin, configure)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug adapter: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// runTUI executes the binary in the full screen debugger
+func runTUI(bin []uint16, opts runOptions, width, height int) {
+	in, closeInput := opts.openInput(os.Stdin)
+	defer closeInput()
+
+	machine := vm.New(bin, nil, nil)
+	d := tui.New(machine, in, os.Stdout)
+	if width > 0 && height > 0 {
+		d.SetSize(width, height)
+	}
+	closeAll := opts.configure(machine)
+	defer closeAll()
+
+	d.Enter()
+	reason, err := opts.run(machine)
+	d.Leave()
+
+	opts.report(machine, os.Stderr)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "VM error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("VM stopped: %s\n", reason)
+}
+
+// serveDAP waits for a client on addr and serves it
+func serveDAP(addr string, bin []uint16, configure func(machine *vm.VM)) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Fprintf(os.Stderr, "Waiting for a debug adapter client on %s\n", l.Addr())
+
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return dap.NewSession(bin, conn, conn, configure).Serve()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// runDiff handles the "diff" subcommand: the differences between two snapshots saved with $save
+func runDiff(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, "Usage: %s diff <snapshot1> <snapshot2>\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	snapshots := []*vm.Snapshot{}
+	for _, path := range args {
+		s, err := vm.LoadSnapshot(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			os.Exit(1)
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	d := snapshots[0].Diff(snapshots[1])
+	if d.Empty() {
+		fmt.Println("The snapshots are identical")
+		return
+	}
+	fmt.Println(d)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/symbols"
+)
+
+// runExport handles the "export" subcommand: it writes the files for loading the binary in Ghidra or IDA
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the binary (e.g. the memory written by extract once the code is decrypted), the embedded challenge.bin is used by default")
+	symbolsFile := fs.String("symbols", "", "Path to a symbol file naming the functions and labels (e.g. data/symbols.json)")
+	min := fs.Int("min", 4, "Minimum length of the strings")
+	dir := fs.String("dir", "export", "Directory where the image (image.bin), the map file (synacor.map) and the Ghidra script (synacor_ghidra.py) are written")
+	fs.Parse(args)
+
+	bin := loadBinary(*file)
+	var syms *symbols.Table
+	if *symbolsFile != "" {
+		var err error
+		if syms, err = symbols.Load(*symbolsFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	list := analysis.Symbols(analysis.AnalyzeAll(bin), syms, analysis.ScanStrings(bin, *min))
+
+	if err := os.MkdirAll(*dir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	err := ioutil.WriteFile(filepath.Join(*dir, "image.bin"), loader.Encode(bin), 0644)
+	if err == nil {
+		err = writeFile(filepath.Join(*dir, "synacor.map"), func(w io.Writer) error { return analysis.WriteMap(w, list) })
+	}
+	if err == nil {
+		err = writeFile(filepath.Join(*dir, "synacor_ghidra.py"), func(w io.Writer) error {
+			return analysis.WriteGhidraScript(w, list)
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d symbols written to %s\n", len(list), *dir)
+}
+
+// writeFile creates path and writes it with write
+func writeFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
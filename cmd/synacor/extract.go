@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// runExtract handles the "extract" subcommand: it writes a region of the memory as raw little-endian values, once the
+// binary ran until it waits for input (the self-test decrypts a part of the code) or from a snapshot
+func runExtract(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	snapshot := fs.String("snapshot", "", "Path to a snapshot ($save) to extract the memory of instead of running -bin")
+	raw := fs.Bool("raw", false, "Extract the memory of -bin as loaded, without running it")
+	addr := fs.Uint("addr", 0, "Address of the first value of the region")
+	length := fs.Uint("len", vm.M, "Number of values of the region, it stops at the end of the memory")
+	out := fs.String("out", "region.bin", "Path of the file to write")
+	opts := runOptions{}
+	opts.register(fs)
+	fs.Parse(args)
+
+	if *addr >= vm.M {
+		fmt.Fprintf(os.Stderr, "Wrong -addr %d, the memory has %d addresses\n", *addr, vm.M)
+		os.Exit(1)
+	}
+
+	var machine *vm.VM
+	if *snapshot != "" {
+		s, err := vm.LoadSnapshot(*snapshot)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		machine = vm.New(s.Memory, nil, nil)
+	} else {
+		// The commands of -input are played before extracting
+		in := []byte{}
+		if opts.input != "" {
+			var err error
+			if in, err = ioutil.ReadFile(opts.input); err != nil {
+				panic(err)
+			}
+		}
+
+		machine = vm.New(loadBinary(*file), bytes.NewReader(in), ioutil.Discard)
+		closeAll := opts.configure(machine)
+		defer closeAll()
+		if !*raw {
+			reason, err := opts.run(machine)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Fprintf(os.Stderr, "VM stopped: %s\n", reason)
+		}
+	}
+
+	b := machine.DumpRegion(uint16(*addr), int(*length))
+	if err := ioutil.WriteFile(*out, b, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d values from %d written to %s\n", len(b)/2, *addr, *out)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sfluor/synacor/analysis"
+)
+
+// runGraph handles the "graph" subcommand
+func runGraph(args []string) {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	kind := fs.String("kind", "calls", "Graph to write: calls (call graph) or cfg (control-flow graph of each function)")
+	format := fs.String("format", "dot", "Output format: dot or json (the JSON lists the functions with their blocks and calls)")
+	function := fs.Int("func", -1, "Address of the only function written with -kind cfg or -format json")
+	out := fs.String("out", "", "Path of the file to write, stdout by default")
+	fs.Parse(args)
+
+	bin := loadBinary(*file)
+	entries := []uint16{}
+	if *function >= 0 {
+		entries = append(entries, uint16(*function))
+	}
+	p := analysis.AnalyzeAll(bin, entries...)
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var err error
+	switch {
+	case *format == "json":
+		err = p.WriteJSON(w, entries...)
+	case *format == "dot" && *kind == "calls":
+		err = p.WriteCallGraphDOT(w)
+	case *format == "dot" && *kind == "cfg":
+		err = p.WriteCFGDOT(w, entries...)
+	default:
+		err = fmt.Errorf("unknown -kind %q or -format %q", *kind, *format)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
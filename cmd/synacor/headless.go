@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// runHeadless handles the "run" subcommand: it plays the input without a terminal and compares the output to a golden
+// transcript, exiting with a non-zero code if they differ
+func runHeadless(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	expect := fs.String("expect", "", "Path to the expected output of the VM")
+	update := fs.Bool("update", false, "Write the output to the -expect file instead of comparing it")
+	opts := runOptions{}
+	opts.register(fs)
+	fs.Parse(args)
+
+	input := []byte{}
+	if opts.input != "" {
+		b, err := ioutil.ReadFile(opts.input)
+		if err != nil {
+			panic(err)
+		}
+		input = b
+	}
+
+	out := &bytes.Buffer{}
+	outputs := opts.openOutput(out)
+	machine := vm.New(loadBinary(*file), outputs.input(bytes.NewReader(input)), outputs.game)
+	machine.SetDiagnostics(outputs.diag)
+	closeAll := opts.configure(machine)
+	defer closeAll()
+
+	reason, err := opts.run(machine)
+	opts.report(machine, outputs.diag)
+	outputs.close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "VM error: %s\n", err)
+		os.Exit(1)
+	}
+
+	if *expect == "" {
+		os.Stdout.Write(out.Bytes())
+		return
+	}
+
+	if *update {
+		if err := ioutil.WriteFile(*expect, out.Bytes(), 0644); err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s updated (%d bytes, stopped: %s)\n", *expect, out.Len(), reason)
+		return
+	}
+
+	expected, err := ioutil.ReadFile(*expect)
+	if err != nil {
+		panic(err)
+	}
+
+	if line, want, got, differ := firstDifference(string(expected), out.String()); differ {
+		fmt.Fprintf(os.Stderr, "Output differs from %s at line %d:\n- expected: %q\n- got:      %q\n", *expect, line, want, got)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Output matches %s (%d bytes, stopped: %s)\n", *expect, out.Len(), reason)
+}
+
+// firstDifference returns the first line (starting at 1) that differs between the two texts
+func firstDifference(expected, got string) (int, string, string, bool) {
+	if expected == got {
+		return 0, "", "", false
+	}
+
+	e, g := strings.Split(expected, "\n"), strings.Split(got, "\n")
+	for i := 0; i < len(e) || i < len(g); i++ {
+		var want, have string
+		if i < len(e) {
+			want = e[i]
+		}
+		if i < len(g) {
+			have = g[i]
+		}
+		if want != have || i >= len(e) || i >= len(g) {
+			return i + 1, want, have, true
+		}
+	}
+
+	return 0, "", "", false
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/asm"
+	"github.com/sfluor/synacor/loader"
+)
+
+// runLink handles the "link" subcommand: it links objects written by -asm -object, or assembly files compiled on the
+// fly, into a binary
+func runLink(args []string) {
+	fs := flag.NewFlagSet("link", flag.ExitOnError)
+	out := fs.String("out", "out.bin", "Path of the linked binary")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: %s link [-out <file>] <object or .asm file>...\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	objects := []*asm.Object{}
+	for _, path := range fs.Args() {
+		if !strings.HasSuffix(path, ".asm") {
+			o, err := asm.LoadObject(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			objects = append(objects, o)
+			continue
+		}
+
+		o, err := asm.CompileFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			os.Exit(1)
+		}
+		objects = append(objects, o)
+	}
+
+	bin, err := asm.Link(objects)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*out, loader.Encode(bin), 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("%d objects linked, %d words written to %s\n", len(objects), len(bin), *out)
+}
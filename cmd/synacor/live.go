@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/vm"
+)
+
+// runLive handles the "live" subcommand: the disassembly of the memory once the binary decrypted its code
+func runLive(args []string) {
+	fs := flag.NewFlagSet("live", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	until := fs.Int("until", -1, "Stop when the cursor reaches this address")
+	quiet := fs.Int("quiet", 0, "Stop after this many instructions without WMEM below -code-end since the last one, 0 disables it")
+	codeEnd := fs.Int("code-end", vm.M, "End of the code region watched by -quiet")
+	out := fs.String("out", "", "Path of the listing to write, stdout by default")
+	dump := fs.String("dump", "", "Path of a binary file where the live memory is written")
+	opts := runOptions{}
+	opts.register(fs)
+	fs.Parse(args)
+
+	// Without -until or -quiet the binary runs until the input (-input only) is exhausted
+	in := []byte{}
+	if opts.input != "" {
+		var err error
+		if in, err = ioutil.ReadFile(opts.input); err != nil {
+			panic(err)
+		}
+	}
+
+	bin := loadBinary(*file)
+	// The machine writes in the memory it's given, keep the binary intact for the diff
+	machine := vm.New(append([]uint16{}, bin...), bytes.NewReader(in), ioutil.Discard)
+	closeAll := opts.configure(machine)
+	defer closeAll()
+
+	writes, sinceWrite := 0, 0
+	for int(machine.Cursor()) != *until {
+		ins, ok := analysis.Decode(machine.MemRange(machine.Cursor(), machine.Cursor()+4), 0)
+		if ok && ins.Op.Code == vm.WMEM && int(machine.Operand(ins.Args[0])) < *codeEnd {
+			writes, sinceWrite = writes+1, 0
+		} else {
+			sinceWrite++
+		}
+
+		if *quiet > 0 && writes > 0 && sinceWrite > *quiet {
+			break
+		}
+
+		if err := machine.Step(); err != nil {
+			if _, err := vm.ExitReasonOf(err); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			break
+		}
+	}
+
+	memory := machine.MemRange(0, uint16(len(bin)))
+	fmt.Fprintf(os.Stderr, "Stopped at %d after %d writes in the code region\n", machine.Cursor(), writes)
+
+	if *dump != "" {
+		if err := ioutil.WriteFile(*dump, loader.Encode(memory), 0644); err != nil {
+			panic(err)
+		}
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := analysis.WriteLiveListing(w, memory, bin); err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,214 @@
+// Command synacor runs the challenge binary and hosts the tools built around the VM
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/asm"
+	"github.com/sfluor/synacor/decompiler"
+	"github.com/sfluor/synacor/extractor"
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/symbols"
+)
+
+func main() {
+
+	coinsFlag := flag.Bool("coins", false, "Print the solution for the coin enigma")
+	orbFlag := flag.Bool("orb", false, "Print the solution for the orb enigma")
+	teleporter := flag.Bool("teleporter", false, "Print the solution for the teleporter enigma")
+	file := flag.String("bin", "", "Path to the challenge.bin file to execute it")
+	extract := flag.String("extract", "", "Path of a file where the extracted code of -bin is written (instead of running it)")
+	decompile := flag.String("decompile", "", "Path of a file where the pseudocode of -bin is written (instead of running it)")
+	function := flag.Int("func", -1, "Address of the only function written by -decompile (e.g. 6027)")
+	asmFile := flag.String("asm", "", "Path to an assembly file to compile into a binary")
+	outFile := flag.String("out", "out.bin", "Path of the binary written by -asm")
+	object := flag.Bool("object", false, "Write an object of -asm to link with others with the link subcommand instead of a binary")
+
+	opts := runOptions{}
+	opts.register(flag.CommandLine)
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %[1]s [options], %[1]s solve <coins|teleporter|vault>, %[1]s run [options], %[1]s map [options], %[1]s graph [options], %[1]s strings [options], %[1]s analyze [options], %[1]s export [options], %[1]s live [options], %[1]s diff <snapshot1> <snapshot2>, %[1]s tracediff [options] <a.trace> <b.trace>, %[1]s verify [options], %[1]s selftest [options], %[1]s replay <file>, %[1]s autoplay [options], %[1]s patch [options], %[1]s link [options] <objects>, %[1]s extract [options], %[1]s bench [options], %[1]s compile [options], %[1]s serve [options], %[1]s debug [--dap] [options], %[1]s postmortem [options] <core>, %[1]s writeup [options] <transcript> or %[1]s codes verify [options] [files]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	// Known by -asm, -extract and -decompile as well as when running -bin
+	opts.registerExtensions()
+
+	if flag.Arg(0) == "solve" {
+		solve(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "run" {
+		runHeadless(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "map" {
+		runMap(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "graph" {
+		runGraph(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "analyze" {
+		runAnalyze(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "export" {
+		runExport(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "strings" {
+		runStrings(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "live" {
+		runLive(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "diff" {
+		runDiff(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "tracediff" {
+		runTracediff(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "verify" {
+		runVerify(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "selftest" {
+		runSelftest(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "compile" {
+		runCompile(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "bench" {
+		runBench(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "extract" {
+		runExtract(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "patch" {
+		runPatch(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "link" {
+		runLink(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "autoplay" {
+		runAutoplay(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "replay" {
+		runReplay(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "serve" {
+		runServe(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "postmortem" {
+		runPostmortem(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "writeup" {
+		runWriteup(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "codes" {
+		runCodes(flag.Args()[1:])
+
+	} else if flag.Arg(0) == "debug" {
+		runDebug(flag.Args()[1:])
+
+	} else if *coinsFlag {
+		// Coins solution
+		solve([]string{"coins"})
+
+	} else if *orbFlag {
+		// Orb search
+		solve([]string{"vault"})
+
+	} else if *teleporter {
+		// Find R7 value
+		solve([]string{"teleporter"})
+
+	} else if *asmFile != "" && *object {
+		// Compile an object for the link subcommand
+		o, err := asm.CompileFile(*asmFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *asmFile, err)
+			os.Exit(1)
+		}
+
+		if err := o.Save(*outFile); err != nil {
+			panic(err)
+		}
+
+	} else if *asmFile != "" {
+		// Compile assembly, with the includes relative to the file
+		bin, err := asm.AssembleFile(*asmFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *asmFile, err)
+			os.Exit(1)
+		}
+
+		if err := ioutil.WriteFile(*outFile, loader.Encode(bin), 0644); err != nil {
+			panic(err)
+		}
+
+	} else if *file != "" && *decompile != "" {
+		// Decompile code
+		decompileCode(loadBinary(*file), *decompile, *function)
+
+	} else if *file != "" && *extract != "" {
+		// Extract code
+		extractCode(loadBinary(*file), *extract, opts.loadSymbols())
+
+	} else if *file != "" {
+		run(loadBinary(*file), opts)
+
+	} else {
+		fmt.Fprintf(os.Stderr, "Please choose an option:\n")
+		flag.Usage()
+	}
+
+}
+
+// loadBinary reads and parses a binary file, the embedded challenge binary is used if path is empty
+func loadBinary(path string) []uint16 {
+	var bin []uint16
+	var err error
+
+	if path == "" {
+		bin, err = loader.LoadEmbedded()
+	} else {
+		bin, err = loader.LoadFile(path)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return bin
+}
+
+func extractCode(bin []uint16, path string, syms *symbols.Table) {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	extractor.WriteExtractedCode(bin, f, syms)
+}
+
+// decompileCode writes the pseudocode of the functions of bin, or only of the one at function if it's not negative
+func decompileCode(bin []uint16, path string, function int) {
+	f, err := os.Create(path)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	entries := []uint16{}
+	if function >= 0 {
+		entries = append(entries, uint16(function))
+	}
+	p := analysis.AnalyzeAll(bin, entries...)
+
+	if err := decompiler.Decompile(f, p, entries...); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
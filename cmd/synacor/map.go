@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/mapper"
+	"github.com/sfluor/synacor/vm"
+)
+
+// runMap handles the "map" subcommand
+func runMap(args []string) {
+	fs := flag.NewFlagSet("map", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	load := fs.String("load", "", "Snapshot (saved with $save) to start the exploration from")
+	input := fs.String("input", "", "File of commands to play before starting the exploration (e.g. processed/moves.record)")
+	roomAddr := fs.Uint("room-addr", 0, "Memory address storing the current room, used to tell apart rooms that look alike")
+	max := fs.Int("max", 0, "Maximum number of rooms to explore, 0 means no limit")
+	out := fs.String("out", "map.dot", "Path of the Graphviz DOT file to write")
+	fs.Parse(args)
+
+	machine := vm.New(loadBinary(*file), os.Stdin, ioutil.Discard)
+
+	if *load != "" {
+		s, err := vm.LoadSnapshot(*load)
+		if err != nil {
+			panic(err)
+		}
+		machine.Restore(s)
+	}
+
+	// Play the game until the input is exhausted, the VM is then waiting for the next command
+	if *input != "" {
+		b, err := ioutil.ReadFile(*input)
+		if err != nil {
+			panic(err)
+		}
+		machine.SetInput(bytes.NewReader(b))
+	} else {
+		machine.SetInput(bytes.NewReader(nil))
+	}
+
+	if reason, err := machine.Run(); err != nil || reason != vm.ExitInputEOF {
+		fmt.Fprintf(os.Stderr, "The game stopped before the exploration: %v %v\n", reason, err)
+		os.Exit(1)
+	}
+
+	m, err := mapper.Explore(machine, mapper.Options{RoomAddr: uint16(*roomAddr), MaxRooms: *max})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Exploration failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	if err := m.WriteDOT(f); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%d rooms written to %s\n", len(m.Rooms), *out)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/binpatch"
+	"github.com/sfluor/synacor/loader"
+)
+
+// runPatch handles the "patch" subcommand: it writes a copy of the binary modified by a patch file, and the patch
+// file undoing it
+func runPatch(args []string) {
+	fs := flag.NewFlagSet("patch", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file to patch, the embedded one is used by default")
+	patches := fs.String("patches", "", "Path to the JSON file of patches to apply (e.g. data/teleporter.patch.json)")
+	out := fs.String("out", "patched.bin", "Path of the patched binary")
+	undo := fs.String("undo", "", "Path of a patch file undoing the patches, to apply to the patched binary")
+	fs.Parse(args)
+
+	if *patches == "" {
+		fmt.Fprintf(os.Stderr, "Usage: %s patch -patches <file> [-bin <file>] [-out <file>] [-undo <file>]\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	list, err := binpatch.Load(*patches)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	patched, err := binpatch.Apply(loadBinary(*file), list)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", *patches, err)
+		os.Exit(1)
+	}
+
+	if err := ioutil.WriteFile(*out, loader.Encode(patched), 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("%d patches applied, patched binary written to %s\n", len(list), *out)
+
+	if *undo != "" {
+		if err := binpatch.Save(*undo, binpatch.Invert(list)); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Undo patches written to %s\n", *undo)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// runPostmortem handles the "postmortem" subcommand: the debugger, read-only, on the state of a core dump
+func runPostmortem(args []string) {
+	fs := flag.NewFlagSet("postmortem", flag.ExitOnError)
+	lines := fs.Int("lines", 20, "Number of the last executed instructions printed, $history isn't available")
+	opts := runOptions{}
+	fs.StringVar(&opts.symbols, "symbols", "", "Path to a symbol file naming addresses (e.g. data/symbols.json)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s postmortem [options] <core>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	c, err := vm.LoadCore(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "The VM stopped after %d instructions: %s\n", c.Instructions, c.Error)
+	fmt.Fprintf(os.Stderr, "\nLast output:\n%s\n", c.Output)
+	trace := c.Trace
+	if *lines >= 0 && len(trace) > *lines {
+		trace = trace[len(trace)-*lines:]
+	}
+	fmt.Fprintf(os.Stderr, "\nLast instructions (%d kept):\n%s\n", len(c.Trace), strings.Join(trace, "\n"))
+	fmt.Fprintln(os.Stderr, "\nThe debugger is read-only: the state can be inspected ($register, $stack, $bt, $dump, $eval...) but not changed")
+
+	machine := vm.New(nil, os.Stdin, os.Stdout)
+	machine.RestoreCore(c)
+	machine.SetSymbols(opts.loadSymbols())
+	machine.SetReadOnly(true)
+	machine.SetStepping(true)
+	machine.Run()
+}
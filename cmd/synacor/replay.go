@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sfluor/synacor/replay"
+)
+
+// runReplay handles the "replay" subcommand: it executes the input of a replay file written by -replay-out again and
+// exits with a non-zero code if the output differs
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s replay [-bin <file>] <replay file>\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	r, err := replay.Load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := r.Verify(loadBinary(*file)); err != nil {
+		fmt.Fprintf(os.Stderr, "Replay %s failed: %s\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+	fmt.Printf("Replay %s verified: %d bytes of input, %d instructions, output %s\n", fs.Arg(0), len(r.Input), r.Instructions, r.Output)
+}
@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sfluor/synacor/checkpoint"
+	"github.com/sfluor/synacor/extensions"
+	"github.com/sfluor/synacor/lineedit"
+	"github.com/sfluor/synacor/mux"
+	"github.com/sfluor/synacor/puzzles"
+	"github.com/sfluor/synacor/replay"
+	"github.com/sfluor/synacor/script"
+	"github.com/sfluor/synacor/symbols"
+	"github.com/sfluor/synacor/vm"
+)
+
+// typewriterDelay is the pause after each character with -speed typewriter
+const typewriterDelay = 20 * time.Millisecond
+
+// firstPrompt ends the output skipped by -skip-selftest
+var firstPrompt = regexp.MustCompile(`What do you do\?`)
+
+// runOptions are the flags tuning how a binary is run
+type runOptions struct {
+	input              string
+	debug              bool
+	step               bool
+	teleportSolve      bool
+	nativeConfirmation bool
+	patch              string
+	record             string
+	trace              string
+	profile            bool
+	pprofOut           string
+	printCodes         bool
+	history            int
+	coverageOut        string
+	coverageAnnotate   bool
+	symbols            string
+	trapFaults         bool
+	protect            string
+	arithmetic         string
+	stackLimit         int
+	detectLoops        uint64
+	skipSelftest       bool
+	session            string
+	maxInstructions    uint64
+	timeout            time.Duration
+	speed              string
+	stateDir           string
+	replayOut          string
+	decodeCache        bool
+	extensions         bool
+	checkpoints        string
+	script             string
+	commandPrefix      string
+	lineEdit           bool
+	diagnostics        string
+	coreOut            string
+	coreTrace          int
+}
+
+// register declares the flags of the options in fs
+func (o *runOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.lineEdit, "line-edit", false, "Edit the lines typed on the terminal with a history (up and down arrows) and the completion of the verbs, the nouns seen, the debugger commands and the symbols (Tab)")
+	fs.StringVar(&o.diagnostics, "diagnostics", "stderr", "Where the debugger prompt and messages and the stop message are written: stderr, stdout with the game, mux to frame both on stdout with the tags game and diag (see the mux package) or a file")
+	fs.StringVar(&o.input, "input", "", "Path to a file of commands played before reading stdin (e.g. processed/moves.record)")
+	fs.BoolVar(&o.debug, "debug", false, "Start in debug mode (same as $debugon)")
+	fs.BoolVar(&o.step, "step", false, "Start in stepping mode (same as $steppingon)")
+	fs.StringVar(&o.commandPrefix, "command-prefix", "$", "Prefix of the debugger commands typed instead of a line of the game, a line starting with it twice is given to the game with it once")
+	fs.StringVar(&o.coreOut, "core-out", "", "Path to a file where the state and the last instructions are written when the VM stops on an error, for the postmortem subcommand (e.g. synacor.core), none if empty")
+	fs.IntVar(&o.coreTrace, "core-trace", 1000, "Number of instructions executed before the error kept in the core dump of -core-out")
+	fs.BoolVar(&o.trapFaults, "trap-faults", false, "Go to stepping mode on an invalid memory access or operand instead of stopping")
+	fs.StringVar(&o.arithmetic, "arithmetic", "strict", "Edge cases of the arithmetic: strict stops on a mod by zero or an operand above the last register, permissive leaves the first operand of the mod unchanged and reads the operand modulo 32768")
+	fs.IntVar(&o.stackLimit, "stack-limit", vm.DefaultStackLimit, "Stop with the chain of calls when the stack holds this many values, e.g. on a runaway recursion, 0 means no limit")
+	fs.Uint64Var(&o.detectLoops, "detect-loops", 0, "Stop when the state (registers, cursor, stack and memory) sampled every N instructions repeats without any input read in between, i.e. the VM loops forever, 0 disables it")
+	fs.BoolVar(&o.skipSelftest, "skip-selftest", false, "Run the self-test and the welcome banner at full speed without writing them, until the first prompt of the game")
+	fs.StringVar(&o.session, "session", "", "Directory where the state and the input history are saved on exit (SIGTERM included) and restored by the next launch with the same directory, until the game halts")
+	fs.StringVar(&o.protect, "protect", "", "Comma separated ranges <start>-<end>[:ro|:xo][:break] (end excluded) the program can't write, nor read with xo: the access stops the VM with an error, or goes to stepping mode with break (same as $protect)")
+	fs.BoolVar(&o.teleportSolve, "teleport-solve", false, "Solve the teleporter enigma and use the answer when running -bin")
+	fs.BoolVar(&o.nativeConfirmation, "native-confirmation", false, "Replace the teleporter confirmation function by a native implementation when running -bin")
+	fs.Uint64Var(&o.maxInstructions, "max-instructions", 0, "Stop after executing this many instructions, 0 means no limit")
+	fs.DurationVar(&o.timeout, "timeout", 0, "Stop after running for this long (e.g. 30s), 0 means no limit")
+	fs.StringVar(&o.speed, "speed", "unlimited", "Pace the execution: unlimited, a number of instructions per second or typewriter to pause after each character ($turbo ignores it)")
+	fs.StringVar(&o.stateDir, "state-dir", "states", "Directory of the slots saved by $qs <n>, restored by $ql <n> and listed by $slots")
+	fs.StringVar(&o.checkpoints, "checkpoints", "", "Path to a JSON file of rules saving a snapshot to the checkpoints directory of -state-dir when the output matches them (e.g. data/checkpoints.json)")
+	fs.StringVar(&o.script, "script", "", "Path to a script of handlers run when the output or the input matches a pattern or the cursor reaches an address, reading and modifying the registers and the memory (see the script package, e.g. data/book.script)")
+	fs.BoolVar(&o.decodeCache, "decode-cache", false, "Decode each instruction once and reuse its operands until its memory is written")
+	fs.BoolVar(&o.extensions, "extensions", false, "Enable the opcodes printnum (22), readline (23) and rand (24) for -asm and when running, see the extensions package")
+	fs.StringVar(&o.patch, "patch", "", "Path to a JSON file of patches to apply when running -bin (e.g. data/teleporter.json)")
+	fs.StringVar(&o.record, "record", "", "Path to a file where the transcript of every byte read and written is written")
+	fs.StringVar(&o.replayOut, "replay-out", "", "Path to a replay file (e.g. game.rpl) where the input and the hash of the output are written on exit, see the replay subcommand")
+	fs.StringVar(&o.trace, "trace", "", "Path to a file where every executed instruction is appended")
+	fs.IntVar(&o.history, "history", 0, "Remember the last N instructions to step backwards with $rstep and $rcontinue-to")
+	fs.StringVar(&o.symbols, "symbols", "", "Path to a symbol file naming addresses for the debugger, the trace, the profile and -extract (e.g. data/symbols.json)")
+	fs.StringVar(&o.coverageOut, "coverage-out", "", "Path to a file where the executed and unexecuted regions are written on exit")
+	fs.BoolVar(&o.coverageAnnotate, "coverage-annotate", false, "Add the disassembly of the memory marking the executed instructions to -coverage-out")
+	fs.BoolVar(&o.printCodes, "print-codes", false, "Print the challenge codes found in the output on exit")
+	fs.BoolVar(&o.profile, "profile", false, "Count executions per address, opcode and function when running -bin, the report is written to stderr on exit")
+	fs.StringVar(&o.pprofOut, "pprof-out", "", "Path to a file where the profile of -bin is written on exit in the pprof format, for go tool pprof and the flame graph viewers")
+}
+
+// run executes the binary on the terminal
+func run(bin []uint16, opts runOptions) {
+	stdin, editor, restoreTerminal := opts.openTerminal()
+	in, closeInput := opts.openInput(stdin)
+	defer closeInput()
+	out := opts.openOutput(os.Stdout)
+
+	// Initialize VM
+	machine := vm.New(bin, out.input(in), out.game)
+	machine.SetDiagnostics(out.diag)
+	if editor != nil {
+		editor.SetCompleter(lineedit.NewCompleter(machine).Complete)
+	}
+	closeFiles := opts.configure(machine)
+	closeAll := func() {
+		restoreTerminal()
+		closeFiles()
+		out.close()
+	}
+	defer closeAll()
+	session := opts.openSession(machine, out.game, out.diag)
+
+	stopInterrupts := handleInterrupts(machine, session, closeAll)
+	defer stopInterrupts()
+
+	// Run
+	reason, err := opts.run(machine)
+	if session != nil {
+		session.close(machine, reason, out.diag)
+	}
+
+	opts.report(machine, out.diag)
+
+	if err != nil {
+		fmt.Fprintf(out.diag, "\nVM error: %s\n", err)
+		closeAll()
+		os.Exit(1)
+	}
+	fmt.Fprintf(out.diag, "\nVM stopped: %s\n", reason)
+}
+
+// outputs are where a session writes the game and the diagnostics, see openOutput
+type outputs struct {
+	game, diag io.Writer
+	mux        *mux.Mux
+	file       *os.File
+}
+
+// openOutput returns the outputs chosen by -diagnostics, the game is written to stdout
+func (o runOptions) openOutput(stdout io.Writer) *outputs {
+	switch o.diagnostics {
+	case "stderr":
+		return &outputs{game: stdout, diag: os.Stderr}
+	case "stdout":
+		return &outputs{game: stdout, diag: stdout}
+	case "mux":
+		m := mux.New(stdout)
+		return &outputs{game: m.Stream("game"), diag: m.Stream("diag"), mux: m}
+	}
+
+	f, err := os.Create(o.diagnostics)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Wrong -diagnostics: %s\n", err)
+		os.Exit(1)
+	}
+	return &outputs{game: stdout, diag: f, file: f}
+}
+
+// input returns in flushing the framed outputs before each read, so that the prompts are received
+func (out *outputs) input(in io.Reader) io.Reader {
+	if out.mux == nil {
+		return in
+	}
+	return out.mux.FlushingReader(in)
+}
+
+// close flushes the framed outputs and closes the diagnostics file
+func (out *outputs) close() {
+	if out.mux != nil {
+		out.mux.Flush()
+	}
+	if out.file != nil {
+		out.file.Close()
+	}
+}
+
+// interruptSlot is the slot where the state is saved when a second Ctrl-C exits
+const interruptSlot = 0
+
+// handleInterrupts makes Ctrl-C drop to the debugger prompt, a second one before the execution resumed saves the state
+// to a slot (and to the session, if any) and exits after closeAll. With a session, SIGTERM saves it and exits. The
+// returned function restores the default behavior.
+func handleInterrupts(machine *vm.VM, session *session, closeAll func()) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	if session != nil {
+		signal.Notify(signals, syscall.SIGTERM)
+	}
+
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGTERM {
+				machine.Exclusive(func(machine *vm.VM) { session.terminate(machine, os.Stderr) })
+				closeAll()
+				os.Exit(143)
+			}
+
+			if !machine.Interrupt() {
+				// Nothing is executed while the program waits for a line, the prompt only shows once it is read
+				fmt.Fprintln(os.Stderr, "\nInterrupting, Ctrl-C again to save and exit")
+				continue
+			}
+
+			// The VM may still be executing the end of a line: it's saved between two instructions, or while it waits for
+			// its input, not from here
+			machine.Exclusive(func(machine *vm.VM) {
+				if _, err := machine.QuickSave(interruptSlot); err != nil {
+					fmt.Fprintf(os.Stderr, "\nCould not save the state: %s\n", err)
+				} else {
+					fmt.Fprintf(os.Stderr, "\nState saved to slot %d, $ql %d restores it\n", interruptSlot, interruptSlot)
+				}
+				if session != nil {
+					session.terminate(machine, os.Stderr)
+				}
+			})
+			closeAll()
+			os.Exit(130)
+		}
+	}()
+
+	return func() { signal.Stop(signals) }
+}
+
+// openTerminal returns the line editor reading stdin with -line-edit (nil otherwise) and the reader of the lines typed
+// by the player, the returned function restores the terminal
+func (o runOptions) openTerminal() (io.Reader, *lineedit.Editor, func()) {
+	if !o.lineEdit {
+		return os.Stdin, nil, func() {}
+	}
+
+	restore, err := lineedit.Raw(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring -line-edit: %s\n", err)
+		return os.Stdin, nil, func() {}
+	}
+	editor := lineedit.New(os.Stdin, os.Stdout)
+	return editor, editor, restore
+}
+
+// openInput returns the -input file followed by stdin, the returned function closes the file
+func (o runOptions) openInput(stdin io.Reader) (io.Reader, func()) {
+	if o.input == "" {
+		return stdin, func() {}
+	}
+
+	f, err := os.Open(o.input)
+	if err != nil {
+		panic(err)
+	}
+	return io.MultiReader(f, stdin), func() { f.Close() }
+}
+
+// registerExtensions registers the opcodes of the extensions package when -extensions is set
+func (o runOptions) registerExtensions() {
+	if !o.extensions {
+		return
+	}
+	if err := extensions.Register(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not register the extensions: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the machine within the limits of -max-instructions and -timeout
+func (o runOptions) run(machine *vm.VM) (vm.ExitReason, error) {
+	ctx := context.Background()
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+	return machine.RunLimit(ctx, o.maxInstructions)
+}
+
+// configure applies the options to the machine, the returned function closes the files opened for it
+func (o runOptions) configure(machine *vm.VM) func() {
+	files := []*os.File{}
+
+	machine.SetSymbols(o.loadSymbols())
+	machine.SetDebugging(o.debug)
+	machine.SetStepping(o.step)
+	machine.SetTrapFaults(o.trapFaults)
+	if o.protect != "" {
+		ranges, err := vm.ParseProtectedRanges(o.protect)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Wrong -protect: %s\n", err)
+			os.Exit(1)
+		}
+		for _, r := range ranges {
+			machine.Protect(r)
+		}
+	}
+	mode, err := vm.ParseArithmeticMode(o.arithmetic)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Wrong -arithmetic: %s\n", err)
+		os.Exit(1)
+	}
+	machine.SetArithmetic(mode)
+	machine.SetStackLimit(o.stackLimit)
+	machine.DetectLoops(o.detectLoops)
+	if o.skipSelftest {
+		machine.SkipOutputUntil(firstPrompt)
+	}
+	machine.SetCommandPrefix(o.commandPrefix)
+	machine.SetStateDir(o.stateDir)
+	machine.EnableCoreDumps(o.coreOut, o.coreTrace)
+
+	switch o.speed {
+	case "", "unlimited":
+	case "typewriter":
+		machine.SetTypewriter(typewriterDelay)
+	default:
+		n, err := strconv.Atoi(o.speed)
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "Wrong -speed %q, should be unlimited, typewriter or a number of instructions per second\n", o.speed)
+			os.Exit(1)
+		}
+		machine.SetSpeed(n)
+	}
+
+	if o.extensions {
+		o.registerExtensions()
+		machine.EnableExtensions()
+	}
+
+	if o.teleportSolve {
+		r7, ok := puzzles.SolveTeleporter()
+		if !ok {
+			fmt.Fprintln(os.Stderr, "No R7 value satisfies the confirmation")
+			os.Exit(1)
+		}
+		if err := puzzles.PatchTeleporter(machine, r7); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not patch the teleporter: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// -teleport-solve already replaced it
+	if o.nativeConfirmation && !o.teleportSolve {
+		if err := puzzles.ReplaceConfirmation(machine); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not replace the confirmation: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.patch != "" {
+		patches, err := vm.LoadPatches(o.patch)
+		if err != nil {
+			panic(err)
+		}
+		for _, p := range patches {
+			machine.AddPatch(p.Address, p.Func())
+		}
+	}
+
+	if o.trace != "" {
+		f, err := os.OpenFile(o.trace, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			panic(err)
+		}
+		files = append(files, f)
+		machine.SetTrace(f)
+	}
+
+	if o.record != "" {
+		f, err := os.Create(o.record)
+		if err != nil {
+			panic(err)
+		}
+		files = append(files, f)
+		machine.SetRecorder(f)
+	}
+
+	if o.profile || o.pprofOut != "" {
+		machine.EnableProfiling()
+	}
+
+	if o.decodeCache {
+		machine.EnableDecodeCache()
+	}
+
+	if o.history > 0 {
+		machine.EnableHistory(o.history)
+	}
+
+	if o.coverageOut != "" {
+		machine.EnableCoverage()
+	}
+
+	var recorder *replay.Recorder
+	if o.checkpoints != "" {
+		rules, err := checkpoint.LoadRules(o.checkpoints)
+		if err == nil {
+			_, err = checkpoint.Watch(machine, rules, filepath.Join(o.stateDir, "checkpoints"), os.Stderr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Wrong -checkpoints: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.script != "" {
+		s, err := script.Load(o.script)
+		if err == nil {
+			err = s.Attach(machine, os.Stderr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Wrong -script: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.replayOut != "" {
+		recorder = replay.Record(machine, o.nativeConfirmation, o.step)
+	}
+
+	return func() {
+		if recorder != nil {
+			if err := recorder.Replay().Save(o.replayOut); err != nil {
+				fmt.Fprintf(os.Stderr, "Could not save the replay: %s\n", err)
+			}
+		}
+		for _, f := range files {
+			f.Close()
+		}
+	}
+}
+
+// loadSymbols reads the -symbols file, nil if there is none
+func (o runOptions) loadSymbols() *symbols.Table {
+	if o.symbols == "" {
+		return nil
+	}
+
+	t, err := symbols.Load(o.symbols)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return t
+}
+
+// report prints to w what the options asked to print once the machine stopped
+func (o runOptions) report(machine *vm.VM, w io.Writer) {
+	if o.printCodes {
+		fmt.Fprintln(w, "\nCodes:")
+		for _, code := range machine.Codes() {
+			fmt.Fprintln(w, code)
+		}
+	}
+
+	if o.profile {
+		fmt.Fprintln(w)
+		if err := machine.WriteProfile(w, 30); err != nil {
+			panic(err)
+		}
+	}
+
+	if o.pprofOut != "" {
+		if err := machine.SavePprof(o.pprofOut); err != nil {
+			panic(err)
+		}
+	}
+
+	if o.coverageOut != "" {
+		f, err := os.Create(o.coverageOut)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		if err := machine.WriteCoverage(f, o.coverageAnnotate); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/verify"
+	"github.com/sfluor/synacor/vmtest"
+)
+
+// runSelftest handles the "selftest" subcommand: it runs the programs and the test ROM of the vmtest package against
+// the implementations, like go test does, and exits with a non-zero code if one of them fails
+func runSelftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	impl := fs.String("impl", "", "Only check this implementation ("+strings.Join(verify.Names(), ", ")+")")
+	rom := fs.String("rom", "", "Write the self-checking test ROM to this file instead (its assembly if it ends with .asm), to validate other implementations")
+	fs.Parse(args)
+
+	if *rom != "" {
+		writeROM(*rom)
+		return
+	}
+
+	names := verify.Names()
+	if *impl != "" {
+		if _, ok := verify.Implementations[*impl]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown implementation %q\n", *impl)
+			os.Exit(2)
+		}
+		names = []string{*impl}
+	}
+
+	failed := false
+	for _, name := range names {
+		failures := vmtest.Check(verify.Implementations[name])
+		for _, f := range failures {
+			fmt.Printf("FAIL %s: %s\n", name, f)
+		}
+		fmt.Printf("%s: %d/%d cases passed\n", name, len(vmtest.Cases)-len(failures), len(vmtest.Cases))
+		failed = failed || len(failures) > 0
+
+		problems := vmtest.CheckROM(verify.Implementations[name])
+		for _, p := range problems {
+			fmt.Printf("FAIL %s: ROM: %s\n", name, p)
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s: the %d tests of the ROM passed\n", name, vmtest.ROMTests())
+		}
+		failed = failed || len(problems) > 0
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// writeROM writes the test ROM of the vmtest package to path, as a binary or as assembly
+func writeROM(path string) {
+	data := []byte(vmtest.ROM())
+	if !strings.HasSuffix(path, ".asm") {
+		bin, err := vmtest.BuildROM()
+		if err != nil {
+			panic(err)
+		}
+		data = loader.Encode(bin)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		panic(err)
+	}
+	fmt.Printf("Test ROM written to %s, it asks for %q on the input\n", path, strings.TrimSpace(vmtest.ROMInput))
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sfluor/synacor/metrics"
+	"github.com/sfluor/synacor/puzzles"
+	"github.com/sfluor/synacor/versions"
+	"github.com/sfluor/synacor/vm"
+	"github.com/sfluor/synacor/webterm"
+)
+
+// runServe handles the "serve" subcommand: every TCP connection (e.g. from telnet or nc) plays its own game, or with
+// -http every browser opening the page
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	listen := fs.String("listen", ":2323", "Address to listen on")
+	maxConns := fs.Int("max-conns", 10, "Number of games played at the same time, the other connections are refused")
+	idle := fs.Duration("idle", 10*time.Minute, "Close the connections idle for this long")
+	nativeConfirmation := fs.Bool("native-confirmation", true, "Replace the teleporter confirmation function by a native implementation")
+	httpAddr := fs.String("http", "", "Serve a browser terminal and its WebSocket on this address (e.g. :8080) instead of listening for TCP connections")
+	metricsAddr := fs.String("metrics", "", "Serve the Prometheus metrics of the games on /metrics on this address (e.g. :9100)")
+	fs.Parse(args)
+
+	var registry *metrics.Registry
+	if *metricsAddr != "" {
+		registry = metrics.New()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry)
+		log.Printf("Serving the metrics on http://%s/metrics", *metricsAddr)
+		go func() {
+			err := http.ListenAndServe(*metricsAddr, mux)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}()
+	}
+
+	bin := loadBinary(*file)
+	if *nativeConfirmation {
+		// Fail now rather than in every game
+		v, err := versions.Detect(bin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		log.Printf("Serving the %s", v.Name)
+	}
+	newGame := func(in io.Reader, out io.Writer) *vm.VM {
+		return newServedGame(bin, in, out, *nativeConfirmation)
+	}
+
+	if *httpAddr != "" {
+		log.Printf("Serving the challenge on http://%s", *httpAddr)
+		server := webterm.New(newGame, *maxConns, *idle)
+		server.SetMetrics(registry)
+		err := http.ListenAndServe(*httpAddr, server)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer l.Close()
+	log.Printf("Serving the challenge on %s", l.Addr())
+
+	slots := make(chan struct{}, *maxConns)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			log.Printf("Accept: %s", err)
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+			go func() {
+				defer func() { <-slots }()
+				serveGame(conn, newGame, *idle, registry)
+			}()
+		default:
+			log.Printf("%s refused: %d games are already running", conn.RemoteAddr(), *maxConns)
+			fmt.Fprintln(conn, "The server is full, try again later")
+			conn.Close()
+		}
+	}
+}
+
+// serveGame plays a game on conn until the program stops or the connection is closed or idle, recorded in registry
+// unless it is nil
+func serveGame(conn net.Conn, newGame func(in io.Reader, out io.Writer) *vm.VM, idle time.Duration, registry *metrics.Registry) {
+	defer conn.Close()
+	log.Printf("%s connected", conn.RemoteAddr())
+
+	c := &idleConn{Conn: conn, idle: idle}
+	c.w = bufio.NewWriter(writerFunc(c.write))
+	defer c.w.Flush()
+
+	machine := newGame(&crStripper{c}, c)
+	session := registry.Track(machine, conn.RemoteAddr().String())
+	reason, err := machine.Run()
+	session.Done(machine.Instructions(), reason)
+	if err != nil {
+		log.Printf("%s disconnected: %s", conn.RemoteAddr(), err)
+		return
+	}
+	log.Printf("%s disconnected: %s", conn.RemoteAddr(), reason)
+}
+
+// newServedGame creates the VM of a player on a copy of bin
+func newServedGame(bin []uint16, in io.Reader, out io.Writer, nativeConfirmation bool) *vm.VM {
+	machine := vm.New(append([]uint16{}, bin...), in, out)
+	// The debugger would let the players read files and write them on the server
+	machine.SetCommands(false)
+	if nativeConfirmation {
+		if err := puzzles.ReplaceConfirmation(machine); err != nil {
+			log.Printf("Could not replace the confirmation: %s", err)
+		}
+	}
+	return machine
+}
+
+// idleConn is a connection whose reads and writes fail once it has been idle for too long. The VM writes one byte
+// at a time, the output is buffered until the program reads the next command.
+type idleConn struct {
+	net.Conn
+	idle time.Duration
+	w    *bufio.Writer
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.idle)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
+
+// write sends the buffered output
+func (c *idleConn) write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.idle)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// writerFunc turns a function into an io.Writer
+type writerFunc func(b []byte) (int, error)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	return f(b)
+}
+
+// crStripper drops the carriage returns sent by telnet clients at the end of the lines
+type crStripper struct {
+	r io.Reader
+}
+
+func (s *crStripper) Read(b []byte) (int, error) {
+	for {
+		n, err := s.r.Read(b)
+		kept := bytes.Replace(b[:n], []byte("\r"), nil, -1)
+		copy(b, kept)
+		if len(kept) > 0 || err != nil {
+			return len(kept), err
+		}
+	}
+}
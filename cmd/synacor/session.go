@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Files of the directory of -session
+const (
+	sessionState  = "state.snapshot" // State of the VM when the last launch exited
+	sessionInput  = "input.txt"      // Lines read by the program since the start of the game, for -input
+	sessionScreen = "screen.txt"     // Output answering the last line read, printed again on resume
+)
+
+// sessionIdle is how long an exit on SIGTERM waits for the VM to stop between two instructions
+const sessionIdle = 2 * time.Second
+
+// session keeps the game of -session between launches: the state is saved when the VM stops or the process is
+// terminated and restored by the next launch, until the game halts
+type session struct {
+	dir      string
+	history  *os.File
+	screen   []byte
+	answered bool // A line was read since the last output, the next output starts a new screen
+}
+
+// openSession restores the state saved in the directory of -session, nil without it. The previous output is written
+// again to w so that the player sees where they left off.
+func (o runOptions) openSession(machine *vm.VM, w, diag io.Writer) *session {
+	if o.session == "" {
+		return nil
+	}
+
+	s, resumed, err := openSession(o.session, machine)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Wrong -session: %s\n", err)
+		os.Exit(1)
+	}
+	if resumed {
+		// The banner was skipped by the previous launch already
+		machine.SkipOutputUntil(nil)
+		fmt.Fprintf(diag, "Resumed the session saved in %s\n", o.session)
+		w.Write(s.screen)
+	}
+	return s
+}
+
+// openSession opens the session kept in dir, restoring its state if there is one
+func openSession(dir string, machine *vm.VM) (*session, bool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, err
+	}
+
+	s := &session{dir: dir}
+	snapshot, err := vm.LoadSnapshot(filepath.Join(dir, sessionState))
+	resumed := err == nil
+	switch {
+	case resumed:
+		machine.Restore(snapshot)
+		if s.screen, err = ioutil.ReadFile(filepath.Join(dir, sessionScreen)); err != nil && !os.IsNotExist(err) {
+			return nil, false, err
+		}
+	case !os.IsNotExist(err):
+		return nil, false, err
+	}
+
+	// The history starts with the game, a new game starts a new one
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resumed {
+		flags |= os.O_TRUNC
+	}
+	if s.history, err = os.OpenFile(filepath.Join(dir, sessionInput), flags, 0644); err != nil {
+		return nil, false, err
+	}
+
+	machine.OnInput(func(_ *vm.VM, c byte) {
+		s.history.Write([]byte{c})
+		s.answered = c == '\n'
+	})
+	machine.OnOutput(func(_ *vm.VM, c byte) {
+		if s.answered {
+			s.screen, s.answered = s.screen[:0], false
+		}
+		s.screen = append(s.screen, c)
+	})
+	return s, resumed, nil
+}
+
+// save writes the state of the VM, which must not be running, to the directory of the session
+func (s *session) save(machine *vm.VM) error {
+	if err := ioutil.WriteFile(filepath.Join(s.dir, sessionScreen), s.screen, 0644); err != nil {
+		return err
+	}
+
+	// Renamed once complete, a launch never finds half a state
+	path := filepath.Join(s.dir, sessionState)
+	if err := machine.Snapshot().Save(path + ".tmp"); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// close saves the state of the VM once it stopped for reason, the next launch starts a new game if it halted. An
+// error keeps the state saved by the previous launch.
+func (s *session) close(machine *vm.VM, reason vm.ExitReason, diag io.Writer) {
+	defer s.history.Close()
+
+	switch reason {
+	case vm.ExitError:
+		return
+	case vm.ExitHalt, vm.ExitRet:
+		if err := os.Remove(filepath.Join(s.dir, sessionState)); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(diag, "\nCould not end the session: %s\n", err)
+		}
+		return
+	}
+
+	if err := s.save(machine); err != nil {
+		fmt.Fprintf(diag, "\nCould not save the session: %s\n", err)
+		return
+	}
+	fmt.Fprintf(diag, "\nSession saved to %s\n", s.dir)
+}
+
+// terminate saves the state of the VM running in another goroutine, once it waits for the input of the program or of
+// the debugger: it is interrupted if it's executing instructions. The outcome is written to diag.
+func (s *session) terminate(machine *vm.VM, diag io.Writer) {
+	defer s.history.Close()
+
+	machine.Interrupt()
+	for deadline := time.Now().Add(sessionIdle); ; time.Sleep(10 * time.Millisecond) {
+		if state := machine.State(); state == vm.StateWaitingInput || state == vm.StateWaitingCommand {
+			break
+		}
+		if time.Now().After(deadline) {
+			fmt.Fprintf(diag, "\nCould not save the session: the VM didn't stop within %s\n", sessionIdle)
+			return
+		}
+	}
+
+	if err := s.save(machine); err != nil {
+		fmt.Fprintf(diag, "\nCould not save the session: %s\n", err)
+		return
+	}
+	fmt.Fprintf(diag, "\nSession saved to %s\n", s.dir)
+}
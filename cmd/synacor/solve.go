@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/puzzles"
+	"github.com/sfluor/synacor/search"
+	"github.com/sfluor/synacor/vm"
+)
+
+// solve handles the "solve <enigma>" subcommand
+func solve(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: solve <coins|teleporter|vault> [options]\n")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("solve", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file run by -vm and -brute, the embedded one is used by default")
+	onVM := fs.Bool("vm", false, "Find the teleporter R7 value by running the binary on a pool of VMs instead of natively")
+	brute := fs.Bool("brute", false, "Find the teleporter R7 value by using the teleporter in the game with each value on a pool of VMs")
+	input := fs.String("input", "processed/teleporter.record", "Path to the commands bringing the game to the use of the teleporter with the strange book read, for -brute")
+	native := fs.Bool("native", true, "Replace the confirmation function by its native implementation for -brute, without it every value exhausts -budget")
+	budget := fs.Uint64("budget", 10000000, "Instructions executed at most per value by -brute, 0 means no limit")
+	workers := fs.Int("workers", 0, "Number of VMs of the pool used by -vm and -brute, one per CPU by default")
+	fs.Parse(args[1:])
+
+	switch args[0] {
+	case "coins":
+		coins, ok := puzzles.SolveCoins()
+		if !ok {
+			fmt.Fprintln(os.Stderr, "No coin order satisfies the equation")
+			os.Exit(1)
+		}
+
+		names := []string{}
+		for _, c := range coins {
+			names = append(names, c.Name)
+		}
+		// > blue coin | red coin | shiny coin | concave coin | corroded coin
+		fmt.Println(strings.Join(names, " | "))
+
+	case "vault":
+		moves, ok := puzzles.SolveVault()
+		if !ok {
+			fmt.Fprintln(os.Stderr, "No path leads the orb to the vault door")
+			os.Exit(1)
+		}
+		fmt.Println(strings.Join(moves, "\n"))
+
+	case "teleporter":
+		// Find R7 value
+		var r7 uint16
+		var ok bool
+		if *brute {
+			r7, ok = bruteTeleporter(loadBinary(*file), *input, *native, search.Options{Workers: *workers, Budget: *budget})
+		} else if *onVM {
+			var err error
+			r7, ok, err = puzzles.SearchTeleporter(loadBinary(*file), search.Options{Workers: *workers, First: true})
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+		} else {
+			r7, ok = puzzles.SolveTeleporter()
+		}
+		if !ok {
+			fmt.Fprintln(os.Stderr, "No R7 value satisfies the confirmation")
+			os.Exit(1)
+		}
+		fmt.Println("Correct R7 value: ", r7)
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown enigma %q, choose one of: coins, teleporter, vault\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// bruteTeleporter plays the commands of the input file then tries every value of R7 with the teleporter
+func bruteTeleporter(bin []uint16, input string, native bool, opts search.Options) (uint16, bool) {
+	commands, err := ioutil.ReadFile(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	base := vm.New(bin, bytes.NewReader(commands), ioutil.Discard)
+	if reason, err := base.Run(); reason != vm.ExitInputEOF {
+		fmt.Fprintf(os.Stderr, "The game stopped before the end of %s: %s %v\n", input, reason, err)
+		os.Exit(1)
+	}
+
+	r7, ok, stats, err := puzzles.BruteTeleporter(base, native, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "%d values tried: %d told by the output, %d exhausted the budget, %d failed (%d canceled)\n", stats.Tried, stats.Matched, stats.Exhausted, stats.Failed, stats.Canceled)
+	return r7, ok
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/vm"
+)
+
+// runStrings handles the "strings" subcommand
+func runStrings(args []string) {
+	fs := flag.NewFlagSet("strings", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	scan := fs.Bool("scan", false, "Scan the memory for length-prefixed strings once the input is played instead of tracing OUT")
+	min := fs.Int("min", 4, "Minimum length of the strings")
+	opts := runOptions{}
+	opts.register(fs)
+	fs.Parse(args)
+
+	// The more commands of -input are played, the more texts are printed
+	in := []byte{}
+	if opts.input != "" {
+		var err error
+		if in, err = ioutil.ReadFile(opts.input); err != nil {
+			panic(err)
+		}
+	}
+
+	bin := loadBinary(*file)
+	machine := vm.New(bin, bytes.NewReader(in), ioutil.Discard)
+	closeAll := opts.configure(machine)
+	defer closeAll()
+
+	var strs []analysis.String
+	if *scan {
+		if _, err := machine.Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		strs = analysis.ScanStrings(machine.MemRange(0, uint16(len(bin))), *min)
+	} else {
+		var err error
+		if strs, err = analysis.TraceStrings(machine, *min); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	for _, s := range strs {
+		fmt.Printf("(%6d) %-6s %q\n", s.Addr, s.Kind, s.Text)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sfluor/synacor/tracediff"
+)
+
+// runTracediff handles the "tracediff" subcommand: the first divergence between two traces written by -trace
+func runTracediff(args []string) {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	ignoreAddresses := fs.Bool("ignore-addresses", false, "Compare the instructions and the registers only, not their addresses")
+	ignoreRegisters := fs.String("ignore-registers", "", "Comma-separated registers whose values are not compared, as named in the trace (e.g. R7 for the eighth)")
+	context := fs.Int("context", 5, "Number of instructions shown before and after the divergence")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s tracediff [options] <a.trace> <b.trace>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	opts := tracediff.Options{IgnoreAddresses: *ignoreAddresses, Context: *context}
+	for _, r := range strings.Split(*ignoreRegisters, ",") {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(r), "R"))
+		if err != nil || n < 0 || n > 7 {
+			fmt.Fprintf(os.Stderr, "Wrong -ignore-registers %q, should be R0 to R7\n", r)
+			os.Exit(1)
+		}
+		opts.IgnoreRegisters[n] = true
+	}
+
+	files := []*os.File{}
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	d, err := tracediff.Diff(files[0], files[1], opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if d == nil {
+		fmt.Println("The traces are identical")
+		return
+	}
+	d.Write(os.Stdout, fs.Arg(0), fs.Arg(1))
+	os.Exit(1)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/verify"
+)
+
+// runVerify handles the "verify" subcommand: it runs two implementations in lockstep and stops at the first divergence
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	file := fs.String("bin", "", "Path to the challenge.bin file, the embedded one is used by default")
+	input := fs.String("input", "", "Path to a file of commands played by both implementations (e.g. processed/moves.record)")
+	names := strings.Join(verify.Names(), ", ")
+	a := fs.String("a", "vm", "Implementation checked ("+names+")")
+	b := fs.String("b", "reference", "Implementation it is compared to ("+names+")")
+	steps := fs.Uint64("steps", 0, "Stop after this many instructions, 0 means no limit")
+	memoryEvery := fs.Uint64("memory-every", 100000, "Compare the whole memory every N instructions (the written cells are compared after each one), 0 to disable")
+	fs.Parse(args)
+
+	factories := []verify.Factory{}
+	for _, name := range []string{*a, *b} {
+		f, ok := verify.Implementations[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown implementation %q, should be one of %s\n", name, names)
+			os.Exit(2)
+		}
+		factories = append(factories, f)
+	}
+
+	in := []byte{}
+	if *input != "" {
+		b, err := ioutil.ReadFile(*input)
+		if err != nil {
+			panic(err)
+		}
+		in = stripCommands(b)
+		if len(in) != len(b) {
+			fmt.Fprintln(os.Stderr, "The debugger commands ($...) of the input are not played")
+		}
+	}
+
+	res := verify.Lockstep(loadBinary(*file), in, factories[0], factories[1], verify.Options{MaxSteps: *steps, MemoryEvery: *memoryEvery})
+	if res.Divergence != nil {
+		fmt.Println(res.Divergence)
+		os.Exit(1)
+	}
+
+	stopped := res.Stopped
+	if res.Err != nil {
+		stopped += ": " + res.Err.Error()
+	}
+	fmt.Printf("%s and %s agree on %d instructions (stopped: %s)\n", *a, *b, res.Steps, stopped)
+}
+
+// stripCommands removes the lines starting with $ and unescapes the ones starting with $$, only the vm implementation
+// understands them
+func stripCommands(input []byte) []byte {
+	lines := bytes.SplitAfter(input, []byte("\n"))
+	kept := [][]byte{}
+	for _, l := range lines {
+		if bytes.HasPrefix(l, []byte("$$")) {
+			kept = append(kept, l[1:])
+		} else if !bytes.HasPrefix(l, []byte("$")) {
+			kept = append(kept, l)
+		}
+	}
+	return bytes.Join(kept, nil)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sfluor/synacor/vm"
+	"github.com/sfluor/synacor/writeup"
+)
+
+// runWriteup handles the "writeup" subcommand: a transcript written by -record rendered as a playthrough
+func runWriteup(args []string) {
+	fs := flag.NewFlagSet("writeup", flag.ExitOnError)
+	format := fs.String("format", writeup.FormatMarkdown, "Format of the playthrough: markdown or html")
+	out := fs.String("out", "", "Path of the file to write, stdout if empty")
+	title := fs.String("title", "Synacor challenge playthrough", "Title of the document, none if empty")
+	redact := fs.Bool("redact", false, "Replace the challenge codes of the output")
+	timestamps := fs.Bool("timestamps", false, "Show when each command was typed, from the start of the session")
+	debugger := fs.Bool("debugger", false, "Keep the debugger commands in the playthrough")
+	prefix := fs.String("command-prefix", "$", "Prefix of the debugger commands of the session")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s writeup [options] <transcript>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	t, err := vm.ParseTranscript(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	opts := writeup.Options{Format: *format, Title: *title, RedactCodes: *redact, Timestamps: *timestamps, Debugger: *debugger, Prefix: *prefix}
+	if err := writeup.Write(w, t, opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,108 @@
+// Package codes checks the challenge codes captured during a session against the MD5 hashes of the milestones, so
+// that the progress can be tracked without sending the codes anywhere. The hashes are supplied by the user, one
+// milestone per line:
+//
+//	# Comment
+//	<MD5 of the code, in hexadecimal> <name of the milestone>
+//
+// The code read in the mirror is checked as printed and as read in the mirror, see autoplay.Mirror.
+package codes
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sfluor/synacor/autoplay"
+)
+
+// Milestone is a code expected at a step of the challenge, known by its hash
+type Milestone struct {
+	Hash string // MD5 of the code, in lowercase hexadecimal
+	Name string // Name of the milestone, e.g. tablet, empty if none was given
+}
+
+// Result tells whether a milestone was earned
+type Result struct {
+	Milestone
+	Code     string // Code matching the hash, empty if the milestone wasn't earned
+	Mirrored bool   // The code matches once read in a mirror
+}
+
+// Earned tells whether a code matched the milestone
+func (r Result) Earned() bool {
+	return r.Code != ""
+}
+
+// ParseHashes reads the milestones, one per line: a hash and an optional name. The blank lines and the ones starting
+// with # are ignored.
+func ParseHashes(r io.Reader) ([]Milestone, error) {
+	milestones := []Milestone{}
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		hash := strings.ToLower(fields[0])
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != md5.Size {
+			return nil, fmt.Errorf("line %d: %q is not an MD5 hash", n, fields[0])
+		}
+		milestones = append(milestones, Milestone{Hash: hash, Name: strings.Join(fields[1:], " ")})
+	}
+	return milestones, scanner.Err()
+}
+
+// LoadHashes reads the milestones from a file, see ParseHashes
+func LoadHashes(path string) ([]Milestone, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	milestones, err := ParseHashes(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return milestones, nil
+}
+
+// Hash returns the MD5 of a code, as written in the hash lists
+func Hash(code string) string {
+	sum := md5.Sum([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify checks the codes found against the milestones, in the order of the milestones
+func Verify(found []string, milestones []Milestone) []Result {
+	type match struct {
+		code     string
+		mirrored bool
+	}
+	hashes := map[string]match{}
+	for _, code := range found {
+		mirrored := autoplay.Mirror(code)
+		if _, ok := hashes[Hash(mirrored)]; !ok {
+			hashes[Hash(mirrored)] = match{code: mirrored, mirrored: true}
+		}
+		// A code as printed wins over the mirror of another one
+		hashes[Hash(code)] = match{code: code}
+	}
+
+	results := make([]Result, len(milestones))
+	for i, m := range milestones {
+		results[i] = Result{Milestone: m}
+		if h, ok := hashes[m.Hash]; ok {
+			results[i].Code, results[i].Mirrored = h.code, h.mirrored
+		}
+	}
+	return results
+}
@@ -0,0 +1,196 @@
+// Package compiler translates a memory image to a standalone Go program. The instructions found by the analysis
+// package become native code, one label per instruction, and the jumps to literal addresses become gotos. The jumps
+// through registers and the returns go through a switch on the address, the addresses that aren't compiled and the
+// instructions overwritten at runtime fall back to an interpreter, so self-modifying code keeps working.
+package compiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Compile writes the Go program running the state s (e.g. a snapshot taken after the binary decrypted its code) to w
+func Compile(w io.Writer, s *vm.Snapshot) error {
+	memory := s.Words()
+	p := analysis.AnalyzeAll(memory, s.Cursor)
+
+	code := map[uint16]analysis.Instruction{}
+	functions := map[uint16]string{}
+	for _, f := range p.SortedFunctions() {
+		functions[f.Entry] = f.Name()
+		for _, addr := range f.Addresses() {
+			code[addr], _ = f.Instruction(addr)
+		}
+	}
+
+	addrs := make([]uint16, 0, len(code))
+	for addr := range code {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintf(b, header, "compiled")
+	writeState(b, s)
+	io.WriteString(b, runtime)
+
+	fmt.Fprint(b, "\n// run executes the program from pc\nfunc run(pc uint16) error {\n")
+	fmt.Fprint(b, "\tvar (\n\t\tv   uint16\n\t\tch  byte\n\t\terr error\n\t)\n\t_, _, _ = v, ch, err\n\tgoto dispatch\n\n")
+	fmt.Fprint(b, "interp:\n\tif pc, err = step(pc); err != nil {\n\t\treturn err\n\t}\n\n")
+	fmt.Fprint(b, "dispatch:\n\tswitch pc {\n")
+	for _, addr := range addrs {
+		fmt.Fprintf(b, "\tcase %d:\n\t\tgoto L%d\n", addr, addr)
+	}
+	fmt.Fprint(b, "\t}\n\tgoto interp\n")
+
+	for i, addr := range addrs {
+		if name, ok := functions[addr]; ok {
+			fmt.Fprintf(b, "\n\t// %s\n", name)
+		}
+
+		ins := code[addr]
+		fmt.Fprintf(b, "L%d: // %s\n", addr, isa.Disassemble(memory, addr))
+		fmt.Fprintf(b, "\tif dirty[%d] {\n\t\tpc = %d\n\t\tgoto interp\n\t}\n", addr, addr)
+
+		lines, falls := instruction(ins, code)
+		if falls && (i+1 == len(addrs) || addrs[i+1] != ins.Next()) {
+			lines = append(lines, jump(fmt.Sprint(ins.Next()), ins.Next(), true, code)...)
+		}
+		for _, line := range lines {
+			fmt.Fprintf(b, "\t%s\n", line)
+		}
+	}
+	fmt.Fprint(b, "}\n")
+
+	return b.Flush()
+}
+
+// writeState writes the memory image, the registers, the stack and the start address
+func writeState(w io.Writer, s *vm.Snapshot) {
+	fmt.Fprint(w, "// image is the memory when the program was compiled\nvar image = []uint16{")
+	for i, v := range s.Words() {
+		if i%16 == 0 {
+			fmt.Fprint(w, "\n\t")
+		} else {
+			fmt.Fprint(w, " ")
+		}
+		fmt.Fprintf(w, "%d,", v)
+	}
+	fmt.Fprint(w, "\n}\n\n")
+
+	fmt.Fprintf(w, "var r = [8]uint16{%s}\n\n", join(s.Register[:]))
+	fmt.Fprintf(w, "var stack = []uint16{%s}\n\n", join(s.Stack))
+	fmt.Fprintf(w, "const start = %d\n", s.Cursor)
+}
+
+// instruction returns the Go code of an instruction and whether the execution goes on with the next one
+func instruction(ins analysis.Instruction, code map[uint16]analysis.Instruction) ([]string, bool) {
+	// Invalid operands are reported by the interpreter
+	interp := []string{fmt.Sprintf("pc = %d", ins.Addr), "goto interp"}
+
+	args := make([]string, len(ins.Args))
+	for i, w := range ins.Args {
+		switch {
+		case w < vm.M:
+			args[i] = fmt.Sprint(w)
+		case w < vm.M+8:
+			args[i] = fmt.Sprintf("r[%d]", w-vm.M)
+		default:
+			return interp, false
+		}
+	}
+
+	// The destination register of the operations storing a value
+	d := ""
+	switch ins.Op.Code {
+	case vm.SET, vm.POP, vm.EQ, vm.GT, vm.ADD, vm.MULT, vm.MOD, vm.AND, vm.OR, vm.NOT, vm.RMEM, vm.IN:
+		if ins.Args[0] < vm.M {
+			return interp, false
+		}
+		d = args[0]
+	}
+
+	target := func(i int) (string, uint16, bool) {
+		return args[i], ins.Args[i], ins.Args[i] < vm.M
+	}
+
+	switch ins.Op.Code {
+	case vm.HALT:
+		return []string{"return errHalt"}, false
+	case vm.SET:
+		return []string{d + " = " + args[1]}, true
+	case vm.PUSH:
+		return []string{"stack = append(stack, " + args[0] + ")"}, true
+	case vm.POP:
+		return []string{fmt.Sprintf("if v, err = pop(%d); err != nil {", ins.Addr), "\treturn err", "}", d + " = v"}, true
+	case vm.EQ, vm.GT:
+		cmp := map[uint16]string{vm.EQ: "==", vm.GT: ">"}[ins.Op.Code]
+		return []string{fmt.Sprintf("if %s %s %s {", args[1], cmp, args[2]), "\t" + d + " = 1", "} else {", "\t" + d + " = 0", "}"}, true
+	case vm.JMP:
+		expr, addr, literal := target(0)
+		return jump(expr, addr, literal, code), false
+	case vm.JT, vm.JF:
+		cmp := map[uint16]string{vm.JT: "!=", vm.JF: "=="}[ins.Op.Code]
+		expr, addr, literal := target(1)
+		lines := []string{fmt.Sprintf("if %s %s 0 {", args[0], cmp)}
+		for _, line := range jump(expr, addr, literal, code) {
+			lines = append(lines, "\t"+line)
+		}
+		return append(lines, "}"), true
+	case vm.ADD:
+		return []string{fmt.Sprintf("%s = uint16((uint32(%s) + uint32(%s)) %% 32768)", d, args[1], args[2])}, true
+	case vm.MULT:
+		return []string{fmt.Sprintf("%s = uint16((uint32(%s) * uint32(%s)) %% 32768)", d, args[1], args[2])}, true
+	case vm.MOD:
+		return []string{fmt.Sprintf("%s = %s %% %s", d, args[1], args[2])}, true
+	case vm.AND:
+		return []string{fmt.Sprintf("%s = %s & %s", d, args[1], args[2])}, true
+	case vm.OR:
+		return []string{fmt.Sprintf("%s = %s | %s", d, args[1], args[2])}, true
+	case vm.NOT:
+		return []string{fmt.Sprintf("%s = 0x7fff &^ %s", d, args[1])}, true
+	case vm.RMEM:
+		return []string{fmt.Sprintf("if v, err = rmem(%d, %s); err != nil {", ins.Addr, args[1]), "\treturn err", "}", d + " = v"}, true
+	case vm.WMEM:
+		return []string{fmt.Sprintf("if err = wmem(%d, %s, %s); err != nil {", ins.Addr, args[0], args[1]), "\treturn err", "}"}, true
+	case vm.CALL:
+		expr, addr, literal := target(0)
+		if !literal {
+			// The push doesn't change the registers but the target must be read first with a single expression
+			return []string{"v = " + expr, fmt.Sprintf("stack = append(stack, %d)", ins.Next()), "pc = v", "goto dispatch"}, false
+		}
+		return append([]string{fmt.Sprintf("stack = append(stack, %d)", ins.Next())}, jump(expr, addr, literal, code)...), false
+	case vm.RET:
+		return []string{"if len(stack) == 0 {", "\treturn errHalt", "}", "pc = stack[len(stack)-1]", "stack = stack[:len(stack)-1]", "goto dispatch"}, false
+	case vm.OUT:
+		return []string{"out.WriteRune(rune(" + args[0] + "))"}, true
+	case vm.IN:
+		return []string{"out.Flush()", "if ch, err = in.ReadByte(); err != nil {", "\treturn err", "}", d + " = uint16(ch)"}, true
+	case vm.NOOP:
+		return nil, true
+	}
+	return interp, false
+}
+
+// jump returns the code going to expr: a goto when it's a compiled literal address, the dispatch switch otherwise
+func jump(expr string, addr uint16, literal bool, code map[uint16]analysis.Instruction) []string {
+	if _, ok := code[addr]; literal && ok {
+		return []string{fmt.Sprintf("goto L%d", addr)}
+	}
+	return []string{"pc = " + expr, "goto dispatch"}
+}
+
+func join(values []uint16) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
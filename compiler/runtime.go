@@ -0,0 +1,197 @@
+package compiler
+
+// header starts the generated file, before the memory image
+const header = `// Code generated by "synacor compile". DO NOT EDIT.
+
+// Command %[1]s runs a Synacor binary compiled to Go: the instructions found by the analysis are native code, the
+// other ones and the ones overwritten at runtime are interpreted. It reads the input from stdin and writes the output
+// to stdout, the debugger commands are not supported.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+`
+
+// runtime is the part of the generated file shared by every binary: the state, the interpreter and main
+const runtime = `
+var (
+	mem   = make([]uint16, len(image))
+	dirty = make([]bool, len(image)) // Instructions overwritten since the compilation, they are interpreted
+	in    = bufio.NewReader(os.Stdin)
+	out   = bufio.NewWriter(os.Stdout)
+
+	errHalt = errors.New("halt")
+)
+
+func main() {
+	copy(mem, image)
+	err := run(start)
+	out.Flush()
+	if err != nil && err != errHalt && err != io.EOF {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// fault is an invalid memory access or operand of the instruction at pc
+func fault(pc uint16, what string, v uint16) error {
+	return fmt.Errorf("cursor %d: %s %d", pc, what, v)
+}
+
+// rmem reads addr like RMEM: the word as is, the values from 32768 aren't registers
+func rmem(pc, addr uint16) (uint16, error) {
+	if int(addr) >= len(mem) {
+		return 0, fault(pc, "invalid address", addr)
+	}
+	return mem[addr], nil
+}
+
+// wmem writes addr like WMEM and marks the instructions containing it as overwritten
+func wmem(pc, addr, v uint16) error {
+	if int(addr) >= len(mem) {
+		return fault(pc, "invalid address", addr)
+	}
+	mem[addr] = v
+	for a := int(addr) - 3; a <= int(addr); a++ {
+		if a >= 0 {
+			dirty[a] = true
+		}
+	}
+	return nil
+}
+
+// pop pops the top of the stack
+func pop(pc uint16) (uint16, error) {
+	if len(stack) == 0 {
+		return 0, fmt.Errorf("stack underflow: pop at cursor %d", pc)
+	}
+	v := stack[len(stack)-1]
+	stack = stack[:len(stack)-1]
+	return v, nil
+}
+
+// nargs is the number of arguments of each operation
+var nargs = [...]int{0, 2, 1, 1, 3, 3, 1, 2, 2, 3, 3, 3, 3, 3, 2, 2, 2, 1, 0, 1, 1, 0}
+
+// step interprets the instruction at pc and returns the address of the next one
+func step(pc uint16) (uint16, error) {
+	if int(pc) >= len(mem) {
+		return pc, fault(pc, "invalid address", pc)
+	}
+	op := mem[pc]
+	if int(op) >= len(nargs) {
+		return pc, fmt.Errorf("invalid opcode %d at cursor %d", op, pc)
+	}
+
+	// The arguments as values and the register of the first one
+	var args [3]uint16
+	dest := -1
+	for i := 0; i < nargs[op]; i++ {
+		a := int(pc) + 1 + i
+		if a >= len(mem) {
+			return pc, fault(pc, "invalid address", uint16(a))
+		}
+		switch v := mem[a]; {
+		case v < 32768:
+			args[i] = v
+		case v < 32776:
+			args[i] = r[v-32768]
+			if i == 0 {
+				dest = int(v - 32768)
+			}
+		default:
+			return pc, fault(pc, "invalid operand", v)
+		}
+	}
+
+	next := pc + 1 + uint16(nargs[op])
+	switch op {
+	case 1, 3, 4, 5, 9, 10, 11, 12, 13, 14, 15, 20:
+		if dest < 0 {
+			return pc, fault(pc, "invalid operand", mem[pc+1])
+		}
+	}
+
+	a, b, c := args[0], args[1], args[2]
+	switch op {
+	case 0:
+		return pc, errHalt
+	case 1:
+		r[dest] = b
+	case 2:
+		stack = append(stack, a)
+	case 3:
+		v, err := pop(pc)
+		if err != nil {
+			return pc, err
+		}
+		r[dest] = v
+	case 4:
+		r[dest] = 0
+		if b == c {
+			r[dest] = 1
+		}
+	case 5:
+		r[dest] = 0
+		if b > c {
+			r[dest] = 1
+		}
+	case 6:
+		next = a
+	case 7:
+		if a != 0 {
+			next = b
+		}
+	case 8:
+		if a == 0 {
+			next = b
+		}
+	case 9:
+		r[dest] = uint16((uint32(b) + uint32(c)) % 32768)
+	case 10:
+		r[dest] = uint16((uint32(b) * uint32(c)) % 32768)
+	case 11:
+		r[dest] = b % c
+	case 12:
+		r[dest] = b & c
+	case 13:
+		r[dest] = b | c
+	case 14:
+		r[dest] = 0x7fff &^ b
+	case 15:
+		v, err := rmem(pc, b)
+		if err != nil {
+			return pc, err
+		}
+		r[dest] = v
+	case 16:
+		if err := wmem(pc, a, b); err != nil {
+			return pc, err
+		}
+	case 17:
+		stack = append(stack, next)
+		next = a
+	case 18:
+		if len(stack) == 0 {
+			return pc, errHalt
+		}
+		next, _ = pop(pc)
+	case 19:
+		out.WriteRune(rune(a))
+	case 20:
+		out.Flush()
+		ch, err := in.ReadByte()
+		if err != nil {
+			return pc, err
+		}
+		r[dest] = uint16(ch)
+	}
+	return next, nil
+}
+`
@@ -0,0 +1,574 @@
+// Package dap implements the Debug Adapter Protocol so editors like VS Code can debug a binary running in the VM.
+//
+// Breakpoints are set on a disassembly listing written at launch: line n of the listing is the n-th instruction. The
+// program output goes to the debug console, lines starting with > typed in the console are sent to the program input and
+// anything else is evaluated as an expression (see the conditional breakpoints of the vm package).
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/vm"
+)
+
+// threadID is the only thread of the VM
+const threadID = 1
+
+// References of the variables scopes
+const (
+	registersRef = iota + 1
+	stackRef
+)
+
+// command resumes the execution
+type command int
+
+const (
+	cmdContinue command = iota
+	cmdStepIn
+	cmdStepOver
+	cmdStepOut
+)
+
+// Session is a debugging session driven by a client
+type Session struct {
+	r *bufio.Reader
+	w io.Writer
+
+	mu  sync.Mutex // Guards w and seq
+	seq int
+
+	bin       []uint16
+	configure func(machine *vm.VM) // Applied to the machine at launch
+
+	machine     *vm.VM
+	input       *inputQueue
+	output      *lineWriter
+	listing     string   // Path of the disassembly listing
+	addrs       []uint16 // Address of each line of the listing
+	stopOnEntry bool
+
+	state       sync.Mutex        // Guards running and breakpoints
+	running     bool              // The machine is owned by the execution goroutine
+	breakpoints map[uint16]string // Condition of the breakpoints by address, empty for none
+
+	commands chan command
+	pause    int32 // Set to 1 to stop the execution at the next instruction
+}
+
+// NewSession returns a session debugging bin over r and w (stdin and stdout or a TCP connection). configure can be nil,
+// it's called on the machine when the client launches it.
+func NewSession(bin []uint16, r io.Reader, w io.Writer, configure func(machine *vm.VM)) *Session {
+	return &Session{
+		r:           bufio.NewReader(r),
+		w:           w,
+		bin:         bin,
+		configure:   configure,
+		breakpoints: map[uint16]string{},
+		commands:    make(chan command, 1),
+	}
+}
+
+// Serve handles the requests until the client disconnects
+func (s *Session) Serve() error {
+	for {
+		req, err := readRequest(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !s.handle(req) {
+			return nil
+		}
+	}
+}
+
+// handle answers a request, it returns false when the session is over
+func (s *Session) handle(req *request) bool {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsSetVariable":              true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil)
+
+	case "launch":
+		args := launchArguments{}
+		err := json.Unmarshal(req.Arguments, &args)
+		if err == nil {
+			err = s.launch(args)
+		}
+		s.respond(req, nil, err)
+		if err == nil {
+			s.event("initialized", nil)
+		}
+
+	case "setBreakpoints":
+		args := setBreakpointsArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.respond(req, nil, err)
+			break
+		}
+		s.respond(req, map[string]interface{}{"breakpoints": s.setBreakpoints(args.Breakpoints)}, nil)
+
+	case "setExceptionBreakpoints":
+		s.respond(req, nil, nil)
+
+	case "configurationDone":
+		s.respond(req, nil, nil)
+		if s.stopOnEntry {
+			s.stopped("entry", "")
+		} else {
+			s.resume(cmdContinue)
+		}
+
+	case "threads":
+		s.respond(req, map[string]interface{}{"threads": []thread{{threadID, "synacor"}}}, nil)
+
+	case "continue":
+		s.respond(req, map[string]bool{"allThreadsContinued": true}, nil)
+		s.resume(cmdContinue)
+
+	case "next":
+		s.respond(req, nil, nil)
+		s.resume(cmdStepOver)
+
+	case "stepIn":
+		s.respond(req, nil, nil)
+		s.resume(cmdStepIn)
+
+	case "stepOut":
+		s.respond(req, nil, nil)
+		s.resume(cmdStepOut)
+
+	case "pause":
+		s.respond(req, nil, nil)
+		atomic.StoreInt32(&s.pause, 1)
+
+	case "stackTrace", "scopes", "variables", "setVariable":
+		if s.isRunning() {
+			s.respond(req, nil, fmt.Errorf("the program is running"))
+			break
+		}
+		body, err := s.inspect(req)
+		s.respond(req, body, err)
+
+	case "evaluate":
+		args := evaluateArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.respond(req, nil, err)
+			break
+		}
+		result, err := s.evaluate(args)
+		s.respond(req, map[string]interface{}{"result": result, "variablesReference": 0}, err)
+
+	case "disconnect", "terminate":
+		s.respond(req, nil, nil)
+		return false
+
+	default:
+		s.respond(req, nil, fmt.Errorf("unsupported request %s", req.Command))
+	}
+
+	return true
+}
+
+// launch creates the machine and writes the listing
+func (s *Session) launch(args launchArguments) error {
+	bin := s.bin
+	if args.Program != "" {
+		var err error
+		if bin, err = loader.LoadFile(args.Program); err != nil {
+			return err
+		}
+	}
+
+	s.input = &inputQueue{lines: make(chan []byte, 64)}
+	var in io.Reader = s.input
+	if args.Input != "" {
+		f, err := os.Open(args.Input)
+		if err != nil {
+			return err
+		}
+		// f stays open for the whole session
+		in = io.MultiReader(f, s.input)
+	}
+
+	s.output = &lineWriter{session: s}
+	s.machine = vm.New(bin, in, s.output)
+	if s.configure != nil {
+		s.configure(s.machine)
+	}
+
+	s.listing = args.Listing
+	if s.listing == "" {
+		s.listing = filepath.Join(os.TempDir(), "synacor.asm")
+	}
+	if err := s.writeListing(bin); err != nil {
+		return err
+	}
+
+	s.stopOnEntry = args.StopOnEntry
+	go s.execute()
+
+	return nil
+}
+
+// writeListing disassembles bin, one instruction per line
+func (s *Session) writeListing(bin []uint16) error {
+	f, err := os.Create(s.listing)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	s.addrs = nil
+	for addr := 0; addr < len(bin); {
+		s.addrs = append(s.addrs, uint16(addr))
+		fmt.Fprintf(w, "(%6d) | %s\n", addr, isa.Disassemble(bin, uint16(addr)))
+
+		addr += isa.Operands(bin[addr]) + 1
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// line returns the line of the listing of addr, or of the closest instruction before it if the program jumped in the
+// middle of an instruction
+func (s *Session) line(addr uint16) int {
+	return sort.Search(len(s.addrs), func(i int) bool { return s.addrs[i] > addr })
+}
+
+// setBreakpoints replaces the breakpoints by the ones of the listing lines
+func (s *Session) setBreakpoints(bps []sourceBreakpoint) []breakpointBody {
+	s.state.Lock()
+	defer s.state.Unlock()
+
+	s.breakpoints = map[uint16]string{}
+	bodies := []breakpointBody{}
+	for _, bp := range bps {
+		if bp.Line < 1 || bp.Line > len(s.addrs) {
+			bodies = append(bodies, breakpointBody{Line: bp.Line, Message: "not an instruction of the listing"})
+			continue
+		}
+
+		s.breakpoints[s.addrs[bp.Line-1]] = bp.Condition
+		bodies = append(bodies, breakpointBody{Verified: true, Line: bp.Line})
+	}
+
+	return bodies
+}
+
+// hitBreakpoint returns true if the execution should stop on a breakpoint at addr
+func (s *Session) hitBreakpoint(addr uint16) bool {
+	s.state.Lock()
+	cond, ok := s.breakpoints[addr]
+	s.state.Unlock()
+
+	if !ok || cond == "" {
+		return ok
+	}
+
+	v, err := s.machine.Eval(cond)
+	if err != nil {
+		s.event("output", outputBody{"stderr", fmt.Sprintf("Breakpoint %d: could not evaluate %q: %s\n", addr, cond, err)})
+		return true
+	}
+	return v != 0
+}
+
+func (s *Session) isRunning() bool {
+	s.state.Lock()
+	defer s.state.Unlock()
+	return s.running
+}
+
+func (s *Session) setRunning(running bool) {
+	s.state.Lock()
+	s.running = running
+	s.state.Unlock()
+}
+
+// resume hands the machine to the execution goroutine
+func (s *Session) resume(cmd command) {
+	if s.machine == nil || s.isRunning() {
+		return
+	}
+	s.setRunning(true)
+	s.commands <- cmd
+}
+
+// stopped tells the client the machine is stopped
+func (s *Session) stopped(reason, description string) {
+	if s.output != nil {
+		s.output.Flush()
+	}
+	s.event("stopped", stoppedBody{Reason: reason, Description: description, ThreadID: threadID, AllThreadsStopped: true})
+}
+
+// execute runs the machine for each command until the program stops
+func (s *Session) execute() {
+	for cmd := range s.commands {
+		reason, err := s.run(cmd)
+		if err != nil {
+			s.output.Flush()
+
+			exit, err := vm.ExitReasonOf(err)
+			code := 0
+			if err != nil {
+				s.event("output", outputBody{"stderr", fmt.Sprintf("VM error: %s\n", err)})
+				code = 1
+			} else {
+				s.event("output", outputBody{"console", fmt.Sprintf("VM stopped: %s\n", exit)})
+			}
+			s.event("exited", map[string]int{"exitCode": code})
+			s.event("terminated", nil)
+			return
+		}
+
+		s.setRunning(false)
+		s.stopped(reason, "")
+	}
+}
+
+// run executes the machine until the command is over, it returns the reason of the stop or the error returned by Step
+// when the program stopped
+func (s *Session) run(cmd command) (string, error) {
+	m := s.machine
+
+	// Step over a call by running until it returns
+	returnTo, depth := -1, m.CallDepth()
+	if cmd == cmdStepOver && m.Memory(m.Cursor()) == vm.CALL {
+		returnTo = int(m.Cursor()) + 2
+	}
+
+	for first := true; ; first = false {
+		if atomic.CompareAndSwapInt32(&s.pause, 1, 0) {
+			return "pause", nil
+		}
+		if !first && s.hitBreakpoint(m.Cursor()) {
+			return "breakpoint", nil
+		}
+
+		op := m.Memory(m.Cursor())
+		if err := m.Step(); err != nil {
+			return "", err
+		}
+
+		switch cmd {
+		case cmdStepIn:
+			return "step", nil
+		case cmdStepOver:
+			if returnTo < 0 || (int(m.Cursor()) == returnTo && m.CallDepth() == depth) {
+				return "step", nil
+			}
+		case cmdStepOut:
+			if op == vm.RET && m.CallDepth() < depth {
+				return "step", nil
+			}
+		}
+	}
+}
+
+// inspect answers the requests reading the state of a stopped machine
+func (s *Session) inspect(req *request) (interface{}, error) {
+	m := s.machine
+	if m == nil {
+		return nil, fmt.Errorf("the program is not launched")
+	}
+
+	switch req.Command {
+	case "stackTrace":
+		src := &source{Name: filepath.Base(s.listing), Path: s.listing}
+		calls := m.CallStack()
+
+		frames := []stackFrame{}
+		addr := m.Cursor()
+		for i := len(calls); i >= 0; i-- {
+			name := "main"
+			if i > 0 {
+				name = fmt.Sprintf("fn_%d", calls[i-1].Target)
+			}
+			frames = append(frames, stackFrame{
+				ID:                          len(frames),
+				Name:                        name,
+				Source:                      src,
+				Line:                        s.line(addr),
+				Column:                      1,
+				InstructionPointerReference: strconv.Itoa(int(addr)),
+			})
+			if i > 0 {
+				addr = calls[i-1].Site
+			}
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+
+	case "scopes":
+		return map[string]interface{}{"scopes": []scope{
+			{Name: "Registers", VariablesReference: registersRef},
+			{Name: "Stack", VariablesReference: stackRef},
+		}}, nil
+
+	case "variables":
+		args := variablesArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+
+		vars := []variable{}
+		switch args.VariablesReference {
+		case registersRef:
+			for r := 0; r < 8; r++ {
+				vars = append(vars, variable{Name: fmt.Sprintf("R%d", r), Value: strconv.Itoa(int(m.Register(r)))})
+			}
+		case stackRef:
+			// Top of the stack first
+			stack := m.Stack()
+			for i := len(stack) - 1; i >= 0; i-- {
+				vars = append(vars, variable{Name: strconv.Itoa(len(stack) - 1 - i), Value: strconv.Itoa(int(stack[i]))})
+			}
+		}
+		return map[string]interface{}{"variables": vars}, nil
+
+	case "setVariable":
+		args := setVariableArguments{}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return nil, err
+		}
+
+		r, err := strconv.Atoi(strings.TrimPrefix(args.Name, "R"))
+		if args.VariablesReference != registersRef || err != nil || r < 0 || r > 7 {
+			return nil, fmt.Errorf("only the registers can be modified")
+		}
+		v, err := strconv.ParseUint(args.Value, 0, 16)
+		if err != nil || v >= vm.M {
+			return nil, fmt.Errorf("wrong value %s", args.Value)
+		}
+
+		m.SetRegister(r, uint16(v))
+		return map[string]string{"value": strconv.Itoa(int(v))}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported request %s", req.Command)
+}
+
+// evaluate sends the console lines starting with > to the program and evaluates the other expressions
+func (s *Session) evaluate(args evaluateArguments) (string, error) {
+	if s.machine == nil {
+		return "", fmt.Errorf("the program is not launched")
+	}
+
+	if args.Context == "repl" && strings.HasPrefix(args.Expression, ">") {
+		s.input.push(strings.TrimSpace(strings.TrimPrefix(args.Expression, ">")) + "\n")
+		return "", nil
+	}
+
+	if s.isRunning() {
+		return "", fmt.Errorf("the program is running")
+	}
+
+	v, err := s.machine.Eval(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(v), nil
+}
+
+// respond answers req with body, or with err if it's not nil
+func (s *Session) respond(req *request, body interface{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	resp := response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: err == nil, Command: req.Command, Body: body}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	writeMessage(s.w, resp)
+}
+
+// event sends an event to the client
+func (s *Session) event(name string, body interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	writeMessage(s.w, event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// inputQueue is the input of the program, the lines typed in the debug console wait there until the program reads them
+type inputQueue struct {
+	lines chan []byte
+	buf   []byte
+}
+
+func (q *inputQueue) push(line string) {
+	q.lines <- []byte(line)
+}
+
+func (q *inputQueue) Read(p []byte) (int, error) {
+	if len(q.buf) == 0 {
+		line, ok := <-q.lines
+		if !ok {
+			return 0, io.EOF
+		}
+		q.buf = line
+	}
+
+	n := copy(p, q.buf)
+	q.buf = q.buf[n:]
+	return n, nil
+}
+
+// lineWriter sends the program output to the client line by line instead of byte by byte
+type lineWriter struct {
+	session *Session
+	mu      sync.Mutex
+	buf     []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	w.buf = append(w.buf, p...)
+	flush := len(p) > 0 && p[len(p)-1] == '\n'
+	w.mu.Unlock()
+
+	if flush {
+		w.Flush()
+	}
+	return len(p), nil
+}
+
+// Flush sends the buffered output
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	out := string(w.buf)
+	w.buf = w.buf[:0]
+	w.mu.Unlock()
+
+	if out != "" {
+		w.session.event("output", outputBody{"stdout", out})
+	}
+}
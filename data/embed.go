@@ -0,0 +1,15 @@
+// Package data embeds the challenge binary so that tools can run it without the file, and the manifest of the known
+// binaries
+package data
+
+import _ "embed" // For go:embed
+
+// Challenge is the content of challenge.bin
+//
+//go:embed challenge.bin
+var Challenge []byte
+
+// Versions is the content of versions.json, the manifest of the known challenge binaries
+//
+//go:embed versions.json
+var Versions []byte
@@ -0,0 +1,397 @@
+// Package decompiler turns the functions found by the analysis package into pseudo-Go
+//
+// Conditional jumps over a block of code become if and if/else, jumps back to an earlier address become for loops
+// (with break and continue when they leave or restart the loop) and the jumps that don't fit these shapes are kept
+// as goto. In the output the registers are r0 to r7, the arithmetic is modulo 32768 and the comparisons are 0 or 1.
+package decompiler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sfluor/synacor/analysis"
+	"github.com/sfluor/synacor/vm"
+)
+
+// condition is a comparison between two operands
+type condition struct {
+	l, op, r string
+}
+
+// negations of the comparison operators
+var negations = map[string]string{"==": "!=", "!=": "==", ">": "<=", "<=": ">"}
+
+func (c condition) not() condition {
+	return condition{c.l, negations[c.op], c.r}
+}
+
+func (c condition) String() string {
+	return c.l + " " + c.op + " " + c.r
+}
+
+// loop is the innermost loop around the code being written
+type loop struct {
+	head, exit uint16
+}
+
+// writer writes the pseudocode of one function
+type writer struct {
+	f      *analysis.Function
+	addrs  []uint16            // Addresses of the instructions, sorted
+	backs  map[uint16][]uint16 // Addresses of the jumps going back to each address
+	labels map[uint16]bool     // Addresses that need a label
+	gotos  map[uint16]bool     // Addresses that are the target of a goto
+	lines  []string
+}
+
+// Decompile writes the pseudocode of the functions starting at entries, or of every function if there are none
+func Decompile(w io.Writer, p *analysis.Program, entries ...uint16) error {
+	fns, err := p.Select(entries)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(w)
+	for i, f := range fns {
+		if i > 0 {
+			bw.WriteString("\n")
+		}
+		for _, line := range Function(p, f) {
+			bw.WriteString(line + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// Function returns the lines of pseudocode of a function
+func Function(p *analysis.Program, f *analysis.Function) []string {
+	wr := &writer{f: f, addrs: f.Addresses(), backs: map[uint16][]uint16{}}
+	for _, addr := range wr.addrs {
+		ins, _ := f.Instruction(addr)
+		if target, ok := ins.Target(); ok && ins.Op.Code != vm.CALL && target <= addr {
+			wr.backs[target] = append(wr.backs[target], addr)
+		}
+	}
+
+	// The gotos are only known once the code is written: write it twice
+	wr.labels = map[uint16]bool{}
+	wr.body()
+	wr.labels = wr.gotos
+	wr.body()
+
+	header := []string{}
+	if len(f.Callers) != 0 {
+		names := []string{}
+		for _, caller := range f.Callers {
+			names = append(names, p.Functions[caller].Name())
+		}
+		header = append(header, fmt.Sprintf("// %s is called by %s", f.Name(), strings.Join(names, ", ")))
+	}
+	header = append(header, fmt.Sprintf("func %s() {", f.Name()))
+
+	return append(append(header, wr.lines...), "}")
+}
+
+// body writes the whole function
+func (wr *writer) body() {
+	wr.lines, wr.gotos = nil, map[uint16]bool{}
+	if len(wr.addrs) == 0 {
+		return
+	}
+
+	// Code placed before the entry
+	if wr.addrs[0] != wr.f.Entry {
+		wr.jump(wr.f.Entry, 1, nil)
+	}
+	wr.block(wr.addrs[0], int(wr.addrs[len(wr.addrs)-1])+1, 1, nil, -1)
+}
+
+// block writes the instructions between lo (included) and hi (excluded). skip is the address of a loop head that must
+// not be turned into a loop again, -1 if none.
+func (wr *writer) block(lo uint16, hi int, depth int, l *loop, skip int) {
+	i := sort.Search(len(wr.addrs), func(i int) bool { return wr.addrs[i] >= lo })
+
+	for i < len(wr.addrs) && int(wr.addrs[i]) < hi {
+		ins, _ := wr.f.Instruction(wr.addrs[i])
+
+		if int(ins.Addr) != skip {
+			if wr.labels[ins.Addr] {
+				wr.line(depth-1, fmt.Sprintf("L_%d:", ins.Addr))
+			}
+
+			if back, ok := wr.loopEnd(ins.Addr, hi); ok {
+				end := wr.loop(ins, back, depth)
+				i = wr.index(end)
+				continue
+			}
+		}
+
+		target, literal := ins.Target()
+		switch {
+		case (ins.Op.Code == vm.JT || ins.Op.Code == vm.JF) && literal && target > ins.Addr && int(target) <= hi:
+			// Jump over a block of code: if, maybe with an else when the block ends by jumping over another one
+			cond := wr.condition(ins).not()
+			last, hasLast := wr.last(ins.Next(), target)
+			end, _ := last.Target()
+
+			if hasLast && last.Op.Code == vm.JMP && end > target && int(end) <= hi && !wr.isLoopJump(last, l) {
+				wr.line(depth, fmt.Sprintf("if %s {", cond))
+				wr.block(ins.Next(), int(last.Addr), depth+1, l, -1)
+				wr.swallowed(last, depth+1, l)
+				wr.line(depth, "} else {")
+				wr.block(target, int(end), depth+1, l, -1)
+				wr.line(depth, "}")
+				i = wr.index(end)
+				continue
+			}
+
+			wr.line(depth, fmt.Sprintf("if %s {", cond))
+			wr.block(ins.Next(), int(target), depth+1, l, -1)
+			wr.line(depth, "}")
+			i = wr.index(target)
+			continue
+
+		case (ins.Op.Code == vm.JT || ins.Op.Code == vm.JF) && literal:
+			wr.line(depth, fmt.Sprintf("if %s {", wr.condition(ins)))
+			wr.jump(target, depth+1, l)
+			wr.line(depth, "}")
+
+		case ins.Op.Code == vm.JT || ins.Op.Code == vm.JF:
+			wr.line(depth, fmt.Sprintf("if %s {", wr.condition(ins)))
+			wr.line(depth+1, fmt.Sprintf("jump(%s)", operand(ins.Args[1])))
+			wr.line(depth, "}")
+
+		case ins.Op.Code == vm.JMP && literal:
+			// A jump to the next instruction does nothing
+			if i+1 < len(wr.addrs) && wr.addrs[i+1] == target && int(target) < hi {
+				break
+			}
+			wr.jump(target, depth, l)
+
+		case ins.Op.Code == vm.OUT:
+			// Merge the characters printed one after the other
+			text, n := wr.text(i)
+			if n > 0 {
+				wr.line(depth, fmt.Sprintf("print(%q)", text))
+				i += n
+				continue
+			}
+			wr.line(depth, fmt.Sprintf("putc(%s)", operand(ins.Args[0])))
+
+		default:
+			if s := statement(ins); s != "" {
+				wr.line(depth, s)
+			}
+		}
+
+		i++
+	}
+}
+
+// loop writes the loop starting at head and ending with the jump back, it returns the address following the loop
+func (wr *writer) loop(head analysis.Instruction, back uint16, depth int) uint16 {
+	last, _ := wr.f.Instruction(back)
+	l := &loop{head: head.Addr, exit: last.Next()}
+
+	if last.Op.Code != vm.JMP {
+		// The condition is checked at the end
+		wr.line(depth, "for {")
+		wr.block(head.Addr, int(last.Addr), depth+1, l, int(head.Addr))
+		if wr.labels[last.Addr] {
+			wr.line(depth, fmt.Sprintf("L_%d:", last.Addr))
+		}
+		wr.line(depth+1, fmt.Sprintf("if %s {", wr.condition(last).not()))
+		wr.line(depth+2, "break")
+		wr.line(depth+1, "}")
+		wr.line(depth, "}")
+		return l.exit
+	}
+
+	// The condition is checked at the start when the loop begins by jumping after its end
+	if target, ok := head.Target(); ok && (head.Op.Code == vm.JT || head.Op.Code == vm.JF) && target == l.exit && head.Addr != last.Addr {
+		wr.line(depth, fmt.Sprintf("for %s {", wr.condition(head).not()))
+		wr.block(head.Next(), int(last.Addr), depth+1, l, -1)
+		wr.swallowed(last, depth+1, l)
+		wr.line(depth, "}")
+		return l.exit
+	}
+
+	wr.line(depth, "for {")
+	wr.block(head.Addr, int(last.Addr), depth+1, l, int(head.Addr))
+	wr.swallowed(last, depth+1, l)
+	wr.line(depth, "}")
+	return l.exit
+}
+
+// swallowed writes the JMP closing a loop or a then block when a goto needs its label, the jump is implied otherwise
+func (wr *writer) swallowed(ins analysis.Instruction, depth int, l *loop) {
+	if !wr.labels[ins.Addr] {
+		return
+	}
+	target, _ := ins.Target()
+	wr.line(depth-1, fmt.Sprintf("L_%d:", ins.Addr))
+	wr.jump(target, depth, l)
+}
+
+// loopEnd returns the last jump back to head placed before hi
+func (wr *writer) loopEnd(head uint16, hi int) (uint16, bool) {
+	end, found := uint16(0), false
+	for _, addr := range wr.backs[head] {
+		if int(addr) < hi && (!found || addr > end) {
+			end, found = addr, true
+		}
+	}
+	return end, found
+}
+
+// isLoopJump returns true if ins is a break or a continue of l
+func (wr *writer) isLoopJump(ins analysis.Instruction, l *loop) bool {
+	target, _ := ins.Target()
+	return l != nil && (target == l.exit || target == l.head)
+}
+
+// jump writes an unconditional jump to target
+func (wr *writer) jump(target uint16, depth int, l *loop) {
+	switch {
+	case l != nil && target == l.exit:
+		wr.line(depth, "break")
+	case l != nil && target == l.head:
+		wr.line(depth, "continue")
+	default:
+		wr.gotos[target] = true
+		wr.line(depth, fmt.Sprintf("goto L_%d", target))
+	}
+}
+
+// condition returns the condition under which the JT or JF ins jumps. A test of a register set by the EQ or GT right
+// before is replaced by the comparison itself.
+func (wr *writer) condition(ins analysis.Instruction) condition {
+	a := operand(ins.Args[0])
+	c := condition{a, "!=", "0"}
+
+	if _, leader := wr.f.Blocks[ins.Addr]; !leader {
+		if i := wr.index(ins.Addr); i > 0 {
+			prev, _ := wr.f.Instruction(wr.addrs[i-1])
+			if prev.Next() == ins.Addr && prev.Args != nil && prev.Args[0] == ins.Args[0] && ins.Args[0] >= vm.M {
+				switch prev.Op.Code {
+				case vm.EQ:
+					c = condition{operand(prev.Args[1]), "==", operand(prev.Args[2])}
+				case vm.GT:
+					c = condition{operand(prev.Args[1]), ">", operand(prev.Args[2])}
+				}
+			}
+		}
+	}
+
+	if ins.Op.Code == vm.JF {
+		return c.not()
+	}
+	return c
+}
+
+// text returns the characters printed by the OUT with a literal operand starting at the index i, n is the number of
+// instructions merged
+func (wr *writer) text(i int) (string, int) {
+	b := &strings.Builder{}
+	n := 0
+	for j := i; j < len(wr.addrs); j++ {
+		ins, _ := wr.f.Instruction(wr.addrs[j])
+		if ins.Op.Code != vm.OUT || ins.Args[0] >= vm.M {
+			break
+		}
+		if _, leader := wr.f.Blocks[ins.Addr]; j > i && (leader || wr.labels[ins.Addr]) {
+			break
+		}
+		b.WriteRune(rune(ins.Args[0]))
+		n++
+	}
+	return b.String(), n
+}
+
+// last returns the last instruction between lo (included) and hi (excluded)
+func (wr *writer) last(lo, hi uint16) (analysis.Instruction, bool) {
+	i := wr.index(hi) - 1
+	if i < 0 || wr.addrs[i] < lo {
+		return analysis.Instruction{}, false
+	}
+	return wr.f.Instruction(wr.addrs[i])
+}
+
+// index returns the index of the first instruction at or after addr
+func (wr *writer) index(addr uint16) int {
+	return sort.Search(len(wr.addrs), func(i int) bool { return wr.addrs[i] >= addr })
+}
+
+func (wr *writer) line(depth int, s string) {
+	if depth < 0 {
+		depth = 0
+	}
+	wr.lines = append(wr.lines, strings.Repeat("\t", depth)+s)
+}
+
+// statement returns the pseudocode of an instruction that doesn't jump
+func statement(ins analysis.Instruction) string {
+	args := make([]string, len(ins.Args))
+	for i, v := range ins.Args {
+		args[i] = operand(v)
+	}
+
+	switch ins.Op.Code {
+	case vm.HALT:
+		return "halt()"
+	case vm.SET:
+		return fmt.Sprintf("%s = %s", args[0], args[1])
+	case vm.PUSH:
+		return fmt.Sprintf("push(%s)", args[0])
+	case vm.POP:
+		return fmt.Sprintf("%s = pop()", args[0])
+	case vm.EQ:
+		return fmt.Sprintf("%s = %s == %s", args[0], args[1], args[2])
+	case vm.GT:
+		return fmt.Sprintf("%s = %s > %s", args[0], args[1], args[2])
+	case vm.ADD:
+		return fmt.Sprintf("%s = %s + %s", args[0], args[1], args[2])
+	case vm.MULT:
+		return fmt.Sprintf("%s = %s * %s", args[0], args[1], args[2])
+	case vm.MOD:
+		return fmt.Sprintf("%s = %s %% %s", args[0], args[1], args[2])
+	case vm.AND:
+		return fmt.Sprintf("%s = %s & %s", args[0], args[1], args[2])
+	case vm.OR:
+		return fmt.Sprintf("%s = %s | %s", args[0], args[1], args[2])
+	case vm.NOT:
+		return fmt.Sprintf("%s = ^%s", args[0], args[1])
+	case vm.RMEM:
+		return fmt.Sprintf("%s = mem[%s]", args[0], args[1])
+	case vm.WMEM:
+		return fmt.Sprintf("mem[%s] = %s", args[0], args[1])
+	case vm.CALL:
+		if ins.Args[0] == 0 {
+			return "main()"
+		}
+		if ins.Args[0] < vm.M {
+			return fmt.Sprintf("fn_%d()", ins.Args[0])
+		}
+		return fmt.Sprintf("call(%s)", args[0])
+	case vm.RET:
+		return "return"
+	case vm.IN:
+		return fmt.Sprintf("%s = getc()", args[0])
+	case vm.JMP:
+		return fmt.Sprintf("jump(%s)", args[0])
+	}
+
+	// NOOP
+	return ""
+}
+
+// operand returns the name of a register or the literal value
+func operand(v uint16) string {
+	if v >= vm.M {
+		return fmt.Sprintf("r%d", v-vm.M)
+	}
+	return fmt.Sprintf("%d", v)
+}
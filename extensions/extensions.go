@@ -0,0 +1,94 @@
+// Package extensions provides syscall-like opcodes for programs written for the VM rather than for the challenge:
+// printing a number, reading a line and drawing a random number
+package extensions
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Opcodes of the extensions, following NOOP
+const (
+	PRINTNUM uint16 = 22 + iota // printnum a: write the decimal value of <a>
+	READLINE                    // readline a b c: read a line at <b>, <c> words at most with the final 0, set <a> to its length
+	RAND                        // rand a b: set <a> to a random number lower than <b> (or than M if <b> is 0)
+)
+
+var (
+	once sync.Once
+	err  error
+)
+
+// Register registers the extensions with vm.RegisterOpcode, the VMs execute them once their EnableExtensions is
+// called. It can be called several times.
+func Register() error {
+	once.Do(func() {
+		for _, ext := range []struct {
+			code  uint16
+			name  string
+			nargs uint16
+			fn    vm.Handler
+		}{
+			{PRINTNUM, "printnum", 1, printNum},
+			{READLINE, "readline", 3, readLine},
+			{RAND, "rand", 2, randN},
+		} {
+			if err = vm.RegisterOpcode(ext.code, ext.name, ext.nargs, ext.fn); err != nil {
+				return
+			}
+		}
+	})
+	return err
+}
+
+func printNum(machine *vm.VM) error {
+	machine.Print(strconv.Itoa(int(machine.Arg(0))))
+	return nil
+}
+
+func readLine(machine *vm.VM) error {
+	addr, size := machine.Arg(1), machine.Arg(2)
+	if size == 0 {
+		return fmt.Errorf("%w: readline of 0 words", vm.ErrInvalidOperand)
+	}
+	if int(addr)+int(size) > vm.M {
+		return fmt.Errorf("%w: readline of %d words at %d is out of memory", vm.ErrInvalidAddress, size, addr)
+	}
+
+	n := uint16(0)
+	for {
+		b, err := machine.ReadByte()
+		if err == io.EOF && n > 0 {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if b == '\n' {
+			break
+		}
+		// The rest of a line too long is dropped
+		if n < size-1 {
+			machine.SetMemory(addr+n, uint16(b))
+			n++
+		}
+	}
+
+	machine.SetMemory(addr+n, 0)
+	machine.SetDest(n)
+	return nil
+}
+
+func randN(machine *vm.VM) error {
+	n := int(machine.Arg(1))
+	if n == 0 {
+		n = vm.M
+	}
+	machine.SetDest(uint16(rand.Intn(n)))
+	return nil
+}
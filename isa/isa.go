@@ -0,0 +1,224 @@
+// Package isa describes the instruction set of the architecture: the codes, names and operands of the operations and
+// how an instruction is printed. The VM, its tracer and debugger, the assembler and the disassemblers all read this
+// description instead of keeping their own tables.
+package isa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sfluor/synacor/symbols"
+)
+
+// M is the first register: a word below it is a literal, a word from M to M+7 is one of the registers R0 to R7
+const M = 32768
+
+// Op codes
+const (
+	HALT uint16 = iota
+	SET
+	PUSH
+	POP
+	EQ
+	GT
+	JMP
+	JT
+	JF
+	ADD
+	MULT
+	MOD
+	AND
+	OR
+	NOT
+	RMEM
+	WMEM
+	CALL
+	RET
+	OUT
+	IN
+	NOOP
+)
+
+// Operation describes an operation of the architecture
+type Operation struct {
+	Code  uint16 // Code of the operation
+	Name  string // Name of the operation
+	NArgs uint16 // Number of arguments
+}
+
+// Operations lists every operation of the spec, indexed by its code
+var Operations = []Operation{
+	{HALT, "halt", 0},
+	{SET, "set", 2},
+	{PUSH, "push", 1},
+	{POP, "pop", 1},
+	{EQ, "eq", 3},
+	{GT, "gt", 3},
+	{JMP, "jmp", 1},
+	{JT, "jt", 2},
+	{JF, "jf", 2},
+	{ADD, "add", 3},
+	{MULT, "mult", 3},
+	{MOD, "mod", 3},
+	{AND, "and", 3},
+	{OR, "or", 3},
+	{NOT, "not", 2},
+	{RMEM, "rmem", 2},
+	{WMEM, "wmem", 2},
+	{CALL, "call", 1},
+	{RET, "ret", 0},
+	{OUT, "out", 1},
+	{IN, "in", 1},
+	{NOOP, "noop", 0},
+}
+
+// extensions are the operations added to the spec by Register, indexed by code
+var extensions = map[uint16]Operation{}
+
+// Register adds an operation to the spec (from code 22, after NOOP): Lookup, the assembler and the disassemblers know
+// it from then on. A VM executes it once it has a handler for it, see vm.RegisterOpcode. It must be called before the
+// binaries are assembled or disassembled, e.g. in an init function.
+func Register(op Operation) error {
+	switch {
+	case int(op.Code) < len(Operations):
+		return fmt.Errorf("opcode %d is the operation %s of the spec", op.Code, Operations[op.Code].Name)
+	case op.Code >= M:
+		return fmt.Errorf("opcode %d would be read as a register", op.Code)
+	case op.NArgs > 3:
+		return fmt.Errorf("opcode %d has %d arguments, at most 3 are supported", op.Code, op.NArgs)
+	}
+
+	if ext, ok := extensions[op.Code]; ok {
+		return fmt.Errorf("opcode %d is already registered as %s", op.Code, ext.Name)
+	}
+	if other, ok := LookupName(op.Name); ok {
+		return fmt.Errorf("the name %s is already used by opcode %d", op.Name, other.Code)
+	}
+
+	extensions[op.Code] = op
+	return nil
+}
+
+// Extensions returns the operations added by Register, sorted by code
+func Extensions() []Operation {
+	ops := []Operation{}
+	for _, op := range extensions {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Code < ops[j].Code })
+	return ops
+}
+
+// Lookup returns the operation with the given code, of the spec or added by Register
+func Lookup(code uint16) (Operation, bool) {
+	if int(code) >= len(Operations) {
+		op, ok := extensions[code]
+		return op, ok
+	}
+	return Operations[code], true
+}
+
+// LookupName returns the operation with the given name, of the spec or added by Register
+func LookupName(name string) (Operation, bool) {
+	for _, op := range Operations {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	for _, op := range extensions {
+		if op.Name == name {
+			return op, true
+		}
+	}
+	return Operation{}, false
+}
+
+// Mnemonic returns the name of the operation with the given code, "?" if there is none
+func Mnemonic(code uint16) string {
+	if op, ok := Lookup(code); ok {
+		return op.Name
+	}
+	return "?"
+}
+
+// Operands returns the number of operands of the operation with the given code, 0 if there is none: the word is then
+// an instruction on its own
+func Operands(code uint16) int {
+	op, _ := Lookup(code)
+	return int(op.NArgs)
+}
+
+// Target returns the index (from 0) of the operand of the operation holding the address it jumps to, -1 if it doesn't
+// jump to an operand
+func Target(code uint16) int {
+	switch code {
+	case JMP, CALL:
+		return 0
+	case JT, JF:
+		return 1
+	}
+	return -1
+}
+
+// Instruction is an instruction read from the memory
+type Instruction struct {
+	Code     uint16   // Code of the operation, the first word
+	Operands []uint16 // Words of the operands, fewer than Operands(Code) when the memory ends before them
+}
+
+// Decode reads the instruction at addr in memory, which must hold addr
+func Decode(memory []uint16, addr uint16) Instruction {
+	end := int(addr) + 1 + Operands(memory[addr])
+	if end > len(memory) {
+		end = len(memory)
+	}
+	return Instruction{Code: memory[addr], Operands: memory[int(addr)+1 : end]}
+}
+
+// Format formats an instruction like the disassemblers, with the registers named R0 to R7: e.g. "add: [R0 R1 1]"
+func Format(instr Instruction) string {
+	return Formatter{}.Format(instr)
+}
+
+// Formatter formats the instructions with more details than Format
+type Formatter struct {
+	Registers *[8]uint16     // Values of the registers printed after their names like "R0=4", like the trace does
+	Symbols   *symbols.Table // Names printed instead of the addresses jumped to
+}
+
+// Format formats an instruction like Format with the details of the formatter
+func (f Formatter) Format(instr Instruction) string {
+	op, ok := Lookup(instr.Code)
+	if !ok {
+		return fmt.Sprintf("%4d: ?", instr.Code)
+	}
+
+	args := make([]string, 0, len(instr.Operands))
+	for i, v := range instr.Operands {
+		if v >= M && v < M+8 {
+			if f.Registers != nil {
+				args = append(args, fmt.Sprintf("R%d=%d", v-M, f.Registers[v-M]))
+			} else {
+				args = append(args, fmt.Sprintf("R%d", v-M))
+			}
+		} else if name, ok := f.Symbols.Name(v); ok && i == Target(op.Code) {
+			args = append(args, name)
+		} else {
+			args = append(args, fmt.Sprintf("%d", v))
+		}
+	}
+
+	return fmt.Sprintf("%4s: [%s]", op.Name, strings.Join(args, " "))
+}
+
+// Disassemble formats the instruction at addr in memory without resolving the registers
+func Disassemble(memory []uint16, addr uint16) string {
+	return DisassembleWith(memory, addr, nil)
+}
+
+// DisassembleWith formats the instruction at addr like Disassemble, the addresses jumped to are replaced by their name
+// when syms has one
+func DisassembleWith(memory []uint16, addr uint16, syms *symbols.Table) string {
+	return Formatter{Symbols: syms}.Format(Decode(memory, addr))
+}
@@ -0,0 +1,80 @@
+package lineedit
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// verbs are the commands of the adventure
+var verbs = []string{"go", "take", "drop", "use", "look", "inv", "help"}
+
+// Completer completes the game verbs and the nouns seen in the output (the things of interest, the exits and the
+// inventory are listed with a leading "- "), or the debugger commands and the symbols in a debugger command
+type Completer struct {
+	machine *vm.VM
+	nouns   map[string]bool
+	line    []byte // Current line of output
+}
+
+// NewCompleter returns a completer following the output of machine, give its Complete to Editor.SetCompleter
+func NewCompleter(machine *vm.VM) *Completer {
+	c := &Completer{machine: machine, nouns: map[string]bool{}}
+	machine.SubscribeOutput(c.feed)
+	return c
+}
+
+// feed collects the nouns of the listed lines of output
+func (c *Completer) feed(b byte) {
+	if b != '\n' {
+		c.line = append(c.line, b)
+		return
+	}
+
+	if line := string(c.line); strings.HasPrefix(line, "- ") {
+		for _, w := range strings.Fields(line[2:]) {
+			c.nouns[w] = true
+		}
+	}
+	c.line = c.line[:0]
+}
+
+// Complete returns the candidates for the last word of line
+func (c *Completer) Complete(line string) []string {
+	fields := strings.Fields(line)
+	first := len(fields) == 0 || (len(fields) == 1 && !strings.HasSuffix(line, " "))
+
+	prefix := c.machine.CommandPrefix()
+	switch {
+	case strings.HasPrefix(line, prefix) && first:
+		candidates := []string{}
+		for _, cmd := range vm.Commands {
+			candidates = append(candidates, prefix+cmd)
+		}
+		return candidates
+
+	case c.machine.Stepping() && first:
+		return vm.Commands
+
+	case strings.HasPrefix(line, prefix) || c.machine.Stepping():
+		candidates := []string{}
+		table := c.machine.Symbols()
+		for _, addr := range table.Addresses() {
+			if name, ok := table.Name(addr); ok {
+				candidates = append(candidates, name)
+			}
+		}
+		return candidates
+
+	case first:
+		return verbs
+	}
+
+	nouns := []string{}
+	for n := range c.nouns {
+		nouns = append(nouns, n)
+	}
+	sort.Strings(nouns)
+	return nouns
+}
@@ -0,0 +1,198 @@
+// Package lineedit edits the lines typed on a terminal before the VM reads them: backspace, Ctrl-U, the history of
+// the previous lines with the up and down arrows and the completion of the last word with Tab
+package lineedit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Editor is a reader giving the lines edited on the terminal, one at a time
+type Editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	complete func(line string) []string
+	history  []string
+	pending  []byte // Rest of the last line, not read yet
+}
+
+// New returns an editor reading the keys from in and echoing the line to out, in should be a terminal in raw mode
+// (see Raw)
+func New(in io.Reader, out io.Writer) *Editor {
+	return &Editor{in: bufio.NewReader(in), out: out}
+}
+
+// SetCompleter makes Tab complete the last word of the line with the candidates returned by complete for the line
+func (e *Editor) SetCompleter(complete func(line string) []string) {
+	e.complete = complete
+}
+
+// Raw disables the line buffering and the echo of the terminal f so that the editor gets every key, the signals like
+// Ctrl-C are still sent. It returns an error if f is not a terminal, the returned function restores the terminal.
+func Raw(f *os.File) (func(), error) {
+	saved, err := stty(f, "-g")
+	if err != nil {
+		return nil, fmt.Errorf("not a terminal: %s", err)
+	}
+	if _, err := stty(f, "-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	return func() { stty(f, strings.TrimSpace(saved)) }, nil
+}
+
+func stty(f *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = f
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// Read gives the lines edited on the terminal, ended by a new line
+func (e *Editor) Read(p []byte) (int, error) {
+	if len(e.pending) == 0 {
+		line, err := e.readLine()
+		if err != nil {
+			return 0, err
+		}
+		e.pending = []byte(line + "\n")
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	return n, nil
+}
+
+// readLine edits a line until Enter, Ctrl-D on an empty line is the end of the input
+func (e *Editor) readLine() (string, error) {
+	line := []byte{}
+	browsed := len(e.history) // Index of the history entry shown, len(e.history) for the new line
+
+	for {
+		b, err := e.in.ReadByte()
+		if err == io.EOF && len(line) > 0 {
+			b = '\n'
+		} else if err != nil {
+			return "", err
+		}
+
+		switch {
+		case b == '\n' || b == '\r':
+			// The game doesn't understand the space following a completed word
+			line = []byte(strings.TrimRight(string(line), " "))
+			fmt.Fprint(e.out, "\n")
+			if len(line) > 0 && (len(e.history) == 0 || e.history[len(e.history)-1] != string(line)) {
+				e.history = append(e.history, string(line))
+			}
+			return string(line), nil
+
+		case b == 4: // Ctrl-D
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+
+		case b == 0x7f || b == '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+
+		case b == 0x15: // Ctrl-U
+			line = e.replace(line, "")
+
+		case b == '\t':
+			line = e.completeLine(line)
+
+		case b == 0x1b:
+			// Escape sequence of a key, only the up and down arrows are handled
+			switch e.escape() {
+			case 'A':
+				if browsed > 0 {
+					browsed--
+					line = e.replace(line, e.history[browsed])
+				}
+			case 'B':
+				if browsed < len(e.history) {
+					browsed++
+					next := ""
+					if browsed < len(e.history) {
+						next = e.history[browsed]
+					}
+					line = e.replace(line, next)
+				}
+			}
+
+		case b >= 0x20:
+			line = append(line, b)
+			e.out.Write([]byte{b})
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence and returns its final byte
+func (e *Editor) escape() byte {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return 0
+	}
+	for {
+		b, err := e.in.ReadByte()
+		if err != nil || (b >= 0x40 && b <= 0x7e) {
+			return b
+		}
+	}
+}
+
+// replace erases line from the terminal and writes s instead
+func (e *Editor) replace(line []byte, s string) []byte {
+	fmt.Fprint(e.out, strings.Repeat("\b \b", len(line))+s)
+	return []byte(s)
+}
+
+// completeLine extends the last word of the line with the common prefix of its candidates, or lists them when there
+// is nothing to add
+func (e *Editor) completeLine(line []byte) []byte {
+	if e.complete == nil {
+		return line
+	}
+
+	word := string(line[strings.LastIndexByte(string(line), ' ')+1:])
+	candidates := []string{}
+	for _, c := range e.complete(string(line)) {
+		if strings.HasPrefix(c, word) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	switch common := commonPrefix(candidates); {
+	case len(candidates) == 0:
+		fmt.Fprint(e.out, "\a")
+	case len(candidates) == 1:
+		add := common[len(word):] + " "
+		line = append(line, add...)
+		fmt.Fprint(e.out, add)
+	case len(common) > len(word):
+		line = append(line, common[len(word):]...)
+		fmt.Fprint(e.out, common[len(word):])
+	default:
+		fmt.Fprint(e.out, "\n"+strings.Join(candidates, "  ")+"\n"+string(line))
+	}
+	return line
+}
+
+// commonPrefix returns the longest prefix of all the words
+func commonPrefix(words []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,90 @@
+// Package loader reads binaries into the 16-bits values the VM executes
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/sfluor/synacor/data"
+)
+
+// maxWords is the size of the address space
+const maxWords = 1 << 15
+
+// gzipMagic are the first bytes of a gzip file
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Load reads a binary, each value is a 16-bits little-endian pair. Gzip-compressed binaries are decompressed.
+func Load(r io.Reader) ([]uint16, error) {
+	br := bufio.NewReader(r)
+
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return FromBytes(b)
+}
+
+// LoadFile reads the binary at path, see Load
+func LoadFile(path string) ([]uint16, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mem, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return mem, nil
+}
+
+// LoadEmbedded returns the challenge binary embedded in the data package
+func LoadEmbedded() ([]uint16, error) {
+	return FromBytes(data.Challenge)
+}
+
+// FromBytes converts a binary already in memory
+func FromBytes(b []byte) ([]uint16, error) {
+	if len(b)%2 != 0 {
+		return nil, fmt.Errorf("odd binary length %d, values are 16-bits pairs", len(b))
+	}
+
+	if len(b)/2 > maxWords {
+		return nil, fmt.Errorf("binary of %d values doesn't fit in the %d addresses of the memory", len(b)/2, maxWords)
+	}
+
+	mem := make([]uint16, len(b)/2)
+	for i := range mem {
+		mem[i] = binary.LittleEndian.Uint16(b[2*i:])
+	}
+	return mem, nil
+}
+
+// Encode is the inverse of FromBytes, it returns the little-endian representation of the given values
+func Encode(mem []uint16) []byte {
+	b := make([]byte, 2*len(mem))
+	for i, v := range mem {
+		binary.LittleEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
@@ -0,0 +1,205 @@
+// Package mapper explores the rooms of the adventure by forking the VM on every exit and draws the resulting map
+package mapper
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Room is a room of the adventure
+type Room struct {
+	ID          string            // Fingerprint of the room
+	Title       string            // Title of the room, e.g. "Twisty passages"
+	Description string            // Description of the room
+	Exits       []string          // Exits listed in the room
+	Edges       map[string]string // Room ID reached through each exit, empty if the exit ended the game
+}
+
+// Map is the result of an exploration
+type Map struct {
+	Start string           // ID of the room the exploration started from
+	Rooms map[string]*Room // Rooms indexed by ID
+}
+
+// Options tunes the exploration
+type Options struct {
+	RoomAddr uint16 // When not 0, the value stored at this address is part of the fingerprint (useful for rooms that look alike)
+	MaxRooms int    // Stop after visiting this many rooms, 0 means no limit
+}
+
+var (
+	titleRegex = regexp.MustCompile(`(?m)^== (.+) ==$`)
+	exitsRegex = regexp.MustCompile(`There (?:is|are) \d+ exits?:`)
+	exitRegex  = regexp.MustCompile(`(?m)^- (.+)$`)
+)
+
+// node is a room waiting to be explored along with the VM standing in it
+type node struct {
+	room    *Room
+	machine *vm.VM
+}
+
+// Explore maps every room reachable from the one the VM is in. The VM must be waiting for input (e.g. restored from a
+// snapshot taken with $save), it's never modified: every exit is tried on a clone.
+func Explore(start *vm.VM, opts Options) (*Map, error) {
+	machine := start.Clone()
+	machine.SetCopyOnWrite(true) // Every exit is tried on a clone sharing its pages
+	output, alive, err := run(machine, "look")
+	if err != nil {
+		return nil, err
+	}
+
+	room, ok := parseRoom(output, machine, opts)
+	if !ok || !alive {
+		return nil, fmt.Errorf("could not find a room description in:\n%s", output)
+	}
+
+	m := &Map{Start: room.ID, Rooms: map[string]*Room{room.ID: room}}
+	queue := []node{{room, machine}}
+
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, exit := range current.room.Exits {
+			next := current.machine.Clone()
+			output, alive, err := run(next, exit)
+			if err != nil {
+				return nil, err
+			}
+
+			room, ok := parseRoom(output, next, opts)
+			if !alive || !ok {
+				current.room.Edges[exit] = ""
+				continue
+			}
+			current.room.Edges[exit] = room.ID
+
+			if _, seen := m.Rooms[room.ID]; seen {
+				continue
+			}
+
+			if opts.MaxRooms > 0 && len(m.Rooms) >= opts.MaxRooms {
+				continue
+			}
+
+			m.Rooms[room.ID] = room
+			queue = append(queue, node{room, next})
+		}
+	}
+
+	return m, nil
+}
+
+// run sends a command to the VM and returns its output, alive is false if the program stopped
+func run(machine *vm.VM, cmd string) (output string, alive bool, err error) {
+	out := &bytes.Buffer{}
+	machine.SetInput(strings.NewReader(cmd + "\n"))
+	machine.SetOutput(out)
+
+	reason, err := machine.Run()
+	if err != nil {
+		return "", false, err
+	}
+
+	return out.String(), reason == vm.ExitInputEOF, nil
+}
+
+// parseRoom extracts the last room description of the output
+func parseRoom(output string, machine *vm.VM, opts Options) (*Room, bool) {
+	titles := titleRegex.FindAllStringSubmatchIndex(output, -1)
+	if len(titles) == 0 {
+		return nil, false
+	}
+
+	last := titles[len(titles)-1]
+	title := output[last[2]:last[3]]
+	rest := output[last[1]:]
+
+	// The description is the first paragraph after the title
+	description := strings.TrimSpace(rest)
+	if i := strings.Index(description, "\n\n"); i != -1 {
+		description = description[:i]
+	}
+
+	exits := []string{}
+	if loc := exitsRegex.FindStringIndex(rest); loc != nil {
+		// The list of exits ends with a blank line
+		list := rest[loc[1]:]
+		if i := strings.Index(list, "\n\n"); i != -1 {
+			list = list[:i]
+		}
+		for _, match := range exitRegex.FindAllStringSubmatch(list, -1) {
+			exits = append(exits, match[1])
+		}
+	}
+
+	fingerprint := fmt.Sprintf("%s\n%s\n%v", title, description, exits)
+	if opts.RoomAddr != 0 {
+		fingerprint += fmt.Sprintf("\n%d", machine.Memory(opts.RoomAddr))
+	}
+
+	return &Room{
+		ID:          fmt.Sprintf("%x", sha1.Sum([]byte(fingerprint)))[:8],
+		Title:       title,
+		Description: description,
+		Exits:       exits,
+		Edges:       map[string]string{},
+	}, true
+}
+
+// WriteDOT writes the map in the Graphviz DOT format
+func (m *Map) WriteDOT(w io.Writer) error {
+	ids := make([]string, 0, len(m.Rooms))
+	for id := range m.Rooms {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("digraph maze {\n")
+	buf.WriteString("\tnode [shape=box];\n")
+
+	for _, id := range ids {
+		room := m.Rooms[id]
+		attrs := ""
+		if id == m.Start {
+			attrs = ", style=bold"
+		}
+		fmt.Fprintf(buf, "\t%q [label=%q%s];\n", id, room.Title+"\n"+id, attrs)
+	}
+
+	for _, id := range ids {
+		room := m.Rooms[id]
+		for _, exit := range room.Exits {
+			to, ok := room.Edges[exit]
+			if !ok {
+				// Not explored because of MaxRooms
+				continue
+			}
+
+			if to == "" {
+				fmt.Fprintf(buf, "\t%q [label=\"game over\", shape=plaintext];\n", id+"-"+exit)
+				fmt.Fprintf(buf, "\t%q -> %q [label=%q];\n", id, id+"-"+exit, exit)
+				continue
+			}
+
+			if _, explored := m.Rooms[to]; !explored {
+				continue
+			}
+			fmt.Fprintf(buf, "\t%q -> %q [label=%q];\n", id, to, exit)
+		}
+	}
+
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
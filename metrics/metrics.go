@@ -0,0 +1,147 @@
+// Package metrics exposes the activity of the served games in the text format of Prometheus: the connected sessions,
+// the instructions executed by each VM and by all of them per second and the reasons the games stopped.
+//
+// The VMs run in the goroutines of their sessions, they publish their instruction counters from their dispatch loops
+// (see vm.VM.PublishInstructions) so that the metrics can be read from other goroutines, a few thousand instructions
+// late at most.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Registry follows the sessions of a server, it is an http.Handler serving the metrics
+type Registry struct {
+	mu       sync.Mutex
+	sessions map[int]*Session // Connected sessions by id
+	started  int              // Sessions started so far, the id of the next one
+	finished uint64           // Instructions executed by the sessions that ended
+	halts    map[string]uint64
+
+	// Instructions per second between the last two scrapes at least a second apart
+	lastTime  time.Time
+	lastTotal uint64
+	rate      float64
+}
+
+// Session is a game followed by a Registry
+type Session struct {
+	registry     *Registry
+	id           int
+	remote       string
+	instructions uint64 // Accessed atomically
+}
+
+// New creates an empty registry
+func New() *Registry {
+	return &Registry{sessions: map[int]*Session{}, halts: map[string]uint64{}}
+}
+
+// Track follows machine, played by remote, until Done is called. It returns nil if r is nil so that the servers
+// without metrics don't have to check.
+func (r *Registry) Track(machine *vm.VM, remote string) *Session {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &Session{registry: r, id: r.started, remote: remote}
+	r.started++
+	r.sessions[s.id] = s
+
+	machine.PublishInstructions(&s.instructions)
+	return s
+}
+
+// Done records the end of the session, once its VM executed instructions and stopped for reason
+func (s *Session) Done(instructions uint64, reason vm.ExitReason) {
+	if s == nil {
+		return
+	}
+
+	r := s.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, s.id)
+	r.finished += instructions
+	r.halts[reason.String()]++
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// Write writes the current metrics to w
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := make([]int, 0, len(r.sessions))
+	total := r.finished
+	for id, s := range r.sessions {
+		ids = append(ids, id)
+		total += atomic.LoadUint64(&s.instructions)
+	}
+	sort.Ints(ids)
+
+	now := time.Now()
+	if r.lastTime.IsZero() {
+		r.lastTime, r.lastTotal = now, total
+	} else if elapsed := now.Sub(r.lastTime); elapsed >= time.Second {
+		r.rate = float64(total-r.lastTotal) / elapsed.Seconds()
+		r.lastTime, r.lastTotal = now, total
+	}
+
+	b := &strings.Builder{}
+	metric(b, "synacor_sessions", "gauge", "Connected sessions")
+	fmt.Fprintf(b, "synacor_sessions %d\n", len(r.sessions))
+	metric(b, "synacor_sessions_total", "counter", "Sessions started")
+	fmt.Fprintf(b, "synacor_sessions_total %d\n", r.started)
+
+	metric(b, "synacor_instructions_total", "counter", "Instructions executed by all the sessions, ended ones included")
+	fmt.Fprintf(b, "synacor_instructions_total %d\n", total)
+	metric(b, "synacor_instructions_per_second", "gauge", "Instructions executed per second between the last two scrapes")
+	fmt.Fprintf(b, "synacor_instructions_per_second %g\n", r.rate)
+
+	metric(b, "synacor_vm_instructions_total", "counter", "Instructions executed by the VM of each connected session")
+	for _, id := range ids {
+		s := r.sessions[id]
+		fmt.Fprintf(b, "synacor_vm_instructions_total{session=\"%d\",remote=\"%s\"} %d\n", id, escape(s.remote), atomic.LoadUint64(&s.instructions))
+	}
+
+	reasons := make([]string, 0, len(r.halts))
+	for reason := range r.halts {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	metric(b, "synacor_halts_total", "counter", "Sessions ended, by the reason their VM stopped")
+	for _, reason := range reasons {
+		fmt.Fprintf(b, "synacor_halts_total{reason=\"%s\"} %d\n", escape(reason), r.halts[reason])
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// metric writes the HELP and TYPE lines of a metric
+func metric(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// escape escapes a label value
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
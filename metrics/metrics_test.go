@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+func TestInstructionsWithoutIO(t *testing.T) {
+	// Loops without reading or writing anything
+	machine := vm.New([]uint16{vm.JMP, 0}, bytes.NewReader(nil), ioutil.Discard)
+	r := New()
+	r.Track(machine, "test")
+	if _, err := machine.RunFor(10000); err != nil {
+		t.Fatal(err)
+	}
+
+	b := &strings.Builder{}
+	if err := r.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if want := `synacor_vm_instructions_total{session="0",remote="test"} 10000`; !strings.Contains(b.String(), want) {
+		t.Errorf("no %s in\n%s", want, b.String())
+	}
+}
@@ -0,0 +1,109 @@
+// Package mux multiplexes several streams, like the output of the game and the diagnostics of the debugger, on a
+// single writer so that tools can tell them apart. Every line of a stream becomes a frame, written as a line:
+//
+//	<tag> <Go quoted text>
+//
+// e.g. `game "What do you do?\n"` or `diag ">>> "`. The text of a frame is a full line, newline included, unless
+// another stream was written or the mux flushed in the middle of it: a line can span several frames but a frame never
+// holds more than one line.
+package mux
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// Mux writes the frames of its streams to a writer
+type Mux struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	tag     string // Tag of the pending text
+	pending []byte // Text of the current line not yet framed
+	err     error  // First write error, returned by the next writes
+}
+
+// New creates a mux writing its frames to w
+func New(w io.Writer) *Mux {
+	return &Mux{w: bufio.NewWriter(w)}
+}
+
+// Stream returns a writer whose bytes are framed with tag, which must not contain spaces or newlines
+func (m *Mux) Stream(tag string) io.Writer {
+	return stream{m: m, tag: tag}
+}
+
+// Flush frames the pending text and flushes the underlying writer, to be called before waiting for input so that
+// the prompts are received
+func (m *Mux) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.frame()
+	if m.err == nil {
+		m.err = m.w.Flush()
+	}
+	return m.err
+}
+
+// FlushingReader returns a reader flushing the mux before each read of r
+func (m *Mux) FlushingReader(r io.Reader) io.Reader {
+	return flushingReader{m: m, r: r}
+}
+
+// write appends the bytes of a stream to the pending text and frames each line
+func (m *Mux) write(tag string, b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if tag != m.tag {
+		m.frame()
+		m.tag = tag
+	}
+
+	for _, c := range b {
+		m.pending = append(m.pending, c)
+		if c == '\n' {
+			m.frame()
+		}
+	}
+
+	if m.err != nil {
+		return 0, m.err
+	}
+	return len(b), nil
+}
+
+// frame writes the pending text as a frame, if any
+func (m *Mux) frame() {
+	if len(m.pending) == 0 || m.err != nil {
+		m.pending = m.pending[:0]
+		return
+	}
+
+	line := append([]byte(m.tag+" "), strconv.Quote(string(m.pending))...)
+	_, m.err = m.w.Write(append(line, '\n'))
+	m.pending = m.pending[:0]
+}
+
+type stream struct {
+	m   *Mux
+	tag string
+}
+
+func (s stream) Write(b []byte) (int, error) {
+	return s.m.write(s.tag, b)
+}
+
+type flushingReader struct {
+	m *Mux
+	r io.Reader
+}
+
+func (f flushingReader) Read(b []byte) (int, error) {
+	if err := f.m.Flush(); err != nil {
+		return 0, err
+	}
+	return f.r.Read(b)
+}
@@ -0,0 +1,52 @@
+package puzzles
+
+// Coin is one of the coins found in the ruins, its value is given by the number of dots on it
+type Coin struct {
+	Name  string
+	Value int
+}
+
+// Coins are the coins to place on the monument
+var Coins = []Coin{
+	{"red coin", 2},
+	{"corroded coin", 3},
+	{"shiny coin", 5},
+	{"concave coin", 7},
+	{"blue coin", 9},
+}
+
+// SolveCoins returns the order in which the coins have to be placed to satisfy the equation found on the monument:
+//
+//	_ + _ * _^2 + _^3 - _ = 399
+func SolveCoins() ([]Coin, bool) {
+	// Ugly solution but the problem is small
+	var solution []Coin
+	permute(append([]Coin{}, Coins...), 0, func(c []Coin) bool {
+		a, b, cc, d, e := c[0].Value, c[1].Value, c[2].Value, c[3].Value, c[4].Value
+		if a+b*cc*cc+d*d*d-e == 399 {
+			solution = append([]Coin{}, c...)
+			return true
+		}
+		return false
+	})
+
+	return solution, solution != nil
+}
+
+// permute calls fn on every permutation of coins (modifying it in place) until fn returns true
+func permute(coins []Coin, k int, fn func([]Coin) bool) bool {
+	if k == len(coins) {
+		return fn(coins)
+	}
+
+	for i := k; i < len(coins); i++ {
+		coins[k], coins[i] = coins[i], coins[k]
+		found := permute(coins, k+1, fn)
+		coins[k], coins[i] = coins[i], coins[k]
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,203 @@
+// Package puzzles contains solvers for the enigmas found along the challenge
+package puzzles
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/sfluor/synacor/search"
+	"github.com/sfluor/synacor/versions"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Confirmation computes the teleporter confirmation function (found at address 6027 in the binary of this repository)
+// for the given registers:
+//
+//	f(0, r1) = r1 + 1
+//	f(r0, 0) = f(r0 - 1, r7)
+//	f(r0, r1) = f(r0 - 1, f(r0, r1 - 1))
+//
+// All operations are modulo 32768. Instead of recursing, the function is memoized row by row: row r0 only depends on row r0 - 1.
+func Confirmation(r0, r1, r7 uint16) uint16 {
+	prev := make([]uint16, vm.M)
+	row := make([]uint16, vm.M)
+
+	for n := range prev {
+		prev[n] = uint16((n + 1) % vm.M)
+	}
+
+	for m := uint16(1); m <= r0; m++ {
+		// The last row only needs to be computed until r1
+		end := uint16(vm.M - 1)
+		if m == r0 {
+			end = r1
+		}
+
+		row[0] = prev[r7]
+		for n := uint16(1); n <= end; n++ {
+			row[n] = prev[row[n-1]]
+		}
+
+		prev, row = row, prev
+	}
+
+	return prev[r1]
+}
+
+// SolveTeleporter finds the value of the eighth register for which the confirmation of (4, 1) returns 6, it uses every CPU
+func SolveTeleporter() (uint16, bool) {
+	workers := runtime.NumCPU()
+	candidates := make(chan uint16)
+	found := make(chan uint16, workers)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r7 := range candidates {
+				if Confirmation(4, 1, r7) == 6 {
+					found <- r7
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(candidates)
+		// 0 disables the teleporter confirmation, it can't be the answer
+		for r7 := uint16(1); r7 < vm.M; r7++ {
+			select {
+			case candidates <- r7:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(found)
+	}()
+
+	r7, ok := <-found
+	close(done)
+	return r7, ok
+}
+
+// PatchTeleporter makes the machine use r7 for the eighth register when the teleporter checks it, the confirmation
+// then verifies it, replaced by Confirmation (see ReplaceConfirmation) so that it completes instantly. The first use of
+// the teleporter of the game is left alone since it has to go to the Synacor Headquarters where there is a code, the
+// uses are counted by the VM and kept in its snapshots (see vm.VM.HookHits) so that it's still known once restored. It
+// fails if the teleporter code of the binary can't be found, see versions.Detect.
+func PatchTeleporter(machine *vm.VM, r7 uint16) error {
+	v, err := versions.Of(machine)
+	if err != nil {
+		return err
+	}
+
+	machine.HookAddress(v.TeleporterCheck, func(machine *vm.VM) vm.HookResult {
+		if machine.HookHits(v.TeleporterCheck) > 1 {
+			machine.SetRegister(7, r7)
+		}
+		return vm.HookFallThrough
+	})
+	return ReplaceConfirmation(machine)
+}
+
+// ReplaceConfirmation replaces the confirmation function of the machine by Confirmation, so that the real
+// verification of the teleporter completes instantly. It fails if the function can't be found, see versions.Detect.
+func ReplaceConfirmation(machine *vm.VM) error {
+	v, err := versions.Of(machine)
+	if err != nil {
+		return err
+	}
+
+	machine.ReplaceRoutine(v.Confirmation, func(args []uint16) []uint16 {
+		return []uint16{Confirmation(args[0], args[1], args[7])}
+	})
+	return nil
+}
+
+// SearchTeleporter finds the value of the eighth register like SolveTeleporter but runs the code of the binary: every
+// candidate executes the setup of the arguments, the call of the (native) confirmation function and the comparison of
+// its result on its own VM of the pool. It fails if the teleporter code can't be found, see versions.Detect.
+func SearchTeleporter(bin []uint16, opts search.Options) (uint16, bool, error) {
+	v, err := versions.Detect(bin)
+	if err != nil {
+		return 0, false, err
+	}
+
+	base := vm.New(append([]uint16{}, bin...), bytes.NewReader(nil), ioutil.Discard)
+	if err := ReplaceConfirmation(base); err != nil {
+		return 0, false, err
+	}
+	base.SetCursor(v.TeleporterArguments)
+
+	// set R0 4, set R1 1, call and eq R1 R0 6 (executed with the return of the native function)
+	opts.Budget = 4
+
+	found, _ := search.Run(context.Background(), base, search.Job{
+		Candidates: vm.M - 1,
+		Prepare: func(machine *vm.VM, candidate int) {
+			// 0 disables the teleporter confirmation, it can't be the answer
+			machine.SetRegister(7, uint16(candidate+1))
+		},
+		Accept: func(machine *vm.VM, candidate int, reason vm.ExitReason, err error) bool {
+			return reason == vm.ExitBudget && machine.Register(1) == 1
+		},
+	}, opts)
+
+	if len(found) == 0 {
+		return 0, false, nil
+	}
+	return uint16(found[0] + 1), true, nil
+}
+
+// Texts ending the second use of the teleporter
+const (
+	teleporterSuccess = "sandy beach"
+	teleporterFailure = "Miscalibration detected"
+)
+
+var teleporterOutcome = regexp.MustCompile(teleporterSuccess + "|" + teleporterFailure)
+
+// BruteTeleporter finds the value of the eighth register by trying each of them in the game: every candidate uses the
+// teleporter on its own clone of base, which must be at the prompt where it's used with the strange book read (e.g.
+// after processed/teleporter.record), and stops as soon as the output tells whether the teleportation succeeded.
+//
+// With native, the confirmation function is replaced by Confirmation. Otherwise every candidate runs the code of the
+// binary, which takes far longer than any budget: the candidates exhausting opts.Budget are counted in the stats.
+func BruteTeleporter(base *vm.VM, native bool, opts search.Options) (uint16, bool, search.Stats, error) {
+	base = base.Clone()
+	if native {
+		if err := ReplaceConfirmation(base); err != nil {
+			return 0, false, search.Stats{}, err
+		}
+	}
+
+	opts.First = true
+	found, stats := search.Run(context.Background(), base, search.Job{
+		Candidates: vm.M - 1,
+		Prepare: func(machine *vm.VM, candidate int) {
+			// 0 disables the teleporter confirmation, it can't be the answer
+			machine.SetRegister(7, uint16(candidate+1))
+			machine.SetInput(strings.NewReader("use teleporter\n"))
+		},
+		Accept: func(machine *vm.VM, candidate int, reason vm.ExitReason, err error) bool {
+			return reason == vm.ExitOutputMatch && strings.Contains(machine.LastOutput(len(teleporterSuccess)), teleporterSuccess)
+		},
+		Until: teleporterOutcome,
+	}, opts)
+
+	if len(found) == 0 {
+		return 0, false, stats, nil
+	}
+	return uint16(found[0] + 1), true, stats, nil
+}
@@ -0,0 +1,115 @@
+package puzzles
+
+type room struct {
+	operation string
+	number    int
+}
+
+// |   *   |   8   |   -   |   1   |
+// |   4   |   *   |   11  |   *   |
+// |   +   |   4   |   -   |   18  |
+// |   22  |   -   |   9   |   *   |
+var vault = [][]room{
+	{{operation: "*"}, {number: 8}, {operation: "-"}, {number: 1}},
+	{{number: 4}, {operation: "*"}, {number: 11}, {operation: "*"}},
+	{{operation: "+"}, {number: 4}, {operation: "-"}, {number: 18}},
+	{{number: 22}, {operation: "-"}, {number: 9}, {operation: "*"}},
+}
+
+var operations = map[string]func(a, b int) int{
+	"+": func(a, b int) int { return a + b },
+	"-": func(a, b int) int { return a - b },
+	"*": func(a, b int) int { return a * b },
+	"":  func(a, b int) int { return a },
+}
+
+// Positions of the orb pedestal and of the vault door in the grid
+const (
+	startX, startY = 0, 3
+	doorX, doorY   = 3, 0
+	startOrb       = 22
+	doorOrb        = 30
+	maxOrb         = 100 // Bound to keep the search finite, the orb never needs to be that heavy
+)
+
+// position is a state of the search, the pending operation is the one of the last room walked on
+type position struct {
+	x, y, orb int
+	op        string
+}
+
+type state struct {
+	position
+	history []string
+}
+
+// SolveVault returns the shortest list of moves (north, east, south or west) leading the orb from its pedestal to the
+// vault door with the right weight. The orb can't go back to its pedestal and shatters when its weight is negative.
+func SolveVault() ([]string, bool) {
+	start := position{startX, startY, startOrb, ""}
+	queue := []state{{start, []string{}}}
+	seen := map[position]bool{start: true}
+
+	for len(queue) != 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for _, next := range nextStates(s) {
+			if seen[next.position] {
+				continue
+			}
+			seen[next.position] = true
+
+			// Vault Door, the orb disappears if it doesn't have the right weight
+			if next.x == doorX && next.y == doorY {
+				if next.orb == doorOrb {
+					return next.history, true
+				}
+				continue
+			}
+
+			if next.orb > 0 && next.orb < maxOrb {
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func nextStates(previous state) []state {
+	states := []state{}
+
+	x, y := previous.x, previous.y
+
+	if x > 0 && !(x-1 == startX && y == startY) {
+		states = append(states, newState(previous, x-1, y, "west"))
+	}
+	if x < 3 {
+		states = append(states, newState(previous, x+1, y, "east"))
+	}
+	if y > 0 {
+		states = append(states, newState(previous, x, y-1, "north"))
+	}
+	if y < 3 && !(x == startX && y+1 == startY) {
+		states = append(states, newState(previous, x, y+1, "south"))
+	}
+
+	return states
+}
+
+func newState(previous state, x, y int, dir string) state {
+	// Copy otherwise weird behaviors happens
+	history := make([]string, len(previous.history), len(previous.history)+1)
+	copy(history, previous.history)
+
+	return state{
+		position: position{
+			x:   x,
+			y:   y,
+			orb: operations[previous.op](previous.orb, vault[y][x].number),
+			op:  vault[y][x].operation,
+		},
+		history: append(history, dir),
+	}
+}
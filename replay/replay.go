@@ -0,0 +1,135 @@
+// Package replay records a session as the hash of the binary, the exact bytes consumed from the input and the hash of
+// the output, so that anyone can execute the input again and check that it leads to the same output: a verifiable
+// proof of a playthrough. The VM is deterministic, there is no randomness to record.
+package replay
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strings"
+
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/puzzles"
+	"github.com/sfluor/synacor/vm"
+)
+
+// version is the version of the Replay format, bump it when the Replay type changes
+const version = 1
+
+// Replay is the content of a replay file, written as JSON
+type Replay struct {
+	Version            int    `json:"version"`
+	Binary             string `json:"binary"`                   // SHA-256 of the binary, in hexadecimal
+	NativeConfirmation bool   `json:"native_confirmation"`      // The confirmation function was replaced, see puzzles.ReplaceConfirmation
+	Stepping           bool   `json:"stepping"`                 // The session started in stepping mode
+	CommandPrefix      string `json:"command_prefix,omitempty"` // Prefix of the debugger commands, $ if empty
+	Input              string `json:"input"`                    // Bytes consumed from the input, debugger commands included
+	Output             string `json:"output"`                   // SHA-256 of the bytes written by OUT, in hexadecimal
+	Instructions       uint64 `json:"instructions"`             // Instructions executed
+}
+
+// Recorder follows a VM to build its replay
+type Recorder struct {
+	machine *vm.VM
+	replay  Replay
+	input   strings.Builder
+	output  hash.Hash
+}
+
+// Record starts recording machine, it must be called before it runs and after its command prefix is set.
+// nativeConfirmation tells whether puzzles.ReplaceConfirmation was applied to it (the other patches are not recorded)
+// and stepping whether it starts in stepping mode.
+func Record(machine *vm.VM, nativeConfirmation, stepping bool) *Recorder {
+	r := &Recorder{
+		machine: machine,
+		replay: Replay{
+			Version:            version,
+			Binary:             hashBinary(machine.MemRange(0, vm.M)),
+			NativeConfirmation: nativeConfirmation,
+			Stepping:           stepping,
+			CommandPrefix:      machine.CommandPrefix(),
+		},
+		output: sha256.New(),
+	}
+
+	machine.OnRead(func(_ *vm.VM, c byte) { r.input.WriteByte(c) })
+	machine.SubscribeOutput(func(c byte) { r.output.Write([]byte{c}) })
+
+	return r
+}
+
+// Replay returns the replay of what the machine executed so far
+func (r *Recorder) Replay() *Replay {
+	replay := r.replay
+	replay.Input = r.input.String()
+	replay.Output = fmt.Sprintf("%x", r.output.Sum(nil))
+	replay.Instructions = r.machine.Instructions()
+	return &replay
+}
+
+// Save writes the replay to the given file
+func (r *Replay) Save(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// Load reads a replay from the given file
+func Load(path string) (*Replay, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replay{}
+	if err := json.Unmarshal(b, r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if r.Version != version {
+		return nil, fmt.Errorf("unsupported replay version %d (expected %d)", r.Version, version)
+	}
+	return r, nil
+}
+
+// Verify executes the input of the replay on bin and returns an error if the binary, the output or the number of
+// instructions differ from the recorded ones
+func (r *Replay) Verify(bin []uint16) error {
+	if h := hashBinary(bin); h != r.Binary {
+		return fmt.Errorf("the binary %s is not the recorded one %s", h, r.Binary)
+	}
+
+	machine := vm.New(append([]uint16{}, bin...), strings.NewReader(r.Input), ioutil.Discard)
+	if r.NativeConfirmation {
+		if err := puzzles.ReplaceConfirmation(machine); err != nil {
+			return err
+		}
+	}
+	machine.SetStepping(r.Stepping)
+	machine.SetCommandPrefix(r.CommandPrefix)
+	recorder := Record(machine, r.NativeConfirmation, r.Stepping)
+
+	if _, err := machine.Run(); err != nil {
+		return fmt.Errorf("the replay stopped with an error: %w", err)
+	}
+
+	got := recorder.Replay()
+	switch {
+	case got.Input != r.Input:
+		return fmt.Errorf("the program consumed %d bytes of input instead of %d", len(got.Input), len(r.Input))
+	case got.Output != r.Output:
+		return fmt.Errorf("the output hashes to %s instead of %s", got.Output, r.Output)
+	case got.Instructions != r.Instructions:
+		return fmt.Errorf("%d instructions were executed instead of %d", got.Instructions, r.Instructions)
+	}
+	return nil
+}
+
+// hashBinary returns the SHA-256 of the binary, as stored in the file
+func hashBinary(bin []uint16) string {
+	return fmt.Sprintf("%x", sha256.Sum256(loader.Encode(bin)))
+}
@@ -0,0 +1,331 @@
+// Package script runs small scripts automating the debugger: handlers triggered by the output, the input or the cursor
+// reaching an address read and modify the registers and the memory and run debugger commands, without recompiling.
+//
+//	# Save when the book is taken and show the eighth register
+//	on_input `^take strange book$` {
+//		save book.snapshot
+//		print r7
+//	}
+//
+//	on_output "strange book" {
+//		set r7 = 25734
+//	}
+//
+//	on_breakpoint 5451 if r7 != 0 {
+//		print r0, r1, mem[2732]
+//	}
+//
+// on_output and on_input match a quoted regular expression (backquotes keep its backslashes) against each line written
+// by the program and each line it reads, on_breakpoint an address (a number or a symbol) and an optional condition.
+// The actions are:
+//
+//	print <expr>, ...           print the values of expressions (see vm.VM.Eval)
+//	set <target> = <expr>       change r0 to r7, mem[<expr>] or cursor
+//	<command>                   any other line is a debugger command, e.g. save, qs 1, steppingon or trace on
+//
+// The handlers of the output and the input run before the instruction following the end of the line, the ones of a
+// breakpoint before the instruction at its address (the one at the cursor once they ran is executed).
+package script
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Events triggering a handler
+const (
+	OnOutput     = "on_output"
+	OnInput      = "on_input"
+	OnBreakpoint = "on_breakpoint"
+)
+
+// Script is a parsed script
+type Script struct {
+	handlers []*handler
+}
+
+// handler runs its actions when its event happens
+type handler struct {
+	event   string
+	line    int            // Line of the handler in the script
+	re      *regexp.Regexp // Pattern of on_output and on_input
+	addr    string         // Address of on_breakpoint, resolved when attached to know the symbols
+	cond    string         // Condition of on_breakpoint, empty means always
+	actions []action
+}
+
+// action is a line of a handler
+type action struct {
+	line   int
+	kind   string // print, set or command
+	exprs  []string
+	target string // Target of set
+}
+
+// Load reads and parses a script file
+func Load(path string) (*Script, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return s, nil
+}
+
+// Parse parses the source of a script
+func Parse(src string) (*Script, error) {
+	s := &Script{}
+	var current *handler
+
+	for i, line := range strings.Split(src, "\n") {
+		n := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if current == nil {
+			h, err := parseHandler(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			h.line = n
+			current = h
+			continue
+		}
+
+		if line == "}" {
+			s.handlers = append(s.handlers, current)
+			current = nil
+			continue
+		}
+
+		a, err := parseAction(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		a.line = n
+		current.actions = append(current.actions, a)
+	}
+
+	if current != nil {
+		return nil, fmt.Errorf("line %d: the %s handler is not closed by }", current.line, current.event)
+	}
+	return s, nil
+}
+
+// parseHandler parses the first line of a handler: <event> <arguments> {
+func parseHandler(line string) (*handler, error) {
+	if !strings.HasSuffix(line, "{") {
+		return nil, fmt.Errorf("should be <event> <arguments> {")
+	}
+	line = strings.TrimSpace(strings.TrimSuffix(line, "{"))
+
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("should be <event> <arguments> {")
+	}
+	h := &handler{event: fields[0]}
+	args := strings.TrimSpace(strings.TrimPrefix(line, h.event))
+
+	switch h.event {
+	case OnOutput, OnInput:
+		quoted, err := strconv.QuotedPrefix(args)
+		if err != nil || quoted != args {
+			return nil, fmt.Errorf("should be %s \"<pattern>\" {", h.event)
+		}
+		pattern, _ := strconv.Unquote(quoted)
+		if h.re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("wrong pattern: %s", err)
+		}
+	case OnBreakpoint:
+		fields = fields[1:]
+		if len(fields) == 2 || (len(fields) > 2 && fields[1] != "if") {
+			return nil, fmt.Errorf("should be %s <addr> [if <expr>] {", h.event)
+		}
+		h.addr = fields[0]
+		if len(fields) > 2 {
+			h.cond = strings.Join(fields[2:], " ")
+		}
+	default:
+		return nil, fmt.Errorf("unknown event %q, should be %s, %s or %s", h.event, OnOutput, OnInput, OnBreakpoint)
+	}
+	return h, nil
+}
+
+// parseAction parses a line of a handler
+func parseAction(line string) (action, error) {
+	name := strings.Fields(line)[0]
+	args := strings.TrimSpace(strings.TrimPrefix(line, name))
+
+	switch name {
+	case "print":
+		if args == "" {
+			return action{}, fmt.Errorf("should be print <expr>, ...")
+		}
+		a := action{kind: "print"}
+		for _, e := range strings.Split(args, ",") {
+			a.exprs = append(a.exprs, strings.TrimSpace(e))
+		}
+		return a, nil
+	case "set":
+		parts := strings.SplitN(args, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			return action{}, fmt.Errorf("should be set <target> = <expr>")
+		}
+		return action{kind: "set", target: strings.TrimSpace(parts[0]), exprs: []string{strings.TrimSpace(parts[1])}}, nil
+	case "}":
+		return action{}, fmt.Errorf("unexpected }")
+	}
+	return action{kind: "command", exprs: []string{line}}, nil
+}
+
+// Attach registers the handlers of the script on machine, the values printed and the errors of the actions are written
+// to log. It fails if the address of a breakpoint is unknown.
+func (s *Script) Attach(machine *vm.VM, log io.Writer) error {
+	lines := map[string]*lineReader{OnOutput: {}, OnInput: {}}
+	pending := []*handler{}
+
+	for _, h := range s.handlers {
+		h := h
+		switch h.event {
+		case OnOutput, OnInput:
+			lines[h.event].handlers = append(lines[h.event].handlers, h)
+		case OnBreakpoint:
+			addr, err := machine.Eval(h.addr)
+			if err != nil || addr < 0 || addr >= vm.M {
+				return fmt.Errorf("line %d: wrong address %q", h.line, h.addr)
+			}
+			machine.HookAddress(uint16(addr), func(machine *vm.VM) vm.HookResult {
+				if h.cond != "" {
+					v, err := machine.Eval(h.cond)
+					if err != nil {
+						fmt.Fprintf(log, "Script line %d: could not evaluate %q: %s\n", h.line, h.cond, err)
+						return vm.HookFallThrough
+					}
+					if v == 0 {
+						return vm.HookFallThrough
+					}
+				}
+				h.run(machine, log)
+				return vm.HookFallThrough
+			})
+		}
+	}
+
+	collect := func(r *lineReader) func(*vm.VM, byte) {
+		return func(_ *vm.VM, c byte) {
+			pending = append(pending, r.feed(c)...)
+		}
+	}
+	machine.OnOutput(collect(lines[OnOutput]))
+	machine.OnInput(collect(lines[OnInput]))
+	machine.OnBeforeInstruction(func(machine *vm.VM) {
+		for len(pending) > 0 {
+			h := pending[0]
+			pending = pending[1:]
+			h.run(machine, log)
+		}
+	})
+	return nil
+}
+
+// lineReader gathers the bytes of the output or the input into lines and returns the handlers they trigger
+type lineReader struct {
+	handlers []*handler
+	line     []byte
+}
+
+func (r *lineReader) feed(c byte) []*handler {
+	if len(r.handlers) == 0 {
+		return nil
+	}
+	if c != '\n' {
+		r.line = append(r.line, c)
+		return nil
+	}
+
+	line := r.line
+	r.line = r.line[:0]
+	triggered := []*handler{}
+	for _, h := range r.handlers {
+		if h.re.Match(line) {
+			triggered = append(triggered, h)
+		}
+	}
+	return triggered
+}
+
+// run executes the actions of the handler, an action that fails is reported and the next ones still run
+func (h *handler) run(machine *vm.VM, log io.Writer) {
+	for _, a := range h.actions {
+		if err := a.run(machine, log); err != nil {
+			fmt.Fprintf(log, "Script line %d: %s\n", a.line, err)
+		}
+	}
+}
+
+func (a action) run(machine *vm.VM, log io.Writer) error {
+	switch a.kind {
+	case "print":
+		values := []string{}
+		for _, e := range a.exprs {
+			v, err := machine.Eval(e)
+			if err != nil {
+				return fmt.Errorf("could not evaluate %q: %s", e, err)
+			}
+			values = append(values, fmt.Sprintf("%s = %d", e, v))
+		}
+		fmt.Fprintln(log, strings.Join(values, ", "))
+	case "set":
+		v, err := machine.Eval(a.exprs[0])
+		if err != nil {
+			return fmt.Errorf("could not evaluate %q: %s", a.exprs[0], err)
+		}
+		if v < 0 || v >= vm.M+8 {
+			return fmt.Errorf("%d is not a value", v)
+		}
+		return set(machine, a.target, uint16(v))
+	default:
+		machine.Command(a.exprs[0])
+	}
+	return nil
+}
+
+var registerRegex = regexp.MustCompile(`^r([0-7])$`)
+
+// set changes a register, a memory address or the cursor
+func set(machine *vm.VM, target string, v uint16) error {
+	if m := registerRegex.FindStringSubmatch(target); m != nil {
+		r, _ := strconv.Atoi(m[1])
+		machine.SetRegister(r, v)
+		return nil
+	}
+	if target == "cursor" {
+		machine.SetCursor(v)
+		return nil
+	}
+	if strings.HasPrefix(target, "mem[") && strings.HasSuffix(target, "]") {
+		e := strings.TrimSuffix(strings.TrimPrefix(target, "mem["), "]")
+		addr, err := machine.Eval(e)
+		if err != nil {
+			return fmt.Errorf("could not evaluate %q: %s", e, err)
+		}
+		if addr < 0 || addr >= vm.M {
+			return fmt.Errorf("mem[%d] is out of memory", addr)
+		}
+		machine.SetMemory(uint16(addr), v)
+		return nil
+	}
+	return fmt.Errorf("can't set %q, should be r0 to r7, mem[<expr>] or cursor", target)
+}
@@ -0,0 +1,153 @@
+// Package search shards a space of candidates (values of a register, sequences of commands...) across a pool of
+// workers, each candidate is tried on its own clone of a VM
+package search
+
+import (
+	"context"
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// defaultShardSize is the number of consecutive candidates a worker takes at once
+const defaultShardSize = 64
+
+// Job describes the candidates and how to try them
+type Job struct {
+	Candidates int // Number of candidates, numbered from 0
+
+	// Prepare sets a candidate up on a clone before it runs (e.g. sets a register or the input). The clone has no input
+	// and discards its output unless Prepare changes them.
+	Prepare func(machine *vm.VM, candidate int)
+
+	// Accept tells whether the candidate is a solution once the clone stopped
+	Accept func(machine *vm.VM, candidate int, reason vm.ExitReason, err error) bool
+
+	// Until stops a candidate with vm.ExitOutputMatch as soon as its output matches, e.g. on the text telling whether
+	// it succeeded, nil runs it until it stops by itself or exhausts the budget
+	Until *regexp.Regexp
+}
+
+// Options tune the pool
+type Options struct {
+	Workers   int    // Number of workers, runtime.NumCPU() if 0
+	Budget    uint64 // Instructions executed at most per candidate, 0 means no limit
+	ShardSize int    // Consecutive candidates given to a worker at once, 64 if 0
+	First     bool   // Stop once a candidate is accepted, the workers still finish the candidate they are trying
+}
+
+// Stats counts how the candidates stopped
+type Stats struct {
+	Tried     int // Candidates that ran until they stopped by themselves, were stopped by Job.Until or the budget
+	Canceled  int // Candidates interrupted before the end by ctx or by an accepted one (with Options.First)
+	Exhausted int // Candidates stopped because they executed the whole budget
+	Matched   int // Candidates stopped because their output matched Job.Until
+	Failed    int // Candidates stopped by an error
+}
+
+// Run tries the candidates of job on clones of base until they are all tried, ctx is done or (with opts.First) one
+// is accepted. It returns the accepted candidates in increasing order. base must not be modified during the search
+// and the functions of its patches are shared by the clones: they must be safe for concurrent use.
+func Run(ctx context.Context, base *vm.VM, job Job, opts Options) ([]int, Stats) {
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	shardSize := opts.ShardSize
+	if shardSize <= 0 {
+		shardSize = defaultShardSize
+	}
+
+	// The clones of the candidates share the pages of a copy-on-write memory instead of copying the whole memory
+	base = base.Clone()
+	base.SetCopyOnWrite(true)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	shards := make(chan int)
+	go func() {
+		defer close(shards)
+		for start := 0; start < job.Candidates; start += shardSize {
+			select {
+			case shards <- start:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	found := []int{}
+	stats := Stats{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for start := range shards {
+				for candidate := start; candidate < start+shardSize && candidate < job.Candidates; candidate++ {
+					if ctx.Err() != nil {
+						return
+					}
+
+					accepted, reason, err := try(ctx, base, job, candidate, opts.Budget)
+
+					mu.Lock()
+					if reason == vm.ExitCanceled {
+						stats.Canceled++
+					} else {
+						stats.Tried++
+					}
+					switch reason {
+					case vm.ExitBudget:
+						stats.Exhausted++
+					case vm.ExitOutputMatch:
+						stats.Matched++
+					}
+					if err != nil {
+						stats.Failed++
+					}
+					if accepted {
+						found = append(found, candidate)
+						if opts.First {
+							cancel()
+						}
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	sort.Ints(found)
+	return found, stats
+}
+
+// try runs a candidate on a clone of base
+func try(ctx context.Context, base *vm.VM, job Job, candidate int, budget uint64) (bool, vm.ExitReason, error) {
+	machine := base.Clone()
+	machine.SetOutput(ioutil.Discard)
+	if job.Prepare != nil {
+		job.Prepare(machine, candidate)
+	}
+
+	var reason vm.ExitReason
+	var err error
+	if job.Until != nil {
+		reason, err = machine.RunUntilOutput(ctx, job.Until, budget)
+	} else {
+		reason, err = machine.RunLimit(ctx, budget)
+	}
+	if reason == vm.ExitCanceled {
+		// Interrupted before the end, the candidate can't be judged
+		return false, reason, nil
+	}
+	return job.Accept(machine, candidate, reason, err), reason, err
+}
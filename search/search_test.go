@@ -0,0 +1,33 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+func TestStatsExcludeCanceled(t *testing.T) {
+	// Halts when R0 is 0, loops forever otherwise
+	base := vm.New([]uint16{vm.JF, vm.M, 5, vm.JMP, 3, vm.HALT}, bytes.NewReader(nil), ioutil.Discard)
+	job := Job{
+		Candidates: 4,
+		Prepare:    func(machine *vm.VM, candidate int) { machine.SetRegister(0, uint16(candidate)) },
+		Accept: func(machine *vm.VM, candidate int, reason vm.ExitReason, err error) bool {
+			return reason == vm.ExitHalt
+		},
+	}
+
+	found, stats := Run(context.Background(), base, job, Options{Workers: 4, ShardSize: 1, First: true})
+	if len(found) != 1 || found[0] != 0 {
+		t.Fatalf("found %v instead of [0]", found)
+	}
+	if stats.Tried != 1 {
+		t.Errorf("%d candidates tried instead of 1 (%d canceled)", stats.Tried, stats.Canceled)
+	}
+	if stats.Tried+stats.Canceled > job.Candidates {
+		t.Errorf("%d tried and %d canceled out of %d candidates", stats.Tried, stats.Canceled, job.Candidates)
+	}
+}
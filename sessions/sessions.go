@@ -0,0 +1,400 @@
+// Package sessions manages named VMs for the servers and for experiments juggling several forks of a game: each
+// session runs its VM in its own goroutine, reads the input sent to it and can be paused, resumed, forked,
+// snapshotted and destroyed.
+//
+// A paused session isn't executing instructions: pausing interrupts the computations between two instructions and
+// the reads of the input, the interrupted IN is executed again on resume. The limits of the manager bound the
+// instructions each session executes and the memory its snapshots take.
+package sessions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Limits bound the resources of each session, 0 means no limit
+type Limits struct {
+	Instructions  uint64 // Instructions executed by the session, it finishes with vm.ExitBudget once they are
+	SnapshotBytes int    // Size of the snapshots kept by the session
+}
+
+// Status is the lifecycle state of a session
+type Status int
+
+// Statuses of a session
+const (
+	Paused   Status = iota // Created or paused, the VM can be used
+	Running                // The VM runs in the goroutine of the session
+	Finished               // The VM stopped (halt, end of input, error or budget), see Info.Reason
+)
+
+func (s Status) String() string {
+	switch s {
+	case Paused:
+		return "paused"
+	case Running:
+		return "running"
+	case Finished:
+		return "finished"
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Info describes a session
+type Info struct {
+	Name          string
+	Status        Status
+	Instructions  uint64   // Executed by the session, as of its last pause
+	Reason        string   // Why the VM stopped, once Finished
+	Snapshots     []string // Labels of the snapshots, sorted
+	SnapshotBytes int      // Size of the snapshots
+}
+
+// errPaused stops the reads of the input of a session being paused
+var errPaused = errors.New("session paused")
+
+// Manager holds the sessions, it can be used from several goroutines
+type Manager struct {
+	mu       sync.Mutex
+	limits   Limits
+	sessions map[string]*Session
+}
+
+// NewManager creates a manager applying limits to its sessions
+func NewManager(limits Limits) *Manager {
+	return &Manager{limits: limits, sessions: map[string]*Session{}}
+}
+
+// Create adds a paused session running machine, whose input is replaced by the one of the session (see Send)
+func (m *Manager) Create(name string, machine *vm.VM) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.sessions[name]; ok {
+		return nil, fmt.Errorf("session %s already exists", name)
+	}
+
+	s := &Session{
+		name:      name,
+		manager:   m,
+		machine:   machine,
+		start:     machine.Instructions(),
+		input:     newInput(),
+		snapshots: map[string]*vm.Snapshot{},
+	}
+	machine.SetInput(s.input)
+	m.sessions[name] = s
+	return s, nil
+}
+
+// Fork adds a paused session running a clone of the VM of from, which must be paused. The clone writes to the same
+// output, its input, snapshots and instruction count start empty.
+func (m *Manager) Fork(name, from string) (*Session, error) {
+	s, ok := m.Session(from)
+	if !ok {
+		return nil, fmt.Errorf("no session %s", from)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.status == Running {
+		return nil, fmt.Errorf("session %s is running", from)
+	}
+	return m.Create(name, s.machine.Clone())
+}
+
+// Session returns the session with the given name
+func (m *Manager) Session(name string) (*Session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[name]
+	return s, ok
+}
+
+// List describes the sessions, sorted by name
+func (m *Manager) List() []Info {
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		sessions = append(sessions, s)
+	}
+	m.mu.Unlock()
+
+	infos := make([]Info, 0, len(sessions))
+	for _, s := range sessions {
+		infos = append(infos, s.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Destroy stops the session and removes it, its input ends
+func (m *Manager) Destroy(name string) error {
+	s, ok := m.Session(name)
+	if !ok {
+		return fmt.Errorf("no session %s", name)
+	}
+
+	s.Pause()
+	s.input.close()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, name)
+	return nil
+}
+
+// Session is a VM managed by a Manager
+type Session struct {
+	name    string
+	manager *Manager
+	input   *input
+
+	mu        sync.Mutex
+	machine   *vm.VM
+	start     uint64 // Instructions of the VM when the session was created
+	executed  uint64 // Instructions executed by the session as of its last pause, the VM can't be read while it runs
+	status    Status
+	reason    string
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed when the goroutine running the VM returns
+	snapshots map[string]*vm.Snapshot
+}
+
+// Name returns the name of the session
+func (s *Session) Name() string {
+	return s.name
+}
+
+// Machine returns the VM of the session, it must not be used while the session is running
+func (s *Session) Machine() *vm.VM {
+	return s.machine
+}
+
+// Info describes the session
+func (s *Session) Info() Info {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := Info{Name: s.name, Status: s.status, Instructions: s.executed, Reason: s.reason}
+	for label := range s.snapshots {
+		info.Snapshots = append(info.Snapshots, label)
+	}
+	sort.Strings(info.Snapshots)
+	info.SnapshotBytes = s.snapshotBytes()
+	return info
+}
+
+// Send appends text to the input of the session, e.g. "look\n"
+func (s *Session) Send(text string) {
+	s.input.write(text)
+}
+
+// Resume runs the VM of a paused session in the goroutine of the session
+func (s *Session) Resume() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.status {
+	case Running:
+		return fmt.Errorf("session %s is already running", s.name)
+	case Finished:
+		return fmt.Errorf("session %s is finished: %s", s.name, s.reason)
+	}
+
+	budget := uint64(0)
+	if limit := s.manager.limits.Instructions; limit > 0 {
+		if s.executed >= limit {
+			s.status, s.reason = Finished, vm.ExitBudget.String()
+			return fmt.Errorf("session %s is finished: %s", s.name, s.reason)
+		}
+		budget = limit - s.executed
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.status, s.cancel, s.done = Running, cancel, make(chan struct{})
+	s.input.resume()
+
+	go s.run(ctx, budget, s.done)
+	return nil
+}
+
+// run executes the VM until it stops or the session is paused, then closes done
+func (s *Session) run(ctx context.Context, budget uint64, done chan struct{}) {
+	defer close(done)
+
+	reason, err := s.machine.RunLimit(ctx, budget)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.executed = s.machine.Instructions() - s.start
+	switch {
+	case reason == vm.ExitCanceled || errors.Is(err, errPaused):
+		s.status = Paused
+	case err != nil:
+		s.status, s.reason = Finished, err.Error()
+	default:
+		s.status, s.reason = Finished, reason.String()
+	}
+}
+
+// Pause stops a running session and waits for its VM to stop, it does nothing if the session isn't running
+func (s *Session) Pause() {
+	s.mu.Lock()
+	if s.status != Running {
+		s.mu.Unlock()
+		return
+	}
+	s.cancel()
+	s.input.pause()
+	done := s.done
+	s.mu.Unlock()
+
+	<-done
+}
+
+// Wait waits for the VM of the session to stop, paused or finished
+func (s *Session) Wait() {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// Snapshot saves the state of the VM of a session that isn't running under label, replacing the snapshot with the
+// same label. It fails if the snapshots would exceed the limit of the manager.
+func (s *Session) Snapshot(label string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status == Running {
+		return fmt.Errorf("session %s is running", s.name)
+	}
+
+	snapshot := s.machine.Snapshot()
+	size := s.snapshotBytes() + snapshotSize(snapshot)
+	if old, ok := s.snapshots[label]; ok {
+		size -= snapshotSize(old)
+	}
+	if limit := s.manager.limits.SnapshotBytes; limit > 0 && size > limit {
+		return fmt.Errorf("the snapshots of session %s would take %d bytes, more than the limit of %d", s.name, size, limit)
+	}
+
+	s.snapshots[label] = snapshot
+	return nil
+}
+
+// Restore puts back the state saved under label in the VM of a session that isn't running. A finished session can
+// run again, unless it exhausted its instructions: restoring doesn't give them back.
+func (s *Session) Restore(label string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status == Running {
+		return fmt.Errorf("session %s is running", s.name)
+	}
+	snapshot, ok := s.snapshots[label]
+	if !ok {
+		return fmt.Errorf("session %s has no snapshot %s", s.name, label)
+	}
+
+	s.machine.Restore(snapshot)
+	s.status, s.reason = Paused, ""
+	return nil
+}
+
+// DeleteSnapshot removes the snapshot saved under label
+func (s *Session) DeleteSnapshot(label string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.snapshots, label)
+}
+
+// snapshotBytes returns the size of the snapshots of the session
+func (s *Session) snapshotBytes() int {
+	size := 0
+	for _, snapshot := range s.snapshots {
+		size += snapshotSize(snapshot)
+	}
+	return size
+}
+
+// snapshotSize is the size of the memory and the stack of a snapshot, the registers and the cursor are negligible
+func snapshotSize(s *vm.Snapshot) int {
+	return 2 * (s.MemoryLen() + len(s.Stack))
+}
+
+// input is the input of a session: the text sent to it, waited for until the session is paused or destroyed
+type input struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	paused bool
+	closed bool
+}
+
+func newInput() *input {
+	in := &input{paused: true}
+	in.cond = sync.NewCond(&in.mu)
+	return in
+}
+
+func (in *input) Read(b []byte) (int, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for len(in.buf) == 0 && !in.paused && !in.closed {
+		in.cond.Wait()
+	}
+	switch {
+	case len(in.buf) > 0 && !in.paused:
+		n := copy(b, in.buf)
+		in.buf = in.buf[n:]
+		return n, nil
+	case in.closed:
+		return 0, io.EOF
+	}
+	return 0, errPaused
+}
+
+func (in *input) write(text string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.buf = append(in.buf, text...)
+	in.cond.Broadcast()
+}
+
+func (in *input) pause() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.paused = true
+	in.cond.Broadcast()
+}
+
+func (in *input) resume() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.paused = false
+}
+
+func (in *input) close() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.closed = true
+	in.cond.Broadcast()
+}
@@ -0,0 +1,204 @@
+// Package symbols names addresses of the binary so that the tools print and accept names instead of raw addresses
+//
+// A symbol file is either a JSON object mapping addresses to names:
+//
+//	{"5451": "teleporter_check", "6027": "confirmation"}
+//
+// or a text file with one symbol per line, as "addr=name" or "addr: name" (a flat YAML mapping), where lines starting
+// with '#' are comments:
+//
+//	# Teleporter
+//	5451=teleporter_check
+//	6027: confirmation
+package symbols
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Table maps addresses to names and back, a nil table has no symbol
+type Table struct {
+	names map[uint16]string
+	addrs map[string]uint16
+}
+
+// New returns an empty table
+func New() *Table {
+	return &Table{names: map[uint16]string{}, addrs: map[string]uint16{}}
+}
+
+// Add names addr, a name must be an identifier (letters, digits and underscores, not starting by a digit) given to a
+// single address
+func (t *Table) Add(addr uint16, name string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid symbol name %q", name)
+	}
+	if other, ok := t.addrs[name]; ok && other != addr {
+		return fmt.Errorf("symbol %q is already the name of %d", name, other)
+	}
+
+	if old, ok := t.names[addr]; ok {
+		delete(t.addrs, old)
+	}
+	t.names[addr] = name
+	t.addrs[name] = addr
+	return nil
+}
+
+// Name returns the name of addr
+func (t *Table) Name(addr uint16) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	name, ok := t.names[addr]
+	return name, ok
+}
+
+// Lookup returns the address named name
+func (t *Table) Lookup(name string) (uint16, bool) {
+	if t == nil {
+		return 0, false
+	}
+	addr, ok := t.addrs[name]
+	return addr, ok
+}
+
+// Format returns the name of addr, or addr in decimal if it has none
+func (t *Table) Format(addr uint16) string {
+	if name, ok := t.Name(addr); ok {
+		return name
+	}
+	return strconv.Itoa(int(addr))
+}
+
+// Resolve parses an address given as a decimal number, a name or a name plus a decimal offset (e.g. confirmation+8)
+func (t *Table) Resolve(s string) (uint16, error) {
+	if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return uint16(n), nil
+	}
+
+	name, offset := s, uint64(0)
+	if i := strings.LastIndex(s, "+"); i > 0 {
+		n, err := strconv.ParseUint(s[i+1:], 10, 16)
+		if err != nil {
+			return 0, fmt.Errorf("wrong offset in %q", s)
+		}
+		name, offset = s[:i], n
+	}
+
+	addr, ok := t.Lookup(name)
+	if !ok {
+		return 0, fmt.Errorf("unknown symbol %q", name)
+	}
+	if uint64(addr)+offset > 0xffff {
+		return 0, fmt.Errorf("%q is out of range", s)
+	}
+	return addr + uint16(offset), nil
+}
+
+// Addresses returns the named addresses in increasing order
+func (t *Table) Addresses() []uint16 {
+	if t == nil {
+		return nil
+	}
+	addrs := make([]uint16, 0, len(t.names))
+	for addr := range t.names {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Load reads a symbol file, files ending by .json are read as JSON and the others as text
+func Load(path string) (*Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		t, err := ReadJSON(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return t, nil
+	}
+
+	t, err := ReadText(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// ReadJSON reads a JSON object mapping addresses to names
+func ReadJSON(r io.Reader) (*Table, error) {
+	symbols := map[string]string{}
+	if err := json.NewDecoder(r).Decode(&symbols); err != nil {
+		return nil, err
+	}
+
+	t := New()
+	for key, name := range symbols {
+		addr, err := strconv.ParseUint(key, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("wrong address %q", key)
+		}
+		if err := t.Add(uint16(addr), name); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// ReadText reads one "addr=name" or "addr: name" symbol per line
+func ReadText(r io.Reader) (*Table, error) {
+	t := New()
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: should be addr=name", n)
+		}
+
+		key, name := strings.TrimSpace(line[:i]), strings.Trim(strings.TrimSpace(line[i+1:]), `"'`)
+		addr, err := strconv.ParseUint(key, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: wrong address %q", n, key)
+		}
+		if err := t.Add(uint16(addr), name); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+
+	return t, scanner.Err()
+}
+
+// validName returns true for identifiers
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+// Package tracediff compares two execution traces written by -trace, e.g. before and after a patch or for two values
+// of the eighth register in the teleporter code: the traces are read side by side until their first divergence, which
+// is reported with the instructions around it.
+package tracediff
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Entry is a line of a trace: an executed instruction
+type Entry struct {
+	N           int    // Number of the line in the trace, from 1
+	Addr        uint16 // Address of the instruction
+	Instruction string // Name and operands, the registers with their values, e.g. "add: [R0=4 R1=2 1]"
+	Registers   [8]uint16
+	Line        string
+}
+
+// Options tune what is compared
+type Options struct {
+	IgnoreAddresses bool    // Compare the instructions and the registers only, e.g. for code moved by a patch
+	IgnoreRegisters [8]bool // Registers whose values don't matter, in the operands as well
+	Context         int     // Entries shown before and after the divergence
+}
+
+// Divergence is the first difference between two traces
+type Divergence struct {
+	Index  int     // Number of entries read from each trace before the divergence
+	A, B   *Entry  // Entries that differ, nil for a trace that ended
+	Before []Entry // Common entries preceding the divergence (the ones of the first trace)
+	AfterA []Entry // Entries following the divergence in each trace
+	AfterB []Entry
+}
+
+var lineRegex = regexp.MustCompile(`^\(\s*(\d+)\)(?: <[^>]*>)? \| (.*) \[([0-9 ]*)\]$`)
+
+// Parse parses a line (numbered n) of a trace
+func Parse(n int, line string) (Entry, error) {
+	m := lineRegex.FindStringSubmatch(line)
+	if m == nil {
+		return Entry{}, fmt.Errorf("line %d: not a trace entry: %q", n, line)
+	}
+
+	addr, err := strconv.ParseUint(m[1], 10, 16)
+	if err != nil {
+		return Entry{}, fmt.Errorf("line %d: wrong address %s", n, m[1])
+	}
+	e := Entry{N: n, Addr: uint16(addr), Instruction: strings.TrimSpace(m[2]), Line: line}
+
+	regs := strings.Fields(m[3])
+	if len(regs) != len(e.Registers) {
+		return Entry{}, fmt.Errorf("line %d: %d registers instead of %d", n, len(regs), len(e.Registers))
+	}
+	for i, r := range regs {
+		v, err := strconv.ParseUint(r, 10, 16)
+		if err != nil {
+			return Entry{}, fmt.Errorf("line %d: wrong register %s", n, r)
+		}
+		e.Registers[i] = uint16(v)
+	}
+	return e, nil
+}
+
+// operandRegex matches the registers in the operands of an instruction
+var operandRegex = regexp.MustCompile(`R([0-7])=\d+`)
+
+// key returns what is compared of an entry
+func (o Options) key(e Entry) string {
+	instruction := operandRegex.ReplaceAllStringFunc(e.Instruction, func(s string) string {
+		if o.IgnoreRegisters[s[1]-'0'] {
+			return s[:3] + "?"
+		}
+		return s
+	})
+
+	regs := e.Registers
+	for i, ignored := range o.IgnoreRegisters {
+		if ignored {
+			regs[i] = 0
+		}
+	}
+
+	if o.IgnoreAddresses {
+		return fmt.Sprintf("%s %v", instruction, regs)
+	}
+	return fmt.Sprintf("%d %s %v", e.Addr, instruction, regs)
+}
+
+// reader reads the entries of a trace
+type reader struct {
+	s *bufio.Scanner
+	n int
+}
+
+func newReader(r io.Reader) *reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &reader{s: s}
+}
+
+// next returns the next entry, nil at the end of the trace
+func (r *reader) next() (*Entry, error) {
+	for r.s.Scan() {
+		r.n++
+		if strings.TrimSpace(r.s.Text()) == "" {
+			continue
+		}
+		e, err := Parse(r.n, r.s.Text())
+		if err != nil {
+			return nil, err
+		}
+		return &e, nil
+	}
+	return nil, r.s.Err()
+}
+
+// Diff reads the traces a and b side by side and returns their first divergence, nil if they are identical. The
+// traces can be larger than the memory: only the context is kept.
+func Diff(a, b io.Reader, opts Options) (*Divergence, error) {
+	ra, rb := newReader(a), newReader(b)
+	before := []Entry{}
+
+	for i := 0; ; i++ {
+		ea, err := ra.next()
+		if err != nil {
+			return nil, fmt.Errorf("first trace: %s", err)
+		}
+		eb, err := rb.next()
+		if err != nil {
+			return nil, fmt.Errorf("second trace: %s", err)
+		}
+
+		if ea == nil && eb == nil {
+			return nil, nil
+		}
+		if ea != nil && eb != nil && opts.key(*ea) == opts.key(*eb) {
+			if opts.Context > 0 {
+				if len(before) == opts.Context {
+					before = before[1:]
+				}
+				before = append(before, *ea)
+			}
+			continue
+		}
+
+		d := &Divergence{Index: i, A: ea, B: eb, Before: before}
+		if d.AfterA, err = following(ra, ea, opts.Context); err != nil {
+			return nil, fmt.Errorf("first trace: %s", err)
+		}
+		if d.AfterB, err = following(rb, eb, opts.Context); err != nil {
+			return nil, fmt.Errorf("second trace: %s", err)
+		}
+		return d, nil
+	}
+}
+
+// following reads up to n entries after current, none if the trace already ended
+func following(r *reader, current *Entry, n int) ([]Entry, error) {
+	entries := []Entry{}
+	for current != nil && len(entries) < n {
+		e, err := r.next()
+		if err != nil || e == nil {
+			return entries, err
+		}
+		entries = append(entries, *e)
+	}
+	return entries, nil
+}
+
+// Write prints the divergence, nameA and nameB naming the traces
+func (d *Divergence) Write(w io.Writer, nameA, nameB string) {
+	describe := func(name string, e *Entry) string {
+		if e == nil {
+			return fmt.Sprintf("%s ended", name)
+		}
+		return fmt.Sprintf("%s:%d: %s", name, e.N, e.Line)
+	}
+
+	fmt.Fprintf(w, "The traces diverge after %d common instructions\n", d.Index)
+	for _, e := range d.Before {
+		fmt.Fprintf(w, "  %s\n", e.Line)
+	}
+	fmt.Fprintf(w, "- %s\n", describe(nameA, d.A))
+	for _, e := range d.AfterA {
+		fmt.Fprintf(w, "  %s\n", e.Line)
+	}
+	fmt.Fprintf(w, "+ %s\n", describe(nameB, d.B))
+	for _, e := range d.AfterB {
+		fmt.Fprintf(w, "  %s\n", e.Line)
+	}
+}
@@ -0,0 +1,187 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Ages of an access (in instructions executed since) drawn in bright and in normal colors by the memory map, the older
+// ones are dimmed
+const (
+	recentAge = 1000
+	activeAge = 100000
+)
+
+// arrows are the escape sequences of the arrow keys, a line made of them moves the selection of the memory map
+var arrows = map[string][2]int{"\033[A": {0, -1}, "\033[B": {0, 1}, "\033[C": {1, 0}, "\033[D": {-1, 0}}
+
+// activity is the last time the program executed, wrote and read (with RMEM) each address, as the number of
+// instructions executed then, 0 for never
+type activity struct {
+	exec, write, read []uint64
+}
+
+// track records the activity of machine from now on
+func track(machine *vm.VM) *activity {
+	a := &activity{exec: make([]uint64, vm.M), write: make([]uint64, vm.M), read: make([]uint64, vm.M)}
+
+	machine.OnBeforeInstruction(func(m *vm.VM) {
+		now := m.Instructions() + 1
+		cursor := m.Cursor()
+		words := m.MemRange(cursor, cursor+3)
+		if len(words) == 0 {
+			return
+		}
+
+		for i := 0; i <= isa.Operands(words[0]) && int(cursor)+i < vm.M; i++ {
+			a.exec[int(cursor)+i] = now
+		}
+		if words[0] == vm.RMEM && len(words) == 3 {
+			addr := words[2]
+			if addr >= vm.M && addr < vm.M+8 {
+				addr = m.Register(int(addr - vm.M))
+			}
+			if addr < vm.M {
+				a.read[addr] = now
+			}
+		}
+	})
+	machine.OnMemoryWrite(func(m *vm.VM, addr, _, _ uint16) {
+		a.write[addr] = m.Instructions() + 1
+	})
+	return a
+}
+
+// cell returns the character of the words from start to end in the memory map: the kind of their latest access
+// (x for executed, w for written, r for read) colored by its age, '.' if they were never accessed
+func (a *activity) cell(start, end int, now uint64) string {
+	var last uint64
+	kind, color := byte('.'), ""
+	for addr := start; addr < end && addr < vm.M; addr++ {
+		for _, k := range []struct {
+			at    uint64
+			kind  byte
+			color string
+		}{{a.exec[addr], 'x', "32"}, {a.write[addr], 'w', "31"}, {a.read[addr], 'r', "36"}} {
+			if k.at > last {
+				last, kind, color = k.at, k.kind, k.color
+			}
+		}
+	}
+
+	switch age := now - last; {
+	case last == 0:
+		return "."
+	case age < recentAge:
+		return fmt.Sprintf("\033[1;%sm%c\033[0m", color, kind-'a'+'A')
+	case age < activeAge:
+		return fmt.Sprintf("\033[%sm%c\033[0m", color, kind)
+	}
+	return fmt.Sprintf("\033[2;%sm%c\033[0m", color, kind)
+}
+
+// describe tells the last accesses to addr, for the status line of the memory map
+func (a *activity) describe(addr int, now uint64) string {
+	accesses := []string{}
+	for _, k := range []struct {
+		at   uint64
+		verb string
+	}{{a.exec[addr], "executed"}, {a.write[addr], "written"}, {a.read[addr], "read"}} {
+		if k.at > 0 {
+			accesses = append(accesses, fmt.Sprintf("%s %d ago", k.verb, now-k.at))
+		}
+	}
+	if len(accesses) == 0 {
+		return "never accessed"
+	}
+	return strings.Join(accesses, ", ")
+}
+
+// memoryMap returns the memory map pane fitting in width and n lines: a heatmap of the activity of the memory, each cell
+// covering zoom words, around the selected address
+func (d *Debugger) memoryMap(width, n int) []string {
+	cols, rows := width-6, n-2
+	if cols < 1 || rows < 1 {
+		return nil
+	}
+	zoom := d.mapZoom(cols, rows)
+	now := d.machine.Instructions() + 1
+
+	// Scroll to keep the selection in the middle when the whole memory doesn't fit
+	perRow := cols * zoom
+	total := (vm.M + perRow - 1) / perRow
+	first := d.selected/perRow - rows/2
+	if first > total-rows {
+		first = total - rows
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	lines := []string{fmt.Sprintf("Memory map, %d words per cell (x executed, w written, r read)", zoom)}
+	for row := first; row < first+rows && row < total; row++ {
+		line := fmt.Sprintf("%5d ", row*perRow)
+		for col := 0; col < cols && row*perRow+col*zoom < vm.M; col++ {
+			start := row*perRow + col*zoom
+			c := d.activity.cell(start, start+zoom, now)
+			if d.selected >= start && d.selected < start+zoom {
+				c = "\033[7m" + escapes.ReplaceAllString(c, "") + "\033[0m"
+			}
+			line += c
+		}
+		lines = append(lines, line)
+	}
+	return append(lines, fmt.Sprintf("Selected %s: %s", d.addr(uint16(d.selected)), d.activity.describe(d.selected, now)))
+}
+
+// mapZoom returns the number of words per cell of the memory map, the one of :zoom or the smallest power of 2 showing
+// the whole memory in cols x rows cells
+func (d *Debugger) mapZoom(cols, rows int) int {
+	if d.zoom > 0 {
+		return d.zoom
+	}
+	zoom := 1
+	for zoom*cols*rows < vm.M {
+		zoom *= 2
+	}
+	return zoom
+}
+
+// moveSelection moves the selection of the memory map by dx cells and dy rows, the hexdump follows it
+func (d *Debugger) moveSelection(dx, dy int) {
+	// The geometry of the pane drawn by draw
+	cols, rows := d.width/2-6, (d.height-3)/2-2
+	zoom := d.mapZoom(cols, rows)
+	d.selectAddr(d.selected + dx*zoom + dy*zoom*cols)
+}
+
+// selectAddr selects addr in the memory map and shows it in the hexdump
+func (d *Debugger) selectAddr(addr int) {
+	if addr < 0 {
+		addr = 0
+	} else if addr >= vm.M {
+		addr = vm.M - 1
+	}
+	d.selected, d.memory = addr, addr
+}
+
+// arrowMoves returns the moves of a line made of arrow keys, false if it's something else
+func arrowMoves(line string) (dx, dy int, ok bool) {
+	if line == "" {
+		return 0, 0, false
+	}
+	for line != "" {
+		if len(line) < 3 {
+			return 0, 0, false
+		}
+		move, known := arrows[line[:3]]
+		if !known {
+			return 0, 0, false
+		}
+		dx, dy, line = dx+move[0], dy+move[1], line[3:]
+	}
+	return dx, dy, true
+}
@@ -0,0 +1,446 @@
+// Package tui is a full screen terminal debugger: panes showing the disassembly at the cursor (or a map of the activity
+// of the memory), the registers, the stack, a hexdump of the memory and the output of the game above a command line.
+// The screen is drawn with ANSI escape codes and redrawn every time the VM waits for a line, so stepping updates it
+// live.
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// prompt is printed by the VM in stepping mode before reading a command
+const prompt = ">>> "
+
+// maxOutput is the number of bytes of output kept for the output pane
+const maxOutput = 1024 * 1024
+
+// pageKeys are the escape sequences of the Page Up and Page Down keys, a line made of one of them scrolls the output
+// pane by a page
+var pageKeys = map[string]int{"\033[5~": 1, "\033[6~": -1}
+
+// escapes matches the color codes printed by the debugger, they would break the alignment of the panes
+var escapes = regexp.MustCompile("\033\\[[0-9;]*[a-zA-Z]")
+
+// Debugger draws the state of a VM on a terminal and reads the commands and the input of the game from it
+type Debugger struct {
+	machine *vm.VM
+	in      *bufio.Reader
+	screen  io.Writer
+
+	width, height int
+
+	output  []byte // Output of the game and the debugger
+	pending []byte // Line read from the terminal, not yet read by the VM
+	memory  int    // Address of the hexdump, -1 to follow the cursor
+	scroll  int    // Lines of the output pane scrolled back, 0 to follow the end
+
+	activity *activity // Accesses to the memory, drawn by the memory map
+	showMap  bool      // The memory map replaces the disassembly
+	selected int       // Address selected in the memory map
+	zoom     int       // Words per cell of the memory map, 0 to fit the whole memory
+}
+
+// New creates a debugger reading the terminal from in and drawing on screen. It replaces the input and the output of
+// machine, which should be in stepping mode.
+func New(machine *vm.VM, in io.Reader, screen io.Writer) *Debugger {
+	d := &Debugger{machine: machine, in: bufio.NewReader(in), screen: screen, memory: -1, activity: track(machine)}
+	d.width, d.height = Size()
+	machine.SetInput(d)
+	machine.SetOutput(&outputPane{d})
+	return d
+}
+
+// SetSize overrides the size of the terminal
+func (d *Debugger) SetSize(width, height int) {
+	d.width, d.height = width, height
+}
+
+// Enter switches the terminal to its alternate screen
+func (d *Debugger) Enter() {
+	fmt.Fprint(d.screen, "\033[?1049h")
+}
+
+// Leave restores the screen that was shown before Enter
+func (d *Debugger) Leave() {
+	fmt.Fprint(d.screen, "\033[?1049l")
+}
+
+// Read gives the VM the next line typed on the terminal, after drawing the screen. An empty line at the debugger
+// prompt steps, the lines starting with : are handled by the debugger itself:
+//
+//	:mem <expr>	shows the memory from <expr> in the hexdump (e.g. :mem room), :mem alone follows the cursor
+//	:map [<expr>]	shows or hides the memory map, selecting <expr> (the arrow keys then Enter move the selection)
+//	:zoom in|out	changes the number of words per cell of the memory map, :zoom alone fits the whole memory
+//	:scroll up|down	scrolls the output pane back or forth by a page, or by n lines with :scroll up n (Page Up and
+//			Page Down then Enter also scroll), :scroll alone follows the end again
+//	:quit		stops the VM as if the input was exhausted
+func (d *Debugger) Read(b []byte) (int, error) {
+	p := "? "
+	if strings.HasSuffix(string(d.output), prompt) {
+		d.output = d.output[:len(d.output)-len(prompt)]
+		p = prompt
+	}
+
+	for len(d.pending) == 0 {
+		// Don't redraw for every line of a file of commands
+		if d.in.Buffered() == 0 {
+			d.draw(p)
+		}
+
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			return 0, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if strings.HasPrefix(line, ":") {
+			if d.command(strings.Fields(line[1:])) {
+				return 0, io.EOF
+			}
+			continue
+		}
+		if dx, dy, ok := arrowMoves(line); ok && d.showMap {
+			d.moveSelection(dx, dy)
+			continue
+		}
+		if pages, ok := pageKeys[line]; ok {
+			d.scrollOutput(pages * d.page())
+			continue
+		}
+		d.scroll = 0
+
+		if line == "" && p == prompt {
+			line = "step"
+		} else {
+			// The game doesn't echo what is typed
+			d.write([]byte(p + line + "\n"))
+		}
+		d.pending = []byte(line + "\n")
+	}
+
+	n := copy(b, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// command handles the commands of the debugger, it returns true to quit
+func (d *Debugger) command(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch fields[0] {
+	case "quit":
+		return true
+
+	case "mem":
+		if len(fields) == 1 {
+			d.memory = -1
+			return false
+		}
+		addr, err := d.machine.Eval(strings.Join(fields[1:], " "))
+		if err != nil || addr < 0 || addr >= vm.M {
+			d.write([]byte(fmt.Sprintf("Wrong address %s\n", strings.Join(fields[1:], " "))))
+			return false
+		}
+		d.memory = addr
+
+	case "map":
+		if len(fields) == 1 {
+			d.showMap = !d.showMap
+			return false
+		}
+		addr, err := d.machine.Eval(strings.Join(fields[1:], " "))
+		if err != nil || addr < 0 || addr >= vm.M {
+			d.write([]byte(fmt.Sprintf("Wrong address %s\n", strings.Join(fields[1:], " "))))
+			return false
+		}
+		d.showMap = true
+		d.selectAddr(addr)
+
+	case "scroll":
+		if len(fields) == 1 {
+			d.scroll = 0
+			return false
+		}
+		n := d.page()
+		if len(fields) == 3 {
+			v, err := strconv.Atoi(fields[2])
+			if err != nil || v <= 0 {
+				d.write([]byte("Wrong number of lines " + fields[2] + "\n"))
+				return false
+			}
+			n = v
+		}
+		switch {
+		case len(fields) > 3 || (fields[1] != "up" && fields[1] != "down"):
+			d.write([]byte("Wrong command, use :scroll up [n], :scroll down [n] or :scroll\n"))
+		case fields[1] == "up":
+			d.scrollOutput(n)
+		default:
+			d.scrollOutput(-n)
+		}
+
+	case "zoom":
+		cols, rows := d.width/2-6, (d.height-3)/2-2
+		switch {
+		case len(fields) == 1:
+			d.zoom = 0
+		case fields[1] == "in" && d.mapZoom(cols, rows) > 1:
+			d.zoom = d.mapZoom(cols, rows) / 2
+		case fields[1] == "out" && d.mapZoom(cols, rows) < vm.M:
+			d.zoom = d.mapZoom(cols, rows) * 2
+		case fields[1] != "in" && fields[1] != "out":
+			d.write([]byte("Wrong command, use :zoom in, :zoom out or :zoom\n"))
+		}
+
+	default:
+		d.write([]byte("Unknown command :" + fields[0] + ", use :mem [<expr>], :map [<expr>], :zoom [in|out], :scroll [up|down] or :quit\n"))
+	}
+
+	return false
+}
+
+// write appends to the output pane
+func (d *Debugger) write(b []byte) {
+	d.output = append(d.output, b...)
+	if len(d.output) > maxOutput {
+		d.output = append([]byte{}, d.output[len(d.output)-maxOutput:]...)
+	}
+}
+
+// page returns the number of lines of the output pane
+func (d *Debugger) page() int {
+	return d.height - 3 - (d.height-3)/2
+}
+
+// scrollOutput scrolls the output pane back by n lines, forth if n is negative, without going past its first or last
+// line
+func (d *Debugger) scrollOutput(n int) {
+	d.scroll += n
+	if last := len(d.outputLines(d.width)) - d.page(); d.scroll > last {
+		d.scroll = last
+	}
+	if d.scroll < 0 {
+		d.scroll = 0
+	}
+}
+
+// outputPane is the output of the VM
+type outputPane struct {
+	d *Debugger
+}
+
+func (o *outputPane) Write(b []byte) (int, error) {
+	o.d.write(b)
+	return len(b), nil
+}
+
+// draw redraws the whole screen, the terminal cursor is left on the command line after p
+func (d *Debugger) draw(p string) {
+	width, height := d.width, d.height
+	upper := (height - 3) / 2
+	lower := height - 3 - upper
+	left := width / 2
+	right := width - left - 3
+
+	rightPane := append(d.registers(), "")
+	rightPane = append(rightPane, d.stack(upper/3)...)
+	rightPane = append(rightPane, "")
+	rightPane = append(rightPane, d.hexdump(right, upper-len(rightPane))...)
+
+	leftPane := d.disassembly(upper)
+	if d.showMap {
+		leftPane = d.memoryMap(left, upper)
+	}
+
+	header := fmt.Sprintf(" Synacor debugger | cursor %s | %s | Enter: step, :mem <expr>, :map, :quit", d.addr(d.machine.Cursor()), d.machine.State())
+	lines := []string{"\033[7m" + pad(header, width) + "\033[0m"}
+	for i := 0; i < upper; i++ {
+		lines = append(lines, pad(at(leftPane, i), left)+" │ "+pad(at(rightPane, i), right))
+	}
+	out := d.outputLines(width)
+	title := " Output"
+	if d.scroll > 0 && len(out) > lower {
+		end := len(out) - d.scroll
+		if end < lower {
+			end = lower
+		}
+		title = fmt.Sprintf(" Output, lines %d-%d of %d (:scroll down or Page Down for the next ones, :scroll for the end)", end-lower+1, end, len(out))
+		out = out[:end]
+	}
+	lines = append(lines, "\033[7m"+pad(title, width)+"\033[0m")
+	if len(out) > lower {
+		out = out[len(out)-lower:]
+	}
+	for i := 0; i < lower; i++ {
+		lines = append(lines, pad(at(out, i), width))
+	}
+
+	fmt.Fprint(d.screen, "\033[H\033[2J"+strings.Join(lines, "\r\n")+"\r\n"+p)
+}
+
+// disassembly returns n instructions from the cursor, preceded by their names
+func (d *Debugger) disassembly(n int) []string {
+	memory := d.machine.MemRange(0, vm.M)
+	syms := d.machine.Symbols()
+
+	lines := []string{"Disassembly"}
+	for addr := int(d.machine.Cursor()); len(lines) < n && addr < len(memory); {
+		if name, ok := syms.Name(uint16(addr)); ok && addr != int(d.machine.Cursor()) {
+			lines = append(lines, "   "+name+":")
+		}
+
+		marker := "  "
+		if addr == int(d.machine.Cursor()) {
+			marker = "=>"
+		}
+		lines = append(lines, fmt.Sprintf("%s (%5d) %s", marker, addr, isa.DisassembleWith(memory, uint16(addr), syms)))
+
+		addr += isa.Operands(memory[addr]) + 1
+	}
+	return lines
+}
+
+// registers returns the registers, four per line
+func (d *Debugger) registers() []string {
+	lines := []string{"Registers"}
+	for r := 0; r < 8; r += 4 {
+		line := ""
+		for i := r; i < r+4; i++ {
+			line += fmt.Sprintf("R%d %5d  ", i, d.machine.Register(i))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// stack returns the top n values of the stack, the top first
+func (d *Debugger) stack(n int) []string {
+	stack := d.machine.StackEntries()
+	lines := []string{fmt.Sprintf("Stack (depth %d)", len(stack))}
+	for i := len(stack) - 1; i >= 0 && len(lines) < n; i-- {
+		e := stack[i]
+		line := fmt.Sprintf("%5d", e.Value)
+		if e.Frame != nil {
+			// A return address, the function it returns to
+			line += " ret"
+			if !e.Top {
+				line += " " + d.machine.Symbols().Format(e.In)
+			}
+		} else if name, ok := d.machine.Symbols().Name(e.Value); ok {
+			line += " <" + name + ">"
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// hexdump returns n lines of memory fitting in width, from the :mem address or the cursor
+func (d *Debugger) hexdump(width, n int) []string {
+	start := d.memory
+	if start < 0 {
+		start = int(d.machine.Cursor())
+	}
+
+	// "addr: " then 5 characters per word and one for its character
+	perLine := (width - 7) / 6
+	if perLine < 1 {
+		perLine = 1
+	} else if perLine > 8 {
+		perLine = 8
+	}
+
+	lines := []string{"Memory at " + d.addr(uint16(start))}
+	for addr := start; len(lines) < n && addr < vm.M; addr += perLine {
+		hex, text := "", ""
+		for _, w := range d.machine.MemRange(uint16(addr), uint16(addr+perLine)) {
+			hex += fmt.Sprintf("%04x ", w)
+			if w >= 32 && w < 127 {
+				text += string(rune(w))
+			} else {
+				text += "."
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%5d: %s%s", addr, hex, text))
+	}
+	return lines
+}
+
+// outputLines returns the output wrapped to width, without the color codes
+func (d *Debugger) outputLines(width int) []string {
+	text := escapes.ReplaceAllString(string(d.output), "")
+	text = strings.Replace(text, "\r", "", -1)
+
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		for len(line) > width {
+			lines = append(lines, line[:width])
+			line = line[width:]
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// addr formats an address with its name
+func (d *Debugger) addr(addr uint16) string {
+	if name, ok := d.machine.Symbols().Name(addr); ok {
+		return fmt.Sprintf("%d <%s>", addr, name)
+	}
+	return strconv.Itoa(int(addr))
+}
+
+// Size returns the size of the terminal from stty, or the COLUMNS and LINES variables, 80x24 if they are unknown
+func Size() (int, int) {
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.Output(); err == nil {
+		var height, width int
+		if _, err := fmt.Sscan(string(out), &height, &width); err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+	}
+
+	width, err1 := strconv.Atoi(os.Getenv("COLUMNS"))
+	height, err2 := strconv.Atoi(os.Getenv("LINES"))
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 80, 24
+	}
+	return width, height
+}
+
+// pad truncates or pads s with spaces to width characters, its color codes don't count but are dropped if it's
+// truncated
+func pad(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	visible := len(s)
+	if strings.Contains(s, "\033") {
+		visible = len(escapes.ReplaceAllString(s, ""))
+	}
+	if visible > width {
+		s = escapes.ReplaceAllString(s, "")
+		return s[:width]
+	}
+	return s + strings.Repeat(" ", width-visible)
+}
+
+// at returns lines[i], "" if there is none
+func at(lines []string, i int) string {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return ""
+}
@@ -0,0 +1,268 @@
+package verify
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Reference is a naive interpreter written from the spec alone, without the debugger, patches or hooks of the vm
+// package, so that it can be used as a reference to check the VM against
+type Reference struct {
+	memory    []uint16
+	registers [8]uint16
+	stack     []uint16
+	cursor    uint16
+	in        *bufio.Reader
+	out       io.Writer
+}
+
+// NewReference creates a reference interpreter running memory
+func NewReference(memory []uint16, in io.Reader, out io.Writer) *Reference {
+	return &Reference{memory: memory, in: bufio.NewReader(in), out: out}
+}
+
+// Cursor returns the address of the next instruction
+func (r *Reference) Cursor() uint16 { return r.cursor }
+
+// Register returns the value of the register i (from 0 to 7)
+func (r *Reference) Register(i int) uint16 { return r.registers[i] }
+
+// Stack returns a copy of the stack, the top of the stack is the last value
+func (r *Reference) Stack() []uint16 { return append([]uint16{}, r.stack...) }
+
+// Memory returns the value stored at addr
+func (r *Reference) Memory(addr uint16) uint16 { return r.memory[addr] }
+
+// Step executes one instruction, it returns an error wrapping vm.ErrHalt when the program stops and io.EOF when the
+// input is exhausted
+func (r *Reference) Step() error {
+	c := int(r.cursor)
+	if c >= len(r.memory) {
+		return fmt.Errorf("%w: cursor %d is out of memory", vm.ErrInvalidAddress, c)
+	}
+
+	// word returns the raw operand i (from 1)
+	word := func(i int) (uint16, error) {
+		if c+i >= len(r.memory) {
+			return 0, fmt.Errorf("%w: operand %d of the instruction at %d is out of memory", vm.ErrInvalidAddress, i, c)
+		}
+		return r.memory[c+i], nil
+	}
+
+	// value returns the operand i as a value: a literal or the content of a register
+	value := func(i int) (uint16, error) {
+		w, err := word(i)
+		switch {
+		case err != nil:
+			return 0, err
+		case w < vm.M:
+			return w, nil
+		case w < vm.M+8:
+			return r.registers[w-vm.M], nil
+		}
+		return 0, fmt.Errorf("%w %d at %d", vm.ErrInvalidOperand, w, c)
+	}
+
+	// dest returns the register named by the operand i
+	dest := func(i int) (*uint16, error) {
+		w, err := word(i)
+		if err != nil {
+			return nil, err
+		}
+		if w < vm.M || w >= vm.M+8 {
+			return nil, fmt.Errorf("%w: %d at %d is not a register", vm.ErrInvalidOperand, w, c)
+		}
+		return &r.registers[w-vm.M], nil
+	}
+
+	// values returns the operands from 2 to n as values
+	values := func(n int) ([]uint16, error) {
+		vs := []uint16{}
+		for i := 2; i <= n; i++ {
+			v, err := value(i)
+			if err != nil {
+				return nil, err
+			}
+			vs = append(vs, v)
+		}
+		return vs, nil
+	}
+
+	// compute stores fn of the n-1 operands following the destination register and moves to the next instruction
+	compute := func(n int, fn func(vs []uint16) uint16) error {
+		a, err := dest(1)
+		if err != nil {
+			return err
+		}
+		vs, err := values(n)
+		if err != nil {
+			return err
+		}
+		*a = fn(vs) % vm.M
+		r.cursor += uint16(n) + 1
+		return nil
+	}
+
+	bool16 := func(b bool) uint16 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+
+	switch op := r.memory[c]; op {
+	case vm.HALT:
+		return vm.ErrHalt
+
+	case vm.SET:
+		return compute(2, func(vs []uint16) uint16 { return vs[0] })
+
+	case vm.PUSH:
+		a, err := value(1)
+		if err != nil {
+			return err
+		}
+		r.stack = append(r.stack, a)
+		r.cursor += 2
+
+	case vm.POP:
+		if len(r.stack) == 0 {
+			return fmt.Errorf("%w: pop at %d", vm.ErrStackUnderflow, c)
+		}
+		a, err := dest(1)
+		if err != nil {
+			return err
+		}
+		*a = r.stack[len(r.stack)-1]
+		r.stack = r.stack[:len(r.stack)-1]
+		r.cursor += 2
+
+	case vm.EQ:
+		return compute(3, func(vs []uint16) uint16 { return bool16(vs[0] == vs[1]) })
+
+	case vm.GT:
+		return compute(3, func(vs []uint16) uint16 { return bool16(vs[0] > vs[1]) })
+
+	case vm.JMP:
+		a, err := value(1)
+		if err != nil {
+			return err
+		}
+		r.cursor = a
+
+	case vm.JT, vm.JF:
+		a, err := value(1)
+		if err != nil {
+			return err
+		}
+		b, err := value(2)
+		if err != nil {
+			return err
+		}
+		if (a != 0) == (op == vm.JT) {
+			r.cursor = b
+		} else {
+			r.cursor += 3
+		}
+
+	case vm.ADD:
+		return compute(3, func(vs []uint16) uint16 { return uint16((uint32(vs[0]) + uint32(vs[1])) % vm.M) })
+
+	case vm.MULT:
+		return compute(3, func(vs []uint16) uint16 { return uint16((uint32(vs[0]) * uint32(vs[1])) % vm.M) })
+
+	case vm.MOD:
+		a, err := values(3)
+		if err != nil {
+			return err
+		}
+		if a[1] == 0 {
+			return fmt.Errorf("%w: mod at %d", vm.ErrDivisionByZero, c)
+		}
+		return compute(3, func(vs []uint16) uint16 { return vs[0] % vs[1] })
+
+	case vm.AND:
+		return compute(3, func(vs []uint16) uint16 { return vs[0] & vs[1] })
+
+	case vm.OR:
+		return compute(3, func(vs []uint16) uint16 { return vs[0] | vs[1] })
+
+	case vm.NOT:
+		return compute(2, func(vs []uint16) uint16 { return ^vs[0] & 0x7fff })
+
+	case vm.RMEM:
+		b, err := value(2)
+		if err != nil {
+			return err
+		}
+		if int(b) >= len(r.memory) {
+			return fmt.Errorf("%w: rmem of %d at %d is out of memory", vm.ErrInvalidAddress, b, c)
+		}
+		a, err := dest(1)
+		if err != nil {
+			return err
+		}
+		// The word as is, not modulo 32768: the words from 32768 aren't registers here
+		*a = r.memory[b]
+		r.cursor += 3
+		return nil
+
+	case vm.WMEM:
+		a, err := value(1)
+		if err != nil {
+			return err
+		}
+		b, err := value(2)
+		if err != nil {
+			return err
+		}
+		if int(a) >= len(r.memory) {
+			return fmt.Errorf("%w: wmem to %d at %d is out of memory", vm.ErrInvalidAddress, a, c)
+		}
+		r.memory[a] = b
+		r.cursor += 3
+
+	case vm.CALL:
+		a, err := value(1)
+		if err != nil {
+			return err
+		}
+		r.stack = append(r.stack, r.cursor+2)
+		r.cursor = a
+
+	case vm.RET:
+		if len(r.stack) == 0 {
+			return fmt.Errorf("%w: ret with an empty stack", vm.ErrHalt)
+		}
+		r.cursor = r.stack[len(r.stack)-1]
+		r.stack = r.stack[:len(r.stack)-1]
+
+	case vm.OUT:
+		a, err := value(1)
+		if err != nil {
+			return err
+		}
+		if _, err := r.out.Write([]byte{byte(a)}); err != nil {
+			return err
+		}
+		r.cursor += 2
+
+	case vm.IN:
+		b, err := r.in.ReadByte()
+		if err != nil {
+			return err
+		}
+		return compute(1, func([]uint16) uint16 { return uint16(b) })
+
+	case vm.NOOP:
+		r.cursor++
+
+	default:
+		return fmt.Errorf("%w %d at %d", vm.ErrInvalidOpcode, op, c)
+	}
+
+	return nil
+}
@@ -0,0 +1,204 @@
+// Package verify runs two interpreters in lockstep on the same binary and input and reports the first instruction after
+// which their states differ, to check the VM against a reference implementation
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Machine is an interpreter that can be verified
+type Machine interface {
+	Step() error // Executes one instruction, the errors wrap vm.ErrHalt when the program stops and io.EOF at the end of the input
+	Cursor() uint16
+	Register(r int) uint16
+	Stack() []uint16
+	Memory(addr uint16) uint16
+}
+
+// Factory creates a machine running memory (its own copy), reading in and writing its output to out
+type Factory func(memory []uint16, in io.Reader, out io.Writer) Machine
+
+// Implementations are the interpreters that can be compared, by name
+var Implementations = map[string]Factory{
+	"vm": func(memory []uint16, in io.Reader, out io.Writer) Machine {
+		return vm.New(memory, in, out)
+	},
+	"vm-decode-cache": func(memory []uint16, in io.Reader, out io.Writer) Machine {
+		machine := vm.New(memory, in, out)
+		machine.EnableDecodeCache()
+		return machine
+	},
+	"reference": func(memory []uint16, in io.Reader, out io.Writer) Machine {
+		return NewReference(memory, in, out)
+	},
+}
+
+// Names returns the names of the implementations, sorted
+func Names() []string {
+	names := []string{}
+	for name := range Implementations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Options tune a lockstep run
+type Options struct {
+	MaxSteps    uint64 // Stop after this many instructions, 0 means no limit
+	MemoryEvery uint64 // Compare the whole memory every MemoryEvery instructions, 0 only compares the written cells
+}
+
+// Divergence is the first instruction after which the machines differ
+type Divergence struct {
+	Step        uint64   // Number of instructions executed before the diverging one
+	Cursor      uint16   // Address of the diverging instruction
+	Instruction string   // Disassembly of the diverging instruction
+	Differences []string // What differs, e.g. "R1: 4 != 5"
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("Divergence at instruction %d: (%6d) %s\n%s", d.Step, d.Cursor, d.Instruction, strings.Join(d.Differences, "\n"))
+}
+
+// Result is the outcome of a lockstep run
+type Result struct {
+	Steps      uint64      // Instructions executed by each machine
+	Stopped    string      // Why the first machine stopped: "halt", "end of input", "error" or "step limit"
+	Err        error       // Error of the first machine when Stopped is "error"
+	Divergence *Divergence // nil if the machines never differed
+}
+
+// Lockstep runs the machines created by a and b on copies of memory with the same input, comparing the cursor, the
+// registers, the stack, the output and the memory written after each instruction. It stops at the first divergence,
+// when both machines stop or after opts.MaxSteps instructions.
+func Lockstep(memory []uint16, input []byte, a, b Factory, opts Options) Result {
+	outA, outB := &bytes.Buffer{}, &bytes.Buffer{}
+	ma := a(append([]uint16{}, memory...), bytes.NewReader(input), outA)
+	mb := b(append([]uint16{}, memory...), bytes.NewReader(input), outB)
+
+	res := Result{}
+	for opts.MaxSteps == 0 || res.Steps < opts.MaxSteps {
+		cursor := ma.Cursor()
+		words := instruction(ma, cursor, len(memory))
+		d := &Divergence{Step: res.Steps, Cursor: cursor}
+
+		written := -1
+		if ma.Memory(cursor) == vm.WMEM && int(cursor)+1 < len(memory) {
+			written = int(operand(ma, ma.Memory(cursor+1)))
+		}
+
+		errA, errB := ma.Step(), mb.Step()
+		res.Steps++
+
+		stopA, stopB := stopReason(errA), stopReason(errB)
+		if stopA != stopB {
+			d.Differences = append(d.Differences, fmt.Sprintf("stopped: %v != %v", errA, errB))
+		}
+
+		d.Differences = append(d.Differences, compare(ma, mb, written, len(memory), opts.MemoryEvery > 0 && res.Steps%opts.MemoryEvery == 0)...)
+
+		if !bytes.Equal(outA.Bytes(), outB.Bytes()) {
+			d.Differences = append(d.Differences, fmt.Sprintf("output: %q != %q", outA.String(), outB.String()))
+		}
+		// Only the output of the last instruction is kept
+		outA.Reset()
+		outB.Reset()
+
+		if len(d.Differences) > 0 || stopA != "" {
+			if len(d.Differences) > 0 {
+				d.Instruction = isa.Disassemble(words, 0)
+				res.Divergence = d
+			}
+			res.Stopped = stopA
+			if stopA == "error" {
+				res.Err = errA
+			}
+			return res
+		}
+	}
+
+	res.Stopped = "step limit"
+	return res
+}
+
+// compare returns the differences between the machines' cursors, registers, stacks and memory at written (if not
+// negative) or on the whole memory if full is true
+func compare(a, b Machine, written, size int, full bool) []string {
+	diffs := []string{}
+
+	if a.Cursor() != b.Cursor() {
+		diffs = append(diffs, fmt.Sprintf("cursor: %d != %d", a.Cursor(), b.Cursor()))
+	}
+
+	for r := 0; r < 8; r++ {
+		if a.Register(r) != b.Register(r) {
+			diffs = append(diffs, fmt.Sprintf("R%d: %d != %d", r, a.Register(r), b.Register(r)))
+		}
+	}
+
+	sa, sb := a.Stack(), b.Stack()
+	if len(sa) != len(sb) {
+		diffs = append(diffs, fmt.Sprintf("stack depth: %d != %d", len(sa), len(sb)))
+	} else {
+		for i := range sa {
+			if sa[i] != sb[i] {
+				diffs = append(diffs, fmt.Sprintf("stack[%d]: %d != %d", i, sa[i], sb[i]))
+			}
+		}
+	}
+
+	addrs := []int{}
+	if full {
+		for addr := 0; addr < size; addr++ {
+			addrs = append(addrs, addr)
+		}
+	} else if written >= 0 && written < size {
+		addrs = append(addrs, written)
+	}
+	for _, addr := range addrs {
+		if va, vb := a.Memory(uint16(addr)), b.Memory(uint16(addr)); va != vb {
+			diffs = append(diffs, fmt.Sprintf("mem[%d]: %d != %d", addr, va, vb))
+		}
+	}
+
+	return diffs
+}
+
+// stopReason returns why a machine stopped, "" if err is nil
+func stopReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, vm.ErrHalt):
+		return "halt"
+	case errors.Is(err, io.EOF):
+		return "end of input"
+	}
+	return "error"
+}
+
+// operand returns the value of an operand of the instruction at the cursor of m
+func operand(m Machine, v uint16) uint16 {
+	if v >= vm.M && v < vm.M+8 {
+		return m.Register(int(v - vm.M))
+	}
+	return v
+}
+
+// instruction returns the words of the instruction at addr, the longest instructions have 4
+func instruction(m Machine, addr uint16, size int) []uint16 {
+	words := make([]uint16, 0, 4)
+	for i := 0; i < 4 && int(addr)+i < size; i++ {
+		words = append(words, m.Memory(addr+uint16(i)))
+	}
+	return words
+}
@@ -0,0 +1,123 @@
+// Package versions identifies the challenge binary being run, so that the puzzles patch the addresses of this binary
+// rather than the ones of the binary of this repository: every player gets a different binary. The known binaries are
+// listed by their SHA-256 in the manifest embedded from data/versions.json, the addresses of the teleporter code of the
+// others are found by looking for its instructions.
+package versions
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sfluor/synacor/data"
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/vm"
+)
+
+// Version describes a challenge binary
+type Version struct {
+	Name                   string `json:"name"`
+	SHA256                 string `json:"sha256"`                  // SHA-256 of the binary, in hexadecimal
+	TeleporterCheck        uint16 `json:"teleporter_check"`        // Checks whether the eighth register is set
+	TeleporterArguments    uint16 `json:"teleporter_arguments"`    // Sets the arguments of the confirmation
+	TeleporterConfirmation uint16 `json:"teleporter_confirmation"` // Calls the confirmation function
+	Confirmation           uint16 `json:"confirmation"`            // Confirmation function
+	Room                   uint16 `json:"room"`                    // Where the current room is stored, 0 if unknown
+
+	Known bool `json:"-"` // Listed in the manifest, the addresses were found in the binary otherwise
+}
+
+// Manifest returns the known versions
+func Manifest() ([]Version, error) {
+	versions := []Version{}
+	if err := json.Unmarshal(data.Versions, &versions); err != nil {
+		return nil, fmt.Errorf("versions.json: %w", err)
+	}
+	for i := range versions {
+		versions[i].Known = true
+	}
+	return versions, nil
+}
+
+// Detect returns the version of bin: the one of the manifest with its hash or, if there is none, the addresses found
+// in its code. It fails if the teleporter code can't be found.
+func Detect(bin []uint16) (*Version, error) {
+	versions, err := Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	hash := fmt.Sprintf("%x", sha256.Sum256(loader.Encode(bin)))
+	for _, v := range versions {
+		if v.SHA256 == hash {
+			return &v, nil
+		}
+	}
+
+	v, ok := find(bin)
+	if !ok {
+		return nil, fmt.Errorf("unknown binary %s: the teleporter code was not found", hash)
+	}
+	v.Name, v.SHA256 = "unknown binary", hash
+	return v, nil
+}
+
+// Of returns the version of the binary loaded in machine, see Detect
+func Of(machine *vm.VM) (*Version, error) {
+	return Detect(machine.MemRange(0, vm.M))
+}
+
+// Registers as operands
+const (
+	r0 = vm.M + iota
+	r1
+	_
+	_
+	_
+	_
+	_
+	r7
+)
+
+// maxCheckDistance is how far before the arguments of the confirmation the check of the eighth register can be
+const maxCheckDistance = 64
+
+// find looks for the teleporter code:
+//
+//	jf R7 <no confirmation>
+//	...
+//	set R0 4
+//	set R1 1
+//	call <confirmation>
+//	eq R1 R0 6
+func find(bin []uint16) (*Version, bool) {
+	pattern := []int{int(vm.SET), r0, 4, int(vm.SET), r1, 1, int(vm.CALL), -1, int(vm.EQ), r1, r0, 6}
+
+	for addr := 0; addr+len(pattern) <= len(bin); addr++ {
+		if !matches(bin[addr:], pattern) {
+			continue
+		}
+
+		for check := addr - 3; check >= 0 && check >= addr-maxCheckDistance; check-- {
+			if (bin[check] == vm.JF || bin[check] == vm.JT) && bin[check+1] == r7 {
+				return &Version{
+					TeleporterCheck:        uint16(check),
+					TeleporterArguments:    uint16(addr),
+					TeleporterConfirmation: uint16(addr + 6),
+					Confirmation:           bin[addr+7],
+				}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// matches tells whether words start with pattern, -1 matching any word
+func matches(words []uint16, pattern []int) bool {
+	for i, p := range pattern {
+		if p != -1 && int(words[i]) != p {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package vm
+
+import "github.com/sfluor/synacor/isa"
+
+// HookResult tells what the VM does with the instruction at the address of a hook once the hook returns
+type HookResult int
+
+const (
+	// HookFallThrough executes the instruction at the cursor, as if there was no hook (the hook may have moved it)
+	HookFallThrough HookResult = iota
+	// HookSkip skips the instruction at the address of the hook, the execution continues after it
+	HookSkip
+	// HookReplace skips the instruction like HookSkip but continues at the cursor left by the hook (see SetCursor): the
+	// hook did the work of the instruction
+	HookReplace
+)
+
+// HookAddress registers fn to be called each time the cursor reaches addr, after the patches: fn can replace or skip
+// the instruction there. The hooks of an address are called in the order they were registered until one of them
+// doesn't return HookFallThrough.
+func (vm *VM) HookAddress(addr uint16, fn func(*VM) HookResult) {
+	if vm.addrHooks == nil {
+		vm.addrHooks = map[uint16][]func(*VM) HookResult{}
+	}
+	vm.addrHooks[addr] = append(vm.addrHooks[addr], fn)
+}
+
+// NopRange makes the instructions from start (included) to end (excluded) behave like NOOPs without modifying the
+// memory: reaching any of their addresses continues at end
+func (vm *VM) NopRange(start, end uint16) {
+	for addr := start; addr < end; addr++ {
+		vm.HookAddress(addr, func(vm *VM) HookResult {
+			vm.cursor = end
+			return HookReplace
+		})
+	}
+}
+
+// HookHits returns the number of times the cursor reached addr while it had hooks, the current one included when one of
+// them calls it: e.g. to only act from the second time. The counts belong to the VM, a clone starts from the ones of
+// the original and they are kept in the snapshots so that a restored game (a slot, a -session...) goes on counting.
+func (vm *VM) HookHits(addr uint16) int {
+	return vm.hookHits[addr]
+}
+
+// runAddressHooks calls the hooks of the cursor, it returns true if the instruction there must not be executed
+func (vm *VM) runAddressHooks() bool {
+	addr := vm.cursor
+	fns := vm.addrHooks[addr]
+	if len(fns) == 0 {
+		return false
+	}
+
+	if vm.hookHits == nil {
+		vm.hookHits = map[uint16]int{}
+	}
+	vm.hookHits[addr]++
+	for _, fn := range fns {
+		switch fn(vm) {
+		case HookSkip:
+			if n := vm.memory.Len(); int(addr) < n {
+				// Only the opcode is read, words would copy the whole memory for it
+				size := 1 + isa.Operands(vm.read(addr))
+				if int(addr)+size > n {
+					size = n - int(addr)
+				}
+				vm.cursor = addr + uint16(size)
+			}
+			return true
+		case HookReplace:
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestHookHitsPerVM(t *testing.T) {
+	// A loop going through the hooked address 0 every two steps
+	machine := New([]uint16{NOOP, JMP, 0}, bytes.NewReader(nil), ioutil.Discard)
+	machine.HookAddress(0, func(*VM) HookResult { return HookFallThrough })
+
+	step := func(machine *VM, n int) {
+		for i := 0; i < n; i++ {
+			if err := machine.Step(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	step(machine, 1)
+	clone := machine.Clone()
+	step(machine, 4)
+	if got := machine.HookHits(0); got != 3 {
+		t.Errorf("%d hits instead of 3", got)
+	}
+	if got := clone.HookHits(0); got != 1 {
+		t.Errorf("%d hits for the clone instead of 1", got)
+	}
+
+	step(clone, 2)
+	if got := clone.HookHits(0); got != 2 {
+		t.Errorf("%d hits for the clone after stepping instead of 2", got)
+	}
+	if got := machine.HookHits(0); got != 3 {
+		t.Errorf("%d hits after the clone stepped instead of 3", got)
+	}
+}
+
+func TestHookSkip(t *testing.T) {
+	out := &bytes.Buffer{}
+	machine := New([]uint16{OUT, 'a', OUT, 'b', HALT}, bytes.NewReader(nil), out)
+	machine.HookAddress(0, func(*VM) HookResult { return HookSkip })
+	if _, err := machine.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "b" {
+		t.Errorf("output %q instead of %q", out.String(), "b")
+	}
+}
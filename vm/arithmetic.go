@@ -0,0 +1,44 @@
+package vm
+
+import "fmt"
+
+// ArithmeticMode defines the edge cases the spec leaves implicit, see SetArithmetic
+type ArithmeticMode int
+
+// Arithmetic modes
+const (
+	// ArithmeticStrict stops on a MOD by zero (a Fault wrapping ErrDivisionByZero) and on an operand from 32776 (a
+	// Fault wrapping ErrInvalidOperand), -trap-faults can catch both
+	ArithmeticStrict ArithmeticMode = iota
+	// ArithmeticPermissive defines them instead: a MOD by zero leaves its first operand unchanged (b mod 0 = b) and an
+	// operand from 32776 is read modulo 32768, like every result. A literal destination still faults.
+	ArithmeticPermissive
+)
+
+func (m ArithmeticMode) String() string {
+	switch m {
+	case ArithmeticStrict:
+		return "strict"
+	case ArithmeticPermissive:
+		return "permissive"
+	}
+	return fmt.Sprintf("ArithmeticMode(%d)", int(m))
+}
+
+// ParseArithmeticMode returns the mode named by String
+func ParseArithmeticMode(name string) (ArithmeticMode, error) {
+	for _, m := range []ArithmeticMode{ArithmeticStrict, ArithmeticPermissive} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown arithmetic mode %q, should be strict or permissive", name)
+}
+
+// SetArithmetic chooses how the edge cases of the arithmetic are handled, ArithmeticStrict by default.
+//
+// ADD and MULT wrap the same way in both modes: they are computed on 32 bits before the modulo 32768. Computing them on
+// 16 bits would give the same results since 65536 is a multiple of 32768, but it would only be right by accident.
+func (vm *VM) SetArithmetic(m ArithmeticMode) {
+	vm.permissive = m == ArithmeticPermissive
+}
@@ -0,0 +1,195 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// bookmarkDir is the directory of the bookmarks in the state directory
+const bookmarkDir = "bookmarks"
+
+// bookmarkNameRegex matches the names of the bookmarks, they name their files
+var bookmarkNameRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Bookmark describes a named snapshot, it is stored next to it
+type Bookmark struct {
+	Name         string
+	Time         time.Time
+	Room         string // Title of the last room described before saving
+	Description  string // First paragraph of its description
+	Instructions uint64 // Instructions executed since the start of the game
+}
+
+// bookmarksDir returns the directory of the bookmarks
+func (vm *VM) bookmarksDir() string {
+	dir := vm.stateDir
+	if dir == "" {
+		dir = defaultStateDir
+	}
+	return filepath.Join(dir, bookmarkDir)
+}
+
+// bookmarkPaths returns the paths of the snapshot and the metadata of a bookmark
+func (vm *VM) bookmarkPaths(name string) (string, string) {
+	base := filepath.Join(vm.bookmarksDir(), name)
+	return base + ".snapshot", base + ".json"
+}
+
+// SaveBookmark saves the state of the VM under a name (e.g. before-teleporter) with the room it's in, replacing the
+// bookmark with the same name
+func (vm *VM) SaveBookmark(name string) (*Bookmark, error) {
+	if !bookmarkNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("wrong bookmark name %q, only letters, digits, dots, dashes and underscores are allowed", name)
+	}
+
+	snapshot, meta := vm.bookmarkPaths(name)
+	if err := os.MkdirAll(filepath.Dir(snapshot), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := vm.Snapshot().Save(snapshot); err != nil {
+		return nil, err
+	}
+
+	b := &Bookmark{Name: name, Time: time.Now(), Instructions: vm.instructions}
+	b.Room, b.Description = lastRoom(vm.LastOutput(outputHistory))
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return b, ioutil.WriteFile(meta, data, 0644)
+}
+
+// GotoBookmark restores the state saved under a name
+func (vm *VM) GotoBookmark(name string) (*Bookmark, error) {
+	if !bookmarkNameRegex.MatchString(name) {
+		return nil, fmt.Errorf("no bookmark %q", name)
+	}
+	snapshot, meta := vm.bookmarkPaths(name)
+
+	b, err := readBookmark(meta)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no bookmark %q", name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	s, err := LoadSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.Restore(s)
+	vm.instructions = b.Instructions
+	return b, nil
+}
+
+// DeleteBookmark removes the bookmark saved under a name
+func (vm *VM) DeleteBookmark(name string) error {
+	if !bookmarkNameRegex.MatchString(name) {
+		return fmt.Errorf("no bookmark %q", name)
+	}
+	snapshot, meta := vm.bookmarkPaths(name)
+
+	if err := os.Remove(meta); os.IsNotExist(err) {
+		return fmt.Errorf("no bookmark %q", name)
+	} else if err != nil {
+		return err
+	}
+	return os.Remove(snapshot)
+}
+
+// Bookmarks returns the saved bookmarks ordered by name
+func (vm *VM) Bookmarks() ([]*Bookmark, error) {
+	dir := vm.bookmarksDir()
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	bookmarks := []*Bookmark{}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		b, err := readBookmark(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+
+	sort.Slice(bookmarks, func(i, j int) bool { return bookmarks[i].Name < bookmarks[j].Name })
+	return bookmarks, nil
+}
+
+func readBookmark(path string) (*Bookmark, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bookmark{}
+	if err := json.Unmarshal(data, b); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// bookmarkCommand handles $bookmark <name>, $goto <name>, $unbookmark <name> and $bookmarks
+func (vm *VM) bookmarkCommand(name string, args []string) {
+	if name == "bookmarks" {
+		bookmarks, err := vm.Bookmarks()
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not list the bookmarks: %s\n", err))
+			return
+		}
+
+		lines := []string{}
+		for _, b := range bookmarks {
+			lines = append(lines, formatBookmark(b))
+		}
+		vm.printDebug("Bookmarks:\n" + strings.Join(lines, "\n") + "\n")
+		return
+	}
+
+	if len(args) != 1 {
+		vm.printError("Wrong command ! Should be $" + name + " <name>\n")
+		return
+	}
+
+	switch name {
+	case "bookmark":
+		b, err := vm.SaveBookmark(args[0])
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not save the bookmark: %s\n", err))
+			return
+		}
+		vm.printDebug("Saved " + formatBookmark(b) + "\n")
+	case "goto":
+		b, err := vm.GotoBookmark(args[0])
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not go to the bookmark: %s\n", err))
+			return
+		}
+		vm.printDebug("Loaded " + formatBookmark(b) + "\n")
+	case "unbookmark":
+		if err := vm.DeleteBookmark(args[0]); err != nil {
+			vm.printError(fmt.Sprintf("Could not delete the bookmark: %s\n", err))
+		}
+	}
+}
+
+func formatBookmark(b *Bookmark) string {
+	return fmt.Sprintf("%-24s %s  %10d instructions  %s", b.Name, b.Time.Format("2006-01-02 15:04:05"), b.Instructions, b.Room)
+}
@@ -0,0 +1,82 @@
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// breakpoint stops the execution when the cursor reaches its address and its condition (if any) is true
+type breakpoint struct {
+	cond   expr   // Condition, nil means always
+	source string // Condition as typed by the user
+}
+
+// addBreakpoint parses "<addr> [if <expr>]" and registers the breakpoint
+func (vm *VM) addBreakpoint(args []string) error {
+	if len(args) == 0 || (len(args) > 1 && args[1] != "if") || len(args) == 2 {
+		return fmt.Errorf("should be $break <addr> [if <expr>]")
+	}
+
+	addr, err := vm.parseAddress(args[0])
+	if err != nil {
+		return fmt.Errorf("wrong address: %s", err)
+	}
+
+	bp := breakpoint{}
+	if len(args) > 2 {
+		bp.source = strings.Join(args[2:], " ")
+		bp.cond, err = parseExpr(bp.source)
+		if err != nil {
+			return fmt.Errorf("wrong condition: %s", err)
+		}
+	}
+
+	if vm.breakpoints == nil {
+		vm.breakpoints = map[uint16]breakpoint{}
+	}
+	vm.breakpoints[addr] = bp
+
+	return nil
+}
+
+// checkBreakpoint goes to stepping mode if there is a breakpoint at the cursor whose condition holds
+func (vm *VM) checkBreakpoint() {
+	bp, ok := vm.breakpoints[vm.cursor]
+	if !ok {
+		return
+	}
+
+	if bp.cond != nil {
+		v, err := bp.cond.eval(vm)
+		if err != nil {
+			vm.printError(fmt.Sprintf("\nBreakpoint %s: could not evaluate %q: %s\n", vm.symbols.Format(vm.cursor), bp.source, err))
+		} else if v == 0 {
+			return
+		}
+	}
+
+	vm.stepping = true
+	vm.until = nil
+	vm.printDebug(fmt.Sprintf("\nBreakpoint: %s %s\n", vm.formatAddr(vm.cursor), vm.formatInstruction()))
+}
+
+// formatBreakpoints lists the breakpoints sorted by address
+func (vm VM) formatBreakpoints() string {
+	addrs := []int{}
+	for addr := range vm.breakpoints {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+
+	lines := []string{}
+	for _, addr := range addrs {
+		line := vm.formatAddr(uint16(addr))
+		if bp := vm.breakpoints[uint16(addr)]; bp.cond != nil {
+			line += " if " + bp.source
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,141 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Frame is an entry of the shadow call stack, maintained alongside the real stack by CALL and RET
+type Frame struct {
+	Site   uint16 // Address of the CALL instruction
+	Target uint16 // Address of the called function
+	Ret    uint16 // Return address pushed on the stack
+	Slot   int    // Index of the return address in the stack
+
+	entered uint64    // Instructions executed before the call, for the profile
+	node    *callNode // Node of the call in the call tree of the profile
+}
+
+// StackEntry is a value of the stack annotated with the call that pushed it, if any
+type StackEntry struct {
+	Value uint16
+	Frame *Frame // Call whose return address is the value, nil for the data pushed by PUSH
+	In    uint16 // Function the return address belongs to (the target of the enclosing call), when Frame isn't nil
+	Top   bool   // The return address belongs to the code outside of any call (In isn't set)
+}
+
+// CallStack returns the shadow call stack, outermost call first
+func (vm *VM) CallStack() []Frame {
+	return append([]Frame{}, vm.calls...)
+}
+
+// CallDepth returns the number of calls that haven't returned yet
+func (vm *VM) CallDepth() int {
+	return len(vm.calls)
+}
+
+// StackEntries returns the stack, the top of the stack last, telling the return addresses pushed by CALL from the
+// data. A value is a return address while the call that pushed it hasn't returned and it wasn't overwritten.
+func (vm *VM) StackEntries() []StackEntry {
+	entries := make([]StackEntry, len(vm.stack))
+	for i, v := range vm.stack {
+		entries[i].Value = v
+	}
+
+	for i := range vm.calls {
+		f := vm.calls[i]
+		if !vm.live(f) {
+			continue
+		}
+		entries[f.Slot].Frame = &f
+		if i > 0 {
+			entries[f.Slot].In = vm.calls[i-1].Target
+		} else {
+			entries[f.Slot].Top = true
+		}
+	}
+	return entries
+}
+
+// live tells whether the return address of a frame is still on the stack, the program can pop or overwrite it
+func (vm *VM) live(f Frame) bool {
+	return f.Slot < len(vm.stack) && vm.stack[f.Slot] == f.Ret
+}
+
+// enterCall records a CALL from the cursor to target
+func (vm *VM) enterCall(target uint16) {
+	if vm.history != nil {
+		vm.history.log(change{kind: callEnter})
+	}
+	f := Frame{Site: vm.cursor, Target: target, Ret: vm.cursor + 2, Slot: len(vm.stack), entered: vm.instructions}
+	if vm.profile != nil {
+		parent := vm.profile.root
+		if len(vm.calls) > 0 && vm.calls[len(vm.calls)-1].node != nil {
+			parent = vm.calls[len(vm.calls)-1].node
+		}
+		f.node = vm.profile.enter(parent, vm.cursor, target)
+	}
+	vm.calls = append(vm.calls, f)
+}
+
+// leaveCall unwinds the shadow call stack after a RET to addr. The program can tamper with the stack so frames are
+// dropped until the one returning to addr, if there is none the shadow stack is left untouched.
+func (vm *VM) leaveCall(addr uint16) {
+	for i := len(vm.calls) - 1; i >= 0; i-- {
+		if vm.calls[i].Ret == addr {
+			if vm.history != nil {
+				vm.history.log(change{kind: callLeave, frames: append([]Frame{}, vm.calls[i:]...)})
+			}
+			if vm.profile != nil {
+				for j := len(vm.calls) - 1; j >= i; j-- {
+					vm.profile.leave(vm.calls[j], vm.instructions)
+				}
+			}
+			vm.calls = vm.calls[:i]
+			return
+		}
+	}
+}
+
+// formatStack returns the stack, the top first, with the call and the function of the return addresses
+func (vm VM) formatStack() string {
+	entries := vm.StackEntries()
+	if len(entries) == 0 {
+		return "empty"
+	}
+
+	lines := []string{fmt.Sprintf("%d values, top first", len(entries))}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		line := fmt.Sprintf("[%3d] %5d  ", i, e.Value)
+		switch {
+		case e.Frame == nil:
+			line += "data"
+			if e.Value >= 32 && e.Value < 127 {
+				line += fmt.Sprintf(" %q", rune(e.Value))
+			}
+		case e.Top:
+			line += fmt.Sprintf("return address, call of %s at %d outside of any function", vm.symbols.Format(e.Frame.Target), e.Frame.Site)
+		default:
+			line += fmt.Sprintf("return address, call of %s at %d in %s", vm.symbols.Format(e.Frame.Target), e.Frame.Site, vm.symbols.Format(e.In))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatBacktrace returns the chain of calls leading to the cursor, innermost first, without the calls whose return
+// address isn't on the stack anymore like StackEntries
+func (vm VM) formatBacktrace() string {
+	lines := []string{fmt.Sprintf("#0  %s %s", vm.formatAddr(vm.cursor), vm.formatInstruction())}
+
+	for i := len(vm.calls) - 1; i >= 0; i-- {
+		f := vm.calls[i]
+		if !vm.live(f) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("#%-2d %s call: [%s] returns to %d", len(lines), vm.formatAddr(f.Site), vm.symbols.Format(f.Target), f.Ret))
+	}
+
+	return strings.Join(lines, "\n")
+}
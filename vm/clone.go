@@ -0,0 +1,106 @@
+package vm
+
+import "bytes"
+
+// Clone returns a deep copy of the VM that can be executed independently of the original one.
+//
+// The memory, stack, registers, cursor, modes, breakpoints, watchpoints, protected ranges, displays, coverage, last
+// output, scrollback, patches, address hooks, handlers and hooks are copied (their functions themselves are shared, so
+// are the variables they capture). The clone writes to the same output but doesn't read the original input: it has no
+// input until SetInput is called, so that two VMs never consume the same bytes. It doesn't inherit the trace, the
+// recorder, the history, the core dumps, the skipping of the output and the profile either since they describe the
+// session of the original VM (the clone doesn't profile until EnableProfiling is called, the counts of the two VMs
+// never mix), and it isn't running: its State is StateHalted. The memoized functions are kept but their caches start
+// empty.
+//
+// A copy-on-write memory (see SetCopyOnWrite) isn't copied: the two VMs share its pages until they write to them.
+func (vm *VM) Clone() *VM {
+	clone := *vm
+
+	clone.SetInput(bytes.NewReader(nil))
+	clone.trace, clone.tracing = nil, false
+	clone.recorder = nil
+	clone.history = nil
+	clone.core = nil
+	clone.profile = nil
+	clone.published = nil
+	clone.memos, clone.pendingMemos = nil, nil
+	clone.skipping = nil
+	for addr, m := range vm.memos {
+		clone.Memoize(addr, m.inputs, m.outputs)
+	}
+	if vm.loops != nil {
+		clone.loops = NewLoopDetector(vm.loops.interval, vm.loops.memory)
+	}
+	clone.interrupt = interruptNone
+	clone.exclusive = &exclusive{}
+	clone.state = int32(StateHalted)
+
+	clone.stack = append([]uint16{}, vm.stack...)
+	clone.setMemory(copyMemory(vm.memory))
+	clone.calls = append([]Frame{}, vm.calls...)
+	for i := range clone.calls {
+		// The nodes belong to the call tree of the original profile
+		clone.calls[i].node = nil
+	}
+	clone.displays = append([]display{}, vm.displays...)
+	if vm.decoded != nil {
+		clone.decoded = append([]decodedInstruction{}, vm.decoded...)
+	}
+	if vm.coverage != nil {
+		clone.coverage = append([]bool{}, vm.coverage...)
+	}
+
+	if vm.watches != nil {
+		clone.watches = map[uint16]bool{}
+		clone.readWatches = map[uint16]bool{}
+		for addr := range vm.watches {
+			clone.watches[addr] = true
+		}
+		for addr := range vm.readWatches {
+			clone.readWatches[addr] = true
+		}
+	}
+
+	clone.codes = vm.codes.clone()
+	clone.game = vm.game.clone()
+	output := *vm.output
+	clone.output = &output
+	clone.scroll = vm.scroll.clone()
+	clone.scanners = nil
+	for _, s := range vm.scanners {
+		clone.scanners = append(clone.scanners, s.clone())
+	}
+
+	if vm.breakpoints != nil {
+		clone.breakpoints = map[uint16]breakpoint{}
+		for addr, bp := range vm.breakpoints {
+			clone.breakpoints[addr] = bp
+		}
+	}
+
+	if vm.patches != nil {
+		clone.patches = map[uint16][]func(*VM){}
+		for addr, fns := range vm.patches {
+			clone.patches[addr] = append([]func(*VM){}, fns...)
+		}
+	}
+
+	if vm.addrHooks != nil {
+		clone.addrHooks = map[uint16][]func(*VM) HookResult{}
+		for addr, fns := range vm.addrHooks {
+			clone.addrHooks[addr] = append([]func(*VM) HookResult{}, fns...)
+		}
+		clone.hookHits = map[uint16]int{}
+		for addr, n := range vm.hookHits {
+			clone.hookHits[addr] = n
+		}
+	}
+
+	clone.hooks = vm.hooks.clone()
+	if vm.handlers != nil {
+		clone.handlers = append([]handler{}, vm.handlers...)
+	}
+
+	return &clone
+}
@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// TestCloneProfiling is meant for go test -race: the clones of a profiled VM run in parallel like the candidates of
+// the search package
+func TestCloneProfiling(t *testing.T) {
+	// A function at 4 called in a loop
+	machine := New([]uint16{CALL, 4, JMP, 0, ADD, M, M, 1, RET}, bytes.NewReader(nil), ioutil.Discard)
+	machine.EnableProfiling()
+	machine.RunFor(2)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		clone := machine.Clone()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clone.EnableProfiling()
+			clone.RunFor(1000)
+		}()
+	}
+	machine.RunFor(1000)
+	wg.Wait()
+
+	if got := machine.profile.total; got != 1002 {
+		t.Errorf("%d instructions in the profile of the original instead of 1002", got)
+	}
+	if machine.Clone().profile != nil {
+		t.Error("the clone shares the profile")
+	}
+}
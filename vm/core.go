@@ -0,0 +1,158 @@
+package vm
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+// coreVersion is the version of the Core format, bump it when the Core type changes
+const coreVersion = 1
+
+// Core is what is known of a VM that stopped on an error (an invalid opcode, address or operand, a stack underflow, a
+// division by zero), see EnableCoreDumps
+type Core struct {
+	Version      int
+	Error        string    // Error that stopped the VM
+	Instructions uint64    // Instructions executed, the faulty one included
+	Snapshot     *Snapshot // State when the error occurred
+	Calls        []Frame   // Shadow call stack
+	Trace        []string  // Last instructions executed, formatted like the trace with the registers before them
+	Output       string    // Last bytes written by OUT
+}
+
+// coreOutput is the number of bytes of output kept in a core dump
+const coreOutput = 1024
+
+// coreEntry is an instruction remembered for the trace of a core dump, it's only formatted if the dump is written
+type coreEntry struct {
+	cursor   uint16
+	words    [4]uint16
+	register [8]uint16
+}
+
+// coreDumps remembers the last instructions in a ring buffer
+type coreDumps struct {
+	path    string
+	entries []coreEntry
+	next    int // Slot of the next instruction
+	n       int // Number of instructions remembered
+}
+
+// EnableCoreDumps writes a core dump to path when the execution stops on an error, with the last n instructions
+// executed. An empty path disables them.
+func (vm *VM) EnableCoreDumps(path string, n int) {
+	if path == "" {
+		vm.core = nil
+		return
+	}
+	if n < 0 {
+		n = 0
+	}
+	vm.core = &coreDumps{path: path, entries: make([]coreEntry, n)}
+}
+
+// record remembers the instruction at the cursor, before it's executed
+func (c *coreDumps) record(vm *VM) {
+	if len(c.entries) == 0 || int(vm.cursor) >= vm.memory.Len() {
+		return
+	}
+
+	e := &c.entries[c.next]
+	e.cursor, e.register = vm.cursor, vm.register
+	copy(e.words[:], wordsAt(vm.memory, int(vm.cursor), len(e.words)))
+
+	c.next = (c.next + 1) % len(c.entries)
+	if c.n < len(c.entries) {
+		c.n++
+	}
+}
+
+// Core returns the core dump of the VM stopped by err, with the instructions remembered by EnableCoreDumps
+func (vm *VM) Core(err error) *Core {
+	c := &Core{
+		Version:      coreVersion,
+		Error:        err.Error(),
+		Instructions: vm.instructions,
+		Snapshot:     vm.Snapshot().flat(),
+		Calls:        vm.CallStack(),
+		Output:       vm.LastOutput(coreOutput),
+	}
+
+	if vm.core != nil {
+		dumps := vm.core
+		for i := 0; i < dumps.n; i++ {
+			e := dumps.entries[(dumps.next-dumps.n+i+len(dumps.entries))%len(dumps.entries)]
+			c.Trace = append(c.Trace, fmt.Sprintf("%s | %s %v", vm.formatAddr(e.cursor), vm.formatWords(e.words[:], e.register), e.register))
+		}
+	}
+	return c
+}
+
+// dumpCore writes the core dump of the VM stopped by err, if enabled
+func (vm *VM) dumpCore(err error) {
+	if vm.core == nil {
+		return
+	}
+
+	if saveErr := vm.Core(err).Save(vm.core.path); saveErr != nil {
+		vm.printError(fmt.Sprintf("\nCould not write the core dump: %s\n", saveErr))
+		return
+	}
+	vm.printError(fmt.Sprintf("\nCore dumped to %s, see the postmortem subcommand\n", vm.core.path))
+}
+
+// Save writes the core dump to the given file
+func (c *Core) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadCore reads a core dump from the given file
+func LoadCore(path string) (*Core, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Core{}
+	if err := gob.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if c.Version != coreVersion {
+		return nil, fmt.Errorf("%s: unsupported core version %d (expected %d)", path, c.Version, coreVersion)
+	}
+	if c.Snapshot == nil {
+		return nil, fmt.Errorf("%s: no state in the core dump", path)
+	}
+	return c, nil
+}
+
+// RestoreCore puts the VM in the state of a core dump, with its call stack
+func (vm *VM) RestoreCore(c *Core) {
+	vm.Restore(c.Snapshot)
+	vm.calls = append([]Frame{}, c.Calls...)
+	vm.instructions = c.Instructions
+}
+
+// readOnlyCommands are the debugger commands refused by a read-only VM: they change its state or execute instructions
+var readOnlyCommands = map[string]bool{
+	"setreg": true, "setmem": true, "push": true, "popstack": true, "load": true, "ql": true, "goto": true,
+	"loadbin": true, "steppingoff": true, "step": true, "next": true, "finish": true, "until": true, "rstep": true,
+	"rcontinue-to": true,
+}
+
+// SetReadOnly makes the debugger refuse the commands changing the state or executing instructions, e.g. to inspect a
+// core dump
+func (vm *VM) SetReadOnly(on bool) {
+	vm.readOnly = on
+}
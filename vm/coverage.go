@@ -0,0 +1,145 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// EnableCoverage starts recording which addresses are executed, see WriteCoverage
+func (vm *VM) EnableCoverage() {
+	if vm.coverage == nil {
+		vm.coverage = make([]bool, M)
+	}
+}
+
+// coverageRegion is a range of consecutive instructions that were all executed or all not executed, end is excluded
+type coverageRegion struct {
+	start, end   int
+	instructions int
+	executed     bool
+}
+
+// coverageRegions splits the linear disassembly of the memory in executed and unexecuted regions. The data between
+// functions is disassembled as well and ends up in unexecuted regions.
+func (vm *VM) coverageRegions() []coverageRegion {
+	regions, memory := []coverageRegion{}, words(vm.memory)
+	for addr := 0; addr < len(memory); {
+		executed := vm.coverage[addr]
+		if len(regions) == 0 || regions[len(regions)-1].executed != executed {
+			regions = append(regions, coverageRegion{start: addr, end: addr, executed: executed})
+		}
+
+		addr += instructionSize(memory, addr)
+
+		r := &regions[len(regions)-1]
+		r.end = addr
+		r.instructions++
+	}
+	return regions
+}
+
+// coverageTotals counts the instructions and words of the regions
+type coverageTotals struct {
+	instructions, executed int
+	words, executedWords   int
+}
+
+func countCoverage(regions []coverageRegion) coverageTotals {
+	t := coverageTotals{}
+	for _, r := range regions {
+		t.instructions += r.instructions
+		t.words += r.end - r.start
+		if r.executed {
+			t.executed += r.instructions
+			t.executedWords += r.end - r.start
+		}
+	}
+	return t
+}
+
+// instructionSize returns the number of words of the instruction at addr, 1 for an unknown opcode
+func instructionSize(memory []uint16, addr int) int {
+	size := 1
+	size += isa.Operands(memory[addr])
+	if addr+size > len(memory) {
+		return len(memory) - addr
+	}
+	return size
+}
+
+// WriteCoverage writes the number of executed instructions and words with their percentages followed by the
+// executed and unexecuted regions, annotated adds the disassembly of the memory with a + before executed instructions
+func (vm *VM) WriteCoverage(w io.Writer, annotated bool) error {
+	if vm.coverage == nil {
+		return fmt.Errorf("coverage is not enabled")
+	}
+
+	bw := bufio.NewWriter(w)
+
+	regions := vm.coverageRegions()
+	t := countCoverage(regions)
+	fmt.Fprintf(bw, "Executed instructions: %d / %d (%.2f%%)\n", t.executed, t.instructions, percent(uint64(t.executed), uint64(t.instructions)))
+	fmt.Fprintf(bw, "Executed words: %d / %d (%.2f%%)\n\nRegions:\n", t.executedWords, t.words, percent(uint64(t.executedWords), uint64(t.words)))
+
+	for _, r := range regions {
+		state := "unexecuted"
+		if r.executed {
+			state = "executed"
+		}
+		fmt.Fprintf(bw, "(%6d) - (%6d) %-10s %6d instructions\n", r.start, r.end, state, r.instructions)
+	}
+
+	if annotated {
+		fmt.Fprintf(bw, "\nDisassembly:\n")
+		memory := words(vm.memory)
+		for addr := 0; addr < len(memory); addr += instructionSize(memory, addr) {
+			mark := " "
+			if vm.coverage[addr] {
+				mark = "+"
+			}
+			fmt.Fprintf(bw, "(%6d) %s %s\n", addr, mark, isa.DisassembleWith(memory, uint16(addr), vm.symbols))
+		}
+	}
+
+	return bw.Flush()
+}
+
+// printCoverage handles the $coverage command
+func (vm *VM) printCoverage(args []string) {
+	if len(args) > 2 || (len(args) == 2 && args[1] != "annotate") {
+		vm.printError("Wrong command ! Should be $coverage [file [annotate]]\n")
+		return
+	}
+
+	if vm.coverage == nil {
+		vm.EnableCoverage()
+		vm.printDebug("Coverage enabled, the addresses executed from now on are recorded\n")
+		return
+	}
+
+	if len(args) == 0 {
+		// Only the summary, the regions are too many to be read on the terminal
+		regions := vm.coverageRegions()
+		t := countCoverage(regions)
+		vm.printDebug(fmt.Sprintf("Executed instructions: %d / %d (%.2f%%) in %d regions, use $coverage <file> for the details\n",
+			t.executed, t.instructions, percent(uint64(t.executed), uint64(t.instructions)), len(regions)))
+		return
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		vm.printError(fmt.Sprintf("Could not create %s: %s\n", args[0], err))
+		return
+	}
+	defer f.Close()
+
+	if err := vm.WriteCoverage(f, len(args) == 2); err != nil {
+		vm.printError(fmt.Sprintf("Could not write the coverage: %s\n", err))
+		return
+	}
+	vm.printDebug("Coverage written to " + args[0] + "\n")
+}
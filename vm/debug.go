@@ -5,30 +5,131 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/sfluor/synacor/symbols"
 )
 
+// setRegRegex matches the arguments of $setreg, the registers are numbered from 0 like in the spec, the assembler and
+// the disassembly (they were numbered from 1, R8 is now R7)
+var setRegRegex = regexp.MustCompile(`^R?([0-7]) (0|[1-9][0-9]*)$`)
+
+// Commands lists the names of the debugger commands, without their prefix
+var Commands = []string{
+	"register", "stack", "cursor", "state", "dump", "dumpbin", "loadbin", "eval", "bt", "setreg", "setmem", "push",
+	"popstack", "save", "load", "qs", "ql", "slots", "bookmark", "goto", "unbookmark", "bookmarks", "break", "delete",
+	"breakpoints", "display", "undisplay", "memoize", "unmemoize", "watch", "rwatch", "unwatch", "protect", "unprotect",
+	"trace", "debugon", "debugoff", "steppingon", "steppingoff", "step", "next", "finish", "until", "symbol", "symbols",
+	"find", "refine", "findstr", "turbo", "coverage", "history", "rstep", "rcontinue-to", "scroll", "export",
+}
+
+// Command runs a debugger command as if it was typed, with or without its prefix: e.g. "save book.snapshot"
+func (vm *VM) Command(cmd string) {
+	vm.debug(cmd)
+}
+
 // Return true if we should go to the next operation
 func (vm *VM) debug(cmd string) bool {
-	if strings.Contains(cmd, "register") {
-		vm.printDebug("Register: " + vm.formatRegister() + "\n")
+	// Commands can be prefixed by a $ (when read by the IN operation) or not (in stepping mode)
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(cmd), vm.CommandPrefix()))
+	if len(fields) == 0 {
+		return false
 	}
 
-	if strings.Contains(cmd, "stack") {
-		vm.printDebug("Stack: " + vm.formatStack() + "\n")
+	name, args := fields[0], fields[1:]
+	if vm.readOnly && readOnlyCommands[name] {
+		vm.printError("Read-only: $" + name + " would change the state\n")
+		return false
 	}
+	vm.resetLoops()
 
-	if strings.Contains(cmd, "cursor") {
-		vm.printDebug("Cursor: " + fmt.Sprintf("%d", vm.cursor) + "\n")
+	// $/<regexp> searches the output, the pattern can contain spaces
+	if strings.HasPrefix(name, "/") {
+		vm.scrollCommand(name, args)
+		return false
 	}
 
-	setRegRegex := regexp.MustCompile(`^\$setreg R([1-8]) (0|[1-9][0-9]*)`)
+	switch name {
+	case "register":
+		vm.printDebug("Register: " + vm.formatRegister() + "\n")
+
+	case "stack":
+		vm.printDebug("Stack: " + vm.formatStack() + "\n")
+
+	case "cursor":
+		vm.printDebug("Cursor: " + vm.formatAddr(vm.cursor) + "\n")
+
+	// Room, things, exits and inventory parsed from the output
+	case "state":
+		vm.printDebug(formatGameState(vm.GameState()) + "\n")
+
+	// Print a part of the memory
+	case "dump":
+		if len(args) != 2 {
+			vm.printError("Wrong command ! Should be $dump <addr> <len>\n")
+			return false
+		}
+
+		addr, err := vm.parseAddress(args[0])
+		if err != nil || int(addr) >= vm.memory.Len() {
+			vm.printError("Wrong address\n")
+			return false
+		}
+
+		length, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			vm.printError("Wrong length\n")
+			return false
+		}
+
+		end := uint64(addr) + length
+		if end > M {
+			end = M
+		}
+		vm.printDebug(formatDump(addr, vm.MemRange(addr, uint16(end))) + "\n")
+
+	// Export or import a region of the memory as raw little-endian values
+	case "dumpbin":
+		vm.dumpBin(args)
+
+	case "loadbin":
+		vm.loadBin(args)
+
+	// Evaluate an expression against the current state, e.g. $eval mem[reg[1]+2] * 3 % 32768
+	case "eval":
+		if len(args) == 0 {
+			vm.printError("Wrong command ! Should be $eval <expr>\n")
+			return false
+		}
+
+		v, err := vm.Eval(strings.Join(args, " "))
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not evaluate: %s\n", err))
+			return false
+		}
+
+		line := fmt.Sprintf("%d", v)
+		if v >= 0 && v <= 0xffff {
+			line += fmt.Sprintf(" (0x%04x)", v)
+			if name, ok := vm.symbols.Name(uint16(v)); ok {
+				line += " <" + name + ">"
+			}
+		}
+		vm.printDebug(line + "\n")
+
+	// Print the chain of calls leading to the cursor
+	case "bt":
+		vm.printDebug("Backtrace:\n" + vm.formatBacktrace() + "\n")
 
 	// Force a value for a given register
-	if strings.Contains(cmd, "setreg") {
-		match := setRegRegex.FindStringSubmatch(cmd)
+	case "setreg":
+		match := setRegRegex.FindStringSubmatch(strings.Join(args, " "))
+		if len(args) == 2 && strings.TrimPrefix(args[0], "R") == "8" {
+			vm.printError("Wrong register ! They are numbered from 0 now, R8 is R7\n")
+			return false
+		}
 		// Wrong command
 		if len(match) < 3 {
-			vm.printError("Wrong command ! Should be $setreg R<n> <value>\n")
+			vm.printError("Wrong command ! Should be $setreg [R]<0-7> <value>\n")
 			return false
 		}
 
@@ -44,29 +145,247 @@ func (vm *VM) debug(cmd string) bool {
 			return false
 		}
 
-		vm.register[reg-1] = uint16(val)
+		vm.register[reg] = uint16(val)
 
-	}
+	// Force a value for a given memory cell
+	case "setmem":
+		if len(args) != 2 {
+			vm.printError("Wrong command ! Should be $setmem <addr> <value>\n")
+			return false
+		}
+
+		addr, err := vm.parseAddress(args[0])
+		if err != nil || int(addr) >= vm.memory.Len() {
+			vm.printError("Wrong address\n")
+			return false
+		}
+
+		val, err := strconv.ParseUint(args[1], 10, 16)
+		if err != nil {
+			vm.printError("Wrong value for memory\n")
+			return false
+		}
+
+		vm.SetMemory(addr, uint16(val))
+
+	// Push a value on the stack
+	case "push":
+		if len(args) != 1 {
+			vm.printError("Wrong command ! Should be $push <value>\n")
+			return false
+		}
+
+		val, err := strconv.ParseUint(args[0], 10, 16)
+		if err != nil {
+			vm.printError("Wrong value for stack\n")
+			return false
+		}
+
+		vm.push(uint16(val))
+
+	// Pop a value from the stack
+	case "popstack":
+		popped, err := vm.pop()
+		if err != nil {
+			vm.printError("The stack is empty\n")
+			return false
+		}
+		vm.printDebug(fmt.Sprintf("Popped: %d\n", popped))
+
+	// Save the state of the VM to a file
+	case "save":
+		if len(args) != 1 {
+			vm.printError("Wrong command ! Should be $save <file>\n")
+			return false
+		}
+
+		if err := vm.Snapshot().Save(args[0]); err != nil {
+			vm.printError(fmt.Sprintf("Could not save snapshot: %s\n", err))
+			return false
+		}
+		vm.printDebug("Snapshot saved to " + args[0] + "\n")
+
+	// Restore the state of the VM from a file
+	case "load":
+		if len(args) != 1 {
+			vm.printError("Wrong command ! Should be $load <file>\n")
+			return false
+		}
+
+		s, err := LoadSnapshot(args[0])
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not load snapshot: %s\n", err))
+			return false
+		}
+		vm.Restore(s)
+		vm.printDebug("Snapshot loaded from " + args[0] + "\n")
+
+	// Save to or restore from numbered slots of the state directory
+	case "qs", "ql", "slots":
+		vm.quickSlots(name, args)
+
+	// Named snapshots
+	case "bookmark", "goto", "unbookmark", "bookmarks":
+		vm.bookmarkCommand(name, args)
+
+	// Break when the cursor reaches an address, optionally under a condition
+	case "break":
+		if err := vm.addBreakpoint(args); err != nil {
+			vm.printError("Wrong command ! " + err.Error() + "\n")
+			return false
+		}
+
+	case "delete":
+		if len(args) != 1 {
+			vm.printError("Wrong command ! Should be $delete <addr>\n")
+			return false
+		}
+
+		addr, err := vm.parseAddress(args[0])
+		if err != nil {
+			vm.printError("Wrong address\n")
+			return false
+		}
+		delete(vm.breakpoints, addr)
 
-	if strings.Contains(cmd, "debugon") {
+	case "breakpoints":
+		vm.printDebug("Breakpoints:\n" + vm.formatBreakpoints() + "\n")
+
+	// Expressions printed each time the execution stops, e.g. $display mem[2732]
+	case "display", "undisplay":
+		vm.displayCommand(name, args)
+
+	// Cache the results of a pure function
+	case "memoize", "unmemoize":
+		vm.memoizeCommand(name, args)
+
+	// Refuse the writes of the program to a range
+	case "protect", "unprotect":
+		vm.protectCommand(name, args)
+
+	// Break when an address is written or read
+	case "watch", "rwatch", "unwatch":
+		if len(args) != 1 {
+			vm.printError("Wrong command ! Should be $" + name + " <addr>\n")
+			return false
+		}
+
+		addr, err := vm.parseAddress(args[0])
+		if err != nil {
+			vm.printError("Wrong address\n")
+			return false
+		}
+
+		vm.setWatch(addr, name)
+
+	// Write every executed instruction to a trace file
+	case "trace":
+		if len(args) == 0 || len(args) > 2 || (args[0] != "on" && args[0] != "off") {
+			vm.printError("Wrong command ! Should be $trace on [file] or $trace off\n")
+			return false
+		}
+
+		if args[0] == "off" {
+			vm.tracing = false
+			return false
+		}
+
+		if len(args) == 2 {
+			w, err := traceFile(args[1])
+			if err != nil {
+				vm.printError(fmt.Sprintf("Could not open trace file: %s\n", err))
+				return false
+			}
+			vm.trace = w
+		}
+
+		if vm.trace == nil {
+			vm.printError("No trace file, use $trace on <file>\n")
+			return false
+		}
+		vm.tracing = true
+
+	case "debugon":
 		vm.debugging = true
-	}
 
-	if strings.Contains(cmd, "debugoff") {
+	case "debugoff":
 		vm.debugging = false
-	}
 
 	// Avance manually
-	if strings.Contains(cmd, "steppingon") {
+	case "steppingon":
 		vm.stepping = true
-	}
 
-	if strings.Contains(cmd, "steppingoff") {
+	case "steppingoff":
 		vm.stepping = false
-	}
 
-	if strings.Contains(cmd, "next") {
+	// Execute one instruction
+	case "step":
+		return true
+
+	// Execute one instruction, stepping over calls
+	case "next":
+		vm.stepOver()
+		return true
+
+	// Run until the current function returns
+	case "finish":
+		vm.stepOut()
 		return true
+
+	// Run until the output matches a pattern
+	case "until":
+		return vm.untilOutput(args)
+
+	// Name addresses
+	case "symbol":
+		if len(args) != 2 {
+			vm.printError("Wrong command ! Should be $symbol <addr> <name>\n")
+			return false
+		}
+
+		addr, err := vm.parseAddress(args[0])
+		if err != nil {
+			vm.printError("Wrong address\n")
+			return false
+		}
+
+		if vm.symbols == nil {
+			vm.symbols = symbols.New()
+		}
+		if err := vm.symbols.Add(addr, args[1]); err != nil {
+			vm.printError(err.Error() + "\n")
+			return false
+		}
+
+	case "symbols":
+		lines := []string{}
+		for _, addr := range vm.symbols.Addresses() {
+			lines = append(lines, vm.formatAddr(addr))
+		}
+		vm.printDebug("Symbols:\n" + strings.Join(lines, "\n") + "\n")
+
+	// Search the memory
+	case "find", "refine", "findstr":
+		vm.find(name, args)
+
+	// Ignore -speed
+	case "turbo":
+		vm.toggleTurbo(args)
+
+	// Executed addresses
+	case "coverage":
+		vm.printCoverage(args)
+
+	// Step backwards
+	case "history", "rstep", "rcontinue-to":
+		vm.reverse(name, args)
+
+	// Output of the game
+	case "scroll", "export":
+		vm.scrollCommand(name, args)
+
+	default:
+		vm.printError("Unknown command " + name + "\n")
 	}
 
 	return false
@@ -74,10 +393,10 @@ func (vm *VM) debug(cmd string) bool {
 
 func (vm VM) printDebug(str string) {
 	// Print debug in light green
-	fmt.Print("\033[32m", str, "\033[0m")
+	fmt.Fprint(vm.diagnostics(), "\033[32m", str, "\033[0m")
 }
 
 func (vm VM) printError(str string) {
 	// Print error in red
-	fmt.Print("\033[31m", str, "\033[0m")
+	fmt.Fprint(vm.diagnostics(), "\033[31m", str, "\033[0m")
 }
@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRegistersNumberedFromZero(t *testing.T) {
+	machine := New([]uint16{HALT}, bytes.NewReader(nil), ioutil.Discard)
+	diagnostics := &bytes.Buffer{}
+	machine.SetDiagnostics(diagnostics)
+
+	machine.Command("setreg R7 5")
+	machine.Command("setreg 0 3")
+	if got := machine.Register(7); got != 5 {
+		t.Errorf("$setreg R7 5 set R7 to %d", got)
+	}
+	if got := machine.Register(0); got != 3 {
+		t.Errorf("$setreg 0 3 set R0 to %d", got)
+	}
+	if v, err := machine.Eval("r7"); err != nil || v != 5 {
+		t.Errorf("$eval r7 = %d, %v after $setreg R7 5", v, err)
+	}
+
+	machine.Command("register")
+	if out := diagnostics.String(); !strings.Contains(out, "R0:      3") || !strings.Contains(out, "R7:      5") {
+		t.Errorf("$register doesn't number the registers like $setreg:\n%s", out)
+	}
+
+	diagnostics.Reset()
+	machine.Command("setreg R8 1")
+	if !strings.Contains(diagnostics.String(), "R8 is R7") {
+		t.Errorf("$setreg R8 1 doesn't tell that R8 is R7 now:\n%s", diagnostics.String())
+	}
+}
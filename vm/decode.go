@@ -0,0 +1,67 @@
+package vm
+
+// decodedInstruction is an instruction whose operands were resolved once, see EnableDecodeCache. The three words
+// following the opcode are decoded whatever the operation, the unused ones are never read.
+type decodedInstruction struct {
+	valid     bool
+	operands  [3]uint16 // Literal values, or register numbers for the operands in registers
+	registers uint8     // Bit i is set when the operand i is a register
+	invalid   uint8     // Bit i is set when the operand i is out of memory or not a valid value, get reports the fault
+}
+
+// EnableDecodeCache decodes every address the first time it is executed and reuses the decoded operands (literal or
+// register) afterwards. The instructions overlapping a written address are decoded again.
+func (vm *VM) EnableDecodeCache() {
+	vm.decoded = make([]decodedInstruction, vm.memory.Len())
+}
+
+// decode returns the decoded instruction at addr, decoding it if needed
+func (vm *VM) decode(addr uint16) *decodedInstruction {
+	d := &vm.decoded[addr]
+	if d.valid {
+		return d
+	}
+
+	*d = decodedInstruction{valid: true}
+	for i := 0; i < 3; i++ {
+		a := int(addr) + 1 + i
+		if a >= vm.memory.Len() {
+			d.invalid |= 1 << i
+			continue
+		}
+
+		switch m := vm.memory.Read(uint16(a)); {
+		case m < M:
+			d.operands[i] = m
+		case m < M+8:
+			d.operands[i] = m - M
+			d.registers |= 1 << i
+		default:
+			d.invalid |= 1 << i
+		}
+	}
+	return d
+}
+
+// invalidate forgets the decoded instructions containing addr
+func (vm *VM) invalidate(addr uint16) {
+	if vm.decoded == nil {
+		return
+	}
+	for a := int(addr) - 3; a <= int(addr); a++ {
+		if a >= 0 && a < len(vm.decoded) {
+			vm.decoded[a].valid = false
+		}
+	}
+}
+
+// operand returns the value of the operand i (from 0) of the instruction at the cursor
+func (vm *VM) operand(i uint16) uint16 {
+	if d := vm.current; d != nil && d.invalid&(1<<i) == 0 {
+		if d.registers&(1<<i) != 0 {
+			return vm.register[d.operands[i]]
+		}
+		return d.operands[i]
+	}
+	return vm.get(vm.cursor + 1 + i)
+}
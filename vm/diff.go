@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Change is a value that differs between two snapshots, -1 means the value doesn't exist (e.g. a stack entry only in
+// one of the snapshots)
+type Change struct {
+	Where    int // Register, memory address or stack index (0 is the bottom of the stack)
+	Old, New int
+}
+
+// Diff lists the differences between two snapshots
+type Diff struct {
+	Cursor    *Change // nil if the cursor didn't move
+	Registers []Change
+	Memory    []Change
+	Stack     []Change
+}
+
+// Empty returns true if the snapshots are identical
+func (d Diff) Empty() bool {
+	return d.Cursor == nil && len(d.Registers) == 0 && len(d.Memory) == 0 && len(d.Stack) == 0
+}
+
+// Diff returns what changed from s to other
+func (s *Snapshot) Diff(other *Snapshot) Diff {
+	d := Diff{}
+
+	if s.Cursor != other.Cursor {
+		d.Cursor = &Change{Old: int(s.Cursor), New: int(other.Cursor)}
+	}
+
+	for r := range s.Register {
+		if s.Register[r] != other.Register[r] {
+			d.Registers = append(d.Registers, Change{r, int(s.Register[r]), int(other.Register[r])})
+		}
+	}
+
+	d.Memory = diffValues(s.Words(), other.Words())
+	d.Stack = diffValues(s.Stack, other.Stack)
+
+	return d
+}
+
+// diffValues compares two slices index by index
+func diffValues(old, new []uint16) []Change {
+	changes := []Change{}
+	for i := 0; i < len(old) || i < len(new); i++ {
+		c := Change{i, -1, -1}
+		if i < len(old) {
+			c.Old = int(old[i])
+		}
+		if i < len(new) {
+			c.New = int(new[i])
+		}
+		if c.Old != c.New {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// String formats the differences, one per line
+func (d Diff) String() string {
+	lines := []string{}
+
+	if d.Cursor != nil {
+		lines = append(lines, fmt.Sprintf("Cursor: %d -> %d", d.Cursor.Old, d.Cursor.New))
+	}
+	for _, c := range d.Registers {
+		lines = append(lines, fmt.Sprintf("R%d: %s", c.Where, formatChange(c)))
+	}
+	for _, c := range d.Memory {
+		lines = append(lines, fmt.Sprintf("(%6d) %s", c.Where, formatChange(c)))
+	}
+	for _, c := range d.Stack {
+		lines = append(lines, fmt.Sprintf("Stack[%d]: %s", c.Where, formatChange(c)))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatChange(c Change) string {
+	format := func(v int) string {
+		if v < 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%d", v)
+	}
+	return format(c.Old) + " -> " + format(c.New)
+}
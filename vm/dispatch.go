@@ -0,0 +1,353 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// Handler executes the operation at the cursor. It reads its operands with the helpers of the VM and leaves the cursor
+// alone: the dispatch loop moves it after the instruction, unless the handler called Jump.
+type Handler func(vm *VM) error
+
+// handler is an entry of the dispatch table
+type handler struct {
+	fn   Handler
+	size uint16 // Words of the instruction, decoded once from its number of arguments
+}
+
+// defaultHandlers is the dispatch table of the spec, indexed by opcode
+var defaultHandlers = newHandlers()
+
+func newHandlers() []handler {
+	fns := map[uint16]Handler{
+		HALT: opHalt, SET: opSet, PUSH: opPush, POP: opPop, EQ: opEq, GT: opGt, JMP: opJmp, JT: opJt, JF: opJf,
+		ADD: opAdd, MULT: opMult, MOD: opMod, AND: opAnd, OR: opOr, NOT: opNot, RMEM: opRmem, WMEM: opWmem,
+		CALL: opCall, RET: opRet, OUT: opOut, IN: opIn, NOOP: opNoop,
+	}
+
+	table := make([]handler, len(isa.Operations))
+	for _, op := range isa.Operations {
+		table[op.Code] = handler{fn: fns[op.Code], size: op.NArgs + 1}
+	}
+	return table
+}
+
+// SetHandler installs fn to execute the opcode code (one of the spec or a custom one) with nargs arguments on this VM
+// only. The disassembler and the assembler only know the operations of the spec and the ones of RegisterOpcode.
+func (vm *VM) SetHandler(code uint16, nargs uint16, fn Handler) {
+	if vm.handlers == nil {
+		vm.handlers = append([]handler{}, defaultHandlers...)
+	}
+	for int(code) >= len(vm.handlers) {
+		vm.handlers = append(vm.handlers, handler{})
+	}
+	vm.handlers[code] = handler{fn: fn, size: nargs + 1}
+}
+
+// Jump makes the instruction being executed continue at addr instead of the next instruction, for handlers
+func (vm *VM) Jump(addr uint16) {
+	vm.next = addr
+}
+
+// execInstruction executes one instruction
+func (vm *VM) execInstruction() error {
+	if int(vm.cursor) >= vm.length {
+		return vm.fault(ErrInvalidAddress, int(vm.cursor))
+	}
+	op := vm.read(vm.cursor)
+
+	// To see what opcodes are called during the confirmation process
+	if vm.debugging && op != OUT {
+		vm.printDebug(fmt.Sprintf("\n - Stack: %v\n - Register: %v\n - (%6d) %2d \n", vm.stack, vm.register, vm.cursor, op))
+	}
+
+	table := vm.handlers
+	if table == nil {
+		table = defaultHandlers
+	}
+	if int(op) >= len(table) || table[op].fn == nil {
+		return fmt.Errorf("%w %v at cursor %d", ErrInvalidOpcode, op, vm.cursor)
+	}
+
+	if vm.decoded != nil {
+		vm.current = vm.decode(vm.cursor)
+	}
+
+	h := table[op]
+	vm.next = vm.cursor + h.size
+	err := h.fn(vm)
+	vm.current = nil
+	if err != nil {
+		return err
+	}
+	vm.cursor = vm.next
+	return nil
+}
+
+// bool16 converts a comparison to the value stored by EQ and GT
+func bool16(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func opHalt(vm *VM) error { // Code 0
+	return ErrHalt
+}
+
+func opSet(vm *VM) error { // Code 1
+	vm.set(vm.b())
+	return nil
+}
+
+func opPush(vm *VM) error { // Code 2
+	if err := vm.checkStack(); err != nil {
+		return err
+	}
+	vm.push(vm.a())
+	return nil
+}
+
+func opPop(vm *VM) error { // Code 3
+	popped, err := vm.pop()
+	if err != nil {
+		return fmt.Errorf("%w: pop at cursor %d", ErrStackUnderflow, vm.cursor)
+	}
+	vm.set(popped)
+	return nil
+}
+
+func opEq(vm *VM) error { // Code 4
+	vm.set(bool16(vm.b() == vm.c()))
+	return nil
+}
+
+func opGt(vm *VM) error { // Code 5
+	vm.set(bool16(vm.b() > vm.c()))
+	return nil
+}
+
+func opJmp(vm *VM) error { // Code 6
+	vm.next = vm.a()
+	return nil
+}
+
+func opJt(vm *VM) error { // Code 7
+	if vm.a() != 0 {
+		vm.next = vm.b()
+	}
+	return nil
+}
+
+func opJf(vm *VM) error { // Code 8
+	if vm.a() == 0 {
+		vm.next = vm.b()
+	}
+	return nil
+}
+
+func opAdd(vm *VM) error { // Code 9
+	vm.set(uint16((uint32(vm.b()) + uint32(vm.c())) % M))
+	return nil
+}
+
+func opMult(vm *VM) error { // Code 10
+	vm.set(uint16((uint32(vm.b()) * uint32(vm.c())) % M))
+	return nil
+}
+
+func opMod(vm *VM) error { // Code 11
+	b, c := vm.b(), vm.c()
+	if c == 0 {
+		if !vm.permissive {
+			panic(vm.fault(ErrDivisionByZero, 0))
+		}
+		vm.set(b)
+		return nil
+	}
+	vm.set(b % c)
+	return nil
+}
+
+func opAnd(vm *VM) error { // Code 12
+	vm.set(vm.b() & vm.c())
+	return nil
+}
+
+func opOr(vm *VM) error { // Code 13
+	vm.set(vm.b() | vm.c())
+	return nil
+}
+
+func opNot(vm *VM) error { // Code 14
+	vm.set(0x7fff &^ vm.b())
+	return nil
+}
+
+func opRmem(vm *VM) error { // Code 15
+	addr := vm.b()
+	if int(addr) >= vm.memory.Len() {
+		return vm.fault(ErrInvalidAddress, int(addr))
+	}
+	if err := vm.checkProtection(addr, false); err != nil {
+		return err
+	}
+	vm.checkWatch(addr, false)
+	// The word is copied as is, like the spec says: a value from 32768 isn't a register here
+	vm.set(vm.read(addr))
+	return nil
+}
+
+func opWmem(vm *VM) error { // Code 16
+	addr := vm.a()
+	if int(addr) >= vm.memory.Len() {
+		return vm.fault(ErrInvalidAddress, int(addr))
+	}
+	if err := vm.checkProtection(addr, true); err != nil {
+		return err
+	}
+	vm.checkWatch(addr, true)
+	if vm.history != nil {
+		vm.history.log(change{kind: memoryWrite, addr: addr, value: vm.memory.Read(addr)})
+	}
+	old, value := vm.memory.Read(addr), vm.b()
+	vm.memory.Write(addr, value)
+	vm.invalidate(addr)
+	for _, fn := range vm.hooks.memoryWrite {
+		fn(vm, addr, old, value)
+	}
+	return nil
+}
+
+func opCall(vm *VM) error { // Code 17
+	target := vm.a()
+	if err := vm.checkStack(); err != nil {
+		return err
+	}
+	vm.enterCall(target)
+	vm.push(vm.next)
+	vm.next = target
+	return nil
+}
+
+func opRet(vm *VM) error { // Code 18
+	popped, err := vm.pop()
+	if err != nil {
+		// Halt
+		return errRetHalt
+	}
+	vm.leaveCall(popped)
+	vm.next = popped
+	return nil
+}
+
+func opOut(vm *VM) error { // Code 19
+	vm.writeOutput(vm.a())
+	return nil
+}
+
+// writeOutput writes the character v to the output, to the recorder and to everything following the output
+func (vm *VM) writeOutput(v uint16) {
+	c := byte(v)
+	skipping := vm.skipping != nil
+	if !skipping {
+		fmt.Fprint(vm.out, string(rune(v)))
+	}
+	vm.record(false, c)
+	vm.scanOutput(c)
+	vm.game.feed(c)
+	vm.output.write(c)
+	vm.scroll.write(c)
+	for _, fn := range vm.hooks.output {
+		fn(vm, c)
+	}
+	if !skipping {
+		vm.speed.typewrite()
+	} else if vm.skipping() {
+		vm.skipping = nil
+	}
+}
+
+func opIn(vm *VM) error { // Code 20
+	// The input may block for long, the count must not stay late meanwhile
+	vm.publish()
+
+	// Check if we are doing a command, only a whole line can be one
+	if !vm.noCommands && !vm.inLine {
+		cmd, ok, err := vm.readCommand()
+		if err != nil {
+			return err
+		}
+		if ok {
+			// The IN operation is executed again afterwards, for the next line
+			vm.debug(cmd)
+			vm.next = vm.cursor
+			return nil
+		}
+	}
+
+	b, err := vm.readInput()
+	if err != nil {
+		return err
+	}
+	vm.set(uint16(b))
+	return nil
+}
+
+// readCommand reads the next line if it starts with the command prefix. A line starting with the prefix twice is given
+// to the program without the first one.
+func (vm *VM) readCommand() (string, bool, error) {
+	// One byte at a time: peeking further than the line could wait for the next one on a terminal
+	prefix := vm.CommandPrefix()
+	for i := 1; i <= len(prefix); i++ {
+		t, err := vm.in.Peek(i)
+		if len(t) == 0 && err != nil {
+			return "", false, err
+		}
+		if len(t) < i || t[i-1] != prefix[i-1] {
+			return "", false, nil
+		}
+	}
+
+	if t, _ := vm.in.Peek(2 * len(prefix)); bytes.Equal(t, []byte(prefix+prefix)) {
+		// Escaped, the rest of the line is the program's. The prefix is compared byte by byte, so is it skipped.
+		for i := 0; i < len(prefix); i++ {
+			b, _ := vm.in.ReadByte()
+			vm.record(true, b)
+			for _, fn := range vm.hooks.read {
+				fn(vm, b)
+			}
+		}
+		vm.inLine = true
+		return "", false, nil
+	}
+
+	cmd, err := vm.readLine()
+	return cmd, err == nil, err
+}
+
+// readInput reads a byte given to the program and shows it to the recorder and to everything following the input
+func (vm *VM) readInput() (byte, error) {
+	b, err := vm.in.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	vm.record(true, b)
+	vm.inLine = b != '\n'
+	vm.resetLoops()
+	vm.game.read(b)
+	for _, fn := range vm.hooks.input {
+		fn(vm, b)
+	}
+	for _, fn := range vm.hooks.read {
+		fn(vm, b)
+	}
+	return b, nil
+}
+
+func opNoop(vm *VM) error { // Code 21
+	return nil
+}
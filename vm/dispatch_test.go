@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestArithmeticWraps(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		op   uint16
+		b, c uint16
+		want uint16
+	}{
+		{"add 32767+32767", ADD, 32767, 32767, 32766},
+		{"add 32767+1", ADD, 32767, 1, 0},
+		{"add 32758+15", ADD, 32758, 15, 5},
+		{"mult 32767*32767", MULT, 32767, 32767, 1},
+		{"mult 32767*2", MULT, 32767, 2, 32766},
+		{"mult 16384*4", MULT, 16384, 4, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := New([]uint16{tc.op, M, tc.b, tc.c, HALT}, bytes.NewReader(nil), ioutil.Discard)
+			for err := error(nil); !errors.Is(err, ErrHalt); err = machine.Step() {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := machine.Register(0); got != tc.want {
+				t.Errorf("R0 = %d instead of %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEscapedMultiByteCommandPrefix(t *testing.T) {
+	// Echoes the input
+	out := &bytes.Buffer{}
+	machine := New([]uint16{IN, M, OUT, M, JMP, 0}, bytes.NewReader([]byte("§§hi\n")), out)
+	machine.SetCommandPrefix("§")
+	if reason, err := machine.Run(); reason != ExitInputEOF {
+		t.Fatalf("stopped with %s (%v) instead of the end of the input", reason, err)
+	}
+	// OUT writes each byte of the UTF-8 encoding of § as a character
+	if want := "\u00c2\u00a7hi\n"; out.String() != want {
+		t.Errorf("the program wrote %q instead of %q", out.String(), want)
+	}
+}
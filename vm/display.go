@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// display is an expression printed each time the execution stops, see $display
+type display struct {
+	id     int
+	source string
+	e      expr
+}
+
+// displayCommand handles $display [expr] and $undisplay <n>: add an expression, list them or remove one
+func (vm *VM) displayCommand(name string, args []string) {
+	if name == "undisplay" {
+		id, err := strconv.Atoi(strings.Join(args, ""))
+		if len(args) != 1 || err != nil {
+			vm.printError("Wrong command ! Should be $undisplay <n>\n")
+			return
+		}
+		for i, d := range vm.displays {
+			if d.id == id {
+				vm.displays = append(vm.displays[:i:i], vm.displays[i+1:]...)
+				return
+			}
+		}
+		vm.printError(fmt.Sprintf("No display %d\n", id))
+		return
+	}
+
+	if len(args) == 0 {
+		lines := []string{}
+		for _, d := range vm.displays {
+			lines = append(lines, fmt.Sprintf("%d: %s", d.id, d.source))
+		}
+		vm.printDebug("Displays:\n" + strings.Join(lines, "\n") + "\n")
+		return
+	}
+
+	source := strings.Join(args, " ")
+	e, err := parseExpr(source)
+	if err != nil {
+		vm.printError(fmt.Sprintf("Wrong expression: %s\n", err))
+		return
+	}
+	vm.lastDisplay++
+	d := display{id: vm.lastDisplay, source: source, e: e}
+	vm.displays = append(vm.displays, d)
+	vm.printDebug(vm.formatDisplay(d))
+	vm.displayedAt = vm.instructions + 1
+}
+
+// showDisplays prints the displays when the execution stops, once per stop: the commands that don't execute anything
+// don't print them again
+func (vm *VM) showDisplays() {
+	if len(vm.displays) == 0 || vm.displayedAt == vm.instructions+1 {
+		return
+	}
+	vm.displayedAt = vm.instructions + 1
+
+	for _, d := range vm.displays {
+		vm.printDebug(vm.formatDisplay(d))
+	}
+}
+
+// formatDisplay returns the line of a display: its number, its expression and its value
+func (vm *VM) formatDisplay(d display) string {
+	v, err := d.e.eval(vm)
+	if err != nil {
+		return fmt.Sprintf("%d: %s = <%s>\n", d.id, d.source, err)
+	}
+	return fmt.Sprintf("%d: %s = %d\n", d.id, d.source, v)
+}
@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// Errors returned by Step and Run
+var (
+	// ErrHalt is returned by Step when the program stops by itself (HALT or RET on an empty stack)
+	ErrHalt = errors.New("halt")
+	// ErrInvalidOpcode is returned when the cursor points to an unknown operation
+	ErrInvalidOpcode = errors.New("invalid opcode")
+	// ErrStackUnderflow is returned when POP is executed on an empty stack
+	ErrStackUnderflow = errors.New("stack underflow")
+	// ErrStackOverflow is returned when PUSH or CALL would exceed the stack limit, see SetStackLimit
+	ErrStackOverflow = errors.New("stack limit exceeded")
+	// ErrLoop is returned when the state of the VM repeats without any input, see DetectLoops
+	ErrLoop = errors.New("infinite loop")
+)
+
+// errRetHalt is the halt caused by a RET on an empty stack
+var errRetHalt = fmt.Errorf("%w: ret with an empty stack", ErrHalt)
+
+// ExitReason describes why Run returned
+type ExitReason int
+
+// Exit reasons
+const (
+	ExitHalt        ExitReason = iota // HALT operation
+	ExitRet                           // RET with an empty stack
+	ExitInputEOF                      // The input has been exhausted
+	ExitError                         // An error occurred, see the returned error
+	ExitBudget                        // RunFor executed all the instructions it was given
+	ExitCanceled                      // The context of RunContext is done
+	ExitOutputMatch                   // The output matched the pattern of RunUntilOutput
+)
+
+func (r ExitReason) String() string {
+	switch r {
+	case ExitHalt:
+		return "halt"
+	case ExitRet:
+		return "ret with an empty stack"
+	case ExitInputEOF:
+		return "end of input"
+	case ExitError:
+		return "error"
+	case ExitBudget:
+		return "instruction budget exhausted"
+	case ExitCanceled:
+		return "canceled"
+	case ExitOutputMatch:
+		return "output matched"
+	}
+	return fmt.Sprintf("ExitReason(%d)", int(r))
+}
+
+// Errors wrapped by a Fault
+var (
+	// ErrInvalidAddress is an access outside of the loaded memory
+	ErrInvalidAddress = errors.New("invalid memory address")
+	// ErrInvalidOperand is an operand above the last register, or a literal where a register is expected
+	ErrInvalidOperand = errors.New("invalid operand")
+	// ErrDivisionByZero is a MOD by zero, see SetArithmetic
+	ErrDivisionByZero = errors.New("division by zero")
+	// ErrWriteProtected is a WMEM to a protected range, see Protect
+	ErrWriteProtected = errors.New("write to a protected address")
+	// ErrReadProtected is a RMEM from an execute-only range, see Protect
+	ErrReadProtected = errors.New("read of an execute-only address")
+)
+
+// Fault is an invalid memory access or operand of an instruction, it wraps ErrInvalidAddress, ErrInvalidOperand,
+// ErrDivisionByZero, ErrWriteProtected or ErrReadProtected
+type Fault struct {
+	Cursor uint16 // Address of the faulty instruction
+	Op     uint16 // Its opcode
+	Value  int    // The invalid address or operand
+	Err    error
+	Break  bool // Stops at the stepping prompt even without SetTrapFaults: a protected range with Break, see Protect
+}
+
+func (f *Fault) Error() string {
+	name := fmt.Sprintf("opcode %d", f.Op)
+	if op, ok := isa.Lookup(f.Op); ok {
+		name = op.Name
+	}
+	return fmt.Sprintf("cursor %d: %s: %s %d", f.Cursor, name, f.Err, f.Value)
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
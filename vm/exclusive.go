@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// exclusive hands the VM over to the functions given to Exclusive by other goroutines when it executes nothing
+type exclusive struct {
+	mu      sync.Mutex
+	running int            // Nested calls of runLimit executing instructions
+	parked  bool           // The VM waits for its input, see stateReader
+	pending []func(vm *VM) // Functions waiting for the next instruction boundary
+	queued  int32          // Number of pending functions, loaded atomically before each instruction
+}
+
+// Exclusive calls fn with the VM while it executes nothing and returns once fn returned, so that another goroutine
+// (e.g. a signal handler saving the game) can use it without racing with the execution. fn is called by the goroutine
+// running the VM between two instructions, or by the caller right away if the VM isn't running or waits for its input
+// (at the stepping prompt or in IN, which reads on once fn returned: fn must then leave the state as it is). It must not
+// be called by the goroutine running the VM (by a hook, a handler...) nor by fn.
+func (vm *VM) Exclusive(fn func(vm *VM)) {
+	e := vm.exclusive
+	e.mu.Lock()
+	if e.running == 0 || e.parked {
+		defer e.mu.Unlock()
+		fn(vm)
+		return
+	}
+
+	done := make(chan struct{})
+	e.pending = append(e.pending, func(vm *VM) {
+		defer close(done)
+		fn(vm)
+	})
+	atomic.AddInt32(&e.queued, 1)
+	e.mu.Unlock()
+	<-done
+}
+
+// enter notes that runLimit starts executing instructions, the returned function notes that it stopped
+func (e *exclusive) enter(vm *VM) func() {
+	e.mu.Lock()
+	e.running++
+	e.mu.Unlock()
+
+	return func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.running--
+		e.runPending(vm)
+	}
+}
+
+// boundary calls the pending functions, runLimit calls it between two instructions when some are queued
+func (e *exclusive) boundary(vm *VM) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.runPending(vm)
+}
+
+// park calls the pending functions and lets the next ones be called right away until the returned function is called,
+// once the VM got its input
+func (e *exclusive) park(vm *VM) func() {
+	e.mu.Lock()
+	e.runPending(vm)
+	e.parked = true
+	e.mu.Unlock()
+
+	return func() {
+		e.mu.Lock()
+		e.parked = false
+		e.mu.Unlock()
+	}
+}
+
+// runPending calls the pending functions, e.mu must be locked
+func (e *exclusive) runPending(vm *VM) {
+	for _, fn := range e.pending {
+		fn(vm)
+	}
+	e.pending = nil
+	atomic.StoreInt32(&e.queued, 0)
+}
@@ -0,0 +1,47 @@
+package vm
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// TestExclusive is meant for go test -race: the snapshots are taken by another goroutine while the VM runs
+func TestExclusive(t *testing.T) {
+	// Counts in R0 until it reads a byte in R1
+	memory := []uint16{ADD, M, M, 1, JT, M, 0, IN, M + 1, HALT}
+	in, feed := io.Pipe()
+	machine := New(memory, in, ioutil.Discard)
+	machine.SetCommands(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan ExitReason)
+	go func() {
+		reason, _ := machine.RunContext(ctx)
+		stopped <- reason
+	}()
+
+	for i := 0; i < 10; i++ {
+		machine.Exclusive(func(machine *VM) { machine.Snapshot() })
+	}
+
+	// Once R0 wraps to 0 the VM waits for its input, Exclusive doesn't wait for it
+	for waiting := false; !waiting; {
+		machine.Exclusive(func(machine *VM) { waiting = machine.Cursor() == 7 && machine.State() == StateWaitingInput })
+	}
+	var r0 uint16
+	machine.Exclusive(func(machine *VM) { r0 = machine.Register(0) })
+	if r0 != 0 {
+		t.Errorf("R0 = %d while waiting for the input", r0)
+	}
+
+	feed.Write([]byte{'x'})
+	if reason := <-stopped; reason != ExitHalt {
+		t.Fatalf("stopped with %s instead of halting", reason)
+	}
+	if got := machine.Register(1); got != 'x' {
+		t.Errorf("R1 = %d instead of the byte read", got)
+	}
+}
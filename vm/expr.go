@@ -0,0 +1,355 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expressions are evaluated against the VM state, they are used by conditional breakpoints, $display and $eval:
+//
+//	r0 == 4 && r1 == 1        registers r0 to r7 (also reg[n])
+//	mem[2732] != 2317         memory reads
+//	depth > 100               stack depth (stack[0] is the top of the stack)
+//	cursor == 6027            position in the memory
+//	mem[room] == 2317         names of addresses given by SetSymbols
+//
+// Operators are the ones of Go: || && == != < <= > >= + - * / % & | ^ << >> ! and unary -, with the same precedence.
+// Values are integers, comparisons and logical operators return 0 or 1.
+
+// expr is a parsed expression
+type expr interface {
+	eval(vm *VM) (int, error)
+}
+
+type (
+	literal  int
+	register int
+	variable string
+	index    struct {
+		name string // mem, reg or stack
+		e    expr
+	}
+	unary struct {
+		op string
+		e  expr
+	}
+	binary struct {
+		op   string
+		l, r expr
+	}
+)
+
+func (l literal) eval(vm *VM) (int, error) {
+	return int(l), nil
+}
+
+func (r register) eval(vm *VM) (int, error) {
+	return int(vm.register[r]), nil
+}
+
+func (v variable) eval(vm *VM) (int, error) {
+	switch v {
+	case "depth", "sp":
+		return len(vm.stack), nil
+	case "cursor", "pc":
+		return int(vm.cursor), nil
+	}
+	if addr, ok := vm.symbols.Lookup(string(v)); ok {
+		return int(addr), nil
+	}
+	return 0, fmt.Errorf("unknown variable or symbol %q", string(v))
+}
+
+func (i index) eval(vm *VM) (int, error) {
+	n, err := i.e.eval(vm)
+	if err != nil {
+		return 0, err
+	}
+
+	switch i.name {
+	case "mem":
+		if n < 0 || n >= vm.memory.Len() {
+			return 0, fmt.Errorf("mem[%d] is out of memory", n)
+		}
+		return int(vm.memory.Read(uint16(n))), nil
+	case "reg":
+		if n < 0 || n > 7 {
+			return 0, fmt.Errorf("reg[%d] is not a register", n)
+		}
+		return int(vm.register[n]), nil
+	case "stack":
+		if n < 0 || n >= len(vm.stack) {
+			return 0, fmt.Errorf("stack[%d] is out of the stack (depth %d)", n, len(vm.stack))
+		}
+		return int(vm.stack[len(vm.stack)-1-n]), nil
+	}
+	return 0, fmt.Errorf("unknown array %q", i.name)
+}
+
+func (u unary) eval(vm *VM) (int, error) {
+	v, err := u.e.eval(vm)
+	if err != nil {
+		return 0, err
+	}
+
+	if u.op == "-" {
+		return -v, nil
+	}
+	return boolToInt(v == 0), nil
+}
+
+func (b binary) eval(vm *VM) (int, error) {
+	l, err := b.l.eval(vm)
+	if err != nil {
+		return 0, err
+	}
+
+	// Short circuit
+	if b.op == "&&" && l == 0 {
+		return 0, nil
+	}
+	if b.op == "||" && l != 0 {
+		return 1, nil
+	}
+
+	r, err := b.r.eval(vm)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		return boolToInt(r != 0), nil
+	case "==":
+		return boolToInt(l == r), nil
+	case "!=":
+		return boolToInt(l != r), nil
+	case "<":
+		return boolToInt(l < r), nil
+	case "<=":
+		return boolToInt(l <= r), nil
+	case ">":
+		return boolToInt(l > r), nil
+	case ">=":
+		return boolToInt(l >= r), nil
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/", "%":
+		if r == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if b.op == "/" {
+			return l / r, nil
+		}
+		return l % r, nil
+	case "&":
+		return l & r, nil
+	case "|":
+		return l | r, nil
+	case "^":
+		return l ^ r, nil
+	case "<<":
+		return l << uint(r), nil
+	case ">>":
+		return l >> uint(r), nil
+	}
+	return 0, fmt.Errorf("unknown operator %q", b.op)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// precedence of the binary operators, the higher binds tighter
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, "<": 3, "<=": 3, ">": 3, ">=": 3,
+	"+": 4, "-": 4, "|": 4, "^": 4,
+	"*": 5, "/": 5, "%": 5, "&": 5, "<<": 5, ">>": 5,
+}
+
+// parser is a precedence climbing parser over the tokens of an expression
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+// parseExpr parses an expression
+func parseExpr(src string) (expr, error) {
+	tokens, err := tokenizeExpr(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	e, err := p.binary(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+
+	return e, nil
+}
+
+// Eval evaluates an expression against the current state of the VM
+func (vm *VM) Eval(src string) (int, error) {
+	e, err := parseExpr(src)
+	if err != nil {
+		return 0, err
+	}
+	return e.eval(vm)
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *parser) binary(min int) (expr, error) {
+	l, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		prec, ok := precedence[op]
+		if !ok || prec < min {
+			return l, nil
+		}
+		p.next()
+
+		r, err := p.binary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = binary{op, l, r}
+	}
+}
+
+func (p *parser) unary() (expr, error) {
+	switch p.peek() {
+	case "-", "!":
+		op := p.next()
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op, e}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.next()
+
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+
+	case t == "(":
+		e, err := p.binary(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+
+	case unicode.IsDigit(rune(t[0])):
+		v, err := strconv.ParseInt(t, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t)
+		}
+		return literal(v), nil
+
+	case len(t) == 2 && (t[0] == 'r' || t[0] == 'R') && t[1] >= '0' && t[1] <= '7':
+		return register(t[1] - '0'), nil
+
+	case t == "mem" || t == "reg" || t == "stack":
+		if p.next() != "[" {
+			return nil, fmt.Errorf("missing [ after %s", t)
+		}
+		e, err := p.binary(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != "]" {
+			return nil, fmt.Errorf("missing ]")
+		}
+		return index{t, e}, nil
+
+	case unicode.IsLetter(rune(t[0])) || t[0] == '_':
+		// depth, sp, cursor, pc or a symbol, which is only known when evaluating
+		return variable(t), nil
+	}
+
+	return nil, fmt.Errorf("unexpected %q", t)
+}
+
+// tokenizeExpr splits an expression in numbers, identifiers and operators
+func tokenizeExpr(src string) ([]string, error) {
+	tokens := []string{}
+
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, src[i:j])
+			i = j
+
+		case strings.ContainsRune("()[]", c):
+			tokens = append(tokens, string(c))
+			i++
+
+		default:
+			// Two characters operators first
+			if i+1 < len(src) {
+				if _, ok := precedence[src[i:i+2]]; ok {
+					tokens = append(tokens, src[i:i+2])
+					i += 2
+					continue
+				}
+			}
+
+			if _, ok := precedence[string(c)]; ok || c == '!' {
+				tokens = append(tokens, string(c))
+				i++
+				continue
+			}
+
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+
+	return tokens, nil
+}
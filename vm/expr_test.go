@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExpr(t *testing.T) {
+	machine := New([]uint16{HALT, 10, 20, 30}, bytes.NewReader(nil), ioutil.Discard)
+	machine.register[0], machine.register[7] = 4, 25734
+	machine.stack = []uint16{100, 200}
+	machine.cursor = 2
+
+	for _, tc := range []struct {
+		src  string
+		want int
+	}{
+		{"r0 == 4 && r7 == 25734", 1},
+		{"R0 + reg[7]", 25738},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 * 3 == 6 || r0 / 0", 1},
+		{"r0 == 5 && r0 / 0", 0},
+		{"-r0 + 10 % 3", -3},
+		{"!r1 + !r0", 1},
+		{"1 << 4 | 1", 17},
+		{"0x10 >> 2 & 6", 4},
+		{"mem[1] + mem[r0 - 1]", 40},
+		{"stack[0] - stack[1]", 100},
+		{"depth == sp && sp == 2", 1},
+		{"cursor + pc", 4},
+		{"r0 >= 4 && r0 <= 4 && r0 != 3 && r0 > 3 && r0 < 5", 1},
+	} {
+		e, err := parseExpr(tc.src)
+		if err != nil {
+			t.Errorf("%s: %s", tc.src, err)
+			continue
+		}
+		if got, err := e.eval(machine); err != nil || got != tc.want {
+			t.Errorf("%s = %d, %v instead of %d", tc.src, got, err, tc.want)
+		}
+	}
+}
+
+func TestExprErrors(t *testing.T) {
+	machine := New([]uint16{HALT}, bytes.NewReader(nil), ioutil.Discard)
+
+	for _, tc := range []struct {
+		src, err string
+	}{
+		{"(r0 + 1", "missing )"},
+		{"mem[1", "missing ]"},
+		{"mem 1", "missing [ after mem"},
+		{"r0 +", "unexpected end of expression"},
+		{"r0 r1", `unexpected "r1"`},
+		{"r0 / 0", "division by zero"},
+		{"mem[5]", "mem[5] is out of memory"},
+		{"reg[8]", "reg[8] is not a register"},
+		{"stack[0]", "stack[0] is out of the stack"},
+		{"nowhere", "unknown variable or symbol"},
+	} {
+		e, err := parseExpr(tc.src)
+		if err == nil {
+			_, err = e.eval(machine)
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v instead of %q", tc.src, err, tc.err)
+		}
+	}
+}
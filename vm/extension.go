@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// extensions are the handlers of the registered opcodes, indexed by code
+var extensions = map[uint16]Handler{}
+
+// RegisterOpcode adds the operation code (from 22, after NOOP) named name with nargs arguments, executed by fn. The
+// assembler, the disassembler and isa.Lookup know it from then on but a VM only executes it once EnableExtensions is
+// called, the other ones keep reporting it as an invalid opcode. It must be called before the VMs are created, e.g. in
+// an init function.
+func RegisterOpcode(code uint16, name string, nargs uint16, fn Handler) error {
+	if fn == nil {
+		return fmt.Errorf("opcode %d has no handler", code)
+	}
+	if err := isa.Register(isa.Operation{Code: code, Name: name, NArgs: nargs}); err != nil {
+		return err
+	}
+
+	extensions[code] = fn
+	return nil
+}
+
+// EnableExtensions lets the VM execute the opcodes registered with RegisterOpcode
+func (vm *VM) EnableExtensions() {
+	for code, fn := range extensions {
+		vm.SetHandler(code, uint16(isa.Operands(code)), fn)
+	}
+}
+
+// Arg returns the value of the argument i (from 0) of the instruction being executed, for handlers
+func (vm *VM) Arg(i int) uint16 {
+	return vm.operand(uint16(i))
+}
+
+// SetDest stores value in the register named by the first argument of the instruction being executed, for handlers
+func (vm *VM) SetDest(value uint16) {
+	vm.set(value)
+}
+
+// ReadByte reads a byte of the input like IN would (without the debugger commands), for handlers
+func (vm *VM) ReadByte() (byte, error) {
+	return vm.readInput()
+}
+
+// Print writes s to the output like OUT would, character by character, for handlers
+func (vm *VM) Print(s string) {
+	for i := 0; i < len(s); i++ {
+		vm.writeOutput(uint16(s[i]))
+	}
+}
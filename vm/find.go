@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxPrintedMatches is the number of addresses printed by $find and $findstr
+const maxPrintedMatches = 50
+
+// Find returns the addresses of the memory holding value
+func (vm *VM) Find(value uint16) []uint16 {
+	matches := []uint16{}
+	for addr, v := range words(vm.memory) {
+		if v == value {
+			matches = append(matches, uint16(addr))
+		}
+	}
+	return matches
+}
+
+// FindString returns the addresses where text is stored, one character per word. The texts of the game are prefixed
+// by their length: the address before a match holding the length of the text is the start of a string.
+func (vm *VM) FindString(text string) []uint16 {
+	matches := []uint16{}
+	if text == "" {
+		return matches
+	}
+
+	runes, memory := []rune(text), words(vm.memory)
+	for addr := 0; addr+len(runes) <= len(memory); addr++ {
+		found := true
+		for i, r := range runes {
+			if memory[addr+i] != uint16(r) {
+				found = false
+				break
+			}
+		}
+		if found {
+			matches = append(matches, uint16(addr))
+		}
+	}
+	return matches
+}
+
+// Refine keeps the addresses that now hold value, to narrow down a $find after the game state changed
+func (vm *VM) Refine(addrs []uint16, value uint16) []uint16 {
+	matches := []uint16{}
+	for _, addr := range addrs {
+		if int(addr) < vm.memory.Len() && vm.memory.Read(addr) == value {
+			matches = append(matches, addr)
+		}
+	}
+	return matches
+}
+
+// find handles the $find, $refine and $findstr commands
+func (vm *VM) find(name string, args []string) {
+	if name == "findstr" {
+		// The text can be quoted to keep its spaces
+		text := strings.Join(args, " ")
+		if unquoted, err := strconv.Unquote(text); err == nil {
+			text = unquoted
+		}
+		if text == "" {
+			vm.printError("Wrong command ! Should be $findstr \"text\"\n")
+			return
+		}
+
+		matches := vm.FindString(text)
+		lines := []string{}
+		for _, addr := range matches {
+			line := fmt.Sprintf("(%6d)", addr)
+			if addr > 0 && int(vm.memory.Read(addr-1)) == len([]rune(text)) {
+				line += fmt.Sprintf(" whole string at %d", addr-1)
+			}
+			lines = append(lines, line)
+		}
+		vm.printMatches(len(matches), lines)
+		return
+	}
+
+	if len(args) != 1 {
+		vm.printError("Wrong command ! Should be $" + name + " <value>\n")
+		return
+	}
+
+	value, err := strconv.ParseUint(args[0], 10, 16)
+	if err != nil {
+		vm.printError("Wrong value\n")
+		return
+	}
+
+	if name == "refine" {
+		if vm.candidates == nil {
+			vm.printError("Nothing to refine, use $find first\n")
+			return
+		}
+		vm.candidates = vm.Refine(vm.candidates, uint16(value))
+	} else {
+		vm.candidates = vm.Find(uint16(value))
+	}
+
+	lines := []string{}
+	for _, addr := range vm.candidates {
+		lines = append(lines, fmt.Sprintf("(%6d)", addr))
+	}
+	vm.printMatches(len(vm.candidates), lines)
+}
+
+// printMatches prints the number of matches and the first ones
+func (vm *VM) printMatches(n int, lines []string) {
+	if len(lines) > maxPrintedMatches {
+		lines = append(lines[:maxPrintedMatches], "...")
+	}
+	vm.printDebug(fmt.Sprintf("%d matches\n", n))
+	if len(lines) > 0 {
+		vm.printDebug(strings.Join(lines, "\n") + "\n")
+	}
+}
@@ -0,0 +1,152 @@
+package vm
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	roomLineRegex = regexp.MustCompile(`^== (.+) ==$`)
+	exitLineRegex = regexp.MustCompile(`^There (?:is|are) \d+ exits?:$`)
+)
+
+// GameState is what the output of the adventure told about the game so far
+type GameState struct {
+	Room        string   // Title of the current room, e.g. "Foothills"
+	Description string   // Description of the current room
+	Things      []string // Things of interest of the current room
+	Exits       []string // Exits of the current room
+	Inventory   []string // Items carried, from the last inventory listing and the items taken and dropped since
+}
+
+// Lists of the GameState filled by the lines starting with "- "
+const (
+	noList = iota
+	thingsList
+	exitsList
+	inventoryList
+)
+
+// gameTracker builds the GameState from the output, line by line
+type gameTracker struct {
+	state       GameState
+	list        int  // List filled by the lines starting with "- "
+	description bool // The lines are the description of the room until an empty one
+
+	line  []byte // Current line of output
+	input []byte // Current line of input
+	last  string // Last line of input, e.g. "take tablet"
+}
+
+// GameState returns the state of the game parsed from the output
+func (vm *VM) GameState() GameState {
+	s := vm.game.state
+	s.Things = append([]string{}, s.Things...)
+	s.Exits = append([]string{}, s.Exits...)
+	s.Inventory = append([]string{}, s.Inventory...)
+	return s
+}
+
+// feed adds a byte of output to the tracker
+func (t *gameTracker) feed(b byte) {
+	if b != '\n' {
+		t.line = append(t.line, b)
+		return
+	}
+	line := string(t.line)
+	t.line = t.line[:0]
+
+	switch {
+	case roomLineRegex.MatchString(line):
+		t.state.Room = roomLineRegex.FindStringSubmatch(line)[1]
+		t.state.Description, t.state.Things, t.state.Exits = "", nil, nil
+		t.list, t.description = noList, true
+
+	case t.description && line != "":
+		t.state.Description = strings.TrimSpace(t.state.Description + " " + line)
+
+	case line == "Things of interest here:":
+		t.list = thingsList
+
+	case exitLineRegex.MatchString(line):
+		t.list = exitsList
+
+	case line == "Your inventory:":
+		t.state.Inventory = nil
+		t.list = inventoryList
+
+	case t.list != noList && strings.HasPrefix(line, "- "):
+		list := t.target()
+		*list = append(*list, line[2:])
+
+	case line == "Taken." && strings.HasPrefix(t.last, "take "):
+		item := strings.TrimPrefix(t.last, "take ")
+		t.state.Things = remove(t.state.Things, item)
+		t.state.Inventory = append(t.state.Inventory, item)
+
+	case line == "Dropped." && strings.HasPrefix(t.last, "drop "):
+		item := strings.TrimPrefix(t.last, "drop ")
+		t.state.Inventory = remove(t.state.Inventory, item)
+		t.state.Things = append(t.state.Things, item)
+
+	default:
+		t.list, t.description = noList, false
+	}
+}
+
+// target returns the list being filled
+func (t *gameTracker) target() *[]string {
+	switch t.list {
+	case thingsList:
+		return &t.state.Things
+	case exitsList:
+		return &t.state.Exits
+	}
+	return &t.state.Inventory
+}
+
+// remove returns the list without the first occurrence of item
+func remove(list []string, item string) []string {
+	for i, v := range list {
+		if v == item {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// read adds a byte of input to the tracker, to know what was taken or dropped
+func (t *gameTracker) read(b byte) {
+	if b != '\n' {
+		t.input = append(t.input, b)
+		return
+	}
+	t.last = strings.TrimSpace(string(t.input))
+	t.input = t.input[:0]
+}
+
+// clone returns an independent copy of the tracker
+func (t *gameTracker) clone() *gameTracker {
+	c := *t
+	c.state.Things = append([]string{}, t.state.Things...)
+	c.state.Exits = append([]string{}, t.state.Exits...)
+	c.state.Inventory = append([]string{}, t.state.Inventory...)
+	c.line = append([]byte{}, t.line...)
+	c.input = append([]byte{}, t.input...)
+	return &c
+}
+
+// formatGameState formats the state for $state
+func formatGameState(s GameState) string {
+	list := func(items []string) string {
+		if len(items) == 0 {
+			return "-"
+		}
+		return strings.Join(items, ", ")
+	}
+	return "Room: " + s.Room + "\n" +
+		"Description: " + s.Description + "\n" +
+		"Things: " + list(s.Things) + "\n" +
+		"Exits: " + list(s.Exits) + "\n" +
+		"Inventory: " + list(s.Inventory)
+}
@@ -0,0 +1,191 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// defaultHistorySize is the number of instructions remembered by $history on
+const defaultHistorySize = 100000
+
+// changeKind is the kind of modification made by an instruction
+type changeKind uint8
+
+const (
+	memoryWrite changeKind = iota
+	stackPush
+	stackPop
+	callEnter
+	callLeave
+)
+
+// change is a modification of the state that can be undone
+type change struct {
+	kind   changeKind
+	addr   uint16  // Address of the memory write
+	value  uint16  // Value overwritten in memory or popped from the stack
+	frames []Frame // Frames dropped by a RET
+}
+
+// delta is what is needed to undo an instruction: the registers and cursor before it and the changes it made
+type delta struct {
+	cursor   uint16
+	register [8]uint16
+	changes  []change
+}
+
+// history is a ring buffer of the last executed instructions. Only the VM state is restored when going backwards: the
+// output stays written and the input stays consumed, neither are patches or debugger commands writing the memory undone.
+type history struct {
+	deltas []delta
+	next   int  // Slot of the next instruction
+	n      int  // Number of instructions remembered
+	open   bool // An instruction is being executed
+}
+
+// EnableHistory remembers the last size instructions so that they can be undone with $rstep, 0 disables it
+func (vm *VM) EnableHistory(size int) {
+	if size <= 0 {
+		vm.history = nil
+		return
+	}
+	vm.history = &history{deltas: make([]delta, size)}
+}
+
+// begin starts recording the instruction at the cursor
+func (h *history) begin(vm *VM) {
+	d := &h.deltas[h.next]
+	d.cursor, d.register, d.changes = vm.cursor, vm.register, d.changes[:0]
+
+	h.next = (h.next + 1) % len(h.deltas)
+	if h.n < len(h.deltas) {
+		h.n++
+	}
+	h.open = true
+}
+
+// end stops recording the instruction, it's forgotten if it failed or did nothing (e.g. IN reading a $ command)
+func (h *history) end(vm *VM, err error) {
+	if !h.open {
+		return
+	}
+	h.open = false
+
+	d := h.top()
+	if err != nil || (d.cursor == vm.cursor && d.register == vm.register && len(d.changes) == 0) {
+		h.drop()
+	}
+}
+
+// log adds a change to the instruction being recorded
+func (h *history) log(c change) {
+	if h.open {
+		d := h.top()
+		d.changes = append(d.changes, c)
+	}
+}
+
+func (h *history) top() *delta {
+	return &h.deltas[(h.next-1+len(h.deltas))%len(h.deltas)]
+}
+
+func (h *history) drop() {
+	h.next = (h.next - 1 + len(h.deltas)) % len(h.deltas)
+	h.n--
+}
+
+// undo restores the state before the last instruction, it returns false if there is nothing to undo
+func (vm *VM) undo() bool {
+	h := vm.history
+	if h.open {
+		// Called by IN reading a command: the instruction didn't happen
+		h.open = false
+		h.drop()
+	}
+	if h.n == 0 {
+		return false
+	}
+
+	d := h.top()
+	for i := len(d.changes) - 1; i >= 0; i-- {
+		switch c := d.changes[i]; c.kind {
+		case memoryWrite:
+			vm.SetMemory(c.addr, c.value)
+		case stackPush:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case stackPop:
+			vm.stack = append(vm.stack, c.value)
+		case callEnter:
+			vm.calls = vm.calls[:len(vm.calls)-1]
+		case callLeave:
+			vm.calls = append(vm.calls, c.frames...)
+		}
+	}
+	vm.cursor, vm.register = d.cursor, d.register
+	h.drop()
+
+	return true
+}
+
+// reverse handles the $history, $rstep and $rcontinue-to commands
+func (vm *VM) reverse(name string, args []string) {
+	if name == "history" {
+		if len(args) == 0 || len(args) > 2 || (args[0] != "on" && args[0] != "off") {
+			vm.printError("Wrong command ! Should be $history on [size] or $history off\n")
+			return
+		}
+
+		size := defaultHistorySize
+		if args[0] == "off" {
+			size = 0
+		} else if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				vm.printError("Wrong size\n")
+				return
+			}
+			size = n
+		}
+		vm.EnableHistory(size)
+		return
+	}
+
+	if vm.history == nil {
+		vm.printError("The history is off, use $history on\n")
+		return
+	}
+
+	// Number of instructions to undo or address to go back to
+	n, addr := 1, -1
+	switch {
+	case name == "rstep" && len(args) == 1:
+		v, err := strconv.Atoi(args[0])
+		if err != nil || v <= 0 {
+			vm.printError("Wrong count\n")
+			return
+		}
+		n = v
+	case name == "rcontinue-to" && len(args) == 1:
+		v, err := vm.parseAddress(args[0])
+		if err != nil {
+			vm.printError("Wrong address\n")
+			return
+		}
+		n, addr = -1, int(v)
+	case len(args) != 0 || name == "rcontinue-to":
+		vm.printError("Wrong command ! Should be $rstep [n] or $rcontinue-to <addr>\n")
+		return
+	}
+
+	for i := 0; n < 0 || i < n; i++ {
+		if !vm.undo() {
+			vm.printError("Beginning of the history\n")
+			break
+		}
+		if int(vm.cursor) == addr {
+			break
+		}
+	}
+
+	vm.printDebug(fmt.Sprintf("%s %s\n", vm.formatAddr(vm.cursor), vm.formatInstruction()))
+}
@@ -0,0 +1,52 @@
+package vm
+
+// hooks are the functions registered by external packages to follow the execution, see OnBeforeInstruction,
+// OnMemoryWrite, OnOutput, OnInput, OnRead and OnStateChange
+type hooks struct {
+	beforeInstruction []func(vm *VM)
+	memoryWrite       []func(vm *VM, addr, old, value uint16)
+	output            []func(vm *VM, c byte)
+	input             []func(vm *VM, c byte)
+	read              []func(vm *VM, c byte)
+	state             []func(vm *VM, s State)
+}
+
+// OnBeforeInstruction registers fn to be called before each instruction, after the patches of the cursor were applied.
+// Like patches, fn can modify the VM state, the instruction at the cursor once it returns is the one executed.
+func (vm *VM) OnBeforeInstruction(fn func(vm *VM)) {
+	vm.hooks.beforeInstruction = append(vm.hooks.beforeInstruction, fn)
+}
+
+// OnMemoryWrite registers fn to be called each time WMEM writes value at addr, old is the value it replaced. Changes
+// made through SetMemory or by patches are not reported.
+func (vm *VM) OnMemoryWrite(fn func(vm *VM, addr, old, value uint16)) {
+	vm.hooks.memoryWrite = append(vm.hooks.memoryWrite, fn)
+}
+
+// OnOutput registers fn to be called with each character written by OUT
+func (vm *VM) OnOutput(fn func(vm *VM, c byte)) {
+	vm.hooks.output = append(vm.hooks.output, fn)
+}
+
+// OnInput registers fn to be called with each character read by IN, the debugger commands are not reported
+func (vm *VM) OnInput(fn func(vm *VM, c byte)) {
+	vm.hooks.input = append(vm.hooks.input, fn)
+}
+
+// OnRead registers fn to be called with each byte consumed from the input, by IN or by the debugger. Playing the same
+// bytes again reproduces the session.
+func (vm *VM) OnRead(fn func(vm *VM, c byte)) {
+	vm.hooks.read = append(vm.hooks.read, fn)
+}
+
+// clone returns a copy of the hooks that can be extended independently, the functions themselves are shared
+func (h hooks) clone() hooks {
+	return hooks{
+		beforeInstruction: append([]func(*VM){}, h.beforeInstruction...),
+		memoryWrite:       append([]func(*VM, uint16, uint16, uint16){}, h.memoryWrite...),
+		output:            append([]func(*VM, byte){}, h.output...),
+		input:             append([]func(*VM, byte){}, h.input...),
+		read:              append([]func(*VM, byte){}, h.read...),
+		state:             append([]func(*VM, State){}, h.state...),
+	}
+}
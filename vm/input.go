@@ -0,0 +1,98 @@
+package vm
+
+import (
+	"bufio"
+	"io"
+)
+
+// InputProvider gives the bytes read by the IN operation and the debugger, see SetInputProvider. NextByte returns
+// false once the provider has nothing more to give, it's then never called again.
+type InputProvider interface {
+	NextByte() (byte, bool)
+}
+
+// InputFunc turns a function into an InputProvider
+type InputFunc func() (byte, bool)
+
+// NextByte calls f
+func (f InputFunc) NextByte() (byte, bool) {
+	return f()
+}
+
+// SetInputProvider replaces the input of the VM by p. The bytes are asked for a line at a time, when the VM needs
+// them, so that a provider can decide what to play from the state of the VM. The input ends when p runs out.
+func (vm *VM) SetInputProvider(p InputProvider) {
+	vm.SetInput(&providerReader{p: p})
+}
+
+// LinesInput provides the given lines, each followed by a newline
+func LinesInput(lines ...string) InputProvider {
+	i, j := 0, 0
+	return InputFunc(func() (byte, bool) {
+		if i == len(lines) {
+			return 0, false
+		}
+		if j == len(lines[i]) {
+			i, j = i+1, 0
+			return '\n', true
+		}
+		j++
+		return lines[i][j-1], true
+	})
+}
+
+// ReaderInput provides the bytes of r until it returns an error, e.g. os.Stdin to let the player type
+func ReaderInput(r io.Reader) InputProvider {
+	br := bufio.NewReader(r)
+	return InputFunc(func() (byte, bool) {
+		b, err := br.ReadByte()
+		return b, err == nil
+	})
+}
+
+// ChainInput provides the bytes of each provider in turn, the next one takes over once the previous one ran out: e.g.
+// ChainInput(LinesInput(commands...), ReaderInput(os.Stdin)) hands the game to the player after the commands
+func ChainInput(providers ...InputProvider) InputProvider {
+	return InputFunc(func() (byte, bool) {
+		for len(providers) > 0 {
+			if b, ok := providers[0].NextByte(); ok {
+				return b, true
+			}
+			providers = providers[1:]
+		}
+		return 0, false
+	})
+}
+
+// providerReader adapts an InputProvider to the io.Reader of the VM
+type providerReader struct {
+	p    InputProvider
+	done bool
+}
+
+// Read stops at the end of a line: asking for more would make the provider give the next one before the program or
+// the debugger read this one
+func (r *providerReader) Read(b []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(b) {
+		c, ok := r.p.NextByte()
+		if !ok {
+			r.done = true
+			break
+		}
+		b[n] = c
+		n++
+		if c == '\n' {
+			break
+		}
+	}
+
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
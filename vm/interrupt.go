@@ -0,0 +1,39 @@
+package vm
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// States of an interruption, see Interrupt
+const (
+	interruptNone    int32 = iota // Running normally
+	interruptPending              // Interrupt was called, the VM stops before the next instruction
+	interruptPrompt               // Stopped by Interrupt, stepping until the execution resumes
+)
+
+// Interrupt asks the VM to go to stepping mode before the next instruction, like a breakpoint. It can be called from
+// another goroutine (e.g. on SIGINT) and returns true if the VM was already interrupted and didn't resume since: the
+// VM is then waiting for the input of the debugger or of the program. An instruction waiting for its input (IN, or a
+// handler reading a line) finishes first, and so does the line being read by the program.
+func (vm *VM) Interrupt() bool {
+	return !atomic.CompareAndSwapInt32(&vm.interrupt, interruptNone, interruptPending)
+}
+
+// checkInterrupt enters stepping mode when Interrupt was called and notes when the execution resumed
+func (vm *VM) checkInterrupt() {
+	switch atomic.LoadInt32(&vm.interrupt) {
+	case interruptPending:
+		// Let the program read the rest of its line, the debugger would take it instead
+		if vm.inLine {
+			return
+		}
+		atomic.StoreInt32(&vm.interrupt, interruptPrompt)
+		vm.stepping = true
+		vm.printDebug(fmt.Sprintf("\nInterrupted at %s, $steppingoff to resume\n", vm.formatAddr(vm.cursor)))
+	case interruptPrompt:
+		if !vm.stepping {
+			atomic.StoreInt32(&vm.interrupt, interruptNone)
+		}
+	}
+}
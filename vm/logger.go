@@ -3,20 +3,41 @@ package vm
 import "fmt"
 import "io"
 import "log"
+import "strings"
 
 // formatRegister returns a string reprensentation of the current state of the register
 func (vm VM) formatRegister() string {
 	res := ""
 	for i, v := range vm.register {
-		res += fmt.Sprintf("R%d: %6d | ", i+1, v)
+		res += fmt.Sprintf("R%d: %6d | ", i, v)
 	}
 
 	return res
 }
 
-// formatStack returns a string representation of the current stack
-func (vm VM) formatStack() string {
-	return fmt.Sprintf("%v", vm.stack)
+// formatDump returns an hexdump of the memory starting at addr, 8 words per line followed by their ASCII representation
+func formatDump(addr uint16, words []uint16) string {
+	lines := []string{}
+	for i := 0; i < len(words); i += 8 {
+		end := i + 8
+		if end > len(words) {
+			end = len(words)
+		}
+
+		hex, ascii := "", ""
+		for _, w := range words[i:end] {
+			hex += fmt.Sprintf("%04x ", w)
+			if w >= 32 && w < 127 {
+				ascii += string(rune(w))
+			} else {
+				ascii += "."
+			}
+		}
+
+		lines = append(lines, fmt.Sprintf("(%6d) %-40s|%s|", int(addr)+i, hex, ascii))
+	}
+
+	return strings.Join(lines, "\n")
 }
 
 // log writes the state of the vm in a writer
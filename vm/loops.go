@@ -0,0 +1,108 @@
+package vm
+
+import "fmt"
+
+// FNV-1a parameters of StateHash
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// StateHash returns a hash of the registers, the cursor and the stack, and of the memory if memory is true. Two VMs in
+// the same state have the same hash, whatever they executed to get there.
+//
+// Without input the execution only depends on this state: once it repeats with the memory included, the VM loops
+// forever. Without the memory the hash is cheaper but a repetition is only a hint, e.g. to prune a brute-force search.
+func (vm *VM) StateHash(memory bool) uint64 {
+	h := uint64(fnvOffset)
+	word := func(w uint16) {
+		h = (h ^ uint64(w&0xff)) * fnvPrime
+		h = (h ^ uint64(w>>8)) * fnvPrime
+	}
+
+	for _, r := range vm.register {
+		word(r)
+	}
+	word(vm.cursor)
+	// The length separates the stack from the memory
+	word(uint16(len(vm.stack)))
+	word(uint16(len(vm.stack) >> 16))
+	for _, v := range vm.stack {
+		word(v)
+	}
+	if memory {
+		for _, m := range words(vm.memory) {
+			word(m)
+		}
+	}
+	return h
+}
+
+// maxSamples bounds the states remembered by a LoopDetector, it starts over once they are reached
+const maxSamples = 1 << 16
+
+// LoopDetector tells when the state of a VM repeats: it samples the StateHash every interval instructions, a loop of
+// any period is found once it ran for interval times its period (up to maxSamples samples).
+type LoopDetector struct {
+	interval uint64
+	memory   bool
+	seen     map[uint64]uint64 // Hash of each state sampled to the instructions executed then
+}
+
+// NewLoopDetector returns a detector sampling the state every interval instructions, with the memory if memory is true
+func NewLoopDetector(interval uint64, memory bool) *LoopDetector {
+	if interval == 0 {
+		interval = 1
+	}
+	return &LoopDetector{interval: interval, memory: memory, seen: map[uint64]uint64{}}
+}
+
+// Check samples the state of vm if its instructions are a multiple of the interval. If the state was already sampled
+// it returns the instructions executed then and true.
+func (d *LoopDetector) Check(vm *VM) (uint64, bool) {
+	n := vm.instructions
+	if n%d.interval != 0 {
+		return 0, false
+	}
+
+	h := vm.StateHash(d.memory)
+	if first, ok := d.seen[h]; ok {
+		return first, true
+	}
+	if len(d.seen) >= maxSamples {
+		d.Reset()
+	}
+	d.seen[h] = n
+	return 0, false
+}
+
+// Reset forgets the states sampled, e.g. after the input or the state was changed from the outside
+func (d *LoopDetector) Reset() {
+	d.seen = map[uint64]uint64{}
+}
+
+// DetectLoops makes Step fail with ErrLoop when the whole state (memory included) sampled every interval instructions
+// repeats without any input read in between, which means that the VM loops forever. The states are forgotten when a
+// byte is read, a debugger command runs or a snapshot is restored. 0 disables the detection.
+func (vm *VM) DetectLoops(interval uint64) {
+	if interval == 0 {
+		vm.loops = nil
+		return
+	}
+	vm.loops = NewLoopDetector(interval, true)
+}
+
+// checkLoop fails if the state of the VM repeats, see DetectLoops
+func (vm *VM) checkLoop() error {
+	if first, ok := vm.loops.Check(vm); ok {
+		return fmt.Errorf("%w: the state at cursor %d after %d instructions is the one after %d", ErrLoop, vm.cursor, vm.instructions, first)
+	}
+	return nil
+}
+
+// resetLoops forgets the states sampled by DetectLoops
+func (vm *VM) resetLoops() {
+	if vm.loops != nil {
+		vm.loops.Reset()
+	}
+}
@@ -0,0 +1,165 @@
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// memo caches the results of a function whose outputs only depend on some registers, see Memoize
+type memo struct {
+	inputs  []int
+	outputs []int
+	cache   map[[8]uint16][]uint16 // Outputs by inputs, the other registers are 0 in the key
+	hits    int
+}
+
+// pendingMemo is a call of a memoized function that wasn't cached, its outputs are cached once it returns
+type pendingMemo struct {
+	memo  *memo
+	key   [8]uint16
+	ret   uint16 // Return address
+	depth int    // Size of the stack once it returned
+}
+
+// Memoize caches the results of the function at addr, a pure function of the input registers: when the cursor reaches
+// addr with inputs already seen (usually through a CALL), the output registers are set to the values they had when the
+// function returned these times and the VM returns to the caller as if the function executed RET. The other registers
+// and the memory are left untouched, so the function must not have other effects the program relies on.
+//
+// The cache lasts until Unmemoize, a clone starts with an empty one since other registers may change the results.
+func (vm *VM) Memoize(addr uint16, inputs, outputs []int) {
+	if vm.memos == nil {
+		vm.memos = map[uint16]*memo{}
+	}
+	vm.memos[addr] = &memo{inputs: inputs, outputs: outputs, cache: map[[8]uint16][]uint16{}}
+}
+
+// Unmemoize stops caching the results of the function at addr and forgets them
+func (vm *VM) Unmemoize(addr uint16) {
+	m := vm.memos[addr]
+	delete(vm.memos, addr)
+	pending := vm.pendingMemos[:0]
+	for _, p := range vm.pendingMemos {
+		if p.memo != m {
+			pending = append(pending, p)
+		}
+	}
+	vm.pendingMemos = pending
+}
+
+// key returns the values of the inputs of m
+func (m *memo) key(vm *VM) [8]uint16 {
+	k := [8]uint16{}
+	for _, r := range m.inputs {
+		k[r] = vm.register[r]
+	}
+	return k
+}
+
+// runMemos caches the outputs of the calls that returned and returns from the calls already cached
+func (vm *VM) runMemos() {
+	for {
+		if n := len(vm.pendingMemos); n > 0 {
+			p := vm.pendingMemos[n-1]
+			// The program can tamper with the stack: a call unwound without returning is forgotten
+			if len(vm.stack) < p.depth || (len(vm.stack) == p.depth && vm.cursor == p.ret) {
+				vm.pendingMemos = vm.pendingMemos[:n-1]
+				if vm.cursor == p.ret {
+					out := make([]uint16, len(p.memo.outputs))
+					for i, r := range p.memo.outputs {
+						out[i] = vm.register[r]
+					}
+					p.memo.cache[p.key] = out
+				}
+				continue
+			}
+		}
+
+		m := vm.memos[vm.cursor]
+		// Without a return address run the original code, RET will halt
+		if m == nil || len(vm.stack) == 0 {
+			return
+		}
+
+		key := m.key(vm)
+		out, ok := m.cache[key]
+		if !ok {
+			vm.pendingMemos = append(vm.pendingMemos, pendingMemo{memo: m, key: key, ret: vm.stack[len(vm.stack)-1], depth: len(vm.stack) - 1})
+			return
+		}
+
+		m.hits++
+		for i, r := range m.outputs {
+			vm.register[r] = out[i]
+		}
+		ret, _ := vm.pop()
+		vm.leaveCall(ret)
+		vm.cursor = ret
+	}
+}
+
+var memoizeRegex = regexp.MustCompile(`^(\S+) +(r[0-7](?: *, *r[0-7])*) *-> *(r[0-7](?: *, *r[0-7])*)$`)
+
+// memoizeCommand handles $memoize [<addr> <inputs> -> <outputs>] and $unmemoize <addr>: cache the results of a
+// function (e.g. $memoize 6027 r0,r1 -> r0), list the functions cached or stop caching one
+func (vm *VM) memoizeCommand(name string, args []string) {
+	if name == "unmemoize" {
+		addr, err := vm.Eval(strings.Join(args, " "))
+		if len(args) == 0 || err != nil || vm.memos[uint16(addr)] == nil {
+			vm.printError("Wrong command ! Should be $unmemoize <addr of a memoized function>\n")
+			return
+		}
+		vm.Unmemoize(uint16(addr))
+		return
+	}
+
+	if len(args) == 0 {
+		addrs := []int{}
+		for addr := range vm.memos {
+			addrs = append(addrs, int(addr))
+		}
+		sort.Ints(addrs)
+
+		lines := []string{}
+		for _, addr := range addrs {
+			m := vm.memos[uint16(addr)]
+			lines = append(lines, fmt.Sprintf("%s %s -> %s: %d results cached, %d calls returned from the cache", vm.symbols.Format(uint16(addr)), formatRegisters(m.inputs), formatRegisters(m.outputs), len(m.cache), m.hits))
+		}
+		vm.printDebug("Memoized functions:\n" + strings.Join(lines, "\n") + "\n")
+		return
+	}
+
+	match := memoizeRegex.FindStringSubmatch(strings.Join(args, " "))
+	if match == nil {
+		vm.printError("Wrong command ! Should be $memoize <addr> <inputs> -> <outputs>, e.g. $memoize 6027 r0,r1 -> r0\n")
+		return
+	}
+	addr, err := vm.Eval(match[1])
+	if err != nil || addr < 0 || addr >= vm.memory.Len() {
+		vm.printError(fmt.Sprintf("Wrong address %s\n", match[1]))
+		return
+	}
+	vm.Memoize(uint16(addr), parseRegisters(match[2]), parseRegisters(match[3]))
+}
+
+// parseRegisters parses a list of registers validated by memoizeRegex, e.g. r0,r1
+func parseRegisters(list string) []int {
+	registers := []int{}
+	for _, r := range strings.Split(list, ",") {
+		n, _ := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(r), "r"))
+		registers = append(registers, n)
+	}
+	return registers
+}
+
+// formatRegisters is the opposite of parseRegisters
+func formatRegisters(registers []int) string {
+	names := []string{}
+	for _, r := range registers {
+		names = append(names, fmt.Sprintf("r%d", r))
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,207 @@
+package vm
+
+import (
+	"io"
+	"sync"
+)
+
+// Memory is the address space of a VM, see NewWithMemory. Its addresses go from 0 to Len()-1, its length doesn't
+// change: the VM checks the addresses before reading or writing, a Memory doesn't have to.
+type Memory interface {
+	Read(addr uint16) uint16
+	Write(addr, value uint16)
+	Len() int
+}
+
+// FlatMemory is a Memory stored in a slice, the one of New
+type FlatMemory []uint16
+
+func (m FlatMemory) Read(addr uint16) uint16 { return m[addr] }
+
+func (m FlatMemory) Write(addr, value uint16) { m[addr] = value }
+
+func (m FlatMemory) Len() int { return len(m) }
+
+// pageSize is the number of words of a page of a COWMemory
+const pageSize = 256
+
+// COWMemory is a copy-on-write Memory: its copies share its pages until one of them writes to a page, which copies
+// that page only. Copying it costs a page table instead of the whole memory, e.g. to fork many VMs in a search.
+type COWMemory struct {
+	mu     sync.Mutex          // Copy can be called from several goroutines, e.g. to clone the base VM of a search
+	pages  []*[pageSize]uint16 // Pages of the memory, shared with the copies unless owned
+	owned  []bool              // Pages written since the last copy, which are then not shared
+	length int
+}
+
+// NewCOWMemory creates a COWMemory holding a copy of words
+func NewCOWMemory(words []uint16) *COWMemory {
+	n := (len(words) + pageSize - 1) / pageSize
+	m := &COWMemory{pages: make([]*[pageSize]uint16, n), owned: make([]bool, n), length: len(words)}
+	for i := range m.pages {
+		m.pages[i] = &[pageSize]uint16{}
+		copy(m.pages[i][:], words[i*pageSize:])
+		m.owned[i] = true
+	}
+	return m
+}
+
+func (m *COWMemory) Read(addr uint16) uint16 {
+	return m.pages[addr/pageSize][addr%pageSize]
+}
+
+func (m *COWMemory) Write(addr, value uint16) {
+	p := addr / pageSize
+	if !m.owned[p] {
+		m.mu.Lock()
+		page := *m.pages[p]
+		m.pages[p], m.owned[p] = &page, true
+		m.mu.Unlock()
+	}
+	m.pages[p][addr%pageSize] = value
+}
+
+func (m *COWMemory) Len() int { return m.length }
+
+// Copy returns a copy of the memory sharing its pages: the next write to a page on either side copies it
+func (m *COWMemory) Copy() *COWMemory {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.owned {
+		m.owned[i] = false
+	}
+	pages := append([]*[pageSize]uint16{}, m.pages...)
+	return &COWMemory{pages: pages, owned: make([]bool, len(pages)), length: m.length}
+}
+
+// WatchedMemory is a Memory reporting the accesses to the one it wraps, the ones of the debugger included. A nil
+// function isn't called.
+type WatchedMemory struct {
+	Memory
+	OnRead  func(addr, value uint16)
+	OnWrite func(addr, old, value uint16)
+}
+
+func (m *WatchedMemory) Read(addr uint16) uint16 {
+	v := m.Memory.Read(addr)
+	if m.OnRead != nil {
+		m.OnRead(addr, v)
+	}
+	return v
+}
+
+func (m *WatchedMemory) Write(addr, value uint16) {
+	if m.OnWrite != nil {
+		m.OnWrite(addr, m.Memory.Read(addr), value)
+	}
+	m.Memory.Write(addr, value)
+}
+
+// words returns the content of m, the slice of a FlatMemory itself: it must not be modified
+func words(m Memory) []uint16 {
+	if flat, ok := m.(FlatMemory); ok {
+		return flat
+	}
+	w := make([]uint16, m.Len())
+	for i := range w {
+		w[i] = m.Read(uint16(i))
+	}
+	return w
+}
+
+// wordsAt returns up to n words of m from addr, like words
+func wordsAt(m Memory, addr, n int) []uint16 {
+	end := addr + n
+	if end > m.Len() {
+		end = m.Len()
+	}
+	if addr > end {
+		addr = end
+	}
+	if flat, ok := m.(FlatMemory); ok {
+		return flat[addr:end]
+	}
+	w := make([]uint16, end-addr)
+	for i := range w {
+		w[i] = m.Read(uint16(addr + i))
+	}
+	return w
+}
+
+// copyMemory returns an independent copy of m of the same kind, a cheap one for a COWMemory
+func copyMemory(m Memory) Memory {
+	switch m := m.(type) {
+	case FlatMemory:
+		return append(FlatMemory{}, m...)
+	case *COWMemory:
+		return m.Copy()
+	case *WatchedMemory:
+		return &WatchedMemory{Memory: copyMemory(m.Memory), OnRead: m.OnRead, OnWrite: m.OnWrite}
+	}
+	return FlatMemory(append([]uint16{}, words(m)...))
+}
+
+// memoryLike returns a Memory of the same kind as m holding a copy of w, e.g. to restore a snapshot
+func memoryLike(m Memory, w []uint16) Memory {
+	switch m := m.(type) {
+	case *COWMemory:
+		return NewCOWMemory(w)
+	case *WatchedMemory:
+		return &WatchedMemory{Memory: memoryLike(m.Memory, w), OnRead: m.OnRead, OnWrite: m.OnWrite}
+	}
+	return FlatMemory(append([]uint16{}, w...))
+}
+
+// NewWithMemory creates a VM like New whose memory is the given implementation, e.g. a COWMemory or a WatchedMemory
+func NewWithMemory(memory Memory, in io.Reader, out io.Writer) *VM {
+	vm := New(nil, in, out)
+	vm.setMemory(memory)
+	return vm
+}
+
+// SetCopyOnWrite moves the memory of the VM to a COWMemory (on) or back to a FlatMemory, e.g. before forking many
+// clones of the VM: the clones and the snapshots of a copy-on-write memory share its pages instead of copying them. The
+// execution is a little slower since each read goes through the page table.
+func (vm *VM) SetCopyOnWrite(on bool) {
+	_, cow := vm.memory.(*COWMemory)
+	switch {
+	case on && !cow:
+		vm.setMemory(NewCOWMemory(words(vm.memory)))
+	case !on && cow:
+		vm.setMemory(FlatMemory(append([]uint16{}, words(vm.memory)...)))
+	}
+}
+
+// setMemory replaces the memory of the VM
+func (vm *VM) setMemory(m Memory) {
+	vm.memory = m
+	vm.length = m.Len()
+	vm.flat, _ = m.(FlatMemory)
+	vm.pages = nil
+	if cow, ok := m.(*COWMemory); ok {
+		vm.pages = cow.pages // Its page table isn't reallocated, only its pages are replaced
+	}
+}
+
+// read returns the value at addr, which must be in the memory. A FlatMemory is read without calling its methods since
+// it's the hot path of the execution.
+func (vm *VM) read(addr uint16) uint16 {
+	if int(addr) < len(vm.flat) {
+		return vm.flat[addr]
+	}
+	return vm.readPage(addr)
+}
+
+// readPage is read for the other implementations, a COWMemory is read without calling its methods either
+func (vm *VM) readPage(addr uint16) uint16 {
+	if vm.pages != nil {
+		return vm.pages[addr/pageSize][addr%pageSize]
+	}
+	return vm.memory.Read(addr)
+}
+
+// MemoryBackend returns the implementation of the memory of the VM
+func (vm *VM) MemoryBackend() Memory {
+	return vm.memory
+}
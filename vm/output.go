@@ -0,0 +1,53 @@
+package vm
+
+// outputHistory is the number of output bytes kept for LastOutput
+const outputHistory = 4096
+
+// outputChanSize is the buffer size of the channels returned by OutputChan
+const outputChanSize = 4096
+
+// outputRing keeps the last bytes written by OUT
+type outputRing struct {
+	buf   [outputHistory]byte
+	total uint64 // Bytes written since the start
+}
+
+func (r *outputRing) write(b byte) {
+	r.buf[r.total%outputHistory] = b
+	r.total++
+}
+
+// last returns the n last bytes, or all the kept ones if there are less
+func (r *outputRing) last(n int) []byte {
+	if uint64(n) > r.total {
+		n = int(r.total)
+	}
+	if n > outputHistory {
+		n = outputHistory
+	}
+
+	out := make([]byte, n)
+	for i := 0; i < n; i++ {
+		out[i] = r.buf[(r.total-uint64(n)+uint64(i))%outputHistory]
+	}
+	return out
+}
+
+// LastOutput returns the last n bytes written by OUT (at most 4096 are kept), e.g. to read the description of the
+// current room
+func (vm *VM) LastOutput(n int) string {
+	return string(vm.output.last(n))
+}
+
+// SubscribeOutput registers fn to be called with every byte written by OUT, see OnOutput to also get the VM
+func (vm *VM) SubscribeOutput(fn func(b byte)) {
+	vm.OnOutput(func(_ *VM, b byte) { fn(b) })
+}
+
+// OutputChan returns a channel receiving every byte written by OUT from now on. It is buffered but the VM blocks
+// when it is full so it must be drained, it is never closed.
+func (vm *VM) OutputChan() <-chan byte {
+	ch := make(chan byte, outputChanSize)
+	vm.SubscribeOutput(func(b byte) { ch <- b })
+	return ch
+}
@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// AddPatch registers fn to be called each time the cursor reaches addr, before the instruction is executed.
+// Patches can modify the VM state, if one of them moves the cursor the instruction at the new cursor is executed instead.
+func (vm *VM) AddPatch(addr uint16, fn func(*VM)) {
+	if vm.patches == nil {
+		vm.patches = map[uint16][]func(*VM){}
+	}
+	vm.patches[addr] = append(vm.patches[addr], fn)
+}
+
+// applyPatches calls the patches registered for the current cursor
+func (vm *VM) applyPatches() {
+	for _, fn := range vm.patches[vm.cursor] {
+		fn(vm)
+	}
+}
+
+// PatchSpec describes a patch in a patch file:
+//
+//	{"address": 5489, "registers": {"0": 6}, "jump": 5491}
+//
+// sets the first register to 6 and jumps to 5491 when the cursor reaches 5489. Registers are numbered from 0 to 7.
+type PatchSpec struct {
+	Address   uint16            `json:"address"`             // Address triggering the patch
+	Registers map[int]uint16    `json:"registers,omitempty"` // Registers to set
+	Memory    map[uint16]uint16 `json:"memory,omitempty"`    // Memory cells to set
+	Jump      *uint16           `json:"jump,omitempty"`      // Where the cursor is moved
+}
+
+// Func returns the function applying the patch
+func (p PatchSpec) Func() func(*VM) {
+	return func(vm *VM) {
+		for r, v := range p.Registers {
+			vm.register[r] = v
+		}
+
+		for addr, v := range p.Memory {
+			vm.SetMemory(addr, v)
+		}
+
+		if p.Jump != nil {
+			vm.cursor = *p.Jump
+		}
+	}
+}
+
+// LoadPatches reads a JSON file containing a list of PatchSpec
+func LoadPatches(path string) ([]PatchSpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	patches := []PatchSpec{}
+	if err := json.Unmarshal(b, &patches); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	for _, p := range patches {
+		for r := range p.Registers {
+			if r < 0 || r > 7 {
+				return nil, fmt.Errorf("%s: invalid register %d in patch at %d", path, r, p.Address)
+			}
+		}
+
+		for addr := range p.Memory {
+			if addr >= M {
+				return nil, fmt.Errorf("%s: invalid memory address %d in patch at %d", path, addr, p.Address)
+			}
+		}
+	}
+
+	return patches, nil
+}
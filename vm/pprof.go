@@ -0,0 +1,257 @@
+package vm
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// callNode is a node of the call tree of the profile: a function reached through a chain of calls. A call of a
+// function already on the chain goes back to its node so that recursive programs keep a tree of bounded size.
+type callNode struct {
+	parent *callNode
+	site   uint16            // Address of the CALL in the parent function
+	target uint16            // Address of the function, meaningless for the root (the code outside of any call)
+	counts map[uint16]uint64 // Executions per address, while this node is the innermost call
+
+	next     map[uint32]*callNode // Node reached by a call from this one, keyed by site and target
+	children []*callNode
+}
+
+func newCallNode(parent *callNode, site, target uint16) *callNode {
+	return &callNode{parent: parent, site: site, target: target, counts: map[uint16]uint64{}, next: map[uint32]*callNode{}}
+}
+
+// call returns the node reached by calling target at site from n
+func (n *callNode) call(site, target uint16) *callNode {
+	key := uint32(site)<<16 | uint32(target)
+	if next, ok := n.next[key]; ok {
+		return next
+	}
+
+	next := (*callNode)(nil)
+	for a := n; a.parent != nil; a = a.parent {
+		if a.target == target {
+			next = a
+			break
+		}
+	}
+	if next == nil {
+		next = newCallNode(n, site, target)
+		n.children = append(n.children, next)
+	}
+	n.next[key] = next
+	return next
+}
+
+// WritePprof writes the profile in the gzipped protobuf format of pprof (github.com/google/pprof), to be read by
+// go tool pprof and the flame graph viewers. The samples count the instructions executed at each address with the
+// chain of calls leading to it, every address is attributed to the function it executes in: fn_<address> or the
+// name of the symbol table, and its number of line is the address.
+func (vm *VM) WritePprof(w io.Writer) error {
+	if vm.profile == nil {
+		return fmt.Errorf("profiling is not enabled")
+	}
+
+	p := &pprofBuilder{
+		vm:        vm,
+		strings:   map[string]int{"": 0},
+		table:     []string{""},
+		locations: map[uint32]uint64{},
+		functions: map[uint32]uint64{},
+	}
+
+	profile := protoBuffer{}
+	instructions := protoBuffer{}
+	instructions.int(1, int64(p.str("instructions")))
+	instructions.int(2, int64(p.str("count")))
+	profile.message(1, &instructions)
+	p.samples(&profile, vm.profile.root)
+
+	mapping := protoBuffer{}
+	mapping.int(1, 1)
+	mapping.int(3, M)
+	mapping.int(5, int64(p.str("synacor")))
+	mapping.bool(7, true)
+	mapping.bool(9, true)
+	profile.message(3, &mapping)
+
+	profile.buf = append(profile.buf, p.entries.buf...)
+	for _, s := range p.table {
+		profile.string(6, s)
+	}
+	profile.message(11, &instructions)
+	profile.int(12, 1)
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(profile.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// SavePprof writes the profile in the pprof format to the given file, see WritePprof
+func (vm *VM) SavePprof(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := vm.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// pprofBuilder assigns the ids of the strings, functions and locations of a pprof profile
+type pprofBuilder struct {
+	vm        *VM
+	entries   protoBuffer // Encoded locations and functions
+	strings   map[string]int
+	table     []string
+	locations map[uint32]uint64 // Location id per address and function
+	functions map[uint32]uint64 // Function id per function, the root is M
+}
+
+// str returns the index of s in the string table
+func (p *pprofBuilder) str(s string) int {
+	if i, ok := p.strings[s]; ok {
+		return i
+	}
+	p.strings[s] = len(p.table)
+	p.table = append(p.table, s)
+	return len(p.table) - 1
+}
+
+// function returns the id of the function n executes in
+func (p *pprofBuilder) function(n *callNode) uint64 {
+	key, name := uint32(n.target), fmt.Sprintf("fn_%d", n.target)
+	if n.parent == nil {
+		key, name = M, "(outside of calls)"
+	} else if s, ok := p.vm.symbols.Name(n.target); ok {
+		name = s
+	}
+
+	if id, ok := p.functions[key]; ok {
+		return id
+	}
+	id := uint64(len(p.functions) + 1)
+	p.functions[key] = id
+
+	f := protoBuffer{}
+	f.int(1, int64(id))
+	f.int(2, int64(p.str(name)))
+	f.int(3, int64(p.str(name)))
+	if n.parent != nil {
+		f.int(5, int64(n.target))
+	}
+	p.entries.message(5, &f)
+	return id
+}
+
+// location returns the id of the location of addr executed by the function of n
+func (p *pprofBuilder) location(addr uint16, n *callNode) uint64 {
+	fn := p.function(n)
+	key := uint32(fn)<<16 | uint32(addr)
+	if id, ok := p.locations[key]; ok {
+		return id
+	}
+	id := uint64(len(p.locations) + 1)
+	p.locations[key] = id
+
+	line := protoBuffer{}
+	line.int(1, int64(fn))
+	line.int(2, int64(addr))
+
+	l := protoBuffer{}
+	l.int(1, int64(id))
+	l.int(2, 1)
+	l.int(3, int64(addr))
+	l.message(4, &line)
+	p.entries.message(4, &l)
+	return id
+}
+
+// samples encodes a sample per address executed by n and its descendants
+func (p *pprofBuilder) samples(profile *protoBuffer, n *callNode) {
+	addrs := make([]int, 0, len(n.counts))
+	for addr := range n.counts {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+
+	for _, addr := range addrs {
+		// The innermost location first, then the calls leading to it
+		ids := []uint64{p.location(uint16(addr), n)}
+		for c := n; c.parent != nil; c = c.parent {
+			ids = append(ids, p.location(c.site, c.parent))
+		}
+
+		s := protoBuffer{}
+		s.packed(1, ids)
+		s.packed(2, []uint64{n.counts[uint16(addr)]})
+		profile.message(2, &s)
+	}
+
+	for _, c := range n.children {
+		p.samples(profile, c)
+	}
+}
+
+// protoBuffer encodes protocol buffer messages, only the wire types needed by WritePprof are supported
+type protoBuffer struct {
+	buf []byte
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.buf = append(b.buf, byte(v)|0x80)
+		v >>= 7
+	}
+	b.buf = append(b.buf, byte(v))
+}
+
+// key writes the key of a field, wire is 0 for a varint and 2 for bytes
+func (b *protoBuffer) key(field int, wire uint64) {
+	b.varint(uint64(field)<<3 | wire)
+}
+
+// int writes a varint field, omitted if zero as the default value
+func (b *protoBuffer) int(field int, v int64) {
+	if v != 0 {
+		b.key(field, 0)
+		b.varint(uint64(v))
+	}
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.int(field, 1)
+	}
+}
+
+// string writes a string field, even if empty since it is used for the repeated string table
+func (b *protoBuffer) string(field int, s string) {
+	b.key(field, 2)
+	b.varint(uint64(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+func (b *protoBuffer) message(field int, m *protoBuffer) {
+	b.key(field, 2)
+	b.varint(uint64(len(m.buf)))
+	b.buf = append(b.buf, m.buf...)
+}
+
+// packed writes a repeated varint field
+func (b *protoBuffer) packed(field int, vs []uint64) {
+	m := protoBuffer{}
+	for _, v := range vs {
+		m.varint(v)
+	}
+	b.message(field, &m)
+}
@@ -0,0 +1,185 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// profile counts the executed instructions
+type profile struct {
+	addresses []uint64 // Executions per address
+	opcodes   []uint64 // Executions per opcode
+	total     uint64   // Executed instructions
+
+	// Per function, indexed by the address called
+	self      []uint64 // Instructions executed by the function itself, not by the ones it calls
+	inclusive []uint64 // Instructions executed by the function and the ones it calls, once its outermost call returns
+	calls     []uint64 // Calls of the function
+	active    []int    // Calls of the function that haven't returned, so that recursive calls are counted once
+	topLevel  uint64   // Instructions executed outside of any call
+
+	root *callNode // Call tree, for WritePprof
+}
+
+// EnableProfiling starts counting executions per address, per opcode and per function, see WriteProfile
+func (vm *VM) EnableProfiling() {
+	vm.profile = &profile{
+		addresses: make([]uint64, M),
+		opcodes:   make([]uint64, len(isa.Operations)),
+		self:      make([]uint64, M),
+		inclusive: make([]uint64, M),
+		calls:     make([]uint64, M),
+		active:    make([]int, M),
+		root:      newCallNode(nil, 0, 0),
+	}
+}
+
+// count records the execution of the instruction at the cursor
+func (p *profile) count(vm *VM) {
+	p.addresses[vm.cursor]++
+	if op := vm.memory.Read(vm.cursor); int(op) < len(p.opcodes) {
+		p.opcodes[op]++
+	}
+	p.total++
+
+	node := p.root
+	if len(vm.calls) == 0 {
+		p.topLevel++
+	} else {
+		f := vm.calls[len(vm.calls)-1]
+		p.self[f.Target]++
+		if f.node != nil {
+			node = f.node
+		}
+	}
+	node.counts[vm.cursor]++
+}
+
+// enter records a call of target at site from the node of the call tree parent and returns the node of the call
+func (p *profile) enter(parent *callNode, site, target uint16) *callNode {
+	p.calls[target]++
+	p.active[target]++
+	return parent.call(site, target)
+}
+
+// leave records the return of the call f, now is the number of instructions executed since the start
+func (p *profile) leave(f Frame, now uint64) {
+	// The calls made before the profiling started are not counted
+	if p.active[f.Target] == 0 {
+		return
+	}
+	p.active[f.Target]--
+	if p.active[f.Target] == 0 {
+		p.inclusive[f.Target] += now - f.entered
+	}
+}
+
+// functionStats are the counters of a function, see WriteProfile
+type functionStats struct {
+	addr            uint16
+	calls           uint64
+	self, inclusive uint64
+}
+
+// functions returns the counters of the functions called, including the calls that haven't returned yet, the most
+// expensive first
+func (vm *VM) functions() []functionStats {
+	p := vm.profile
+	inclusive := append([]uint64{}, p.inclusive...)
+	counted := map[uint16]bool{}
+	for _, f := range vm.calls {
+		if p.active[f.Target] > 0 && !counted[f.Target] {
+			inclusive[f.Target] += vm.instructions - f.entered
+			counted[f.Target] = true
+		}
+	}
+
+	stats := []functionStats{}
+	for addr, n := range p.calls {
+		if n > 0 {
+			stats = append(stats, functionStats{uint16(addr), n, p.self[addr], inclusive[addr]})
+		}
+	}
+	sort.SliceStable(stats, func(i, j int) bool { return stats[i].inclusive > stats[j].inclusive })
+	return stats
+}
+
+// WriteProfile writes a report of the executions per opcode and of the top hottest addresses
+func (vm *VM) WriteProfile(w io.Writer, top int) error {
+	p := vm.profile
+	if p == nil {
+		return fmt.Errorf("profiling is not enabled")
+	}
+
+	if _, err := fmt.Fprintf(w, "Executed instructions: %d\n\nPer opcode:\n", p.total); err != nil {
+		return err
+	}
+
+	ops := []isa.Operation{}
+	for _, op := range isa.Operations {
+		if p.opcodes[op.Code] > 0 {
+			ops = append(ops, op)
+		}
+	}
+	sort.SliceStable(ops, func(i, j int) bool { return p.opcodes[ops[i].Code] > p.opcodes[ops[j].Code] })
+
+	for _, op := range ops {
+		n := p.opcodes[op.Code]
+		if _, err := fmt.Fprintf(w, "%6s %12d %6.2f%%\n", op.Name, n, percent(n, p.total)); err != nil {
+			return err
+		}
+	}
+
+	addrs := []int{}
+	for addr, n := range p.addresses {
+		if n > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool { return p.addresses[addrs[i]] > p.addresses[addrs[j]] })
+	if len(addrs) > top {
+		addrs = addrs[:top]
+	}
+
+	if _, err := fmt.Fprintf(w, "\nHot spots:\n"); err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		n := p.addresses[addr]
+		_, err := fmt.Fprintf(w, "%s %12d %6.2f%% %s\n", vm.formatAddr(uint16(addr)), n, percent(n, p.total), isa.DisassembleWith(words(vm.memory), uint16(addr), vm.symbols))
+		if err != nil {
+			return err
+		}
+	}
+
+	functions := vm.functions()
+	if len(functions) > top {
+		functions = functions[:top]
+	}
+
+	_, err := fmt.Fprintf(w, "\nPer function (self excludes the functions called):\n%-20s %10s %12s %7s %12s %7s %12s\n", "function", "calls", "self", "", "inclusive", "", "per call")
+	if err != nil {
+		return err
+	}
+
+	for _, f := range functions {
+		_, err := fmt.Fprintf(w, "%-20s %10d %12d %6.2f%% %12d %6.2f%% %12.1f\n", vm.formatAddr(f.addr), f.calls, f.self, percent(f.self, p.total), f.inclusive, percent(f.inclusive, p.total), float64(f.inclusive)/float64(f.calls))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = fmt.Fprintf(w, "%-20s %10s %12d %6.2f%%\n", "(outside of calls)", "", p.topLevel, percent(p.topLevel, p.total))
+	return err
+}
+
+func percent(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Protection is what the program can do with a protected range of the memory, see Protect
+type Protection uint8
+
+// Protections of a range
+const (
+	ReadOnly    Protection = 1 + iota // WMEM is refused
+	ExecuteOnly                       // WMEM and RMEM are refused, the instructions are still executed
+)
+
+func (p Protection) String() string {
+	switch p {
+	case ReadOnly:
+		return "read-only"
+	case ExecuteOnly:
+		return "execute-only"
+	}
+	return fmt.Sprintf("Protection(%d)", int(p))
+}
+
+// ParseProtection parses the name of a protection: read-only (ro) or execute-only (xo)
+func ParseProtection(s string) (Protection, error) {
+	switch s {
+	case "ro", "read-only":
+		return ReadOnly, nil
+	case "xo", "execute-only":
+		return ExecuteOnly, nil
+	}
+	return 0, fmt.Errorf("unknown protection %q, should be ro (read-only) or xo (execute-only)", s)
+}
+
+// ProtectedRange is a range of addresses the program can't write (or read), End is excluded
+type ProtectedRange struct {
+	Start, End uint16
+	Protection Protection
+	Break      bool // Go to stepping mode before the refused access, left undone, instead of stopping with a Fault
+}
+
+func (r ProtectedRange) String() string {
+	s := fmt.Sprintf("%d-%d %s", r.Start, r.End, r.Protection)
+	if r.Break {
+		s += " break"
+	}
+	return s
+}
+
+// ParseProtectedRanges parses a comma separated list of ranges <start>-<end>[:ro|:xo][:break], read-only by default:
+// e.g. "0-6068:ro,6068-30050:xo:break"
+func ParseProtectedRanges(s string) ([]ProtectedRange, error) {
+	ranges := []ProtectedRange{}
+	for _, field := range strings.Split(s, ",") {
+		parts := strings.Split(strings.TrimSpace(field), ":")
+		bounds := strings.SplitN(parts[0], "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("range %q should be <start>-<end>", parts[0])
+		}
+		start, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("wrong start of %q: %s", parts[0], err)
+		}
+		end, err := strconv.ParseUint(bounds[1], 10, 16)
+		if err != nil || end <= start {
+			return nil, fmt.Errorf("wrong end of %q, it's excluded and must follow the start", parts[0])
+		}
+
+		r := ProtectedRange{Start: uint16(start), End: uint16(end), Protection: ReadOnly}
+		for _, option := range parts[1:] {
+			if option == "break" {
+				r.Break = true
+				continue
+			}
+			if r.Protection, err = ParseProtection(option); err != nil {
+				return nil, err
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// Protect refuses the writes (and the reads for ExecuteOnly) of the program to a range: e.g. to catch a patch
+// overwriting the code or to find where the binary decrypts itself. A refused access stops the execution with a Fault
+// wrapping ErrWriteProtected or ErrReadProtected (see SetTrapFaults), or goes to stepping mode with r.Break: the
+// instruction isn't executed and is tried again when the execution continues, refused again unless the state changed
+// (e.g. with $unprotect). The debugger can still change the memory. A range overlapping a previous one takes precedence over it.
+func (vm *VM) Protect(r ProtectedRange) {
+	if r.Start < r.End {
+		vm.protections = append(append([]ProtectedRange{}, vm.protections...), r)
+		vm.indexProtections()
+	}
+}
+
+// Unprotect removes the protection of the addresses from start to end (excluded), splitting the ranges covering them
+func (vm *VM) Unprotect(start, end uint16) {
+	ranges := []ProtectedRange{}
+	for _, r := range vm.protections {
+		if r.End <= start || r.Start >= end {
+			ranges = append(ranges, r)
+			continue
+		}
+		if r.Start < start {
+			left := r
+			left.End = start
+			ranges = append(ranges, left)
+		}
+		if r.End > end {
+			right := r
+			right.Start = end
+			ranges = append(ranges, right)
+		}
+	}
+	vm.protections = ranges
+	vm.indexProtections()
+}
+
+// Protections returns the protected ranges, in the order they were added
+func (vm *VM) Protections() []ProtectedRange {
+	return append([]ProtectedRange{}, vm.protections...)
+}
+
+// indexProtections builds the index of the range protecting each address, a new one since a clone shares the old one
+func (vm *VM) indexProtections() {
+	if len(vm.protections) == 0 {
+		vm.protected = nil
+		return
+	}
+
+	vm.protected = make([]uint16, vm.memory.Len())
+	for i, r := range vm.protections {
+		for addr := int(r.Start); addr < int(r.End) && addr < len(vm.protected); addr++ {
+			vm.protected[addr] = uint16(i + 1)
+		}
+	}
+}
+
+// checkProtection returns the Fault of an access of the program to addr refused by its protection, nil if it's
+// allowed. The Fault of a range with Break stops the execution at the stepping prompt.
+func (vm *VM) checkProtection(addr uint16, write bool) error {
+	if int(addr) >= len(vm.protected) || vm.protected[addr] == 0 {
+		return nil
+	}
+
+	r := vm.protections[vm.protected[addr]-1]
+	if !write && r.Protection != ExecuteOnly {
+		return nil
+	}
+
+	err, access := ErrReadProtected, "rmem reads"
+	if write {
+		err, access = ErrWriteProtected, "wmem writes to"
+	}
+	f := vm.fault(err, int(addr))
+	if r.Break {
+		vm.printDebug(fmt.Sprintf("\nProtected: (%6d) %s %d in %s\n", vm.cursor, access, addr, r))
+		f.Break = true
+	}
+	return f
+}
+
+// protectCommand handles $protect [<start> <end> [ro|xo] [break]] and $unprotect <start> <end>: protect a range,
+// list the protected ranges or remove a protection
+func (vm *VM) protectCommand(name string, args []string) {
+	if name == "protect" && len(args) == 0 {
+		lines := []string{}
+		for _, r := range vm.protections {
+			lines = append(lines, r.String())
+		}
+		vm.printDebug("Protected ranges:\n" + strings.Join(lines, "\n") + "\n")
+		return
+	}
+
+	usage := "Wrong command ! Should be $protect <start> <end> [ro|xo] [break]\n"
+	if name == "unprotect" {
+		usage = "Wrong command ! Should be $unprotect <start> <end>\n"
+	}
+	if len(args) < 2 || (name == "unprotect" && len(args) != 2) || len(args) > 4 {
+		vm.printError(usage)
+		return
+	}
+
+	start, err := vm.parseAddress(args[0])
+	if err != nil {
+		vm.printError("Wrong start address\n")
+		return
+	}
+	end, err := vm.parseAddress(args[1])
+	if err != nil || end <= start {
+		vm.printError("Wrong end address, it's excluded and must follow the start\n")
+		return
+	}
+
+	if name == "unprotect" {
+		vm.Unprotect(start, end)
+		vm.printDebug(fmt.Sprintf("Not protecting %d-%d anymore\n", start, end))
+		return
+	}
+
+	r := ProtectedRange{Start: start, End: end, Protection: ReadOnly}
+	for _, arg := range args[2:] {
+		if arg == "break" {
+			r.Break = true
+			continue
+		}
+		if r.Protection, err = ParseProtection(arg); err != nil {
+			vm.printError(usage)
+			return
+		}
+	}
+	vm.Protect(r)
+	vm.printDebug(fmt.Sprintf("Protecting %s\n", r))
+}
@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProtectBreakRefusesWrite(t *testing.T) {
+	memory := make([]uint16, 16)
+	copy(memory, []uint16{WMEM, 10, 42, HALT})
+	machine := New(memory, bytes.NewReader(nil), ioutil.Discard)
+	machine.SetDiagnostics(ioutil.Discard)
+	machine.Protect(ProtectedRange{Start: 8, End: 16, Protection: ReadOnly, Break: true})
+
+	// The prompt has no input to read
+	if reason, err := machine.Run(); reason != ExitInputEOF {
+		t.Fatalf("stopped with %s (%v) instead of at the prompt", reason, err)
+	}
+	if !machine.Stepping() {
+		t.Error("not stepping after the refused write")
+	}
+	if got := machine.Memory(10); got != 0 {
+		t.Errorf("memory[10] = %d after the refused write", got)
+	}
+	if got := machine.Cursor(); got != 0 {
+		t.Errorf("cursor %d instead of staying on the wmem", got)
+	}
+}
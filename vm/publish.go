@@ -0,0 +1,22 @@
+package vm
+
+import "sync/atomic"
+
+// publishInterval is the number of instructions runLimit executes between two updates of the counter given to
+// PublishInstructions, storing it atomically for each instruction would slow the dispatch loop down
+const publishInterval = 4096
+
+// PublishInstructions makes the VM store Instructions in counter, atomically, every publishInterval instructions, when
+// it waits for the input and when it stops running so that other goroutines can follow it with atomic.LoadUint64 (e.g.
+// the metrics of a server). nil stops publishing, a clone doesn't publish until it's given its own counter.
+func (vm *VM) PublishInstructions(counter *uint64) {
+	vm.published = counter
+	vm.publish()
+}
+
+// publish stores the number of executed instructions in the counter of PublishInstructions, if any
+func (vm *VM) publish() {
+	if vm.published != nil {
+		atomic.StoreUint64(vm.published, vm.instructions)
+	}
+}
@@ -0,0 +1,107 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a byte consumed by the IN operation (or by the debugger) or emitted by the OUT operation
+type Event struct {
+	Time  time.Time // When the byte went through the VM
+	Input bool      // Whether the byte was read or written
+	Byte  byte      // The byte itself
+}
+
+// Transcript is a list of events recorded by a VM
+type Transcript []Event
+
+// SetRecorder records every byte read and written by the VM to w, one line per byte: "<RFC3339 time> <in|out> <byte> <quoted byte>"
+func (vm *VM) SetRecorder(w io.Writer) {
+	vm.recorder = w
+}
+
+// record writes an event to the recorder, if any
+func (vm *VM) record(input bool, b byte) {
+	if vm.recorder == nil {
+		return
+	}
+
+	dir := "out"
+	if input {
+		dir = "in"
+	}
+
+	_, err := fmt.Fprintf(vm.recorder, "%s %s %d %q\n", time.Now().Format(time.RFC3339Nano), dir, b, b)
+	if err != nil {
+		log.Fatalf("Could not record transcript: %s", err)
+	}
+}
+
+// readLine reads a line for the debugger, recording it
+func (vm *VM) readLine() (string, error) {
+	line, _, err := vm.in.ReadLine()
+	if err != nil {
+		return "", err
+	}
+
+	for _, b := range append(line, '\n') {
+		vm.record(true, b)
+		for _, fn := range vm.hooks.read {
+			fn(vm, b)
+		}
+	}
+
+	return string(line), nil
+}
+
+// ParseTranscript reads a transcript written by a recorder
+func ParseTranscript(r io.Reader) (Transcript, error) {
+	t := Transcript{}
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) < 3 || (fields[1] != "in" && fields[1] != "out") {
+			return nil, fmt.Errorf("line %d: invalid transcript event %q", n, scanner.Text())
+		}
+
+		date, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		b, err := strconv.ParseUint(fields[2], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+
+		t = append(t, Event{Time: date, Input: fields[1] == "in", Byte: byte(b)})
+	}
+
+	return t, scanner.Err()
+}
+
+// Input returns every byte that has been read, feeding it to a new VM replays the session
+func (t Transcript) Input() []byte {
+	return t.bytes(true)
+}
+
+// Output returns every byte that has been written
+func (t Transcript) Output() []byte {
+	return t.bytes(false)
+}
+
+func (t Transcript) bytes(input bool) []byte {
+	res := []byte{}
+	for _, e := range t {
+		if e.Input == input {
+			res = append(res, e.Byte)
+		}
+	}
+	return res
+}
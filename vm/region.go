@@ -0,0 +1,91 @@
+package vm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// DumpRegion returns length words of memory from addr (up to the end of the memory) as 16-bits little-endian pairs,
+// the format of the binary: e.g. to give the code decrypted at runtime to other tools
+func (vm *VM) DumpRegion(addr uint16, length int) []byte {
+	if int(addr) >= vm.memory.Len() {
+		return nil
+	}
+
+	region := wordsAt(vm.memory, int(addr), length)
+	b := make([]byte, 2*len(region))
+	for i, v := range region {
+		b[2*i], b[2*i+1] = byte(v), byte(v>>8)
+	}
+	return b
+}
+
+// LoadRegion writes the 16-bits little-endian pairs of b to the memory from addr, it returns the number of words
+// written. It fails if b has an odd length or doesn't fit in the memory.
+func (vm *VM) LoadRegion(addr uint16, b []byte) (int, error) {
+	if len(b)%2 != 0 {
+		return 0, fmt.Errorf("odd length %d, values are 16-bits pairs", len(b))
+	}
+	n := len(b) / 2
+	if int(addr)+n > vm.memory.Len() {
+		return 0, fmt.Errorf("%d values from %d don't fit in the %d addresses of the memory", n, addr, vm.memory.Len())
+	}
+
+	for i := 0; i < n; i++ {
+		vm.SetMemory(addr+uint16(i), uint16(b[2*i])|uint16(b[2*i+1])<<8)
+	}
+	return n, nil
+}
+
+// dumpBin handles $dumpbin <addr> <len> <file>
+func (vm *VM) dumpBin(args []string) {
+	if len(args) != 3 {
+		vm.printError("Wrong command ! Should be $dumpbin <addr> <len> <file>\n")
+		return
+	}
+
+	addr, err := vm.parseAddress(args[0])
+	if err != nil || int(addr) >= vm.memory.Len() {
+		vm.printError("Wrong address\n")
+		return
+	}
+	length, err := strconv.ParseUint(args[1], 10, 16)
+	if err != nil {
+		vm.printError("Wrong length\n")
+		return
+	}
+
+	b := vm.DumpRegion(addr, int(length))
+	if err := ioutil.WriteFile(args[2], b, 0644); err != nil {
+		vm.printError(fmt.Sprintf("Could not write the region: %s\n", err))
+		return
+	}
+	vm.printDebug(fmt.Sprintf("%d values from %d written to %s\n", len(b)/2, addr, args[2]))
+}
+
+// loadBin handles $loadbin <addr> <file>
+func (vm *VM) loadBin(args []string) {
+	if len(args) != 2 {
+		vm.printError("Wrong command ! Should be $loadbin <addr> <file>\n")
+		return
+	}
+
+	addr, err := vm.parseAddress(args[0])
+	if err != nil || int(addr) >= vm.memory.Len() {
+		vm.printError("Wrong address\n")
+		return
+	}
+
+	b, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		vm.printError(fmt.Sprintf("Could not read the region: %s\n", err))
+		return
+	}
+	n, err := vm.LoadRegion(addr, b)
+	if err != nil {
+		vm.printError(fmt.Sprintf("Could not load the region: %s\n", err))
+		return
+	}
+	vm.printDebug(fmt.Sprintf("%d values loaded from %s at %d\n", n, args[1], addr))
+}
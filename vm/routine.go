@@ -0,0 +1,24 @@
+package vm
+
+// ReplaceRoutine replaces the routine starting at addr by a native function. When the cursor reaches addr (usually
+// through a CALL) fn is called with the registers, the values it returns are written back to the first registers and
+// the VM returns to the caller as if the routine executed RET.
+func (vm *VM) ReplaceRoutine(addr uint16, fn func(args []uint16) []uint16) {
+	vm.AddPatch(addr, func(vm *VM) {
+		// Without a return address run the original code, RET will halt
+		if len(vm.stack) == 0 {
+			return
+		}
+
+		args := vm.register
+		for i, v := range fn(args[:]) {
+			if i < len(vm.register) {
+				vm.register[i] = v % M
+			}
+		}
+
+		ret, _ := vm.pop()
+		vm.leaveCall(ret)
+		vm.cursor = ret
+	})
+}
@@ -0,0 +1,97 @@
+package vm
+
+import (
+	"regexp"
+	"unicode"
+)
+
+// codeRegex matches the 12 characters codes printed at the milestones of the challenge
+var codeRegex = regexp.MustCompile(`\b[A-Za-z0-9]{12}\b`)
+
+// OutputScanner collects the matches of a regular expression in the OUT stream, line by line
+type OutputScanner struct {
+	re      *regexp.Regexp
+	filter  func(match string) bool // Keeps only the matches for which it returns true, nil keeps everything
+	line    []byte                  // Current line
+	matches []string                // Matches found so far
+}
+
+// NewOutputScanner returns a scanner collecting the matches of re, add it to a VM with AddOutputScanner
+func NewOutputScanner(re *regexp.Regexp) *OutputScanner {
+	return &OutputScanner{re: re}
+}
+
+// newCodeScanner returns a scanner collecting the challenge codes, see isCode
+func newCodeScanner() *OutputScanner {
+	s := NewOutputScanner(codeRegex)
+	s.filter = isCode
+	return s
+}
+
+// isCode tells whether a match of codeRegex is a code. A 12 letters word like "Headquarters" isn't a code: codes have a
+// digit or an uppercase letter after their first character.
+func isCode(match string) bool {
+	for _, r := range match[1:] {
+		if unicode.IsUpper(r) || unicode.IsDigit(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindCodes returns the start and end indexes of the challenge codes in text, like the ones collected by Codes
+func FindCodes(text string) [][]int {
+	codes := [][]int{}
+	for _, loc := range codeRegex.FindAllStringIndex(text, -1) {
+		if isCode(text[loc[0]:loc[1]]) {
+			codes = append(codes, loc)
+		}
+	}
+	return codes
+}
+
+// feed adds a byte of output to the scanner
+func (s *OutputScanner) feed(b byte) {
+	if b != '\n' {
+		s.line = append(s.line, b)
+		return
+	}
+
+	for _, match := range s.re.FindAllString(string(s.line), -1) {
+		if s.filter == nil || s.filter(match) {
+			s.matches = append(s.matches, match)
+		}
+	}
+	s.line = s.line[:0]
+}
+
+// Matches returns the matches found so far, in order of appearance
+func (s *OutputScanner) Matches() []string {
+	return append([]string{}, s.matches...)
+}
+
+// clone returns an independent copy of the scanner
+func (s *OutputScanner) clone() *OutputScanner {
+	c := *s
+	c.line = append([]byte{}, s.line...)
+	c.matches = append([]string{}, s.matches...)
+	return &c
+}
+
+// AddOutputScanner makes the VM feed every byte written by OUT to the scanner
+func (vm *VM) AddOutputScanner(s *OutputScanner) {
+	vm.scanners = append(vm.scanners, s)
+}
+
+// Codes returns the challenge codes printed so far
+func (vm *VM) Codes() []string {
+	return vm.codes.Matches()
+}
+
+// scanOutput feeds a byte written by OUT to the scanners
+func (vm *VM) scanOutput(b byte) {
+	vm.codes.feed(b)
+	for _, s := range vm.scanners {
+		s.feed(b)
+	}
+}
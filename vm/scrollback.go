@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// scrollbackLines is the maximum number of lines of output kept for $scroll, $/ and $export, the oldest half is
+// dropped beyond it
+const scrollbackLines = 100000
+
+// scrollPage is the number of lines printed by $scroll without a count
+const scrollPage = 20
+
+// OutputLine is a line written by OUT, numbered from 1 since the start of the game
+type OutputLine struct {
+	Number int
+	Text   string
+}
+
+// scrollback keeps the lines written by OUT, unlike outputRing which only keeps the last bytes
+type scrollback struct {
+	lines   []string // Complete lines
+	dropped int      // Lines dropped before the first one kept
+	partial []byte   // Line being written
+}
+
+func (s *scrollback) write(c byte) {
+	if c != '\n' {
+		s.partial = append(s.partial, c)
+		return
+	}
+
+	s.lines = append(s.lines, string(s.partial))
+	s.partial = s.partial[:0]
+	if len(s.lines) > scrollbackLines {
+		// Drop half of them at once rather than copying the lines for each new one
+		drop := len(s.lines) - scrollbackLines/2
+		s.lines = append([]string{}, s.lines[drop:]...)
+		s.dropped += drop
+	}
+}
+
+// clone returns a copy of the scrollback that can be written independently
+func (s *scrollback) clone() *scrollback {
+	return &scrollback{
+		lines:   append([]string{}, s.lines...),
+		dropped: s.dropped,
+		partial: append([]byte{}, s.partial...),
+	}
+}
+
+// Scrollback returns the lines of output kept (at least the last 50000), the line being written included
+func (vm *VM) Scrollback() []OutputLine {
+	s := vm.scroll
+	lines := make([]OutputLine, 0, len(s.lines)+1)
+	for i, text := range s.lines {
+		lines = append(lines, OutputLine{Number: s.dropped + i + 1, Text: text})
+	}
+	if len(s.partial) > 0 {
+		lines = append(lines, OutputLine{Number: s.dropped + len(s.lines) + 1, Text: string(s.partial)})
+	}
+	return lines
+}
+
+// SearchScrollback returns the lines of the scrollback matching a regular expression, ignoring the case: e.g. "grue"
+// to find every warning about them
+func (vm *VM) SearchScrollback(pattern string) ([]OutputLine, error) {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []OutputLine{}
+	for _, l := range vm.Scrollback() {
+		if re.MatchString(l.Text) {
+			matches = append(matches, l)
+		}
+	}
+	return matches, nil
+}
+
+// ExportScrollback writes the lines of the scrollback to a file, e.g. to keep a transcript of the game
+func (vm *VM) ExportScrollback(path string) error {
+	b := &strings.Builder{}
+	for _, l := range vm.Scrollback() {
+		b.WriteString(l.Text + "\n")
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// scrollCommand handles $scroll [<n> [<from>]], $/<regexp> and $export <path>: print the last n lines of output (or
+// n lines from the line from), search them or write them to a file
+func (vm *VM) scrollCommand(name string, args []string) {
+	switch {
+	case strings.HasPrefix(name, "/"):
+		pattern := strings.TrimPrefix(strings.Join(append([]string{name}, args...), " "), "/")
+		matches, err := vm.SearchScrollback(pattern)
+		if err != nil {
+			vm.printError(fmt.Sprintf("Wrong pattern: %s\n", err))
+			return
+		}
+		if len(matches) == 0 {
+			vm.printDebug(fmt.Sprintf("No line of output matches %s\n", pattern))
+			return
+		}
+		vm.printDebug(formatOutputLines(matches))
+
+	case name == "export":
+		if len(args) != 1 {
+			vm.printError("Wrong command ! Should be $export <path>\n")
+			return
+		}
+		if err := vm.ExportScrollback(args[0]); err != nil {
+			vm.printError(fmt.Sprintf("Could not export the output: %s\n", err))
+			return
+		}
+		vm.printDebug(fmt.Sprintf("Output written to %s\n", args[0]))
+
+	default:
+		if len(args) > 2 {
+			vm.printError("Wrong command ! Should be $scroll [<lines> [<from>]]\n")
+			return
+		}
+
+		n := scrollPage
+		if len(args) > 0 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v <= 0 {
+				vm.printError("Wrong number of lines\n")
+				return
+			}
+			n = v
+		}
+
+		lines := vm.Scrollback()
+		start := len(lines) - n
+		if len(args) == 2 {
+			from, err := strconv.Atoi(args[1])
+			if err != nil || from <= 0 {
+				vm.printError("Wrong line\n")
+				return
+			}
+			start = from - 1 - vm.scroll.dropped
+		}
+		if start < 0 {
+			start = 0
+		}
+		if start >= len(lines) {
+			vm.printError("No output there\n")
+			return
+		}
+		if start+n < len(lines) {
+			lines = lines[:start+n]
+		}
+		vm.printDebug(formatOutputLines(lines[start:]))
+	}
+}
+
+// formatOutputLines formats lines of output preceded by their numbers
+func formatOutputLines(lines []OutputLine) string {
+	b := &strings.Builder{}
+	for _, l := range lines {
+		fmt.Fprintf(b, "%6d | %s\n", l.Number, l.Text)
+	}
+	return b.String()
+}
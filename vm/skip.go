@@ -0,0 +1,20 @@
+package vm
+
+import "regexp"
+
+// SkipOutputUntil fast-forwards the execution until the output written from now on matches re, e.g. to skip the
+// self-test and the welcome banner until the first prompt: meanwhile OUT writes nothing (the output is still recorded,
+// scanned for codes and given to the hooks) and the pacing of SetSpeed and SetTypewriter is ignored. nil stops the
+// skipping.
+func (vm *VM) SkipOutputUntil(re *regexp.Regexp) {
+	if re == nil {
+		vm.skipping = nil
+		return
+	}
+	vm.skipping = vm.outputMatcher(re)
+}
+
+// Skipping tells whether the output is skipped, see SkipOutputUntil
+func (vm *VM) Skipping() bool {
+	return vm.skipping != nil
+}
@@ -0,0 +1,203 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultStateDir is where the slots are kept unless SetStateDir is called
+const defaultStateDir = "states"
+
+// roomTitleRegex matches the title of a room in the output, e.g. "== Foothills =="
+var roomTitleRegex = regexp.MustCompile(`(?m)^== (.+) ==$`)
+
+// slotFileRegex matches the metadata files of the slots
+var slotFileRegex = regexp.MustCompile(`^slot(\d+)\.json$`)
+
+// SlotInfo describes a quicksave, it is stored next to its snapshot
+type SlotInfo struct {
+	Slot         int
+	Time         time.Time
+	Room         string // Title of the last room described before saving
+	Description  string // First paragraph of its description
+	Instructions uint64 // Instructions executed since the start of the game
+}
+
+// SetStateDir sets the directory where the slots of $qs, $ql and $slots are kept
+func (vm *VM) SetStateDir(dir string) {
+	vm.stateDir = dir
+}
+
+// Instructions returns the number of instructions executed since the start of the game
+func (vm *VM) Instructions() uint64 {
+	return vm.instructions
+}
+
+// slotPaths returns the paths of the snapshot and the metadata of a slot
+func (vm *VM) slotPaths(slot int) (string, string) {
+	dir := vm.stateDir
+	if dir == "" {
+		dir = defaultStateDir
+	}
+	base := filepath.Join(dir, fmt.Sprintf("slot%d", slot))
+	return base + ".snapshot", base + ".json"
+}
+
+// QuickSave saves the state of the VM in a numbered slot with its metadata
+func (vm *VM) QuickSave(slot int) (*SlotInfo, error) {
+	snapshot, meta := vm.slotPaths(slot)
+	if err := os.MkdirAll(filepath.Dir(snapshot), 0755); err != nil {
+		return nil, err
+	}
+
+	if err := vm.Snapshot().Save(snapshot); err != nil {
+		return nil, err
+	}
+
+	info := &SlotInfo{Slot: slot, Time: time.Now(), Instructions: vm.instructions}
+	info.Room, info.Description = lastRoom(vm.LastOutput(outputHistory))
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return info, ioutil.WriteFile(meta, data, 0644)
+}
+
+// QuickLoad restores the state saved in a slot
+func (vm *VM) QuickLoad(slot int) (*SlotInfo, error) {
+	snapshot, meta := vm.slotPaths(slot)
+
+	info, err := readSlotInfo(meta)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := LoadSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.Restore(s)
+	vm.instructions = info.Instructions
+	return info, nil
+}
+
+// Slots returns the metadata of the saved slots ordered by slot number
+func (vm *VM) Slots() ([]*SlotInfo, error) {
+	_, meta := vm.slotPaths(0)
+	entries, err := ioutil.ReadDir(filepath.Dir(meta))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	slots := []*SlotInfo{}
+	for _, e := range entries {
+		if !slotFileRegex.MatchString(e.Name()) {
+			continue
+		}
+		info, err := readSlotInfo(filepath.Join(filepath.Dir(meta), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, info)
+	}
+
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Slot < slots[j].Slot })
+	return slots, nil
+}
+
+func readSlotInfo(path string) (*SlotInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SlotInfo{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return info, nil
+}
+
+// lastRoom returns the title and the first paragraph of the description of the last room in output
+func lastRoom(output string) (string, string) {
+	titles := roomTitleRegex.FindAllStringSubmatchIndex(output, -1)
+	if len(titles) == 0 {
+		return "", ""
+	}
+
+	last := titles[len(titles)-1]
+	description := strings.TrimSpace(output[last[1]:])
+	if i := strings.Index(description, "\n\n"); i != -1 {
+		description = description[:i]
+	}
+	return output[last[2]:last[3]], description
+}
+
+// quickSlots handles $qs <n>, $ql <n> and $slots
+func (vm *VM) quickSlots(name string, args []string) {
+	if name == "slots" {
+		slots, err := vm.Slots()
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not list the slots: %s\n", err))
+			return
+		}
+
+		lines := []string{}
+		for _, info := range slots {
+			lines = append(lines, formatSlot(info))
+		}
+		vm.printDebug("Slots:\n" + strings.Join(lines, "\n") + "\n")
+		return
+	}
+
+	if len(args) != 1 {
+		vm.printError("Wrong command ! Should be $" + name + " <slot>\n")
+		return
+	}
+
+	slot, err := strconv.Atoi(args[0])
+	if err != nil || slot < 0 {
+		vm.printError("Wrong slot\n")
+		return
+	}
+
+	if name == "qs" {
+		info, err := vm.QuickSave(slot)
+		if err != nil {
+			vm.printError(fmt.Sprintf("Could not save slot %d: %s\n", slot, err))
+			return
+		}
+		vm.printDebug("Saved " + formatSlot(info) + "\n")
+		return
+	}
+
+	info, err := vm.QuickLoad(slot)
+	if err != nil {
+		vm.printError(fmt.Sprintf("Could not load slot %d: %s\n", slot, err))
+		return
+	}
+	vm.printDebug("Loaded " + formatSlot(info) + "\n")
+}
+
+func formatSlot(info *SlotInfo) string {
+	description := info.Description
+	if i := strings.Index(description, "\n"); i != -1 {
+		description = description[:i]
+	}
+	if len(description) > 60 {
+		description = description[:57] + "..."
+	}
+	return fmt.Sprintf("%3d  %s  %10d instructions  %-20s %s", info.Slot, info.Time.Format("2006-01-02 15:04:05"), info.Instructions, info.Room, description)
+}
@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+)
+
+// snapshotVersion is the version of the Snapshot format, bump it when the Snapshot type changes
+const snapshotVersion = 1
+
+// Snapshot is a copy of the full state of a VM
+type Snapshot struct {
+	Version  int            // Version of the format
+	Register [8]uint16      // Registers values
+	Stack    []uint16       // Stack content
+	Memory   []uint16       // Memory content, nil when pages holds it: see Words
+	Cursor   uint16         // Position in the memory
+	Calls    []Frame        // Shadow call stack, see CallStack (nil in the snapshots saved before it was kept)
+	HookHits map[uint16]int // Times the address hooks were reached, see HookHits (nil in the older snapshots too)
+
+	pages *COWMemory // Pages shared with the VM when its memory is copy-on-write
+}
+
+// Snapshot returns a copy of the current state of the VM. The snapshot of a copy-on-write memory (see
+// SetCopyOnWrite) shares its pages instead of copying them.
+func (vm *VM) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:  snapshotVersion,
+		Register: vm.register,
+		Stack:    append([]uint16{}, vm.stack...),
+		Cursor:   vm.cursor,
+		Calls:    vm.CallStack(),
+		HookHits: map[uint16]int{},
+	}
+	for addr, n := range vm.hookHits {
+		s.HookHits[addr] = n
+	}
+	if cow, ok := vm.memory.(*COWMemory); ok {
+		s.pages = cow.Copy()
+	} else {
+		s.Memory = append([]uint16{}, words(vm.memory)...)
+	}
+	return s
+}
+
+// Words returns the content of the memory of the snapshot, it must not be modified
+func (s *Snapshot) Words() []uint16 {
+	if s.Memory == nil && s.pages != nil {
+		return words(s.pages)
+	}
+	return s.Memory
+}
+
+// MemoryLen returns the number of words of the memory of the snapshot, without copying shared pages like Words
+func (s *Snapshot) MemoryLen() int {
+	if s.Memory == nil && s.pages != nil {
+		return s.pages.Len()
+	}
+	return len(s.Memory)
+}
+
+// flat returns the snapshot with its Memory filled, to be encoded
+func (s *Snapshot) flat() *Snapshot {
+	if s.Memory != nil || s.pages == nil {
+		return s
+	}
+	flat := *s
+	flat.Memory, flat.pages = s.Words(), nil
+	return &flat
+}
+
+// Restore replaces the state of the VM by the given snapshot
+func (vm *VM) Restore(s *Snapshot) {
+	vm.register = s.Register
+	vm.stack = append([]uint16{}, s.Stack...)
+	if _, ok := vm.memory.(*COWMemory); ok && s.pages != nil {
+		vm.setMemory(s.pages.Copy())
+	} else {
+		vm.setMemory(memoryLike(vm.memory, s.Words()))
+	}
+	vm.cursor = s.Cursor
+	vm.calls = append([]Frame{}, s.Calls...)
+	vm.hookHits = map[uint16]int{}
+	for addr, n := range s.HookHits {
+		vm.hookHits[addr] = n
+	}
+	vm.resetLoops()
+	vm.pendingMemos = nil
+	if vm.decoded != nil {
+		vm.EnableDecodeCache()
+	}
+
+	// The history leads to the previous state
+	if vm.history != nil {
+		vm.EnableHistory(len(vm.history.deltas))
+	}
+}
+
+// Encode writes the snapshot to w
+func (s *Snapshot) Encode(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(s.flat())
+}
+
+// DecodeSnapshot reads a snapshot written by Encode
+func DecodeSnapshot(r io.Reader) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := gob.NewDecoder(r).Decode(s); err != nil {
+		return nil, err
+	}
+
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d (expected %d)", s.Version, snapshotVersion)
+	}
+
+	return s, nil
+}
+
+// Save writes the snapshot to the given file
+func (s *Snapshot) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := s.Encode(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// LoadSnapshot reads a snapshot from the given file
+func LoadSnapshot(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodeSnapshot(f)
+}
@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestRestoreCallStack(t *testing.T) {
+	// call 4, halt, then the function at 4 calls 7, which loops
+	machine := New([]uint16{CALL, 4, HALT, NOOP, CALL, 7, RET, JMP, 7}, bytes.NewReader(nil), ioutil.Discard)
+	before := machine.Snapshot()
+	machine.RunFor(3)
+	if depth := machine.CallDepth(); depth != 2 {
+		t.Fatalf("CallDepth() = %d before restoring instead of 2", depth)
+	}
+	inside := machine.Snapshot()
+
+	machine.Restore(before)
+	if depth := machine.CallDepth(); depth != 0 {
+		t.Errorf("CallDepth() = %d after restoring a snapshot taken outside of any call", depth)
+	}
+	if bt := machine.formatBacktrace(); strings.Count(bt, "\n") != 0 {
+		t.Errorf("backtrace outside of any call:\n%s", bt)
+	}
+
+	b := &bytes.Buffer{}
+	if err := inside.Encode(b); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeSnapshot(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine.Restore(decoded)
+	if depth := machine.CallDepth(); depth != 2 {
+		t.Errorf("CallDepth() = %d after restoring a snapshot taken in 2 calls", depth)
+	}
+}
+
+func TestBacktraceSkipsPoppedFrames(t *testing.T) {
+	// The function pops its return address and jumps back instead of returning
+	machine := New([]uint16{CALL, 3, HALT, POP, M, JMP, 3}, bytes.NewReader(nil), ioutil.Discard)
+	machine.RunFor(2)
+	if depth := machine.CallDepth(); depth != 1 {
+		t.Fatalf("CallDepth() = %d instead of 1", depth)
+	}
+	if bt := machine.formatBacktrace(); strings.Contains(bt, "#1") {
+		t.Errorf("backtrace shows the popped call:\n%s", bt)
+	}
+}
+
+func TestRestoreHookHits(t *testing.T) {
+	// A loop going through the hooked address 0 every two steps
+	machine := New([]uint16{NOOP, JMP, 0}, bytes.NewReader(nil), ioutil.Discard)
+	machine.HookAddress(0, func(*VM) HookResult { return HookFallThrough })
+	machine.RunFor(5)
+
+	b := &bytes.Buffer{}
+	if err := machine.Snapshot().Encode(b); err != nil {
+		t.Fatal(err)
+	}
+	s, err := DecodeSnapshot(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Like a game resumed with the same hooks
+	resumed := New([]uint16{NOOP, JMP, 0}, bytes.NewReader(nil), ioutil.Discard)
+	resumed.HookAddress(0, func(*VM) HookResult { return HookFallThrough })
+	resumed.Restore(s)
+	if got := resumed.HookHits(0); got != 3 {
+		t.Errorf("%d hits after restoring instead of 3", got)
+	}
+}
@@ -0,0 +1,77 @@
+package vm
+
+import "time"
+
+// maxLag is how late a paced VM can be before it stops catching up (e.g. after waiting for the input)
+const maxLag = 100 * time.Millisecond
+
+// speed paces the execution, see SetSpeed and SetTypewriter
+type speed struct {
+	perSecond int           // Instructions per second, 0 means unlimited
+	charDelay time.Duration // Pause after each character written by OUT, 0 means none
+	turbo     bool          // Ignore the pacing, toggled by $turbo
+
+	start    time.Time // Start of the current pacing window
+	executed int       // Instructions executed since start
+}
+
+// SetSpeed limits the execution to n instructions per second, 0 removes the limit
+func (vm *VM) SetSpeed(n int) {
+	vm.speed.perSecond = n
+	vm.speed.executed = 0
+}
+
+// SetTypewriter pauses for delay after each character written by OUT, 0 removes the pause
+func (vm *VM) SetTypewriter(delay time.Duration) {
+	vm.speed.charDelay = delay
+}
+
+// pace sleeps as long as the VM is ahead of its instructions per second
+func (s *speed) pace() {
+	if s.perSecond <= 0 || s.turbo {
+		return
+	}
+
+	now := time.Now()
+	if s.executed == 0 {
+		s.start = now
+	}
+	s.executed++
+
+	ahead := time.Duration(s.executed)*time.Second/time.Duration(s.perSecond) - now.Sub(s.start)
+	switch {
+	case ahead < -maxLag:
+		// Late since the VM waited for something, start a new window instead of running at full speed to catch up
+		s.executed = 0
+	case ahead > time.Millisecond:
+		time.Sleep(ahead)
+	}
+}
+
+// typewrite pauses after a character was written
+func (s *speed) typewrite() {
+	if s.charDelay > 0 && !s.turbo {
+		time.Sleep(s.charDelay)
+	}
+}
+
+// toggleTurbo handles $turbo [on|off], without argument it toggles the turbo
+func (vm *VM) toggleTurbo(args []string) {
+	switch {
+	case len(args) == 0:
+		vm.speed.turbo = !vm.speed.turbo
+	case len(args) == 1 && (args[0] == "on" || args[0] == "off"):
+		vm.speed.turbo = args[0] == "on"
+	default:
+		vm.printError("Wrong command ! Should be $turbo [on|off]\n")
+		return
+	}
+
+	// Don't catch up with the time spent in turbo
+	vm.speed.executed = 0
+	if vm.speed.turbo {
+		vm.printDebug("Turbo on\n")
+	} else {
+		vm.printDebug("Turbo off\n")
+	}
+}
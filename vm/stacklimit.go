@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultStackLimit is the stack limit of a new VM, far deeper than the challenge ever goes (its deepest legitimate
+// recursion is the teleporter confirmation) but small enough that runaway recursion fails before it exhausts the host
+// memory: each value on the stack also costs a frame of the shadow call stack.
+const DefaultStackLimit = 1 << 20
+
+// chainGroups is the number of functions shown in the call chain of a stack overflow
+const chainGroups = 8
+
+// SetStackLimit bounds the number of values on the stack, PUSH and CALL fail with ErrStackOverflow once it's reached.
+// 0 means no limit.
+func (vm *VM) SetStackLimit(n int) {
+	vm.stackLimit = n
+}
+
+// checkStack fails if another value can't be pushed, the error tells the chain of calls leading to the cursor
+func (vm *VM) checkStack() error {
+	if vm.stackLimit <= 0 || len(vm.stack) < vm.stackLimit {
+		return nil
+	}
+
+	return fmt.Errorf("%w at cursor %d (%d values), call chain: %s", ErrStackOverflow, vm.cursor, len(vm.stack), vm.formatCallChain())
+}
+
+// formatCallChain returns the functions called and not returned yet, innermost first, with the consecutive calls of
+// the same function (a recursion) counted once
+func (vm *VM) formatCallChain() string {
+	if len(vm.calls) == 0 {
+		return "no calls"
+	}
+
+	groups := []string{}
+	for i := len(vm.calls) - 1; i >= 0; {
+		target, n := vm.calls[i].Target, 0
+		for ; i >= 0 && vm.calls[i].Target == target; i-- {
+			n++
+		}
+		if len(groups) == chainGroups {
+			groups = append(groups, fmt.Sprintf("... (%d more calls)", i+1+n))
+			break
+		}
+
+		group := vm.symbols.Format(target)
+		if n > 1 {
+			group += fmt.Sprintf(" (x%d)", n)
+		}
+		groups = append(groups, group)
+	}
+	return strings.Join(groups, " <- ")
+}
@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// State tells what the VM is doing, see VM.State
+type State int32
+
+// States of the VM
+const (
+	StateHalted         State = iota // Not running: Run wasn't called yet or it returned
+	StateRunning                     // Executing instructions
+	StateWaitingInput                // The program (IN) waits for the next bytes of the input, e.g. the next command
+	StateWaitingCommand              // The debugger waits for a command at the stepping prompt
+)
+
+func (s State) String() string {
+	switch s {
+	case StateHalted:
+		return "halted"
+	case StateRunning:
+		return "running"
+	case StateWaitingInput:
+		return "waiting for input"
+	case StateWaitingCommand:
+		return "waiting for a command"
+	}
+	return "unknown"
+}
+
+// State returns what the VM is doing, it can be called from any goroutine: e.g. for a frontend to show that the game
+// waits for the player. The VM only waits when the bytes already read from the input are consumed.
+func (vm *VM) State() State {
+	return State(atomic.LoadInt32(&vm.state))
+}
+
+// OnStateChange registers fn to be called with the new state each time it changes, in the goroutine running the VM
+func (vm *VM) OnStateChange(fn func(vm *VM, s State)) {
+	vm.hooks.state = append(vm.hooks.state, fn)
+}
+
+// setState changes the state and calls the hooks if it is a new one
+func (vm *VM) setState(s State) {
+	if State(atomic.SwapInt32(&vm.state, int32(s))) == s {
+		return
+	}
+	for _, fn := range vm.hooks.state {
+		fn(vm, s)
+	}
+}
+
+// stateReader is the reader under the buffer of the input, it's only read when the buffer is empty: the VM waits
+// while it reads
+type stateReader struct {
+	vm *VM
+	r  io.Reader
+}
+
+func (r *stateReader) Read(b []byte) (int, error) {
+	waiting := StateWaitingInput
+	if r.vm.prompting {
+		waiting = StateWaitingCommand
+	}
+
+	previous := r.vm.State()
+	r.vm.setState(waiting)
+	unpark := r.vm.exclusive.park(r.vm)
+	n, err := r.r.Read(b)
+	unpark()
+	r.vm.setState(previous)
+	return n, err
+}
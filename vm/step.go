@@ -0,0 +1,41 @@
+package vm
+
+import "fmt"
+
+// stepOver executes the instruction at the cursor, if it's a CALL the execution goes on until the call returns
+func (vm *VM) stepOver() {
+	if vm.memory.Read(vm.cursor) != CALL {
+		return
+	}
+
+	ret, depth := vm.cursor+2, len(vm.stack)
+	vm.runUntil(func(vm *VM, op uint16) bool {
+		return vm.cursor == ret && len(vm.stack) == depth
+	})
+}
+
+// stepOut runs until the current function returns: the first RET leaving the shadow call stack shallower than it is
+// now, whatever the function pushes or pops (the first RET outside of any call)
+func (vm *VM) stepOut() {
+	depth := len(vm.calls)
+	vm.runUntil(func(vm *VM, op uint16) bool {
+		return op == RET && (depth == 0 || len(vm.calls) < depth)
+	})
+}
+
+// runUntil leaves the stepping mode until stop returns true, stop is called after each instruction with its opcode
+func (vm *VM) runUntil(stop func(vm *VM, op uint16) bool) {
+	vm.stepping = false
+	vm.until = stop
+}
+
+// checkUntil goes back to stepping mode if the condition set by runUntil is met
+func (vm *VM) checkUntil(op uint16) {
+	if vm.until == nil || !vm.until(vm, op) {
+		return
+	}
+
+	vm.until = nil
+	vm.stepping = true
+	vm.printDebug(fmt.Sprintf("%s %s\n", vm.formatAddr(vm.cursor), vm.formatInstruction()))
+}
@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestStepOutIgnoresData(t *testing.T) {
+	// The function at 4 returns 7 on the stack, under its return address
+	machine := New([]uint16{CALL, 4, HALT, NOOP, POP, M + 1, PUSH, 7, PUSH, M + 1, RET}, bytes.NewReader(nil), ioutil.Discard)
+	machine.SetDiagnostics(ioutil.Discard)
+	if err := machine.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	machine.stepOut()
+	// The prompt has no input to read
+	if reason, err := machine.Run(); reason != ExitInputEOF {
+		t.Fatalf("stopped with %s (%v) instead of at the prompt", reason, err)
+	}
+	if got := machine.Cursor(); got != 2 {
+		t.Errorf("stopped at %d instead of the return address 2", got)
+	}
+}
+
+func TestStepOutOfNestedCall(t *testing.T) {
+	// The function at 4 pushes 5 then calls the one at 10, which pops its return address and 5 before returning
+	machine := New([]uint16{
+		CALL, 4, HALT, NOOP,
+		PUSH, 5, CALL, 10, RET, NOOP,
+		POP, M + 1, POP, M + 2, PUSH, M + 1, RET,
+	}, bytes.NewReader(nil), ioutil.Discard)
+	machine.SetDiagnostics(ioutil.Discard)
+	for i := 0; i < 2; i++ {
+		if err := machine.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	machine.stepOut()
+	if reason, err := machine.Run(); reason != ExitInputEOF {
+		t.Fatalf("stopped with %s (%v) instead of at the prompt", reason, err)
+	}
+	if got := machine.Cursor(); got != 2 {
+		t.Errorf("stopped at %d instead of the return address 2", got)
+	}
+}
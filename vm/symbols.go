@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/sfluor/synacor/symbols"
+)
+
+// SetSymbols names addresses: the debugger, the trace and the profile print the names and the debugger commands
+// accept them wherever an address is expected
+func (vm *VM) SetSymbols(t *symbols.Table) {
+	vm.symbols = t
+}
+
+// Symbols returns the names of the addresses, nil if there are none
+func (vm *VM) Symbols() *symbols.Table {
+	return vm.symbols
+}
+
+// parseAddress parses an address of the memory given as a number or as a symbol
+func (vm VM) parseAddress(s string) (uint16, error) {
+	addr, err := vm.symbols.Resolve(s)
+	if err != nil {
+		return 0, err
+	}
+	if addr >= M {
+		return 0, fmt.Errorf("%s is out of memory", s)
+	}
+	return addr, nil
+}
+
+// formatAddr formats an address followed by its name if it has one, e.g. "(  6027) <confirmation>"
+func (vm VM) formatAddr(addr uint16) string {
+	return formatAddr(addr, vm.symbols)
+}
+
+func formatAddr(addr uint16, syms *symbols.Table) string {
+	if name, ok := syms.Name(addr); ok {
+		return fmt.Sprintf("(%6d) <%s>", addr, name)
+	}
+	return fmt.Sprintf("(%6d)", addr)
+}
@@ -0,0 +1,39 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/sfluor/synacor/isa"
+)
+
+// SetTrace enables the execution trace, one line per executed instruction is written to w
+func (vm *VM) SetTrace(w io.Writer) {
+	vm.trace = w
+	vm.tracing = w != nil
+}
+
+// traceFile opens a file in append mode to write the trace into
+func traceFile(path string) (io.Writer, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// formatInstruction returns the instruction at the cursor with its operands resolved, e.g. "add: [R0=4 R1=2 1]"
+func (vm VM) formatInstruction() string {
+	return vm.formatWords(wordsAt(vm.memory, int(vm.cursor), 4), vm.register)
+}
+
+// formatWords formats the instruction starting words like formatInstruction, with the values of the given registers
+func (vm VM) formatWords(words []uint16, register [8]uint16) string {
+	return isa.Formatter{Registers: &register, Symbols: vm.symbols}.Format(isa.Decode(words, 0))
+}
+
+// logTrace writes the instruction (formatted before its execution) along with the current registers to the trace
+func (vm VM) logTrace(cursor uint16, instruction string) {
+	_, err := fmt.Fprintf(vm.trace, "%s | %s %v\n", vm.formatAddr(cursor), instruction, vm.register)
+	if err != nil {
+		log.Fatalf("Could not write trace: %s", err)
+	}
+}
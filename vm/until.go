@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RunUntilOutput is RunLimit stopping with ExitOutputMatch as soon as the output written from now on matches re, e.g.
+// to run until the next prompt of the game. The pattern is matched against the last 4096 bytes of that output, after
+// each byte.
+func (vm *VM) RunUntilOutput(ctx context.Context, re *regexp.Regexp, n uint64) (ExitReason, error) {
+	return vm.runLimit(ctx, n, vm.outputMatcher(re))
+}
+
+// outputMatcher returns a function telling whether the output written since the call matches re
+func (vm *VM) outputMatcher(re *regexp.Regexp) func() bool {
+	start := vm.output.total
+	checked := start
+	return func() bool {
+		if vm.output.total == checked {
+			return false
+		}
+		checked = vm.output.total
+		return re.Match(vm.output.last(int(checked - start)))
+	}
+}
+
+// untilOutput handles the $until command: it leaves the stepping mode until the output matches the pattern or the
+// budget of instructions is exhausted
+func (vm *VM) untilOutput(args []string) bool {
+	usage := "Wrong command ! Should be $until <pattern> or $until \"pattern\" [budget]\n"
+
+	// The pattern can be quoted to keep its spaces, the budget follows it
+	pattern, budget := strings.Join(args, " "), uint64(0)
+	if quoted, err := strconv.QuotedPrefix(pattern); err == nil {
+		rest := strings.TrimSpace(strings.TrimPrefix(pattern, quoted))
+		pattern, _ = strconv.Unquote(quoted)
+		if rest != "" {
+			if budget, err = strconv.ParseUint(rest, 10, 64); err != nil || budget == 0 {
+				vm.printError(usage)
+				return false
+			}
+		}
+	}
+	if pattern == "" {
+		vm.printError(usage)
+		return false
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		vm.printError(fmt.Sprintf("Wrong pattern: %s\n", err))
+		return false
+	}
+
+	matched := vm.outputMatcher(re)
+	executed := uint64(0)
+	vm.runUntil(func(vm *VM, op uint16) bool {
+		executed++
+		switch {
+		case matched():
+			vm.printDebug(fmt.Sprintf("\nOutput matched after %d instructions\n", executed))
+			return true
+		case budget > 0 && executed >= budget:
+			vm.printDebug(fmt.Sprintf("\nNo match after %d instructions\n", executed))
+			return true
+		}
+		return false
+	})
+	return true
+}
@@ -3,223 +3,458 @@ package vm
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"io"
+	"sync/atomic"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/symbols"
 )
 
 // M is the Mem size
-const M = 32768
+const M = isa.M
+
+// ctxCheckInterval is the number of instructions RunContext executes between two checks of its context
+const ctxCheckInterval = 1024
 
-// Op codes
+// Op codes, see the isa package
 const (
-	HALT uint16 = iota
-	SET
-	PUSH
-	POP
-	EQ
-	GT
-	JMP
-	JT
-	JF
-	ADD
-	MULT
-	MOD
-	AND
-	OR
-	NOT
-	RMEM
-	WMEM
-	CALL
-	RET
-	OUT
-	IN
-	NOOP
+	HALT = isa.HALT
+	SET  = isa.SET
+	PUSH = isa.PUSH
+	POP  = isa.POP
+	EQ   = isa.EQ
+	GT   = isa.GT
+	JMP  = isa.JMP
+	JT   = isa.JT
+	JF   = isa.JF
+	ADD  = isa.ADD
+	MULT = isa.MULT
+	MOD  = isa.MOD
+	AND  = isa.AND
+	OR   = isa.OR
+	NOT  = isa.NOT
+	RMEM = isa.RMEM
+	WMEM = isa.WMEM
+	CALL = isa.CALL
+	RET  = isa.RET
+	OUT  = isa.OUT
+	IN   = isa.IN
+	NOOP = isa.NOOP
 )
 
 // VM type
 type VM struct {
 	register  [8]uint16 // the VM register
 	stack     []uint16  // The VM stack
-	memory    []uint16  // The memory read from the file challenge.bin
+	memory    Memory    // The memory read from the file challenge.bin
 	cursor    uint16    // The current position in the memory
 	debugging bool      // Debug mode
 	stepping  bool      // Step by step mode
+
+	length int                 // Len of the memory
+	flat   []uint16            // The memory when it's a FlatMemory, see read
+	pages  []*[pageSize]uint16 // The page table of the memory when it's a COWMemory
+
+	trapFaults bool   // Go to stepping mode on a Fault instead of returning it
+	permissive bool   // Define the edge cases of the arithmetic instead of faulting, see SetArithmetic
+	stackLimit int    // Maximum number of values on the stack, 0 means no limit
+	noCommands bool   // The lines starting with $ are read by the program instead of the debugger
+	prefix     string // Prefix of the debugger commands read by IN, $ if empty
+
+	until func(vm *VM, op uint16) bool // Condition to go back to stepping mode, see runUntil
+	calls []Frame                      // Shadow call stack
+
+	breakpoints map[uint16]breakpoint // Addresses that stop the execution
+	displays    []display             // Expressions printed when the execution stops, see $display
+	lastDisplay int                   // Number of the last display added
+	displayedAt uint64                // Instructions+1 when the displays were last printed, 0 if never
+
+	profile  *profile // Execution counters, nil when not profiling
+	coverage []bool   // Executed addresses, nil when the coverage is not recorded
+
+	loops *LoopDetector // States sampled by DetectLoops, nil if disabled
+
+	skipping func() bool // Tells whether the output skipped by SkipOutputUntil matched, nil when not skipping
+
+	memos        map[uint16]*memo // Functions whose results are cached, see Memoize
+	pendingMemos []pendingMemo    // Calls of these functions that will be cached once they return
+
+	watches     map[uint16]bool // Addresses that break execution when written
+	readWatches map[uint16]bool // Addresses that break execution when read
+
+	protections []ProtectedRange // Ranges the program can't write, see Protect
+	protected   []uint16         // Index+1 of the range protecting each address in protections, nil without any
+
+	trace   io.Writer // Where the execution trace is written
+	tracing bool      // Trace mode
+
+	patches   map[uint16][]func(*VM)            // Functions called when the cursor reaches an address
+	addrHooks map[uint16][]func(*VM) HookResult // Functions replacing or skipping the instruction at an address
+	hookHits  map[uint16]int                    // Times the cursor reached the addresses of addrHooks
+	handlers  []handler                         // Dispatch table when SetHandler changed it, nil for the one of the spec
+	next      uint16                            // Address of the instruction following the one being executed, see Jump
+
+	decoded []decodedInstruction // Decoded instructions by address, nil without EnableDecodeCache
+	current *decodedInstruction  // Decoded instruction being executed, nil without EnableDecodeCache
+	hooks   hooks                // Functions following the execution, see OnBeforeInstruction
+	symbols *symbols.Table       // Names of the addresses, see SetSymbols
+	speed   speed                // Pacing of the execution, see SetSpeed
+
+	recorder io.Writer // Where the transcript of the session is written
+
+	instructions uint64  // Instructions executed since the start, see Instructions
+	published    *uint64 // Where the instructions are published, see PublishInstructions
+	stateDir     string  // Where the slots are kept, see SetStateDir
+
+	interrupt int32      // State of an interruption, see Interrupt
+	exclusive *exclusive // Functions of other goroutines waiting for the VM, see Exclusive
+	inLine    bool       // The program read a part of a line: the rest is neither a command nor interrupted
+	state     int32      // What the VM is doing, see State
+	prompting bool       // The debugger reads a command at the stepping prompt
+
+	history    *history   // Last executed instructions, to step backwards
+	core       *coreDumps // Last executed instructions, for the core dumps
+	readOnly   bool       // The debugger refuses the commands changing the state, see SetReadOnly
+	candidates []uint16   // Addresses found by the last $find or $refine
+
+	codes    *OutputScanner   // Collects the challenge codes
+	game     *gameTracker     // Parses the output into a GameState
+	output   *outputRing      // Last bytes written by OUT
+	scroll   *scrollback      // Lines written by OUT
+	scanners []*OutputScanner // Additional scanners of the output
+
+	in   *bufio.Reader // Where the IN operation and the debugger read from
+	out  io.Writer     // Where the OUT operation writes to
+	diag io.Writer     // Where the debugger writes to, out when nil
 }
 
-// New creates a VM instance
-func New(memory []uint16) *VM {
-	return &VM{
-		memory: memory,
+// New creates a VM instance reading its input from in and writing its output to out
+func New(memory []uint16, in io.Reader, out io.Writer) *VM {
+	vm := &VM{
+		out:    out,
+		codes:  newCodeScanner(),
+		game:   &gameTracker{},
+		output: &outputRing{},
+		scroll: &scrollback{},
+
+		exclusive: &exclusive{},
+
+		stackLimit: DefaultStackLimit,
 	}
+	vm.setMemory(FlatMemory(memory))
+	vm.SetInput(in)
+	return vm
 }
 
-// Run executes the code in memory
-func (vm *VM) Run() {
-	// Reader for standard input
-	stdinReader := bufio.NewReader(os.Stdin)
+// SetDebugging enables or disables the debug mode that prints the state of the VM before each instruction
+func (vm *VM) SetDebugging(on bool) {
+	vm.debugging = on
+}
 
-	// Execute the binary
-	for {
-		if vm.stepping {
-			fmt.Print(">>> ")
-			cmd, _, _ := stdinReader.ReadLine()
-			if vm.debug(string(cmd)) {
-				vm.execInstruction(stdinReader)
-			}
-		} else {
-			vm.execInstruction(stdinReader)
-		}
+// SetCommands enables (the default) or disables the debugger commands read by IN, once disabled the lines starting
+// with $ are given to the program like any other line
+func (vm *VM) SetCommands(on bool) {
+	vm.noCommands = !on
+}
+
+// SetCommandPrefix replaces the $ starting the lines read by IN that are debugger commands, an empty prefix restores
+// it. A line starting with the prefix twice is given to the program with the prefix once.
+func (vm *VM) SetCommandPrefix(prefix string) {
+	vm.prefix = prefix
+}
+
+// CommandPrefix returns the prefix of the debugger commands read by IN
+func (vm *VM) CommandPrefix() string {
+	if vm.prefix == "" {
+		return "$"
 	}
+	return vm.prefix
+}
+
+// SetTrapFaults chooses what Run does on a Fault: return it (the default) or print it and go to stepping mode so that
+// the state can be inspected and fixed with the debugger
+func (vm *VM) SetTrapFaults(on bool) {
+	vm.trapFaults = on
+}
+
+// SetStepping enables or disables the stepping mode where the debugger prompts for a command before each instruction
+func (vm *VM) SetStepping(on bool) {
+	vm.stepping = on
+}
+
+// Stepping tells whether the debugger prompts for a command before each instruction
+func (vm *VM) Stepping() bool {
+	return vm.stepping
+}
+
+// SetInput replaces the reader used by the IN operation and the debugger
+func (vm *VM) SetInput(in io.Reader) {
+	vm.in = bufio.NewReader(&stateReader{vm: vm, r: in})
+}
+
+// SetOutput replaces the writer used by the OUT operation, and by the debugger unless SetDiagnostics was called
+func (vm *VM) SetOutput(out io.Writer) {
+	vm.out = out
 }
 
-// execInstruction executes one instruction
-func (vm *VM) execInstruction(reader *bufio.Reader) {
-	// Our cursor that points to the actual position in the memory
+// SetDiagnostics makes the debugger (its prompt, its messages and the state printed in debug mode) write to w instead
+// of the output of the program, so that the output only holds what OUT wrote. A nil w writes them to the output again.
+func (vm *VM) SetDiagnostics(w io.Writer) {
+	vm.diag = w
+}
 
-	// Skip the verification process
-	if vm.cursor == 5489 {
-		vm.cursor = 5491
-		vm.register[0] = 6
+// diagnostics returns where the debugger writes to
+func (vm *VM) diagnostics() io.Writer {
+	if vm.diag == nil {
+		return vm.out
 	}
+	return vm.diag
+}
 
-	// Retrieve the operation
-	op := vm.memory[vm.cursor]
+// Memory returns the value stored at addr
+func (vm *VM) Memory(addr uint16) uint16 {
+	return vm.memory.Read(addr)
+}
 
-	// To see what opcodes are called during the confirmation process
-	if vm.debugging && op != OUT {
-		vm.printDebug(fmt.Sprintf("\n - Stack: %v\n - Register: %v\n - (%6d) %2d \n", vm.stack, vm.register, vm.cursor, op))
+// MemRange returns a copy of the memory between start (included) and end (excluded), bounded by the memory size
+func (vm *VM) MemRange(start, end uint16) []uint16 {
+	if int(end) > vm.memory.Len() {
+		end = uint16(vm.memory.Len())
+	}
+	if start > end {
+		start = end
 	}
+	return append([]uint16{}, wordsAt(vm.memory, int(start), int(end-start))...)
+}
 
-	switch op {
-	case HALT: // Code 0
-		fmt.Print("Halt op code !")
-		os.Exit(0)
+// SetMemory sets the value stored at addr
+func (vm *VM) SetMemory(addr, value uint16) {
+	vm.memory.Write(addr, value)
+	vm.invalidate(addr)
+}
 
-	case SET: // Code 1
-		vm.set(vm.b())
-		vm.cursor += 3
+// Register returns the value of the register r (from 0 to 7)
+func (vm *VM) Register(r int) uint16 {
+	return vm.register[r]
+}
 
-	case PUSH: // Code 2
-		vm.stack = append(vm.stack, vm.a())
-		vm.cursor += 2
+// SetRegister sets the value of the register r (from 0 to 7)
+func (vm *VM) SetRegister(r int, value uint16) {
+	vm.register[r] = value
+}
 
-	case POP: // Code 3
-		popped, err := vm.pop()
-		if err != nil {
-			panic(err)
-		}
-		vm.set(popped)
-		vm.cursor += 2
-
-	case EQ: // Code 4
-		if vm.b() == vm.c() {
-			vm.set(1)
-		} else {
-			vm.set(0)
-		}
-		vm.cursor += 4
+// Operand returns the value of an operand of an instruction: the register for values from M to M+7, the value itself
+// otherwise
+func (vm *VM) Operand(v uint16) uint16 {
+	if v >= M && v < M+8 {
+		return vm.register[v-M]
+	}
+	return v
+}
 
-	case GT: // Code 5
-		if vm.b() > vm.c() {
-			vm.set(1)
-		} else {
-			vm.set(0)
-		}
-		vm.cursor += 4
+// Stack returns a copy of the stack, the top of the stack is the last value
+func (vm *VM) Stack() []uint16 {
+	return append([]uint16{}, vm.stack...)
+}
 
-	case JMP: // Code 6
-		vm.cursor = vm.a()
+// Cursor returns the address of the next instruction
+func (vm *VM) Cursor() uint16 {
+	return vm.cursor
+}
 
-	case JT: // Code 7
-		if vm.a() != 0 {
-			vm.cursor = vm.b()
-		} else {
-			vm.cursor += 3
-		}
+// SetCursor moves the cursor to addr
+func (vm *VM) SetCursor(addr uint16) {
+	vm.cursor = addr
+}
 
-	case JF: // Code 8
-		if vm.a() == 0 {
-			vm.cursor = vm.b()
-		} else {
-			vm.cursor += 3
-		}
+// Run executes the code in memory until the program stops, the input is exhausted or an error occurs
+func (vm *VM) Run() (ExitReason, error) {
+	return vm.RunLimit(context.Background(), 0)
+}
 
-	case ADD: // Code 9
-		vm.set((vm.b() + vm.c()) % M)
-		vm.cursor += 4
+// RunFor is Run stopping with ExitBudget once n instructions were executed
+func (vm *VM) RunFor(n uint64) (ExitReason, error) {
+	return vm.RunLimit(context.Background(), n)
+}
 
-	case MULT: // Code 10
-		vm.set((vm.b() * vm.c()) % M)
-		vm.cursor += 4
+// RunContext is Run stopping with ExitCanceled once ctx is done. The context is checked between instructions, it
+// doesn't interrupt the debugger or the IN operation waiting for the input.
+func (vm *VM) RunContext(ctx context.Context) (ExitReason, error) {
+	return vm.RunLimit(ctx, 0)
+}
 
-	case MOD: // Code 11
-		vm.set(vm.b() % vm.c())
-		vm.cursor += 4
+// RunLimit combines RunFor and RunContext, n is the number of instructions executed at most (0 means no limit)
+func (vm *VM) RunLimit(ctx context.Context, n uint64) (ExitReason, error) {
+	return vm.runLimit(ctx, n, nil)
+}
 
-	case AND: // Code 12
-		vm.set(vm.b() & vm.c())
-		vm.cursor += 4
+// runLimit is RunLimit stopping with ExitOutputMatch once stop, if not nil, returns true after an instruction
+func (vm *VM) runLimit(ctx context.Context, n uint64, stop func() bool) (ExitReason, error) {
+	vm.setState(StateRunning)
+	defer vm.setState(StateHalted)
+	defer vm.publish()
+	defer vm.exclusive.enter(vm)()
 
-	case OR: // Code 13
-		vm.set(vm.b() | vm.c())
-		vm.cursor += 4
+	done := ctx.Done()
+	executed := uint64(0)
 
-	case NOT: // Code 14
-		vm.set(0x7fff &^ vm.b())
-		vm.cursor += 3
+	// Execute the binary
+	for {
+		if n > 0 && executed >= n {
+			return ExitBudget, nil
+		}
+		// Checking the channel is cheap but not free, every instruction would be a waste
+		if done != nil && executed%ctxCheckInterval == 0 {
+			select {
+			case <-done:
+				return ExitCanceled, nil
+			default:
+			}
+		}
+		if vm.published != nil && executed%publishInterval == 0 {
+			vm.publish()
+		}
+		if atomic.LoadInt32(&vm.exclusive.queued) != 0 {
+			vm.exclusive.boundary(vm)
+		}
 
-	case RMEM: // Code 15
-		vm.set(vm.get(vm.b()))
-		vm.cursor += 3
+		vm.checkInterrupt()
+		if vm.stepping {
+			vm.showDisplays()
+			fmt.Fprint(vm.diagnostics(), ">>> ")
+			vm.prompting = true
+			cmd, err := vm.readLine()
+			vm.prompting = false
+			if err != nil {
+				return ExitReasonOf(err)
+			}
+			if !vm.debug(cmd) {
+				continue
+			}
+		}
 
-	case WMEM: // Code 16
-		vm.memory[vm.a()] = vm.b()
-		vm.cursor += 3
+		op := uint16(0)
+		if int(vm.cursor) < vm.length {
+			op = vm.read(vm.cursor)
+		}
+		executed++
+		if err := vm.Step(); err != nil {
+			if f := (*Fault)(nil); errors.As(err, &f) && (vm.trapFaults || f.Break) {
+				// Let the user fix the state from the debugger, the faulty instruction is executed again
+				if !f.Break {
+					vm.printError(fmt.Sprintf("\nFault: %s\n", f))
+				}
+				vm.stepping = true
+				continue
+			}
+			reason, err := ExitReasonOf(err)
+			if reason == ExitError {
+				vm.dumpCore(err)
+			}
+			return reason, err
+		}
+		vm.checkUntil(op)
+		if len(vm.breakpoints) > 0 {
+			vm.checkBreakpoint()
+		}
+		if stop != nil && stop() {
+			return ExitOutputMatch, nil
+		}
+	}
+}
 
-	case CALL: // Code 17
-		vm.push(vm.cursor + 2)
-		vm.cursor = vm.a()
+// ExitReasonOf converts an error returned by Step to an ExitReason, the error is nil unless the reason is ExitError
+func ExitReasonOf(err error) (ExitReason, error) {
+	switch {
+	case err == errRetHalt:
+		return ExitRet, nil
+	case errors.Is(err, ErrHalt):
+		return ExitHalt, nil
+	case errors.Is(err, io.EOF):
+		return ExitInputEOF, nil
+	}
+	return ExitError, err
+}
 
-	case RET: // Code 18
-		popped, err := vm.pop()
-		if err != nil {
-			// Halt
-			fmt.Print("RET operation resulted in halt !")
-			os.Exit(0)
+// Step executes one instruction, it returns ErrHalt when the program stops and io.EOF when the input is exhausted
+func (vm *VM) Step() (err error) {
+	// Invalid memory accesses panic with a Fault deep inside the operands helpers, report them as errors
+	defer func() {
+		if r := recover(); r != nil {
+			vm.current = nil
+			if f, ok := r.(*Fault); ok {
+				err = f
+			} else {
+				err = fmt.Errorf("cursor %d: %v", vm.cursor, r)
+			}
 		}
-		vm.cursor = popped
+	}()
+
+	if vm.history != nil {
+		vm.history.begin(vm)
+		defer func() { vm.history.end(vm, err) }()
+	}
 
-	case OUT: // Code 19
-		fmt.Print(string(vm.a()))
-		vm.cursor += 2
+	vm.applyPatches()
+	if vm.memos != nil {
+		vm.runMemos()
+	}
+
+	for _, fn := range vm.hooks.beforeInstruction {
+		fn(vm)
+	}
 
-	case IN: // Code 20
-		// Check if we are doing a command
-		t, _ := reader.Peek(1)
-		if string(t[0]) == "$" {
-			// It's a command
-			cmd, _, _ := reader.ReadLine()
+	if vm.profile != nil {
+		vm.profile.count(vm)
+	}
 
-			vm.debug(string(cmd))
+	if vm.coverage != nil {
+		vm.coverage[vm.cursor] = true
+	}
 
-		} else {
-			b, _ := reader.ReadByte()
-			vm.set(uint16(b))
-			vm.cursor += 2
+	if vm.loops != nil {
+		if err := vm.checkLoop(); err != nil {
+			return err
 		}
-	case NOOP: // Code 21
-		vm.cursor++
+	}
+
+	if vm.skipping == nil {
+		vm.speed.pace()
+	}
+	vm.instructions++
+	if vm.core != nil {
+		vm.core.record(vm)
+	}
+
+	if vm.addrHooks != nil && vm.runAddressHooks() {
+		return nil
+	}
 
-	default:
-		panic(fmt.Errorf("Unrecognized opcode %v", op))
+	if !vm.tracing {
+		return vm.execInstruction()
 	}
+
+	cursor, instruction := vm.cursor, vm.formatInstruction()
+	err = vm.execInstruction()
+	vm.logTrace(cursor, instruction)
+	return err
 }
 
 // get Retrieves a value by checking the register
-func (vm VM) get(addr uint16) uint16 {
-	m := vm.memory[addr]
+func (vm *VM) get(addr uint16) uint16 {
+	if int(addr) >= vm.length {
+		panic(vm.fault(ErrInvalidAddress, int(addr)))
+	}
+
+	m := vm.read(addr)
 	if m > M+7 {
-		panic(fmt.Errorf("Get operation: Invalid address %v", m))
+		if vm.permissive {
+			return m % M
+		}
+		panic(vm.fault(ErrInvalidOperand, int(m)))
 	}
 
 	// Register
@@ -232,19 +467,40 @@ func (vm VM) get(addr uint16) uint16 {
 
 // set Modify a value in the memory
 func (vm *VM) set(value uint16) {
+	if d := vm.current; d != nil && d.registers&1 != 0 {
+		vm.register[d.operands[0]] = value
+		return
+	}
+
 	// We always use set in the first argument < a >
 	addr := vm.cursor + 1
-	m := vm.memory[addr]
-	if m > M+8 {
-		panic(fmt.Errorf("Set operation: Invalid address %v", m))
+	if int(addr) >= vm.length {
+		panic(vm.fault(ErrInvalidAddress, int(addr)))
+	}
+
+	m := vm.read(addr)
+	if m < M || m > M+7 {
+		panic(vm.fault(ErrInvalidOperand, int(m)))
 	}
 
 	// Set in register
 	vm.register[m-M] = value
 }
 
+// fault returns a Fault of the instruction at the cursor
+func (vm *VM) fault(err error, value int) *Fault {
+	f := &Fault{Cursor: vm.cursor, Value: value, Err: err}
+	if int(vm.cursor) < vm.memory.Len() {
+		f.Op = vm.memory.Read(vm.cursor)
+	}
+	return f
+}
+
 // Push to stack
 func (vm *VM) push(value uint16) {
+	if vm.history != nil {
+		vm.history.log(change{kind: stackPush})
+	}
 	vm.stack = append(vm.stack, value)
 }
 
@@ -253,22 +509,25 @@ func (vm *VM) pop() (uint16, error) {
 	if len(vm.stack) > 0 {
 		res := vm.stack[len(vm.stack)-1]
 		vm.stack = vm.stack[:len(vm.stack)-1]
+		if vm.history != nil {
+			vm.history.log(change{kind: stackPop, value: res})
+		}
 		return res, nil
 	}
 	return 0, fmt.Errorf("empty stack ")
 }
 
 // a returns the first argument of the current command
-func (vm VM) a() uint16 {
-	return vm.get(vm.cursor + 1)
+func (vm *VM) a() uint16 {
+	return vm.operand(0)
 }
 
 // b returns the second argument of the current command
-func (vm VM) b() uint16 {
-	return vm.get(vm.cursor + 2)
+func (vm *VM) b() uint16 {
+	return vm.operand(1)
 }
 
 // c returns the first argument of the current command
-func (vm VM) c() uint16 {
-	return vm.get(vm.cursor + 3)
+func (vm *VM) c() uint16 {
+	return vm.operand(2)
 }
@@ -0,0 +1,37 @@
+package vm
+
+import "fmt"
+
+// setWatch adds (watch, rwatch) or removes (unwatch) a watchpoint on addr
+func (vm *VM) setWatch(addr uint16, cmd string) {
+	if vm.watches == nil {
+		vm.watches = map[uint16]bool{}
+		vm.readWatches = map[uint16]bool{}
+	}
+
+	switch cmd {
+	case "watch":
+		vm.watches[addr] = true
+		vm.printDebug(fmt.Sprintf("Watching writes to %d\n", addr))
+	case "rwatch":
+		vm.readWatches[addr] = true
+		vm.printDebug(fmt.Sprintf("Watching reads of %d\n", addr))
+	case "unwatch":
+		delete(vm.watches, addr)
+		delete(vm.readWatches, addr)
+		vm.printDebug(fmt.Sprintf("Not watching %d anymore\n", addr))
+	}
+}
+
+// checkWatch stops the execution (by switching to stepping mode) if addr is watched
+func (vm *VM) checkWatch(addr uint16, write bool) {
+	if write && vm.watches[addr] {
+		vm.printDebug(fmt.Sprintf("\nWatchpoint: (%6d) wmem writes %d to %d (was %d)\n", vm.cursor, vm.b(), addr, vm.memory.Read(addr)))
+		vm.stepping = true
+	}
+
+	if !write && vm.readWatches[addr] {
+		vm.printDebug(fmt.Sprintf("\nWatchpoint: (%6d) rmem reads %d from %d\n", vm.cursor, vm.memory.Read(addr), addr))
+		vm.stepping = true
+	}
+}
@@ -0,0 +1,98 @@
+package vmtest
+
+import (
+	"io"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Cases are the programs of the suite, those using data jump over it first so that it starts at address 2
+var Cases = []Case{
+	// halt, noop
+	{Name: "halt", Source: "halt", Err: vm.ErrHalt},
+	{Name: "noop", Source: "noop\nnoop\nset R0 1\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "invalid opcode", Source: ".data 22", Err: vm.ErrInvalidOpcode},
+
+	// set
+	{Name: "set literal", Source: "set R0 5\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 5}},
+	{Name: "set register", Source: "set R3 7\nset R7 R3\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{3: 7, 7: 7}},
+	{Name: "set max literal", Source: "set R0 32767\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 32767}},
+
+	// push, pop
+	{Name: "push pop", Source: "push 1\npush 2\npop R0\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 2}, Stack: []uint16{1}},
+	{Name: "push register", Source: "set R1 9\npush R1\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{1: 9}, Stack: []uint16{9}},
+	{Name: "pop empty stack", Source: "pop R0", Err: vm.ErrStackUnderflow},
+	{Name: "pop after emptied", Source: "push 3\npop R0\npop R1", Err: vm.ErrStackUnderflow, Registers: map[int]uint16{0: 3}},
+
+	// eq, gt
+	{Name: "eq true", Source: "eq R0 4 4\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "eq false", Source: "set R0 9\neq R0 4 5\nhalt", Err: vm.ErrHalt},
+	{Name: "eq registers", Source: "set R1 6\nset R2 6\neq R0 R1 R2\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1, 1: 6, 2: 6}},
+	{Name: "gt true", Source: "gt R0 5 4\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "gt equal", Source: "gt R0 4 4\nhalt", Err: vm.ErrHalt},
+	{Name: "gt false", Source: "gt R0 4 32767\nhalt", Err: vm.ErrHalt},
+
+	// jmp, jt, jf
+	{Name: "jmp", Source: "jmp end\nset R0 1\nend: halt", Err: vm.ErrHalt},
+	{Name: "jmp register", Source: "set R1 end\njmp R1\nset R0 1\nend: halt", Err: vm.ErrHalt, Registers: map[int]uint16{1: 8}},
+	{Name: "jt taken", Source: "jt 1 end\nset R0 1\nend: halt", Err: vm.ErrHalt},
+	{Name: "jt not taken", Source: "jt 0 end\nset R0 1\nend: halt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "jt nonzero", Source: "set R1 32767\njt R1 end\nset R0 1\nend: halt", Err: vm.ErrHalt, Registers: map[int]uint16{1: 32767}},
+	{Name: "jf taken", Source: "jf 0 end\nset R0 1\nend: halt", Err: vm.ErrHalt},
+	{Name: "jf not taken", Source: "jf 2 end\nset R0 1\nend: halt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "loop", Source: "set R0 5\nloop: add R1 R1 2\nadd R0 R0 32767\njt R0 loop\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{1: 10}},
+
+	// Arithmetic, the spec example first
+	{Name: "add wraparound", Source: "add R0 32758 15\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 5}},
+	{Name: "add registers", Source: "set R1 4\nadd R0 R1 R1\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 8, 1: 4}},
+	{Name: "add max", Source: "add R0 32767 32767\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 32766}},
+	{Name: "add minus one", Source: "set R0 10\nadd R0 R0 32767\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 9}},
+	{Name: "mult", Source: "mult R0 6 7\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 42}},
+	{Name: "mult wraparound", Source: "mult R0 1000 1000\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 16960}},
+	{Name: "mult max", Source: "mult R0 32767 32767\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "mult overflow", Source: "mult R0 300 300\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 24464}},
+	{Name: "mult wraps to zero", Source: "mult R0 256 128\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 0}},
+	{Name: "mult registers", Source: "set R1 32767\nset R2 32766\nmult R0 R1 R2\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 2, 1: 32767, 2: 32766}},
+	{Name: "mod", Source: "mod R0 10 3\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "mod smaller", Source: "mod R0 3 10\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 3}},
+	{Name: "mod by zero", Source: "set R1 5\nmod R0 R1 0", Err: vm.ErrDivisionByZero, Registers: map[int]uint16{1: 5}},
+	{Name: "and", Source: "and R0 12 10\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 8}},
+	{Name: "or", Source: "or R0 12 10\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 14}},
+	{Name: "not zero", Source: "not R0 0\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 32767}},
+	{Name: "not max", Source: "not R0 32767\nhalt", Err: vm.ErrHalt},
+	{Name: "not masks 15 bits", Source: "not R0 21845\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 10922}},
+	{Name: "not register", Source: "set R1 1\nnot R0 R1\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 32766, 1: 1}},
+
+	// rmem, wmem
+	{Name: "rmem", Source: "jmp main\n.data 1234\nmain: rmem R0 2\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1234}},
+	{Name: "rmem register address", Source: "jmp main\n.data 0, 77\nmain: set R1 3\nrmem R0 R1\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 77, 1: 3}},
+	{Name: "rmem register word", Source: "jmp main\n.data R1\nmain: set R1 5\nrmem R0 2\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 32769, 1: 5}},
+	{Name: "wmem", Source: "jmp main\n.data 0\nmain: wmem 2 42\nhalt", Err: vm.ErrHalt, Memory: map[uint16]uint16{2: 42}},
+	{Name: "wmem registers", Source: "jmp main\n.data 0\nmain: set R1 2\nset R2 99\nwmem R1 R2\nrmem R0 2\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 99, 1: 2, 2: 99}, Memory: map[uint16]uint16{2: 99}},
+	{Name: "wmem executed code", Source: "start: set R0 1\njt R1 end\nwmem 2 7\nset R1 1\njmp start\nend: halt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 7, 1: 1}, Memory: map[uint16]uint16{2: 7}},
+	{Name: "wmem code", Source: "wmem patched 21\npatched: halt\nset R0 1\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}, Memory: map[uint16]uint16{3: 21}},
+
+	// call, ret
+	{Name: "call ret", Source: "call f\nhalt\nf: set R0 1\nret", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1}},
+	{Name: "call pushes the return address", Source: "call f\nf: halt", Err: vm.ErrHalt, Stack: []uint16{2}},
+	{Name: "call register", Source: "set R1 f\ncall R1\nhalt\nf: set R0 1\nret", Err: vm.ErrHalt, Registers: map[int]uint16{0: 1, 1: 6}},
+	{Name: "nested calls", Source: "call f\nhalt\nf: call g\nadd R0 R0 1\nret\ng: set R0 10\nret", Err: vm.ErrHalt, Registers: map[int]uint16{0: 11}},
+	{Name: "recursion", Source: "set R0 4\ncall f\nhalt\nf: jf R0 done\nadd R1 R1 R0\nadd R0 R0 32767\ncall f\ndone: ret", Err: vm.ErrHalt, Registers: map[int]uint16{1: 10}},
+	{Name: "ret to pushed address", Source: "push end\nret\nset R0 1\nend: halt", Err: vm.ErrHalt},
+	{Name: "ret empty stack", Source: "ret", Err: vm.ErrHalt},
+
+	// out, in
+	{Name: "out", Source: "out 'H'\nout 'i'\nout 10\nhalt", Err: vm.ErrHalt, Output: "Hi\n"},
+	{Name: "out register", Source: "set R0 'A'\nout R0\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 'A'}, Output: "A"},
+	{Name: "spec hint", Source: ".data 9, R0, R1, 4, 19, R0 ; 9,32768,32769,4,19,32768\nhalt", Err: vm.ErrHalt, Registers: map[int]uint16{0: 4}, Output: "\x04"},
+	{Name: "in", Source: "in R0\nin R1\nhalt", Input: "ab", Err: vm.ErrHalt, Registers: map[int]uint16{0: 'a', 1: 'b'}},
+	{Name: "in line", Source: "loop: in R0\nout R0\neq R1 R0 10\njf R1 loop\nhalt", Input: "go north\n", Err: vm.ErrHalt, Registers: map[int]uint16{0: 10, 1: 1}, Output: "go north\n"},
+	{Name: "in end of input", Source: "in R0\nin R0", Input: "x", Err: io.EOF, Registers: map[int]uint16{0: 'x'}},
+
+	// Faults
+	{Name: "wmem outside memory", Source: "wmem 30000 1\nhalt", Err: vm.ErrInvalidAddress},
+	{Name: "rmem outside memory", Source: "rmem R0 100\nhalt", Err: vm.ErrInvalidAddress},
+	{Name: "jmp outside memory", Source: "jmp 100", Err: vm.ErrInvalidAddress},
+	{Name: "literal destination", Source: ".data 1, 5, 3\nhalt", Err: vm.ErrInvalidOperand},
+	{Name: "missing operand", Source: "set R0 1\n.data 9, R0", Err: vm.ErrInvalidAddress, Registers: map[int]uint16{0: 1}},
+}
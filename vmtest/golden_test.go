@@ -0,0 +1,106 @@
+package vmtest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sfluor/synacor/isa"
+	"github.com/sfluor/synacor/loader"
+	"github.com/sfluor/synacor/symbols"
+	"github.com/sfluor/synacor/vm"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden files of testdata instead of comparing them, after a deliberate change of the formatting")
+
+// goldenSteps is the number of instructions of the sample binary traced by its golden trace
+const goldenSteps = 1000
+
+// goldens are the golden files of the sample binary, by name, and how they are produced from it
+var goldens = []struct {
+	name   string
+	render func(bin []uint16, syms *symbols.Table) string
+}{
+	{"sample.listing", func(bin []uint16, _ *symbols.Table) string { return listing(bin, nil) }},
+	{"sample.symbols.listing", listing},
+	{"sample.trace", trace},
+}
+
+// listing disassembles the whole memory one instruction per line, with the names of syms (which can be nil) for the
+// addresses jumped to
+func listing(memory []uint16, syms *symbols.Table) string {
+	b := &strings.Builder{}
+	for addr := 0; addr < len(memory); addr += isa.Operands(memory[addr]) + 1 {
+		fmt.Fprintf(b, "(%6d) | %s\n", addr, isa.DisassembleWith(memory, uint16(addr), syms))
+	}
+	return b.String()
+}
+
+// trace returns the trace of the execution of bin until it stops, without any input
+func trace(bin []uint16, syms *symbols.Table) string {
+	b := &bytes.Buffer{}
+	machine := vm.New(bin, bytes.NewReader(nil), ioutil.Discard)
+	machine.SetSymbols(syms)
+	machine.SetTrace(b)
+	machine.RunFor(goldenSteps)
+	return b.String()
+}
+
+// TestGolden compares the disassembly (with and without symbols) and the trace of the sample binary of testdata with
+// the files committed next to it, so that a refactoring doesn't change the formatting by accident. go test -update
+// rewrites them.
+func TestGolden(t *testing.T) {
+	bin, err := loader.LoadFile(filepath.Join("testdata", "sample.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := symbols.Load(filepath.Join("testdata", "sample.symbols.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, g := range goldens {
+		t.Run(g.name, func(t *testing.T) {
+			path := filepath.Join("testdata", g.name)
+			got := g.render(bin, syms)
+			if *update {
+				if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%s, write it with go test -update", err)
+			}
+			if diff := firstDiff(string(want), got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// firstDiff describes the first line differing between the golden content want and the rendered one got, empty if
+// they are the same
+func firstDiff(want, got string) string {
+	if want == got {
+		return ""
+	}
+
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(wantLines):
+			return fmt.Sprintf("line %d: unexpected %q", i+1, gotLines[i])
+		case i >= len(gotLines):
+			return fmt.Sprintf("line %d: missing %q", i+1, wantLines[i])
+		case wantLines[i] != gotLines[i]:
+			return fmt.Sprintf("line %d: %q instead of %q", i+1, gotLines[i], wantLines[i])
+		}
+	}
+}
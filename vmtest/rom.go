@@ -0,0 +1,139 @@
+package vmtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sfluor/synacor/asm"
+	"github.com/sfluor/synacor/verify"
+	"github.com/sfluor/synacor/vm"
+)
+
+// ROMInput is the input expected by the test of IN, the last test of the ROM
+const ROMInput = "ok\n"
+
+// maxROMSteps stops a ROM that never stops
+const maxROMSteps = 100000
+
+// romTest is a test of the ROM: code run with R0 to R6 cleared, then the values it left. An '@' in the code and the
+// values is replaced by the number of the test for its labels, the word at the label scratch can be used freely.
+type romTest struct {
+	name   string
+	code   string
+	checks []romCheck
+}
+
+// romCheck is a value expected once the code of a test ran
+type romCheck struct {
+	where string // Register (R0 to R6) or label of the memory read with RMEM
+	want  string // Operand
+}
+
+// romTests cover every operation of the spec, HALT ending the ROM
+var romTests = []romTest{
+	{"set", "set R0 42\nset R2 7\nset R1 R2", []romCheck{{"R0", "42"}, {"R1", "7"}}},
+	{"push pop", "set R2 6\npush 5\npush R2\npop R0\npop R1", []romCheck{{"R0", "6"}, {"R1", "5"}}},
+	{"eq", "eq R0 3 3\neq R1 3 4\nset R2 9\neq R3 R2 9", []romCheck{{"R0", "1"}, {"R1", "0"}, {"R3", "1"}}},
+	{"gt", "gt R0 4 3\ngt R1 3 3\ngt R2 3 4", []romCheck{{"R0", "1"}, {"R1", "0"}, {"R2", "0"}}},
+	{"jmp", "jmp skip@\nset R0 1\nskip@: set R1 1", []romCheck{{"R0", "0"}, {"R1", "1"}}},
+	{"jmp register", "set R2 skip@\njmp R2\nset R0 1\nskip@: set R1 1", []romCheck{{"R0", "0"}, {"R1", "1"}}},
+	{"jt", "jt 2 taken@\nset R0 1\ntaken@: jt 0 next@\nset R1 1\nnext@: noop", []romCheck{{"R0", "0"}, {"R1", "1"}}},
+	{"jf", "jf 0 taken@\nset R0 1\ntaken@: jf 3 next@\nset R1 1\nnext@: noop", []romCheck{{"R0", "0"}, {"R1", "1"}}},
+	{"add", "add R0 2 3\nadd R1 32758 15", []romCheck{{"R0", "5"}, {"R1", "5"}}},
+	{"mult", "mult R0 6 7\nmult R1 32767 2", []romCheck{{"R0", "42"}, {"R1", "32766"}}},
+	{"mod", "mod R0 17 5\nmod R1 5 17", []romCheck{{"R0", "2"}, {"R1", "5"}}},
+	{"and", "and R0 0x5555 0x3333", []romCheck{{"R0", "0x1111"}}},
+	{"or", "or R0 0x5555 0x3333", []romCheck{{"R0", "0x7777"}}},
+	{"not", "not R0 0\nnot R1 0x7000", []romCheck{{"R0", "32767"}, {"R1", "0x0fff"}}},
+	{"wmem rmem", "wmem scratch 1234\nrmem R0 scratch", []romCheck{{"R0", "1234"}, {"scratch", "1234"}}},
+	{"wmem rmem register", "set R1 scratch\nset R2 4321\nwmem R1 R2\nrmem R0 R1", []romCheck{{"R0", "4321"}, {"scratch", "4321"}}},
+	{"call ret", "call f@\nset R1 1\njmp end@\nf@: set R0 9\nret\nend@: noop", []romCheck{{"R0", "9"}, {"R1", "1"}}},
+	{"call pushes the next address", "call f@\nback@: jmp end@\nf@: pop R0\npush R0\nret\nend@: noop", []romCheck{{"R0", "back@"}}},
+	{"call register", "set R2 f@\ncall R2\njmp end@\nf@: set R0 3\nret\nend@: noop", []romCheck{{"R0", "3"}}},
+	{"register operands", "set R1 20\nset R2 22\nadd R0 R1 R2\nset R3 'A'\nout R3\nout 10", []romCheck{{"R0", "42"}}},
+	{"noop", "noop\nset R0 1", []romCheck{{"R0", "1"}}},
+	{"in", "print \"Type " + strings.TrimSpace(ROMInput) + " and press enter\\n\"\nin R0\nin R1\nin R2", []romCheck{{"R0", "'o'"}, {"R1", "'k'"}, {"R2", "10"}}},
+}
+
+// ROM returns the assembly of a self-checking test ROM exercising every operation of the spec, to validate any
+// implementation: it writes a PASS or a FAIL line per test, then DONE before halting. Its last test reads ROMInput.
+func ROM() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "; Synacor test ROM: a PASS or FAIL line per test, DONE at the end. Type %q when asked.\n", strings.TrimSpace(ROMInput))
+
+	for i, t := range romTests {
+		at := func(s string) string { return strings.Replace(s, "@", fmt.Sprintf("_%d", i), -1) }
+
+		fmt.Fprintf(b, "\n; %s\ntest_%d:\n\tset R0 0\n\tset R1 0\n\tset R2 0\n\tset R3 0\n\tset R4 0\n\tset R5 0\n\tset R6 0\n", t.name, i)
+		fmt.Fprintf(b, "\twmem failed 0\n")
+		for _, l := range strings.Split(at(t.code), "\n") {
+			fmt.Fprintf(b, "\t%s\n", l)
+		}
+
+		for j, c := range t.checks {
+			if len(c.where) == 2 && c.where[0] == 'R' {
+				fmt.Fprintf(b, "\teq R7 %s %s\n", c.where, at(c.want))
+			} else {
+				fmt.Fprintf(b, "\trmem R7 %s\n\teq R7 R7 %s\n", c.where, at(c.want))
+			}
+			fmt.Fprintf(b, "\tjt R7 check_%d_%d\n", i, j)
+			fmt.Fprintf(b, "\tprint %q\n\twmem failed 1\n", fmt.Sprintf("FAIL %s: %s should be %s\n", t.name, c.where, at(c.want)))
+			fmt.Fprintf(b, "check_%d_%d:\n", i, j)
+		}
+		fmt.Fprintf(b, "\trmem R7 failed\n\tjt R7 test_%d\n\tprint %q\n", i+1, fmt.Sprintf("PASS %s\n", t.name))
+	}
+
+	fmt.Fprintf(b, "\n; halt\ntest_%d:\n\tprint \"DONE\\n\"\n\thalt\n", len(romTests))
+	fmt.Fprintf(b, "\tprint \"FAIL halt: the execution continued\\n\"\n\tret\n")
+	fmt.Fprintf(b, "\nfailed: .data 0\nscratch: .data 0\n")
+	return b.String()
+}
+
+// BuildROM assembles the test ROM of ROM
+func BuildROM() ([]uint16, error) {
+	return asm.Assemble(ROM())
+}
+
+// CheckROM runs the test ROM on a machine created by f and returns its FAIL lines, and the problems of its execution
+func CheckROM(f verify.Factory) []string {
+	bin, err := BuildROM()
+	if err != nil {
+		return []string{fmt.Sprintf("could not assemble the ROM: %s", err)}
+	}
+
+	out := &bytes.Buffer{}
+	m := f(bin, strings.NewReader(ROMInput), out)
+
+	var last error
+	for i := 0; last == nil; i++ {
+		if i == maxROMSteps {
+			return []string{fmt.Sprintf("still running after %d instructions", maxROMSteps)}
+		}
+		last = m.Step()
+	}
+
+	problems := []string{}
+	passed := 0
+	for _, l := range strings.Split(out.String(), "\n") {
+		switch {
+		case strings.HasPrefix(l, "PASS "):
+			passed++
+		case strings.HasPrefix(l, "FAIL "):
+			problems = append(problems, strings.TrimPrefix(l, "FAIL "))
+		}
+	}
+	if !errors.Is(last, vm.ErrHalt) {
+		problems = append(problems, fmt.Sprintf("stopped with %q instead of halting", last))
+	}
+	if !strings.HasSuffix(out.String(), "DONE\n") {
+		problems = append(problems, fmt.Sprintf("stopped before DONE, after %d tests passed", passed))
+	}
+	return problems
+}
+
+// ROMTests returns the number of tests of the ROM
+func ROMTests() int {
+	return len(romTests)
+}
@@ -0,0 +1,49 @@
+; Sample binary of the golden listings checked by the selftest subcommand, see vmtest/golden.go. It is a homebrew
+; program covering every operation and the edge cases of the disassembler, sample.bin is assembled from it with:
+;
+;	go run ./cmd/synacor -asm vmtest/testdata/sample.asm -out vmtest/testdata/sample.bin
+
+main:
+    set R0 'a'
+    push R0
+    pop R1
+    eq R2 R0 R1
+    gt R3 R0 96
+    jt R2 arith
+    jf R3 done
+    jmp done
+
+arith:
+    add R0 R0 1
+    mult R1 R0 2
+    mod R2 R1 7
+    and R3 R2 0x7fff
+    or R4 R3 1
+    not R5 R4
+    rmem R6 counter
+    wmem counter R6
+    call print
+    call R7
+    noop
+    in R0
+    out R0
+    ret
+
+print:
+    set R1 message
+loop:
+    rmem R0 R1
+    jf R0 done
+    out R0
+    add R1 R1 1
+    jmp loop
+
+done:
+    halt
+
+counter: .data 0
+message: .data "Hi!", 0
+
+; An unknown opcode and an instruction cut by the end of the memory
+.data 9999
+.data 9, R0
@@ -0,0 +1,102 @@
+// Package vmtest holds tiny programs covering every operation of the spec and its edge cases along with the state
+// expected once they stop. Check runs them against an implementation, go test and the selftest subcommand run them
+// against all of them.
+package vmtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/sfluor/synacor/asm"
+	"github.com/sfluor/synacor/verify"
+)
+
+// maxSteps stops the programs that never stop
+const maxSteps = 10000
+
+// Case is a program and the state expected once it stopped
+type Case struct {
+	Name      string
+	Source    string            // Assembly, see the asm package
+	Input     string            // Read by IN
+	Err       error             // Expected error of the last step, checked with errors.Is
+	Registers map[int]uint16    // Expected registers, the other ones must be 0
+	Stack     []uint16          // Expected stack, the top is the last value
+	Memory    map[uint16]uint16 // Expected values of some addresses
+	Output    string            // Expected output
+}
+
+// Failure is a case whose final state isn't the expected one
+type Failure struct {
+	Case     string
+	Problems []string
+}
+
+func (f Failure) String() string {
+	return fmt.Sprintf("%s: %v", f.Case, f.Problems)
+}
+
+// Check runs every case of Cases on the machines created by f and returns the ones that failed
+func Check(f verify.Factory) []Failure {
+	failures := []Failure{}
+	for _, c := range Cases {
+		if problems := c.Run(f); len(problems) > 0 {
+			failures = append(failures, Failure{c.Name, problems})
+		}
+	}
+	return failures
+}
+
+// Run assembles the program, runs it on a machine created by f until it stops and returns how the final state differs
+// from the expected one
+func (c Case) Run(f verify.Factory) []string {
+	bin, err := asm.Assemble(c.Source)
+	if err != nil {
+		return []string{fmt.Sprintf("could not assemble: %s", err)}
+	}
+
+	out := &bytes.Buffer{}
+	m := f(bin, bytes.NewReader([]byte(c.Input)), out)
+
+	var last error
+	for i := 0; last == nil; i++ {
+		if i == maxSteps {
+			return []string{fmt.Sprintf("still running after %d instructions", maxSteps)}
+		}
+		last = m.Step()
+	}
+
+	problems := []string{}
+	if !errors.Is(last, c.Err) {
+		problems = append(problems, fmt.Sprintf("stopped with %q instead of %q", last, c.Err))
+	}
+
+	for r := 0; r < 8; r++ {
+		if got, want := m.Register(r), c.Registers[r]; got != want {
+			problems = append(problems, fmt.Sprintf("R%d = %d instead of %d", r, got, want))
+		}
+	}
+
+	if stack := m.Stack(); fmt.Sprint(stack) != fmt.Sprint(append([]uint16{}, c.Stack...)) {
+		problems = append(problems, fmt.Sprintf("stack %v instead of %v", stack, c.Stack))
+	}
+
+	addrs := []int{}
+	for addr := range c.Memory {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	for _, addr := range addrs {
+		if got, want := m.Memory(uint16(addr)), c.Memory[uint16(addr)]; got != want {
+			problems = append(problems, fmt.Sprintf("mem[%d] = %d instead of %d", addr, got, want))
+		}
+	}
+
+	if out.String() != c.Output {
+		problems = append(problems, fmt.Sprintf("output %q instead of %q", out.String(), c.Output))
+	}
+
+	return problems
+}
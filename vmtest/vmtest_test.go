@@ -0,0 +1,34 @@
+package vmtest
+
+import (
+	"testing"
+
+	"github.com/sfluor/synacor/verify"
+)
+
+func TestCases(t *testing.T) {
+	for _, name := range verify.Names() {
+		f := verify.Implementations[name]
+		t.Run(name, func(t *testing.T) {
+			for _, c := range Cases {
+				c := c
+				t.Run(c.Name, func(t *testing.T) {
+					for _, p := range c.Run(f) {
+						t.Error(p)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestROM(t *testing.T) {
+	for _, name := range verify.Names() {
+		f := verify.Implementations[name]
+		t.Run(name, func(t *testing.T) {
+			for _, p := range CheckROM(f) {
+				t.Error(p)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+//go:build js && wasm
+
+// Package wasm runs the game in a browser, once compiled with GOOS=js GOARCH=wasm: it bridges a VM and the JavaScript
+// of the page, which gets the output through a callback and sends the lines typed. See cmd/synacor-wasm and index.html.
+package wasm
+
+import (
+	"fmt"
+	"io"
+	"syscall/js"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Bridge is the input and the output of a VM running in the browser
+type Bridge struct {
+	onOutput js.Value    // Function called with every chunk of output, as a string
+	lines    chan []byte // Lines sent by the page, not yet read by the VM
+	pending  []byte      // Line being read by the VM
+}
+
+// NewBridge creates a bridge writing the output of the VM to the JavaScript function onOutput
+func NewBridge(onOutput js.Value) *Bridge {
+	return &Bridge{onOutput: onOutput, lines: make(chan []byte)}
+}
+
+func (b *Bridge) Write(p []byte) (int, error) {
+	b.onOutput.Invoke(string(p))
+	return len(p), nil
+}
+
+// Read blocks until the page sends a line, it returns io.EOF once the bridge is closed
+func (b *Bridge) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		line, ok := <-b.lines
+		if !ok {
+			return 0, io.EOF
+		}
+		b.pending = line
+	}
+
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+// Send gives a line to the VM, without its newline. It doesn't block: the JavaScript callbacks must return for the VM
+// to run.
+func (b *Bridge) Send(line string) {
+	go func() { b.lines <- []byte(line + "\n") }()
+}
+
+// Close ends the input, the VM stops with vm.ExitInputEOF once it read the lines sent before
+func (b *Bridge) Close() {
+	go func() { close(b.lines) }()
+}
+
+// Expose defines the global JavaScript object name to play games on VMs created by newGame, one at a time:
+//
+//	start(onOutput)	starts a new game writing its output to onOutput, it returns a Promise resolved with the reason
+//			the VM stopped (e.g. "halt"), or rejected with its error
+//	send(line)	sends a line typed by the player to the game
+//	stop()		ends the input of the game, which stops once it read the lines sent
+func Expose(name string, newGame func(in io.Reader, out io.Writer) *vm.VM) {
+	var current *Bridge
+
+	api := map[string]interface{}{
+		"start": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			if len(args) != 1 || args[0].Type() != js.TypeFunction {
+				panic(fmt.Sprintf("%s.start expects the function receiving the output", name))
+			}
+			if current != nil {
+				current.Close()
+			}
+			b := NewBridge(args[0])
+			current = b
+
+			return js.Global().Get("Promise").New(js.FuncOf(func(_ js.Value, promise []js.Value) interface{} {
+				resolve, reject := promise[0], promise[1]
+				go func() {
+					reason, err := newGame(b, b).Run()
+					if err != nil {
+						reject.Invoke(js.Global().Get("Error").New(err.Error()))
+						return
+					}
+					resolve.Invoke(reason.String())
+				}()
+				return nil
+			}))
+		}),
+		"send": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			if current != nil && len(args) == 1 {
+				current.Send(args[0].String())
+			}
+			return nil
+		}),
+		"stop": js.FuncOf(func(_ js.Value, _ []js.Value) interface{} {
+			if current != nil {
+				current.Close()
+				current = nil
+			}
+			return nil
+		}),
+	}
+	js.Global().Set(name, js.ValueOf(api))
+}
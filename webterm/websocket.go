@@ -0,0 +1,179 @@
+package webterm
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// websocketGUID is appended to the key of the client to compute the accept header, see RFC 6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxMessageSize bounds the messages read from the browser, they are lines of commands
+const maxMessageSize = 64 * 1024
+
+// WebSocket opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// wsConn is the server side of a WebSocket connection, only one goroutine may read and write it
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// upgrade answers the handshake of a WebSocket request and takes over its connection
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") || key == "" {
+		http.Error(w, "Expected a WebSocket handshake", http.StatusBadRequest)
+		return nil, fmt.Errorf("not a WebSocket handshake")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets are not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("the response can't be hijacked")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	_, err = fmt.Fprintf(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// headerContains returns true if one of the comma separated values of the header is value, ignoring the case
+func headerContains(h http.Header, name, value string) bool {
+	for _, v := range h.Values(name) {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readMessage returns the payload of the next text or binary message, answering the pings on the way. It returns
+// io.EOF once the browser closed the connection.
+func (c *wsConn) readMessage() ([]byte, error) {
+	message := []byte{}
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+		}
+
+		message = append(message, payload...)
+		if len(message) > maxMessageSize {
+			return nil, fmt.Errorf("message larger than %d bytes", maxMessageSize)
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads a frame sent by the browser, its payload is unmasked
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin, op := header[0]&0x80 != 0, header[0]&0x0f
+	masked, length := header[1]&0x80 != 0, uint64(header[1]&0x7f)
+	if !masked {
+		return false, 0, nil, errors.New("the frames of a client must be masked")
+	}
+
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(c.r, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(c.r, ext); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > maxMessageSize {
+		return false, 0, nil, fmt.Errorf("frame larger than %d bytes", maxMessageSize)
+	}
+
+	mask := make([]byte, 4)
+	if _, err := io.ReadFull(c.r, mask); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, op, payload, nil
+}
+
+// writeFrame sends a whole message in one unmasked frame
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	frame := []byte{0x80 | op}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+func (c *wsConn) close() error {
+	return c.conn.Close()
+}
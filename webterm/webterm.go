@@ -0,0 +1,134 @@
+// Package webterm serves the game to browsers: a page running a terminal that talks to its own VM over a WebSocket.
+// The WebSocket carries the output of the VM in binary messages and the commands typed in the terminal, line by line.
+package webterm
+
+import (
+	_ "embed"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/sfluor/synacor/metrics"
+	"github.com/sfluor/synacor/vm"
+)
+
+//go:embed index.html
+var page []byte
+
+// Server is an http.Handler serving the terminal page on / and the games on /ws
+type Server struct {
+	newGame func(in io.Reader, out io.Writer) *vm.VM
+	idle    time.Duration
+	slots   chan struct{}
+	metrics *metrics.Registry
+}
+
+// New creates a server playing at most maxGames games at the same time, each on a VM created by newGame and closed
+// once idle for idle
+func New(newGame func(in io.Reader, out io.Writer) *vm.VM, maxGames int, idle time.Duration) *Server {
+	return &Server{newGame: newGame, idle: idle, slots: make(chan struct{}, maxGames)}
+}
+
+// SetMetrics makes the server record its games in r
+func (s *Server) SetMetrics(r *metrics.Registry) {
+	s.metrics = r
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	case "/ws":
+		s.serveGame(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveGame upgrades the request and plays a game on it until the program stops or the browser leaves
+func (s *Server) serveGame(w http.ResponseWriter, r *http.Request) {
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		log.Printf("%s refused: %d games are already running", r.RemoteAddr, cap(s.slots))
+		http.Error(w, "The server is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	ws, err := upgrade(w, r)
+	if err != nil {
+		log.Printf("%s: %s", r.RemoteAddr, err)
+		return
+	}
+	defer ws.close()
+	log.Printf("%s connected", r.RemoteAddr)
+
+	t := &terminal{ws: ws, idle: s.idle}
+	defer t.flush()
+
+	machine := s.newGame(t, t)
+	session := s.metrics.Track(machine, r.RemoteAddr)
+	reason, err := machine.Run()
+	session.Done(machine.Instructions(), reason)
+	if err != nil {
+		log.Printf("%s disconnected: %s", r.RemoteAddr, err)
+		return
+	}
+	log.Printf("%s disconnected: %s", r.RemoteAddr, reason)
+	ws.writeFrame(opClose, nil)
+}
+
+// terminal adapts a WebSocket to the input and output of a VM. The VM writes one byte at a time, the output is
+// buffered and sent in one message when the program reads the next command.
+type terminal struct {
+	ws      *wsConn
+	idle    time.Duration
+	out     []byte
+	pending []byte
+}
+
+func (t *terminal) Read(b []byte) (int, error) {
+	for len(t.pending) == 0 {
+		if err := t.flush(); err != nil {
+			return 0, err
+		}
+		if err := t.ws.conn.SetReadDeadline(time.Now().Add(t.idle)); err != nil {
+			return 0, err
+		}
+
+		message, err := t.ws.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range message {
+			if c != '\r' {
+				t.pending = append(t.pending, c)
+			}
+		}
+	}
+
+	n := copy(b, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *terminal) Write(b []byte) (int, error) {
+	t.out = append(t.out, b...)
+	return len(b), nil
+}
+
+// flush sends the buffered output
+func (t *terminal) flush() error {
+	if len(t.out) == 0 {
+		return nil
+	}
+	if err := t.ws.conn.SetWriteDeadline(time.Now().Add(t.idle)); err != nil {
+		return err
+	}
+	err := t.ws.writeFrame(opBinary, t.out)
+	t.out = t.out[:0]
+	return err
+}
@@ -0,0 +1,198 @@
+// Package writeup renders a transcript recorded by -record as a playthrough to publish: the output of the game in
+// blocks and the commands typed between them, highlighted, in Markdown or HTML. The challenge codes can be redacted
+// and the commands timed from the start of the session.
+package writeup
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sfluor/synacor/vm"
+)
+
+// Formats of Write
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// redacted replaces the codes with Options.RedactCodes
+const redacted = "[code redacted]"
+
+// Options tune the rendering
+type Options struct {
+	Format      string // FormatMarkdown or FormatHTML
+	Title       string // Title of the document, none if empty
+	RedactCodes bool   // Replace the challenge codes of the output, e.g. to publish a write-up without spoilers
+	Timestamps  bool   // Show when each command was typed, from the start of the session
+	Debugger    bool   // Keep the debugger commands, they are left out by default
+	Prefix      string // Prefix of the debugger commands, $ if empty
+}
+
+// Turn is a command typed and the output written until the next one
+type Turn struct {
+	Command string    // Line read, without its newline, empty for the output written before the first command
+	Time    time.Time // When its first byte was read (or written, before the first command)
+	Output  string
+}
+
+// Turns splits a transcript in turns, the first one holds the output written before the first command
+func Turns(t vm.Transcript) []Turn {
+	turns := []Turn{{}}
+	line := []byte{}
+	var start time.Time
+
+	for _, e := range t {
+		if turns[0].Time.IsZero() {
+			turns[0].Time = e.Time
+		}
+
+		if !e.Input {
+			turns[len(turns)-1].Output += string(e.Byte)
+			continue
+		}
+		if len(line) == 0 {
+			start = e.Time
+		}
+		if e.Byte != '\n' {
+			line = append(line, e.Byte)
+			continue
+		}
+		turns = append(turns, Turn{Command: string(line), Time: start})
+		line = line[:0]
+	}
+	return turns
+}
+
+// Write renders the transcript to w
+func Write(w io.Writer, t vm.Transcript, opts Options) error {
+	if opts.Format != FormatMarkdown && opts.Format != FormatHTML {
+		return fmt.Errorf("unknown format %q, should be %s or %s", opts.Format, FormatMarkdown, FormatHTML)
+	}
+	prefix := opts.Prefix
+	if prefix == "" {
+		prefix = "$"
+	}
+
+	// The output of the debugger commands left out goes with the previous turn
+	turns := []Turn{}
+	for _, turn := range Turns(t) {
+		if !opts.Debugger && len(turns) > 0 && strings.HasPrefix(turn.Command, prefix) {
+			turns[len(turns)-1].Output += turn.Output
+			continue
+		}
+		turns = append(turns, turn)
+	}
+
+	r := &renderer{w: w, opts: opts}
+	if len(turns) > 0 {
+		r.start = turns[0].Time
+	}
+	r.header()
+	for i, turn := range turns {
+		if i > 0 {
+			r.command(turn)
+		}
+		r.output(turn.Output)
+	}
+	r.footer()
+	return r.err
+}
+
+// renderer writes the parts of the document, remembering the first error
+type renderer struct {
+	w     io.Writer
+	opts  Options
+	start time.Time
+	err   error
+}
+
+func (r *renderer) printf(format string, args ...interface{}) {
+	if r.err == nil {
+		_, r.err = fmt.Fprintf(r.w, format, args...)
+	}
+}
+
+func (r *renderer) header() {
+	if r.opts.Format == FormatHTML {
+		title := html.EscapeString(r.opts.Title)
+		r.printf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n", title)
+		r.printf("<style>\nbody { max-width: 50em; margin: auto; font-family: sans-serif; }\n")
+		r.printf("pre { background: #f4f4f4; padding: 0.5em; white-space: pre-wrap; }\n")
+		r.printf(".command { font-family: monospace; font-weight: bold; color: #0b5394; }\n")
+		r.printf(".time { color: #888; font-weight: normal; }\n</style>\n</head>\n<body>\n")
+		if r.opts.Title != "" {
+			r.printf("<h1>%s</h1>\n", title)
+		}
+		return
+	}
+	if r.opts.Title != "" {
+		r.printf("# %s\n\n", r.opts.Title)
+	}
+}
+
+func (r *renderer) footer() {
+	if r.opts.Format == FormatHTML {
+		r.printf("</body>\n</html>\n")
+	}
+}
+
+// command writes the command of a turn
+func (r *renderer) command(turn Turn) {
+	elapsed := ""
+	if r.opts.Timestamps {
+		elapsed = "+" + turn.Time.Sub(r.start).Round(time.Second).String()
+	}
+
+	if r.opts.Format == FormatHTML {
+		if elapsed != "" {
+			elapsed = fmt.Sprintf(" <span class=\"time\">%s</span>", elapsed)
+		}
+		r.printf("<p class=\"command\">&gt; %s%s</p>\n", html.EscapeString(turn.Command), elapsed)
+		return
+	}
+	if elapsed != "" {
+		elapsed = fmt.Sprintf(" _(%s)_", elapsed)
+	}
+	r.printf("**> %s**%s\n\n", escapeMarkdown(turn.Command), elapsed)
+}
+
+// output writes the output of a turn, without the blank lines around it
+func (r *renderer) output(text string) {
+	text = strings.Trim(text, "\n")
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	if r.opts.RedactCodes {
+		text = redact(text)
+	}
+
+	if r.opts.Format == FormatHTML {
+		r.printf("<pre>%s</pre>\n", html.EscapeString(text))
+		return
+	}
+	r.printf("```text\n%s\n```\n\n", text)
+}
+
+// redact replaces the challenge codes of text
+func redact(text string) string {
+	b := strings.Builder{}
+	last := 0
+	for _, loc := range vm.FindCodes(text) {
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(redacted)
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// markdownSpecial are the characters escaped in the commands, which are written as bold text
+var markdownSpecial = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `_`, `\_`, "`", "\\`", `[`, `\[`, `]`, `\]`, `<`, `\<`)
+
+func escapeMarkdown(s string) string {
+	return markdownSpecial.Replace(s)
+}